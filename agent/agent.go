@@ -14,9 +14,11 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
 
 	"github.com/Dataman-Cloud/swan/agent/ipam"
 	"github.com/Dataman-Cloud/swan/agent/janitor"
+	"github.com/Dataman-Cloud/swan/agent/janitor/watcher"
 	"github.com/Dataman-Cloud/swan/agent/resolver"
 	"github.com/Dataman-Cloud/swan/config"
 	"github.com/Dataman-Cloud/swan/mole"
@@ -108,6 +110,14 @@ func (agent *Agent) StartAndJoin() error {
 				log.Fatalln("janitor occured fatal error:", err)
 			}
 		}()
+
+		if addrs := agent.config.Janitor.EtcdAddrs; len(addrs) > 0 {
+			w, err := watcher.NewEtcdWatcher(addrs, agent.config.Janitor.EtcdPrefix, agent.janitor)
+			if err != nil {
+				return fmt.Errorf("janitor etcd watcher setup error: %v", err)
+			}
+			go w.Run(context.Background())
+		}
 	}
 
 	if agent.config.IPAM.Enabled {
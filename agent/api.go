@@ -44,8 +44,14 @@ func (agent *Agent) setupProxyHandlers(mux *mux.Router) {
 	r.Path("/upstreams/{uid}").Methods("GET").HandlerFunc(janitor.GetUpstream)
 	r.Path("/upstreams").Methods("PUT").HandlerFunc(janitor.UpsertUpstream)
 	r.Path("/upstreams").Methods("DELETE").HandlerFunc(janitor.DelUpstream)
+	r.Path("/upstreams/{uid}/canary").Methods("PUT").HandlerFunc(janitor.UpdateCanary)
+	r.Path("/upstreams/{uid}/canary").Methods("DELETE").HandlerFunc(janitor.RemoveCanary)
+	r.Path("/snapshot").Methods("GET").HandlerFunc(janitor.ExportSnapshot)
+	r.Path("/snapshot").Methods("POST").HandlerFunc(janitor.RestoreSnapshot)
 	r.Path("/sessions").Methods("GET").HandlerFunc(janitor.ListSessions)
+	r.Path("/drain/{uid}/{bid}").Methods("GET").HandlerFunc(janitor.ShowDrainStatus)
 	r.Path("/configs").Methods("GET").HandlerFunc(janitor.ShowConfigs)
+	r.Path("/reload").Methods("POST").HandlerFunc(janitor.ReloadConfigs)
 	r.Path("/stats").Methods("GET").HandlerFunc(janitor.ShowStats)
 	r.Path("/stats/{uid}").Methods("GET").HandlerFunc(janitor.ShowUpstreamStats)
 	r.Path("/stats/{uid}/{bid}").Methods("GET").HandlerFunc(janitor.ShowBackendStats)
@@ -3,28 +3,87 @@ package janitor
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/Dataman-Cloud/swan/agent/janitor/stats"
 	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+	"github.com/Dataman-Cloud/swan/utils/pagination"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 )
 
+// upstreamView is the upstream listed with the runtime selection counters of backends,
+// which are kept out of the upstream & backend used for registration, the secrets are redacted.
+type upstreamView struct {
+	*upstream.Upstream
+	BasicAuth *upstream.BasicAuth `json:"basic_auth,omitempty"`
+	JWT       *upstream.JWT       `json:"jwt,omitempty"`
+	Backends  []*backendView      `json:"backends"`
+}
+
+type backendView struct {
+	*upstream.Backend
+	Selections   uint64     `json:"selections"`              // nb of times selected
+	LastSelected *time.Time `json:"last_selected,omitempty"` // nil if never selected
+}
+
+func newUpstreamView(u *upstream.Upstream) *upstreamView {
+	view := &upstreamView{
+		Upstream:  u,
+		BasicAuth: u.BasicAuth.Redact(),
+		JWT:       u.JWT.Redact(),
+		Backends:  make([]*backendView, 0, len(u.Backends)),
+	}
+
+	for _, b := range u.Backends {
+		bv := &backendView{Backend: b}
+		if n, last := b.Selections(); !last.IsZero() {
+			bv.Selections, bv.LastSelected = n, &last
+		}
+		view.Backends = append(view.Backends, bv)
+	}
+
+	return view
+}
+
 func (s *JanitorServer) ListUpstreams(w http.ResponseWriter, r *http.Request) {
+	page, err := pagination.Parse(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	ret := []*upstreamView{}
+	for _, u := range upstream.AllUpstreams() {
+		ret = append(ret, newUpstreamView(u))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(upstream.AllUpstreams())
+
+	if page == nil {
+		json.NewEncoder(w).Encode(ret)
+		return
+	}
+
+	// stable order across the pages
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+
+	start, end := page.Bounds(len(ret))
+	json.NewEncoder(w).Encode(page.Page(len(ret), ret[start:end]))
 }
 
 func (s *JanitorServer) GetUpstream(w http.ResponseWriter, r *http.Request) {
 	var (
 		uid = mux.Vars(r)["uid"]
 		m   = upstream.AllUpstreams()
-		ret = []*upstream.Upstream{}
+		ret = []*upstreamView{}
 	)
 
 	for _, u := range m {
 		if u.Name == uid {
-			ret = append(ret, u)
+			ret = append(ret, newUpstreamView(u))
 		}
 	}
 
@@ -39,7 +98,7 @@ func (s *JanitorServer) UpsertUpstream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := cmb.Valid(); err != nil {
+	if err := s.validate(cmb); err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
@@ -49,6 +108,7 @@ func (s *JanitorServer) UpsertUpstream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setTargetChange(w, cmb)
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -59,7 +119,111 @@ func (s *JanitorServer) DelUpstream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.removeBackend(cmb)
+	s.RemoveBackend(cmb)
+
+	setTargetChange(w, cmb)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setTargetChange reports the target change applied by the request,
+// so that the manager could publish the routing changes onto the event stream.
+func setTargetChange(w http.ResponseWriter, cmb *upstream.BackendCombined) {
+	if change := cmb.Change(); change != "" {
+		w.Header().Set(upstream.TargetChangeHeader, change)
+	}
+}
+
+// ExportSnapshot exports the upstreams with the secrets redacted, they are filled back
+// from the registered upstreams once the snapshot restored.
+func (s *JanitorServer) ExportSnapshot(w http.ResponseWriter, r *http.Request) {
+	snap := upstream.Snapshot()
+	for _, u := range snap {
+		u.Redact()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snap)
+}
+
+func (s *JanitorServer) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	var ups []*upstream.Upstream
+	if err := json.NewDecoder(r.Body).Decode(&ups); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if err := s.Restore(ups); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// ShowDrainStatus tells whether a draining backend could be removed cleanly:
+// drained if the weight is 0 and no active clients remained.
+func (s *JanitorServer) ShowDrainStatus(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars = mux.Vars(r)
+		uid  = vars["uid"]
+		bid  = vars["bid"]
+	)
+
+	status := upstream.GetDrainStatus(uid, bid)
+	if status == nil {
+		http.Error(w, "no such upstream backend", 404)
+		return
+	}
+
+	var active uint
+	if ups, ok := stats.UpstreamStats()[uid]; ok {
+		if backend, ok := ups[bid]; ok {
+			active = backend.ActiveClients
+		}
+	}
+
+	wrapper := map[string]interface{}{
+		"draining":       status.Draining,
+		"sessions":       status.Sessions,
+		"active_clients": active,
+		"drained":        status.Draining && active == 0,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wrapper)
+}
+
+// UpdateCanary adjusts the canary traffic split of the upstream at runtime, the
+// new sessions are split by the percentage immediately.
+func (s *JanitorServer) UpdateCanary(w http.ResponseWriter, r *http.Request) {
+	var canary *upstream.Canary
+	if err := json.NewDecoder(r.Body).Decode(&canary); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if canary == nil {
+		http.Error(w, "canary required", 400)
+		return
+	}
+
+	s.setCanary(w, mux.Vars(r)["uid"], canary)
+}
+
+// RemoveCanary removes the canary traffic split of the upstream
+func (s *JanitorServer) RemoveCanary(w http.ResponseWriter, r *http.Request) {
+	s.setCanary(w, mux.Vars(r)["uid"], nil)
+}
+
+func (s *JanitorServer) setCanary(w http.ResponseWriter, uid string, canary *upstream.Canary) {
+	if err := upstream.SetCanary(uid, canary); err != nil {
+		code := 400
+		if err == upstream.ErrUpstreamNotFound {
+			code = 404
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	log.Printf("proxy upstream %s canary updated: %+v", uid, canary)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -69,16 +233,50 @@ func (s *JanitorServer) ListSessions(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *JanitorServer) ShowConfigs(w http.ResponseWriter, r *http.Request) {
+	s.RLock()
+	defer s.RUnlock()
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(s.config)
 }
 
+// ReloadConfigs applies the posted janitor settings live, the settings absent are kept as is.
+func (s *JanitorServer) ReloadConfigs(w http.ResponseWriter, r *http.Request) {
+	s.RLock()
+	cfg := *s.config
+	cfg.AccessLogSinks = nil // replaced as a whole if posted, never decoded into the live one
+	s.RUnlock()
+
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if cfg.AccessLogSinks == nil {
+		s.RLock()
+		cfg.AccessLogSinks = s.config.AccessLogSinks
+		s.RUnlock()
+	}
+
+	ret, err := s.Reload(&cfg)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ret)
+}
+
 func (s *JanitorServer) ShowStats(w http.ResponseWriter, r *http.Request) {
 	wrapper := map[string]interface{}{
-		"httpd":    s.config.ListenAddr,
-		"httpdTLS": s.config.TLSListenAddr,
-		"counter":  stats.Get(),
-		"tcpd":     s.tcpd,
+		"httpd":      s.config.ListenAddr,
+		"httpdTLS":   s.config.TLSListenAddr,
+		"counter":    stats.Get(),
+		"limits":     upstream.LimitStats(),
+		"breakers":   upstream.BreakerStats(),
+		"rateLimits": upstream.RateLimitStats(),
+		"tcpd":       s.tcpd,
+		"udpd":       s.udpd,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(wrapper)
@@ -0,0 +1,305 @@
+package janitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+	"github.com/Dataman-Cloud/swan/config"
+
+	"github.com/gorilla/mux"
+)
+
+func TestListUpstreamsSelections(t *testing.T) {
+	var (
+		s   = NewJanitorServer(&config.Janitor{})
+		cmb = &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: "list.default.bbk.dataman"},
+			Backend:  &upstream.Backend{ID: "0.list.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 1},
+		}
+	)
+
+	if err := s.UpsertBackend(cmb); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	defer s.RemoveBackend(cmb)
+
+	u := upstream.GetUpstream(cmb.Upstream.Name)
+	for i := 0; i < 3; i++ {
+		if _, err := upstream.Lookup(&upstream.Client{IP: "10.0.0.1"}, u, ""); err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.ListUpstreams(w, httptest.NewRequest("GET", "/proxy/upstreams", nil))
+
+	var got []struct {
+		Name     string `json:"name"`
+		Backends []struct {
+			ID           string  `json:"id"`
+			Selections   uint64  `json:"selections"`
+			LastSelected *string `json:"last_selected"`
+		} `json:"backends"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode upstreams error = %v", err)
+	}
+
+	var found bool
+	for _, u := range got {
+		if u.Name != cmb.Upstream.Name {
+			continue
+		}
+		found = true
+		if len(u.Backends) != 1 || u.Backends[0].Selections != 3 || u.Backends[0].LastSelected == nil {
+			t.Errorf("listed backends = %+v, want 3 selections with last selected time", u.Backends)
+		}
+	}
+	if !found {
+		t.Fatalf("upstream %s not listed", cmb.Upstream.Name)
+	}
+
+	// the wire backend used for registration carries no runtime counters
+	b, _ := json.Marshal(upstream.GetBackend(u, cmb.Backend.ID))
+	if strings.Contains(string(b), "selections") || strings.Contains(string(b), "last_selected") {
+		t.Errorf("wire backend = %s, should not carry the selection counters", b)
+	}
+}
+
+func TestUpstreamTargetChangeHeader(t *testing.T) {
+	var (
+		s    = NewJanitorServer(&config.Janitor{})
+		body = `{"upstream":{"name":"change.default.bbk.dataman"},"backend":{"id":"0.change.default.bbk.dataman","ip":"192.168.1.101","port":31000,"weight":1}}`
+	)
+
+	tests := []struct {
+		name    string
+		method  string
+		handler func(http.ResponseWriter, *http.Request)
+		want    string
+	}{
+		{name: "add", method: "PUT", handler: s.UpsertUpstream, want: upstream.TargetAdd},
+		{name: "update", method: "PUT", handler: s.UpsertUpstream, want: upstream.TargetUpdate},
+		{name: "del", method: "DELETE", handler: s.DelUpstream, want: upstream.TargetDel},
+		{name: "del absent", method: "DELETE", handler: s.DelUpstream, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(tt.method, "/proxy/upstreams", strings.NewReader(body)))
+			if w.Code >= 400 {
+				t.Fatalf("%s upstream code = %d: %s", tt.method, w.Code, w.Body)
+			}
+			if got := w.Header().Get(upstream.TargetChangeHeader); got != tt.want {
+				t.Errorf("target change = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListUpstreamsPagination(t *testing.T) {
+	s := NewJanitorServer(&config.Janitor{})
+	for _, name := range []string{"c.page.bbk.dataman", "a.page.bbk.dataman", "b.page.bbk.dataman"} {
+		cmb := &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: name},
+			Backend:  &upstream.Backend{ID: "0." + name, IP: "192.168.1.101", Port: 31000, Weight: 1},
+		}
+		if err := s.UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+		defer s.RemoveBackend(cmb)
+	}
+
+	var page struct {
+		Items []struct {
+			Name string `json:"name"`
+		} `json:"items"`
+		Total int    `json:"total"`
+		Next  string `json:"next"`
+	}
+
+	w := httptest.NewRecorder()
+	s.ListUpstreams(w, httptest.NewRequest("GET", "/proxy/upstreams?limit=2", nil))
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("decode page error = %v", err)
+	}
+	if page.Total != 3 || len(page.Items) != 2 || page.Items[0].Name != "a.page.bbk.dataman" || page.Next != "2" {
+		t.Errorf("first page = %+v, want a & b of 3 with next 2", page)
+	}
+
+	w = httptest.NewRecorder()
+	s.ListUpstreams(w, httptest.NewRequest("GET", "/proxy/upstreams?offset=-1", nil))
+	if w.Code != 400 {
+		t.Errorf("invalid offset code = %d, want 400", w.Code)
+	}
+}
+
+func TestUpdateCanary(t *testing.T) {
+	var (
+		s   = NewJanitorServer(&config.Janitor{})
+		cmb = &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: "canary.default.bbk.dataman"},
+			Backend:  &upstream.Backend{ID: "0.canary.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 1, Version: "v1"},
+		}
+	)
+
+	if err := s.UpsertBackend(cmb); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	defer s.RemoveBackend(cmb)
+
+	r := mux.NewRouter()
+	r.Path("/proxy/upstreams/{uid}/canary").Methods("PUT").HandlerFunc(s.UpdateCanary)
+	r.Path("/proxy/upstreams/{uid}/canary").Methods("DELETE").HandlerFunc(s.RemoveCanary)
+
+	tests := []struct {
+		name     string
+		method   string
+		upstream string
+		body     string
+		wantCode int
+		want     *upstream.Canary
+	}{
+		{name: "set", method: "PUT", upstream: cmb.Upstream.Name, body: `{"version":"v2","percent":20}`, wantCode: 204, want: &upstream.Canary{Version: "v2", Percent: 20}},
+		{name: "adjust", method: "PUT", upstream: cmb.Upstream.Name, body: `{"version":"v2","percent":50}`, wantCode: 204, want: &upstream.Canary{Version: "v2", Percent: 50}},
+		{name: "out of range", method: "PUT", upstream: cmb.Upstream.Name, body: `{"version":"v2","percent":120}`, wantCode: 400, want: &upstream.Canary{Version: "v2", Percent: 50}},
+		{name: "empty body", method: "PUT", upstream: cmb.Upstream.Name, body: `null`, wantCode: 400, want: &upstream.Canary{Version: "v2", Percent: 50}},
+		{name: "no such upstream", method: "PUT", upstream: "none", body: `{"version":"v2","percent":20}`, wantCode: 404, want: &upstream.Canary{Version: "v2", Percent: 50}},
+		{name: "remove", method: "DELETE", upstream: cmb.Upstream.Name, wantCode: 204},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, "/proxy/upstreams/"+tt.upstream+"/canary", strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", w.Code, tt.wantCode, w.Body.String())
+			}
+
+			got := upstream.GetUpstream(cmb.Upstream.Name).Canary
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("canary = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShowStatsBreakers(t *testing.T) {
+	var (
+		s   = NewJanitorServer(&config.Janitor{})
+		cmb = &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: "stats-breaker.default.bbk.dataman", Breaker: &upstream.Breaker{Failures: 1}},
+			Backend:  &upstream.Backend{ID: "0.stats-breaker.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 1},
+		}
+	)
+
+	if err := s.UpsertBackend(cmb); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	defer s.RemoveBackend(cmb)
+
+	u := upstream.GetUpstream(cmb.Upstream.Name)
+	upstream.Report(&upstream.BackendCombined{Upstream: u, Backend: upstream.GetBackend(u, cmb.Backend.ID)}, false)
+
+	w := httptest.NewRecorder()
+	s.ShowStats(w, httptest.NewRequest("GET", "/proxy/stats", nil))
+
+	var got struct {
+		Breakers map[string]map[string]upstream.BreakerStatus `json:"breakers"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode stats error = %v", err)
+	}
+	if st := got.Breakers[cmb.Upstream.Name][cmb.Backend.ID]; st.State != upstream.CircuitOpen || st.OpenedAt == nil {
+		t.Errorf("breaker = %+v, want open", st)
+	}
+}
+
+func TestUpstreamsRedactSecrets(t *testing.T) {
+	const (
+		hash   = "{SHA256}HsHCa1DV08WNlYMYGvgHZlX+AHVr9yhZQLo2cPmfy6A="
+		secret = "jwt-shared-secret"
+	)
+
+	var (
+		s   = NewJanitorServer(&config.Janitor{})
+		cmb = &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{
+				Name:      "secret.default.bbk.dataman",
+				BasicAuth: &upstream.BasicAuth{Users: map[string]string{"admin": hash}},
+				JWT:       &upstream.JWT{Secret: secret},
+			},
+			Backend: &upstream.Backend{ID: "0.secret.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 1},
+		}
+	)
+
+	if err := s.UpsertBackend(cmb); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	defer s.RemoveBackend(cmb)
+
+	r := mux.NewRouter()
+	r.Path("/proxy/upstreams").Methods("GET").HandlerFunc(s.ListUpstreams)
+	r.Path("/proxy/upstreams/{uid}").Methods("GET").HandlerFunc(s.GetUpstream)
+	r.Path("/proxy/snapshot").Methods("GET").HandlerFunc(s.ExportSnapshot)
+
+	var snap string
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "list", path: "/proxy/upstreams"},
+		{name: "get", path: "/proxy/upstreams/" + cmb.Upstream.Name},
+		{name: "snapshot", path: "/proxy/snapshot"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			body := w.Body.String()
+			if strings.Contains(body, hash) || strings.Contains(body, secret) {
+				t.Errorf("%s body = %s, should not carry the secrets", tt.name, body)
+			}
+			if !strings.Contains(body, `"admin":"******"`) || !strings.Contains(body, `"secret":"******"`) {
+				t.Errorf("%s body = %s, want the secrets redacted", tt.name, body)
+			}
+			if tt.name == "snapshot" {
+				snap = body
+			}
+		})
+	}
+
+	// the registered upstream keeps the secrets
+	if u := upstream.GetUpstream(cmb.Upstream.Name); u.BasicAuth.Users["admin"] != hash || u.JWT.Secret != secret {
+		t.Fatalf("registered upstream secrets changed: %+v %+v", u.BasicAuth, u.JWT)
+	}
+
+	// the redacted snapshot is restored with the registered secrets
+	w := httptest.NewRecorder()
+	s.RestoreSnapshot(w, httptest.NewRequest("POST", "/proxy/snapshot", strings.NewReader(snap)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("restore snapshot code = %d: %s", w.Code, w.Body)
+	}
+	if u := upstream.GetUpstream(cmb.Upstream.Name); u.BasicAuth.Users["admin"] != hash || u.JWT.Secret != secret {
+		t.Errorf("restored upstream secrets = %+v %+v, want the registered ones", u.BasicAuth, u.JWT)
+	}
+
+	// rejected once the secrets are unknown
+	s.RemoveBackend(cmb)
+	w = httptest.NewRecorder()
+	s.RestoreSnapshot(w, httptest.NewRequest("POST", "/proxy/snapshot", strings.NewReader(snap)))
+	if w.Code != 400 {
+		t.Errorf("restore redacted snapshot code = %d, want 400", w.Code)
+	}
+	if u := upstream.GetUpstream(cmb.Upstream.Name); u != nil {
+		t.Errorf("upstream restored with the redacted secrets: %+v", u)
+	}
+}
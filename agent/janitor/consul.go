@@ -0,0 +1,185 @@
+package janitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+const consulRetryDelay = time.Second * 5
+
+// consulRegistry registers each upstream backend as a consul service instance
+// through the consul agent http api, so they could be discovered by other systems.
+// the registrations are queued and applied by the background reconciler, so that an
+// unreachable consul never stalls the upstream updates. the latest change of each
+// backend wins, the failed ones are retried later unless superseded.
+type consulRegistry struct {
+	addr   string
+	client *http.Client
+
+	sync.Mutex                           // protect pending & resync
+	pending    map[string]*consulService // backend id -> service to register, nil to deregister
+	resync     bool                      // register all of the current upstream backends
+	notify     chan struct{}
+}
+
+type consulService struct {
+	ID      string   `json:"ID"`
+	Name    string   `json:"Name"`
+	Address string   `json:"Address"`
+	Port    uint64   `json:"Port"`
+	Tags    []string `json:"Tags"`
+}
+
+func newConsulRegistry(addr string) *consulRegistry {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+
+	return &consulRegistry{
+		addr:    strings.TrimSuffix(addr, "/"),
+		client:  &http.Client{Timeout: time.Second * 5},
+		pending: make(map[string]*consulService),
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// serviceName converts the upstream name to a dns compatible consul service name
+func serviceName(ups string) string {
+	return strings.Replace(ups, ".", "-", -1)
+}
+
+func newConsulService(cmb *upstream.BackendCombined) *consulService {
+	svc := &consulService{
+		ID:      cmb.Backend.ID,
+		Name:    serviceName(cmb.Upstream.Name),
+		Address: cmb.Backend.IP,
+		Port:    cmb.Backend.Port,
+		Tags:    []string{"swan"},
+	}
+
+	if alias := cmb.Upstream.Alias; alias != "" {
+		svc.Tags = append(svc.Tags, "alias="+alias)
+	}
+	if version := cmb.Backend.Version; version != "" {
+		svc.Tags = append(svc.Tags, "version="+version)
+	}
+
+	return svc
+}
+
+// register queues the registration of the backend
+func (r *consulRegistry) register(cmb *upstream.BackendCombined) {
+	r.enqueue(cmb.Backend.ID, newConsulService(cmb))
+}
+
+// deregister queues the deregistration of the backend
+func (r *consulRegistry) deregister(cmb *upstream.BackendCombined) {
+	r.enqueue(cmb.Backend.ID, nil)
+}
+
+// reconcile queues the registration of all of the current upstream backends
+func (r *consulRegistry) reconcile() {
+	r.Lock()
+	r.resync = true
+	r.Unlock()
+	r.wake()
+}
+
+func (r *consulRegistry) enqueue(id string, svc *consulService) {
+	r.Lock()
+	r.pending[id] = svc
+	r.Unlock()
+	r.wake()
+}
+
+func (r *consulRegistry) wake() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run applies the queued changes in background, retry after a while on failure
+func (r *consulRegistry) run() {
+	for range r.notify {
+		if !r.flush() {
+			time.Sleep(consulRetryDelay)
+			r.wake()
+		}
+	}
+}
+
+// flush applies all of the queued changes, the failed ones are queued again unless
+// superseded meanwhile. it returns false if any of them failed.
+func (r *consulRegistry) flush() bool {
+	r.Lock()
+	pending, resync := r.pending, r.resync
+	r.pending, r.resync = make(map[string]*consulService), false
+	r.Unlock()
+
+	if resync {
+		for _, cmb := range upstream.Flatten(upstream.Snapshot()) {
+			pending[cmb.Backend.ID] = newConsulService(cmb)
+		}
+	}
+
+	ok := true
+	for id, svc := range pending {
+		if err := r.apply(id, svc); err != nil {
+			log.Errorf("consul registry of backend %s error: %v", id, err)
+
+			r.Lock()
+			if _, superseded := r.pending[id]; !superseded {
+				r.pending[id] = svc
+			}
+			r.Unlock()
+			ok = false
+		}
+	}
+	return ok
+}
+
+// apply registers the service, or deregisters the backend if svc is nil
+func (r *consulRegistry) apply(id string, svc *consulService) error {
+	if svc == nil {
+		return r.put("/v1/agent/service/deregister/"+id, nil)
+	}
+
+	bs, err := json.Marshal(svc)
+	if err != nil {
+		return err
+	}
+
+	return r.put("/v1/agent/service/register", bs)
+}
+
+func (r *consulRegistry) put(path string, body []byte) error {
+	req, err := http.NewRequest("PUT", r.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if code := resp.StatusCode; code != http.StatusOK {
+		bs, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("consul %s: %d - %s", path, code, string(bs))
+	}
+
+	return nil
+}
@@ -0,0 +1,172 @@
+package janitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+	"github.com/Dataman-Cloud/swan/config"
+)
+
+// mockConsul records the services registered through the consul agent api
+type mockConsul struct {
+	sync.Mutex
+	services map[string]*consulService
+}
+
+func (m *mockConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	if r.Method != "PUT" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v1/agent/service/register":
+		var svc *consulService
+		if err := json.NewDecoder(r.Body).Decode(&svc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.services[svc.ID] = svc
+
+	case len(r.URL.Path) > len("/v1/agent/service/deregister/"):
+		delete(m.services, r.URL.Path[len("/v1/agent/service/deregister/"):])
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestConsulRegistry(t *testing.T) {
+	mock := &mockConsul{services: make(map[string]*consulService)}
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+
+	var (
+		r   = newConsulRegistry(srv.URL)
+		ups = &upstream.Upstream{Name: "consul.default.bbk.dataman", Alias: "g.cn"}
+		b0  = &upstream.Backend{ID: "0.consul.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Version: "v1", Weight: 1}
+		b1  = &upstream.Backend{ID: "1.consul.default.bbk.dataman", IP: "192.168.1.102", Port: 31001, Weight: 1}
+	)
+	defer func() {
+		upstream.RemoveBackend(&upstream.BackendCombined{Upstream: ups, Backend: b0})
+		upstream.RemoveBackend(&upstream.BackendCombined{Upstream: ups, Backend: b1})
+	}()
+
+	tests := []struct {
+		name string
+		do   func()
+		want map[string]uint64 // service id -> port
+	}{
+		{
+			name: "register",
+			do:   func() { r.register(&upstream.BackendCombined{Upstream: ups, Backend: b0}) },
+			want: map[string]uint64{b0.ID: 31000},
+		},
+		{
+			name: "reconcile",
+			do: func() {
+				for _, b := range []*upstream.Backend{b0, b1} {
+					if _, err := upstream.UpsertBackend(&upstream.BackendCombined{Upstream: ups, Backend: b}); err != nil {
+						t.Fatalf("UpsertBackend() error = %v", err)
+					}
+				}
+				r.reconcile()
+			},
+			want: map[string]uint64{b0.ID: 31000, b1.ID: 31001},
+		},
+		{
+			name: "deregister",
+			do:   func() { r.deregister(&upstream.BackendCombined{Upstream: ups, Backend: b0}) },
+			want: map[string]uint64{b1.ID: 31001},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.do()
+			if !r.flush() {
+				t.Fatal("consul registry flush failed")
+			}
+
+			mock.Lock()
+			defer mock.Unlock()
+
+			if len(mock.services) != len(tt.want) {
+				t.Fatalf("consul services = %v, want %v", mock.services, tt.want)
+			}
+			for id, port := range tt.want {
+				svc, ok := mock.services[id]
+				if !ok || svc.Port != port || svc.Name != "consul-default-bbk-dataman" {
+					t.Errorf("consul service %s = %+v, want port %d", id, svc, port)
+				}
+			}
+		})
+	}
+
+	if tags := mock.services[b1.ID].Tags; len(tags) != 2 || tags[1] != "alias=g.cn" {
+		t.Errorf("consul service tags = %v", tags)
+	}
+
+	// the failed one is kept for retry
+	srv.Close()
+	r.register(&upstream.BackendCombined{Upstream: ups, Backend: b0})
+	if r.flush() {
+		t.Errorf("flush to unavailable consul succeed, want failed")
+	}
+	if svc := r.pending[b0.ID]; svc == nil || svc.Port != 31000 {
+		t.Errorf("pending %s = %+v, want queued again for retry", b0.ID, svc)
+	}
+}
+
+func TestConsulAsync(t *testing.T) {
+	var (
+		mock    = &mockConsul{services: make(map[string]*consulService)}
+		release = make(chan struct{})
+	)
+	// the unresponsive consul, until released
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		mock.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	var (
+		s   = NewJanitorServer(&config.Janitor{ConsulEnabled: true, ConsulAddr: srv.URL})
+		cmb = &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: "async.default.bbk.dataman"},
+			Backend:  &upstream.Backend{ID: "0.async.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 1},
+		}
+	)
+	go s.consul.run()
+
+	start := time.Now()
+	if err := s.UpsertBackend(cmb); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("UpsertBackend() took %s, want not blocked by consul", elapsed)
+	}
+	defer s.RemoveBackend(cmb)
+
+	close(release)
+	for i := 0; ; i++ {
+		mock.Lock()
+		_, ok := mock.services[cmb.Backend.ID]
+		mock.Unlock()
+		if ok {
+			break
+		}
+		if i == 500 {
+			t.Fatal("backend never registered to consul")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -1,9 +1,14 @@
 package janitor
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -25,65 +30,187 @@ type JanitorServer struct {
 	config       *config.Janitor
 	httpd        *http.Server
 	httpdTLS     *http.Server
+	httpl        *handoffListener                 // listening socket of httpd, nil until started
+	httplTLS     *handoffListener                 // listening socket of httpdTLS, nil until started
 	tcpd         map[string]*proxy.TCPProxyServer // listen -> tcp proxy server
-	sync.RWMutex                                  // protect tcpd
+	udpd         map[string]*proxy.UDPProxyServer // listen -> udp proxy server
+	sync.RWMutex                                  // protect tcpd, udpd, the listeners & the live reloaded configs
+	consul       *consulRegistry                  // nil if consul registration disabled
+	errCh        chan error                       // the serving failures
 }
 
 func NewJanitorServer(cfg *config.Janitor) *JanitorServer {
 	s := &JanitorServer{
 		config: cfg,
 		tcpd:   make(map[string]*proxy.TCPProxyServer),
+		udpd:   make(map[string]*proxy.UDPProxyServer),
+		errCh:  make(chan error, 1),
 	}
 
-	s.httpd = &http.Server{
-		Addr:    s.config.ListenAddr,
+	s.httpd = newHTTPServer(cfg)
+	s.httpdTLS = newHTTPSServer(cfg)
+
+	upstream.SetSlowStart(cfg.SlowStart)
+	proxy.SetPoolOptions(proxy.PoolOptions{
+		MaxIdle:     cfg.PoolMaxIdle,
+		MaxConns:    cfg.PoolMaxConns,
+		IdleTimeout: cfg.PoolIdleTimeout,
+	})
+	proxy.SetAccessLogs(proxy.AccessLogOptions{
+		Default: cfg.AccessLog,
+		Sinks:   cfg.AccessLogSinks,
+	})
+
+	if cfg.ConsulEnabled {
+		s.consul = newConsulRegistry(cfg.ConsulAddr)
+	}
+
+	return s
+}
+
+func newHTTPServer(cfg *config.Janitor) *http.Server {
+	return &http.Server{
+		Addr:    cfg.ListenAddr,
 		Handler: proxy.NewHTTPProxyHandler(cfg.Domain),
 	}
+}
 
-	if s.config.TLSListenAddr != "" {
-		s.httpdTLS = &http.Server{
-			Addr:    s.config.TLSListenAddr,
-			Handler: proxy.NewHTTPProxyHandler(cfg.Domain),
-		}
+// newHTTPSServer returns nil if the tls listen address not set
+func newHTTPSServer(cfg *config.Janitor) *http.Server {
+	if cfg.TLSListenAddr == "" {
+		return nil
 	}
 
-	return s
+	return &http.Server{
+		Addr:      cfg.TLSListenAddr,
+		Handler:   proxy.NewHTTPProxyHandler(cfg.Domain),
+		TLSConfig: proxy.NewTLSConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCertDir),
+	}
 }
 
 func (s *JanitorServer) Start() error {
 	log.Println("agent proxy in serving ...")
 
-	errCh := make(chan error, 2)
+	if s.config.SessionsFile != "" {
+		go s.persistSessions()
+	}
+
+	go reopenAccessLogs()
+
+	if s.consul != nil {
+		go s.consul.run()
+		s.consul.reconcile()
+	}
+
+	s.Lock()
+	err := s.startListeners()
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return <-s.errCh
+}
+
+// reopenAccessLogs reopens the access log files on SIGHUP, eg: after rotated by logrotate
+func reopenAccessLogs() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
 
-	go func() {
-		defer s.httpd.Close()
-		errCh <- s.httpd.ListenAndServe()
-	}()
+	for range ch {
+		log.Println("proxy reopening the access logs")
+		proxy.ReopenAccessLogs()
+	}
+}
 
-	go func() {
-		if s.httpdTLS != nil {
-			defer s.httpdTLS.Close()
-			errCh <- s.httpdTLS.ListenAndServeTLS(s.config.TLSCertFile, s.config.TLSKeyFile)
+// startListeners opens the listening sockets and serves on them,
+// must be called under protection of mutex lock
+func (s *JanitorServer) startListeners() error {
+	l, err := s.listen(s.httpd.Addr)
+	if err != nil {
+		return err
+	}
+	s.httpl = newHandoffListener(l)
+	go s.serve(s.httpd, s.httpl.handoff())
+
+	if s.httpdTLS != nil {
+		l, err := s.listen(s.httpdTLS.Addr)
+		if err != nil {
+			return err
 		}
-	}()
+		s.httplTLS = newHandoffListener(l)
+		go s.serve(s.httpdTLS, s.httplTLS.handoff())
+	}
+
+	return nil
+}
+
+// serve on the listener handed off, the failure is reported unless shut down by draining
+func (s *JanitorServer) serve(srv *http.Server, l net.Listener) {
+	if srv.TLSConfig != nil {
+		l = tls.NewListener(l, srv.TLSConfig) // certificates selected by TLSConfig
+	}
+
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		select {
+		case s.errCh <- err:
+		default: // already failed
+		}
+	}
+}
+
+// listen on the addr, the PROXY protocol header is required on
+// the accepted connections if enabled.
+func (s *JanitorServer) listen(addr string) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.config.ProxyProtocol {
+		l = proxy.NewProxyProtocolListener(l)
+	}
+	return l, nil
+}
+
+// validate verify the backend combined by the janitor's naming policy
+func (s *JanitorServer) validate(cmb *upstream.BackendCombined) error {
+	s.RLock()
+	policy := s.config.NamingPolicy
+	s.RUnlock()
 
-	return <-errCh
+	return cmb.ValidNaming(policy)
 }
 
 func (s *JanitorServer) UpsertBackend(cmb *upstream.BackendCombined) error {
-	if err := cmb.Valid(); err != nil {
+	if err := s.validate(cmb); err != nil {
 		return err
 	}
 
 	cmb.Format()
 
+	if cmb.Upstream.Balance == "" {
+		s.RLock()
+		cmb.Upstream.Balance = s.config.Balance
+		s.RUnlock()
+	}
+
 	log.Printf("proxy upserting upstream backend: %s", cmb)
 
+	// the draining backend takes no new requests, release its idle pooled connections
+	if cmb.Backend.Weight == 0 {
+		proxy.ClosePool(cmb.Backend.Addr())
+	}
+
 	first, err := upstream.UpsertBackend(cmb)
 	if err != nil {
 		return err
 	}
 
+	if s.consul != nil {
+		s.consul.register(cmb)
+	}
+
 	if !first {
 		return nil
 	}
@@ -93,9 +220,25 @@ func (s *JanitorServer) UpsertBackend(cmb *upstream.BackendCombined) error {
 		return nil
 	}
 
-	tcpProxy := proxy.NewTCPProxyServer(l)
+	if cmb.Upstream.Protocol == upstream.ProtocolUDP {
+		udpProxy := proxy.NewUDPProxyServer(l, s.config.UDPMaxSessions)
+		if err := udpProxy.Listen(); err != nil {
+			s.rollback(cmb)
+			return err
+		}
+
+		go udpProxy.Serve()
+
+		s.Lock()
+		s.udpd[l] = udpProxy
+		s.Unlock()
+
+		return nil
+	}
+
+	tcpProxy := proxy.NewTCPProxyServer(l, s.config.ProxyProtocol)
 	if err := tcpProxy.Listen(); err != nil {
-		upstream.RemoveBackend(cmb) // roll back
+		s.rollback(cmb)
 		return err
 	}
 
@@ -108,7 +251,15 @@ func (s *JanitorServer) UpsertBackend(cmb *upstream.BackendCombined) error {
 	return nil
 }
 
-func (s *JanitorServer) removeBackend(cmb *upstream.BackendCombined) {
+// rollback removes the first backend of the upstream failed to listen on
+func (s *JanitorServer) rollback(cmb *upstream.BackendCombined) {
+	upstream.RemoveBackend(cmb)
+	if s.consul != nil {
+		s.consul.deregister(cmb)
+	}
+}
+
+func (s *JanitorServer) RemoveBackend(cmb *upstream.BackendCombined) {
 	log.Printf("proxy removing upstream backend: %s", cmb)
 
 	u := upstream.GetUpstream(cmb.Upstream.Name)
@@ -116,9 +267,17 @@ func (s *JanitorServer) removeBackend(cmb *upstream.BackendCombined) {
 		return
 	}
 
+	if b := upstream.GetBackend(u, cmb.Backend.ID); b != nil {
+		defer proxy.ClosePool(b.Addr())
+	}
+
 	onLast := upstream.RemoveBackend(cmb)
 	stats.Del(cmb.Upstream.Name, cmb.Backend.ID)
 
+	if s.consul != nil {
+		s.consul.deregister(cmb)
+	}
+
 	if !onLast {
 		return
 	}
@@ -133,5 +292,41 @@ func (s *JanitorServer) removeBackend(cmb *upstream.BackendCombined) {
 		tcpProxy.Stop()
 	}
 	delete(s.tcpd, l)
+	if udpProxy, ok := s.udpd[l]; ok {
+		udpProxy.Stop()
+	}
+	delete(s.udpd, l)
 	s.Unlock()
 }
+
+// ReconcileRegistry queues the registration of all of the current upstream backends to consul,
+// eg: once the full sync done, in case any of the changes was lost. noop if consul disabled.
+func (s *JanitorServer) ReconcileRegistry() {
+	if s.consul != nil {
+		s.consul.reconcile()
+	}
+}
+
+// Restore rebuilds the upstreams from the snapshot by upserting the backends one by
+// one, so the balancers, sessions stores and tcp listeners are setup as usual.
+// the existing upstreams not present in the snapshot are kept, the redacted secrets of
+// the exported snapshot are filled back from the registered upstreams.
+func (s *JanitorServer) Restore(ups []*upstream.Upstream) error {
+	for _, u := range ups {
+		if err := u.Unredact(); err != nil {
+			return err
+		}
+	}
+
+	if err := upstream.CheckConflicts(ups); err != nil {
+		return err
+	}
+
+	for _, cmb := range upstream.Flatten(ups) {
+		if err := s.UpsertBackend(cmb); err != nil {
+			return fmt.Errorf("restore backend %s error: %v", cmb.Backend, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package janitor
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+	"github.com/Dataman-Cloud/swan/config"
+)
+
+func TestNamingPolicy(t *testing.T) {
+	var (
+		conforming = &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: "nginx.default.bbk.dataman"},
+			Backend:  &upstream.Backend{ID: "0.nginx.default.bbk.dataman", IP: "192.168.1.101", Port: 31000},
+		}
+		external = &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: "nginx"},
+			Backend:  &upstream.Backend{ID: "i-0a1b2c3d", IP: "192.168.1.102", Port: 31001},
+		}
+		noID = &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: "nginx"},
+			Backend:  &upstream.Backend{IP: "192.168.1.103", Port: 31002},
+		}
+	)
+
+	tests := []struct {
+		name    string
+		policy  string
+		cmb     *upstream.BackendCombined
+		wantErr bool
+	}{
+		{name: "default conforming", policy: "", cmb: conforming},
+		{name: "default non-conforming", policy: "", cmb: external, wantErr: true},
+		{name: "strict conforming", policy: upstream.NamingStrict, cmb: conforming},
+		{name: "strict non-conforming", policy: upstream.NamingStrict, cmb: external, wantErr: true},
+		{name: "relaxed conforming", policy: upstream.NamingRelaxed, cmb: conforming},
+		{name: "relaxed non-conforming", policy: upstream.NamingRelaxed, cmb: external},
+		{name: "relaxed empty id", policy: upstream.NamingRelaxed, cmb: noID, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewJanitorServer(&config.Janitor{NamingPolicy: tt.policy})
+			if err := s.validate(tt.cmb); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUDPListen(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := ":" + strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+	conn.Close()
+
+	var (
+		s   = NewJanitorServer(&config.Janitor{})
+		cmb = &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: "dns.default.bbk.dataman", Listen: listen, Protocol: upstream.ProtocolUDP},
+			Backend:  &upstream.Backend{ID: "0.dns.default.bbk.dataman", IP: "192.168.1.101", Port: 31053, Weight: 1},
+		}
+	)
+
+	if err := s.UpsertBackend(cmb); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	if _, ok := s.udpd[listen]; !ok || len(s.tcpd) != 0 {
+		t.Fatalf("udpd = %v, tcpd = %v, want listened on udp %s", s.udpd, s.tcpd, listen)
+	}
+
+	s.RemoveBackend(cmb)
+	if len(s.udpd) != 0 {
+		t.Errorf("udpd = %v, want stopped on the last backend removed", s.udpd)
+	}
+
+	// the port is released
+	conn, err = net.ListenUDP("udp", &net.UDPAddr{Port: conn.LocalAddr().(*net.UDPAddr).Port})
+	if err != nil {
+		t.Fatalf("listen on the released port error = %v", err)
+	}
+	conn.Close()
+}
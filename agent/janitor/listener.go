@@ -0,0 +1,157 @@
+package janitor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// errHandedOff is returned by the Accept of the replaced server
+var errHandedOff = errors.New("listener handed off")
+
+// handoffListener accepts on the listening socket and hands the connections to the current
+// server, so that a reload could replace the server without closing the socket: the new
+// connections go to the new server once handed off, while the established ones on the
+// previous server finish draining.
+type handoffListener struct {
+	net.Listener // the listening socket
+
+	mu      sync.Mutex
+	cur     *serveListener // the listener of the current server
+	closed  bool
+	started sync.Once // accepting since the first server handed off
+}
+
+func newHandoffListener(l net.Listener) *handoffListener {
+	return &handoffListener{Listener: l}
+}
+
+// handoff returns the listener of the new server, taking over the new connections
+// from the previous one, which should be shut down then.
+func (h *handoffListener) handoff() net.Listener {
+	sl := &serveListener{
+		addr:  h.Addr(),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	h.cur = sl
+	h.mu.Unlock()
+
+	h.started.Do(func() { go h.loop() })
+
+	return sl
+}
+
+func (h *handoffListener) current() *serveListener {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cur
+}
+
+func (h *handoffListener) loop() {
+	for {
+		conn, err := h.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(time.Millisecond * 5)
+				continue
+			}
+			h.mu.Lock()
+			if sl := h.cur; sl != nil && !h.closed {
+				sl.fail(err) // the current server fails, but not the one closed to drain
+			}
+			h.mu.Unlock()
+			return
+		}
+
+		h.dispatch(conn)
+	}
+}
+
+// dispatch the accepted connection to the current server, the connection accepted while
+// handing off goes to the new server.
+func (h *handoffListener) dispatch(conn net.Conn) {
+	for sl := h.current(); sl != nil; {
+		select {
+		case sl.conns <- conn:
+			return
+		case <-sl.done:
+			next := h.current()
+			if next == sl { // closed without handing off
+				conn.Close()
+				return
+			}
+			sl = next
+		}
+	}
+	conn.Close()
+}
+
+// Close closes the listening socket, the current server should be shut down by draining
+func (h *handoffListener) Close() error {
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+
+	return h.Listener.Close()
+}
+
+// serveListener is the listener of one server over the shared listening socket,
+// closing it leaves the socket open.
+type serveListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	err   error // the socket failure, set before done closed
+}
+
+func (sl *serveListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-sl.conns:
+		return conn, nil
+	case <-sl.done:
+		if sl.err != nil {
+			return nil, sl.err
+		}
+		return nil, errHandedOff
+	}
+}
+
+func (sl *serveListener) Close() error {
+	sl.once.Do(func() { close(sl.done) })
+	return nil
+}
+
+func (sl *serveListener) fail(err error) {
+	sl.once.Do(func() {
+		sl.err = err
+		close(sl.done)
+	})
+}
+
+func (sl *serveListener) Addr() net.Addr {
+	return sl.addr
+}
+
+// drain shuts down the replaced server gracefully: no more new connections, the idle ones
+// are closed and the active ones finish within the timeout, then they're closed forcibly.
+// the upgraded or hijacked connections aren't tracked by the server and keep going on.
+func drain(srv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warnf("proxy listener %s draining timeout, closing the remained connections: %v", srv.Addr, err)
+		srv.Close()
+		return
+	}
+	log.Printf("proxy listener %s drained", srv.Addr)
+}
@@ -0,0 +1,102 @@
+package janitor
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHandoffListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		sock    = newHandoffListener(l)
+		addr    = l.Addr().String()
+		arrived = make(chan struct{})
+		release = make(chan struct{})
+	)
+	defer sock.Close()
+
+	server := func(id string) *http.Server {
+		return &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				close(arrived)
+				<-release
+			}
+			w.Write([]byte(id))
+		})}
+	}
+
+	get := func(path string) (string, error) {
+		client := &http.Client{Timeout: time.Second * 5, Transport: &http.Transport{DisableKeepAlives: true}}
+		resp, err := client.Get("http://" + addr + path)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	var (
+		prev     = server("prev")
+		prevDone = make(chan error, 1)
+	)
+	go func() { prevDone <- prev.Serve(sock.handoff()) }()
+
+	if body, err := get("/"); err != nil || body != "prev" {
+		t.Fatalf("request got %q, %v, want served by prev", body, err)
+	}
+
+	// an active connection on prev
+	slow := make(chan string, 1)
+	go func() {
+		body, err := get("/slow")
+		if err != nil {
+			body = err.Error()
+		}
+		slow <- body
+	}()
+	<-arrived
+
+	// hand off the same socket
+	next := server("next")
+	go next.Serve(sock.handoff())
+	defer next.Close()
+
+	drained := make(chan struct{})
+	go func() {
+		drain(prev, time.Second*5)
+		close(drained)
+	}()
+
+	for i := 0; i < 3; i++ {
+		if body, err := get("/"); err != nil || body != "next" {
+			t.Errorf("request after handoff got %q, %v, want served by next", body, err)
+		}
+	}
+
+	close(release)
+	select {
+	case body := <-slow:
+		if body != "prev" {
+			t.Errorf("active request got %q, want finished by prev", body)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("active request not finished")
+	}
+
+	select {
+	case <-drained:
+	case <-time.After(time.Second * 5):
+		t.Fatal("prev not drained")
+	}
+	if err := <-prevDone; err != http.ErrServerClosed {
+		t.Errorf("prev Serve() = %v, want %v", err, http.ErrServerClosed)
+	}
+}
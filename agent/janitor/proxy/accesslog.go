@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var accessLogs = &accessLogSinks{
+	sinks: make(map[string]*accessSink),
+}
+
+// the timeout of dialing & writing to the collectors, the access logging never holds the requests for long
+var collectorTimeout = time.Second
+
+// AccessLogOptions is the setup of the access log sinks, the sink is a file path or the
+// collector address forwarded to: udp://host:port or tcp://host:port.
+type AccessLogOptions struct {
+	Default string            // the shared sink of the apps without a sink of their own, empty to disable
+	Sinks   map[string]string // app id -> the sink of the app
+}
+
+// SetAccessLogs setup the sink of each app and the default one, the opened sinks still in
+// use are kept, the others are closed.
+func SetAccessLogs(opts AccessLogOptions) {
+	accessLogs.Lock()
+	defer accessLogs.Unlock()
+
+	accessLogs.opts = opts
+
+	used := map[string]bool{opts.Default: true}
+	for _, sink := range opts.Sinks {
+		used[sink] = true
+	}
+	for sink, s := range accessLogs.sinks {
+		if !used[sink] {
+			s.retire()
+			delete(accessLogs.sinks, sink)
+		}
+	}
+}
+
+// ReopenAccessLogs closes the opened sinks to be reopened by the next entry, eg: the log
+// files are rotated by renaming, the following entries go to the new files.
+func ReopenAccessLogs() {
+	accessLogs.RLock()
+	defer accessLogs.RUnlock()
+
+	for _, s := range accessLogs.sinks {
+		s.close()
+	}
+}
+
+// accessLogSinks routes the access log entries of each app to its sink
+type accessLogSinks struct {
+	sync.RWMutex
+	opts  AccessLogOptions
+	sinks map[string]*accessSink // sink -> the opened one, shared by the apps of the same sink
+}
+
+func (a *accessLogSinks) enabled() bool {
+	a.RLock()
+	defer a.RUnlock()
+	return a.opts.Default != "" || len(a.opts.Sinks) > 0
+}
+
+// sink returns the sink of the app, or else the default one, nil if neither set
+func (a *accessLogSinks) sink(app string) *accessSink {
+	a.RLock()
+	name, ok := a.opts.Sinks[app]
+	if !ok {
+		name = a.opts.Default
+	}
+	s := a.sinks[name]
+	a.RUnlock()
+
+	if name == "" || s != nil {
+		return s
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	if s = a.sinks[name]; s == nil {
+		s = &accessSink{name: name}
+		a.sinks[name] = s
+	}
+	return s
+}
+
+// accessEntry is one line of the access log
+type accessEntry struct {
+	Time        time.Time     `json:"time"`
+	RequestID   string        `json:"request_id"`
+	Upstream    string        `json:"upstream"`
+	Backend     string        `json:"backend"`
+	Client      string        `json:"client"`
+	Method      string        `json:"method"`
+	Host        string        `json:"host"`
+	URI         string        `json:"uri"`
+	Status      int           `json:"status"` // 0 if unknown, eg: the tunneled upgrade requests
+	Received    int64         `json:"received"`
+	Transmitted int64         `json:"transmitted"`
+	Duration    time.Duration `json:"duration"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// logAccess writes the entry to the sink of its upstream, the failure is logged and
+// never fails the request.
+func logAccess(e *accessEntry) {
+	s := accessLogs.sink(e.Upstream)
+	if s == nil {
+		return
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("encode access log of %s error: %v", e.RequestID, err)
+		return
+	}
+
+	if err := s.write(append(line, '\n')); err != nil {
+		log.Errorf("write access log to %s error: %v", s.name, err)
+	}
+}
+
+// splitSink returns the network and address of the sink, "file" for the file path
+func splitSink(sink string) (string, string) {
+	for _, network := range []string{"udp", "tcp"} {
+		if strings.HasPrefix(sink, network+"://") {
+			return network, strings.TrimPrefix(sink, network+"://")
+		}
+	}
+	return "file", sink
+}
+
+// accessSink is the log file or the collector connection opened on the first entry,
+// and reopened by the next one once closed or failed.
+type accessSink struct {
+	sync.Mutex
+	name    string
+	w       io.WriteCloser // nil until opened
+	retired bool           // no longer in use, never reopened
+}
+
+func (s *accessSink) open() (io.WriteCloser, error) {
+	network, addr := splitSink(s.name)
+	if network == "file" {
+		return os.OpenFile(addr, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	}
+	return net.DialTimeout(network, addr, collectorTimeout)
+}
+
+func (s *accessSink) write(line []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.retired {
+		return nil
+	}
+
+	if s.w == nil {
+		w, err := s.open()
+		if err != nil {
+			return err
+		}
+		s.w = w
+	}
+
+	if conn, ok := s.w.(net.Conn); ok {
+		conn.SetWriteDeadline(time.Now().Add(collectorTimeout))
+	}
+
+	if _, err := s.w.Write(line); err != nil {
+		s.w.Close() // reopened by the next entry
+		s.w = nil
+		return err
+	}
+	return nil
+}
+
+func (s *accessSink) close() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.w != nil {
+		s.w.Close()
+		s.w = nil
+	}
+}
+
+func (s *accessSink) retire() {
+	s.Lock()
+	s.retired = true
+	s.Unlock()
+
+	s.close()
+}
+
+// statusWriter records the response code for the access log
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.code == 0 {
+		sw.code = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.code == 0 {
+		sw.code = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.ResponseWriter does not implement http.Hijacker")
+	}
+	return hj.Hijack()
+}
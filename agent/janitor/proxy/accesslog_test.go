@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+// setupAccessLogUpstreams upserts the upstreams on one backend and returns the proxy url
+func setupAccessLogUpstreams(t *testing.T, names ...string) (string, func()) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("ok"))
+	}))
+
+	var (
+		addr       = backend.Listener.Addr().String()
+		host, port = splitHostPort(addr)
+		cmbs       []*upstream.BackendCombined
+	)
+	for _, name := range names {
+		cmb := &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: name, Alias: name},
+			Backend:  &upstream.Backend{ID: "0." + name, IP: host, Port: port, Scheme: "http", Weight: 100},
+		}
+		if _, err := upstream.UpsertBackend(cmb); err != nil {
+			t.Fatal(err)
+		}
+		cmbs = append(cmbs, cmb)
+	}
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+
+	return srv.URL, func() {
+		srv.Close()
+		for _, cmb := range cmbs {
+			upstream.RemoveBackend(cmb)
+		}
+		ClosePool(addr)
+		backend.Close()
+	}
+}
+
+func doAccessLogRequest(t *testing.T, url, host string) {
+	req, _ := http.NewRequest("GET", url+"/index.html?from=test", nil)
+	req.Host = host
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error = %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+}
+
+// readAccessLog waits for the entries written, which are written after the responses sent
+func readAccessLog(t *testing.T, file string, want int) []*accessEntry {
+	var data []byte
+	for deadline := time.Now().Add(time.Second * 3); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		data, _ = ioutil.ReadFile(file)
+		if strings.Count(string(data), "\n") >= want {
+			break
+		}
+	}
+
+	var entries []*accessEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		e := new(accessEntry)
+		if err := json.Unmarshal([]byte(line), e); err != nil {
+			t.Fatalf("decode access log line %q error = %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestAccessLogSinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	collector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+
+	var (
+		appA      = "a.default.bbk.dataman"
+		appB      = "b.default.bbk.dataman"
+		appC      = "c.default.bbk.dataman" // without a sink of its own
+		fileA     = filepath.Join(dir, "a.log")
+		fileShare = filepath.Join(dir, "access.log")
+	)
+
+	url, cleanup := setupAccessLogUpstreams(t, appA, appB, appC)
+	defer cleanup()
+
+	SetAccessLogs(AccessLogOptions{
+		Default: fileShare,
+		Sinks: map[string]string{
+			appA: fileA,
+			appB: "udp://" + collector.LocalAddr().String(),
+		},
+	})
+	defer SetAccessLogs(AccessLogOptions{})
+
+	doAccessLogRequest(t, url, appA)
+	doAccessLogRequest(t, url, appA)
+	doAccessLogRequest(t, url, appB)
+	doAccessLogRequest(t, url, appC)
+
+	tests := []struct {
+		name    string
+		entries func() []*accessEntry
+		app     string
+		want    int
+	}{
+		{name: "file", entries: func() []*accessEntry { return readAccessLog(t, fileA, 2) }, app: appA, want: 2},
+		{name: "collector", app: appB, want: 1, entries: func() []*accessEntry {
+			collector.SetReadDeadline(time.Now().Add(time.Second * 3))
+			buf := make([]byte, 4096)
+			n, _, err := collector.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("collector read error = %v", err)
+			}
+			e := new(accessEntry)
+			if err := json.Unmarshal(buf[:n], e); err != nil {
+				t.Fatalf("decode collected %q error = %v", buf[:n], err)
+			}
+			return []*accessEntry{e}
+		}},
+		{name: "default", entries: func() []*accessEntry { return readAccessLog(t, fileShare, 1) }, app: appC, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := tt.entries()
+			if len(entries) != tt.want {
+				t.Fatalf("got %d entries, want %d", len(entries), tt.want)
+			}
+			for _, e := range entries {
+				if e.Upstream != tt.app || e.Backend != "0."+tt.app {
+					t.Errorf("entry of %s/%s, want %s", e.Upstream, e.Backend, tt.app)
+				}
+				if e.Host != tt.app || e.Method != "GET" || e.URI != "/index.html?from=test" {
+					t.Errorf("entry request %s %s %s, want GET %s /index.html?from=test", e.Method, e.Host, e.URI, tt.app)
+				}
+				if e.Status != http.StatusAccepted || e.Transmitted == 0 || e.RequestID == "" {
+					t.Errorf("entry = %+v, want status 202, transmitted bytes and request id", e)
+				}
+			}
+		})
+	}
+}
+
+func TestReopenAccessLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		name    = "rotate.default.bbk.dataman"
+		file    = filepath.Join(dir, "access.log")
+		rotated = file + ".1"
+	)
+
+	url, cleanup := setupAccessLogUpstreams(t, name)
+	defer cleanup()
+
+	SetAccessLogs(AccessLogOptions{Default: file})
+	defer SetAccessLogs(AccessLogOptions{})
+
+	doAccessLogRequest(t, url, name)
+	readAccessLog(t, file, 1)
+
+	// rotated by renaming, the entries go to the renamed one until reopened
+	if err := os.Rename(file, rotated); err != nil {
+		t.Fatal(err)
+	}
+	doAccessLogRequest(t, url, name)
+	if got := readAccessLog(t, rotated, 2); len(got) != 2 {
+		t.Errorf("rotated file got %d entries, want 2", len(got))
+	}
+
+	ReopenAccessLogs()
+	doAccessLogRequest(t, url, name)
+
+	if got := readAccessLog(t, file, 1); len(got) != 1 {
+		t.Errorf("reopened file got %d entries, want 1", len(got))
+	}
+}
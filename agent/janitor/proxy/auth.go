@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+var (
+	// dummyBcrypt is compared against by the unknown users if any of the users hashed by bcrypt
+	dummyBcrypt     []byte
+	dummyBcryptOnce sync.Once
+)
+
+// authenticate verify the basic auth credentials carried by the request,
+// always pass if the path is exempted.
+func authenticate(r *http.Request, cfg *upstream.BasicAuth) bool {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	for _, p := range cfg.ExemptPaths {
+		if path == strings.TrimSuffix(p, "/") {
+			return true
+		}
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	hash, found := cfg.Users[user]
+	if !found {
+		hash = dummyHash(cfg) // still hash the password to not leak the user existence by timing
+	}
+
+	return matchPassword(hash, pass) && found
+}
+
+// matchPassword compares the password against the htpasswd style hash in constant time,
+// supported schemes: bcrypt $2a$/$2b$/$2y$ (htpasswd -B), {SHA256}base64, {SHA}base64 (htpasswd -s)
+func matchPassword(hash, pass string) bool {
+	if isBcrypt(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	}
+
+	var sum []byte
+	switch {
+	case strings.HasPrefix(hash, "{SHA256}"):
+		s := sha256.Sum256([]byte(pass))
+		sum, hash = s[:], strings.TrimPrefix(hash, "{SHA256}")
+	case strings.HasPrefix(hash, "{SHA}"):
+		s := sha1.Sum([]byte(pass))
+		sum, hash = s[:], strings.TrimPrefix(hash, "{SHA}")
+	default:
+		return false
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	return subtle.ConstantTimeCompare([]byte(encoded), []byte(hash)) == 1
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// dummyHash returns the hash compared against by the unknown users, which costs about the same
+// as the known ones: bcrypt if any of the users hashed by bcrypt, otherwise sha256.
+func dummyHash(cfg *upstream.BasicAuth) string {
+	for _, hash := range cfg.Users {
+		if isBcrypt(hash) {
+			dummyBcryptOnce.Do(func() {
+				dummyBcrypt, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+			})
+			return string(dummyBcrypt)
+		}
+	}
+	return "{SHA256}"
+}
+
+// challenge the client to provide the basic auth credentials
+func challenge(w http.ResponseWriter, cfg *upstream.BasicAuth) {
+	realm := cfg.Realm
+	if realm == "" {
+		realm = "swan"
+	}
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
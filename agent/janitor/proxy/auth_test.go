@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+func TestBasicAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "proxied")
+	}))
+	defer backend.Close()
+
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	cmb := &upstream.BackendCombined{
+		Upstream: &upstream.Upstream{
+			Name:  "legacy.example.com",
+			Alias: "legacy.example.com",
+			BasicAuth: &upstream.BasicAuth{
+				Realm: "legacy",
+				Users: map[string]string{
+					"admin": "{SHA256}HsHCa1DV08WNlYMYGvgHZlX+AHVr9yhZQLo2cPmfy6A=",         // s3cret
+					"old":   "{SHA}mzMEbtOdGC462vqQRa1nh9S7wyE=",                            // legacy
+					"ops":   "$2a$05$GImM0ZHsOmb6sEma/OY4AOCDItGqMEXjum1cgK5jbSlTKgkpYB.S2", // t0ps3cret
+					"web":   "$2y$05$ousIGSGlYO9gaNZrzl0y0ORhjwSgCuKvFSRojhnDG8eJOaUXS.myi", // htpass, by htpasswd -B
+				},
+				ExemptPaths: []string{"/health"},
+			},
+		},
+		Backend: &upstream.Backend{ID: "0.legacy", IP: host, Port: uint64(p), Scheme: "http", Weight: 100},
+	}
+	if _, err := upstream.UpsertBackend(cmb); err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.RemoveBackend(cmb)
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	tests := []struct {
+		name     string
+		path     string
+		user     string
+		pass     string
+		wantCode int
+	}{
+		{name: "missing", path: "/", wantCode: 401},
+		{name: "wrong password", path: "/", user: "admin", pass: "guess", wantCode: 401},
+		{name: "unknown user", path: "/", user: "nobody", pass: "s3cret", wantCode: 401},
+		{name: "correct sha256", path: "/", user: "admin", pass: "s3cret", wantCode: 200},
+		{name: "correct sha1", path: "/", user: "old", pass: "legacy", wantCode: 200},
+		{name: "correct bcrypt", path: "/", user: "ops", pass: "t0ps3cret", wantCode: 200},
+		{name: "wrong bcrypt password", path: "/", user: "ops", pass: "t0ps3cre", wantCode: 401},
+		{name: "correct bcrypt 2y", path: "/", user: "web", pass: "htpass", wantCode: 200},
+		{name: "wrong bcrypt 2y password", path: "/", user: "web", pass: "HTPASS", wantCode: 401},
+		{name: "exempt path", path: "/health/", wantCode: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", srv.URL+tt.path, nil)
+			req.Host = "legacy.example.com"
+			if tt.user != "" {
+				req.SetBasicAuth(tt.user, tt.pass)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status code = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+
+			if tt.wantCode == 401 {
+				if got, want := resp.Header.Get("WWW-Authenticate"), `Basic realm="legacy"`; got != want {
+					t.Errorf("WWW-Authenticate = %s, want %s", got, want)
+				}
+				return
+			}
+
+			if body, _ := ioutil.ReadAll(resp.Body); string(body) != "proxied" {
+				t.Errorf("body = %s, want proxied", body)
+			}
+		})
+	}
+}
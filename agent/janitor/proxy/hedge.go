@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/stats"
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+// hedged reports whether the request could be hedged, which is the idempotent one without
+// body proxied through the pooled connections, on the upstream with the hedging enabled.
+func hedged(r *http.Request, u *upstream.Upstream) bool {
+	if u.Hedge == nil || !pooled(r, u) {
+		return false
+	}
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+	default:
+		return false
+	}
+	return r.ContentLength == 0
+}
+
+// hedgeDelay is the percentile of the upstream's response time, but no less than the min delay
+func hedgeDelay(u *upstream.Upstream) time.Duration {
+	d := stats.Percentile(u.Name, stats.LatencyFirstByte, u.Hedge.Quantile())
+	if min := u.Hedge.MinDelay(); d < min {
+		return min
+	}
+	return d
+}
+
+// hedgeAttempt is one of the raced requests of the hedged proxying
+type hedgeAttempt struct {
+	cmb      *upstream.BackendCombined
+	in       int64
+	start    time.Time
+	resp     *http.Response
+	release  func() // closes the response
+	rt       time.Duration
+	err      error
+	cancel   context.CancelFunc
+	returned bool   // the round trip returned
+	canceled bool   // canceled as the loser
+	done     func() // releases the hedge slot, nil for the first request
+}
+
+// finish accounts the attempt to its backend, the canceled one is not a failure of the backend
+func (a *hedgeAttempt) finish(out int64) {
+	var (
+		ups = a.cmb.Upstream.Name
+		bid = a.cmb.Backend.ID
+	)
+
+	a.cancel()
+	if a.done != nil {
+		a.done()
+	}
+
+	// the canceled one is slower than the winner at least
+	if a.canceled {
+		upstream.Done(a.cmb, time.Since(a.start))
+		stats.Incr(&stats.DeltaBackend{Uid: ups, Bid: bid, Ac: -1, Rx: uint64(a.in)}, nil) // disconnect
+		return
+	}
+
+	if _, ok := a.err.(*connectError); ok {
+		upstream.Eject(a.cmb, ejectDuration)
+	}
+	upstream.Done(a.cmb, a.rt)
+	upstream.Report(a.cmb, a.rt >= 0)
+	stats.Incr(&stats.DeltaBackend{Uid: ups, Bid: bid, Ac: -1, Rx: uint64(a.in), Tx: uint64(out), Rt: a.rt}, nil) // disconnect
+	stats.Observe(ups, stats.LatencyFirstByte, a.rt)
+}
+
+// doHedgedProxy proxies the request to the selected backend, and hedges it to another backend
+// if not responded within the hedge delay. the first response is copied to the client and the
+// other request is canceled, each of the backends is accounted for its own request. it returns
+// the received & transmitted bytes and the backend responded.
+func (p *HTTPProxy) doHedgedProxy(w http.ResponseWriter, req *http.Request, selected *upstream.BackendCombined) (int64, int64, *upstream.BackendCombined, error) {
+	var (
+		u        = selected.Upstream
+		results  = make(chan *hedgeAttempt, 2)
+		attempts []*hedgeAttempt
+	)
+
+	launch := func(cmb *upstream.BackendCombined, r *http.Request) *hedgeAttempt {
+		ctx, cancel := context.WithCancel(r.Context())
+		a := &hedgeAttempt{cmb: cmb, in: httpRequestLen(r), start: time.Now(), cancel: cancel}
+		attempts = append(attempts, a)
+
+		stats.Incr(&stats.DeltaBackend{Uid: u.Name, Bid: cmb.Backend.ID, Ac: 1, Req: 1}, nil) // conn, active
+		upstream.Begin(cmb)
+
+		go func() {
+			a.resp, a.release, a.rt, a.err = p.roundTrip(r.WithContext(ctx), cmb.Backend.Scheme, cmb.Backend.Addr(), u)
+			results <- a
+		}()
+		return a
+	}
+
+	launch(selected, req)
+
+	timer := time.NewTimer(hedgeDelay(u))
+	defer timer.Stop()
+
+	var (
+		winner, failed *hedgeAttempt
+		running        = 1
+	)
+	for running > 0 && winner == nil {
+		select {
+		case a := <-results:
+			running--
+			a.returned = true
+			if a.err == nil {
+				winner = a
+				break
+			}
+			a.finish(0)
+			if failed == nil {
+				failed = a
+			}
+		case <-timer.C:
+			if p.hedge(req, selected, launch) {
+				running++
+			}
+		}
+	}
+
+	// cancel the losers, which are accounted once returned
+	if running > 0 {
+		for _, a := range attempts {
+			if !a.returned {
+				a.canceled = true
+				a.cancel()
+			}
+		}
+		go func(n int) {
+			for ; n > 0; n-- {
+				a := <-results
+				if a.err == nil {
+					a.release()
+				}
+				a.finish(0)
+			}
+		}(running)
+	}
+
+	in := httpRequestLen(req)
+	if winner == nil {
+		proxyError(w, u, failed.err.Error(), 500)
+		return in, 0, failed.cmb, failed.err
+	}
+
+	out, err := writeResponse(w, winner.resp, winner.cmb.Addr(), u)
+	winner.release()
+	winner.finish(out)
+	return in, out, winner.cmb, err
+}
+
+// hedge launches the hedged request to another backend than the selected one, false if all of
+// the hedge slots taken or no other backend. the backends not detected the scheme yet are skipped.
+func (p *HTTPProxy) hedge(req *http.Request, selected *upstream.BackendCombined, launch func(*upstream.BackendCombined, *http.Request) *hedgeAttempt) bool {
+	u := selected.Upstream
+
+	release, ok := upstream.AcquireHedge(u)
+	if !ok {
+		return false
+	}
+
+	ip, _, _ := net.SplitHostPort(req.RemoteAddr)
+	cmb, err := upstream.LookupHedge(&upstream.Client{IP: ip, Header: req.Header}, selected)
+	if err != nil || cmb.Backend.Scheme == "" {
+		release()
+		return false
+	}
+
+	r := req
+	if u.HostHeader == upstream.HostBackend {
+		r = new(http.Request)
+		*r = *req
+		r.Host = cmb.Backend.Addr()
+	}
+
+	launch(cmb, r).done = release
+	return true
+}
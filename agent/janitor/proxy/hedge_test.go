@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+func TestHedgedRequests(t *testing.T) {
+	canceled := make(chan struct{}, 8)
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			canceled <- struct{}{}
+		case <-time.After(time.Second * 3):
+			w.Write([]byte("slow"))
+		}
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	var (
+		name = "hedge.default.bbk.dataman"
+		ups  = &upstream.Upstream{Name: name, Alias: name, Hedge: &upstream.Hedge{Delay: time.Millisecond * 20}}
+		cmbs []*upstream.BackendCombined
+	)
+	for id, srv := range map[string]*httptest.Server{"slow": slow, "fast": fast} {
+		host, port := splitHostPort(srv.Listener.Addr().String())
+		cmb := &upstream.BackendCombined{
+			Upstream: ups,
+			Backend:  &upstream.Backend{ID: id + "." + name, IP: host, Port: port, Scheme: "http", Weight: 100},
+		}
+		if _, err := upstream.UpsertBackend(cmb); err != nil {
+			t.Fatal(err)
+		}
+		cmbs = append(cmbs, cmb)
+	}
+	defer func() {
+		for _, cmb := range cmbs {
+			upstream.RemoveBackend(cmb)
+			ClosePool(cmb.Backend.Addr())
+		}
+	}()
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+
+	// the balancer takes turns, the slow one is the first selected at least once
+	for i := 0; i < 4; i++ {
+		req, _ := http.NewRequest("GET", srv.URL+"/", nil)
+		req.Host = name
+
+		start := time.Now()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request error = %v", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(body) != "fast" {
+			t.Errorf("request %d got %q, want the faster response", i, body)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("request %d took %s, want hedged", i, elapsed)
+		}
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Error("the slow request never canceled")
+	}
+}
+
+func TestHedged(t *testing.T) {
+	var (
+		hedging = &upstream.Upstream{Hedge: &upstream.Hedge{}}
+		plain   = &upstream.Upstream{}
+	)
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		header string
+		u      *upstream.Upstream
+		want   bool
+	}{
+		{name: "get", method: "GET", u: hedging, want: true},
+		{name: "head", method: "HEAD", u: hedging, want: true},
+		{name: "disabled", method: "GET", u: plain, want: false},
+		{name: "post", method: "POST", body: "x", u: hedging, want: false},
+		{name: "get with body", method: "GET", body: "x", u: hedging, want: false},
+		{name: "upgrade", method: "GET", header: "websocket", u: hedging, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			if tt.header != "" {
+				r.Header.Set("Upgrade", tt.header)
+			}
+			if got := hedged(r, tt.u); got != tt.want {
+				t.Errorf("hedged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net"
 	"net/http"
 	"strings"
@@ -14,8 +15,24 @@ import (
 
 	"github.com/Dataman-Cloud/swan/agent/janitor/stats"
 	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+	"github.com/Dataman-Cloud/swan/utils"
 )
 
+var (
+	// the backend is ejected for a while on connecting failure
+	ejectDuration = time.Second * 10
+)
+
+// connectError is returned by doRawProxy on connecting to backend failure
+type connectError struct {
+	addr string
+	err  error
+}
+
+func (e *connectError) Error() string {
+	return fmt.Sprintf("cannot connect to upstream %s: %v", e.addr, e.err)
+}
+
 // generic http proxy handler
 type HTTPProxy struct {
 	suffix string
@@ -27,30 +44,35 @@ func NewHTTPProxyHandler(domain string) http.Handler {
 	}
 }
 
-// lookup a proper backend according by request
-func (p *HTTPProxy) lookup(r *http.Request) (*upstream.BackendCombined, error) {
-	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return nil, fmt.Errorf("request RemoteAddr [%s] unrecognized", r.RemoteAddr)
-	}
-
+// resolve the upstream and the specified backend (optional) according by request
+func (p *HTTPProxy) resolve(r *http.Request) (*upstream.Upstream, string, error) {
 	if len(r.Host) == 0 {
-		return nil, errors.New("request Host empty")
+		return nil, "", errors.New("request Host empty")
 	}
 
 	var (
-		split    = strings.Split(r.Host, ":")
-		host     = split[0]
-		port     = split[1]
-		byAlias  bool // flag on looking up by upstream alias or not
-		selected *upstream.BackendCombined
+		split   = strings.Split(r.Host, ":")
+		host    = split[0]
+		port    string
+		byAlias bool // flag on looking up by upstream alias or not
+		u       *upstream.Upstream
+		backend string
 	)
+	if len(split) > 1 {
+		port = split[1]
+	}
+
+	// route by the SNI server name for the requests on tls listener
+	if r.TLS != nil && r.TLS.ServerName != "" {
+		host = r.TLS.ServerName
+	}
+
 	if !strings.HasSuffix(host, p.suffix) {
 		byAlias = true
 	}
 
 	if byAlias {
-		selected = upstream.LookupAlias(remoteIP, host)
+		u = upstream.GetUpstreamByAlias(host)
 
 	} else {
 		trimed := strings.TrimSuffix(host, p.suffix)
@@ -59,18 +81,55 @@ func (p *HTTPProxy) lookup(r *http.Request) (*upstream.BackendCombined, error) {
 		switch len(ss) {
 		case 3: // upstream
 			ups := trimed
-			selected = upstream.LookupUpstream(remoteIP, ups, port, "")
+			u = upstream.GetUpstreamByTarget(ups, port)
 		case 4: // specified backend
 			ups := fmt.Sprintf("%s.%s.%s.%s", ss[1], ss[2], ss[3], ss[4])
-			backend := trimed
-			selected = upstream.LookupUpstream(remoteIP, ups, port, backend)
+			backend = trimed
+			u = upstream.GetUpstreamByTarget(ups, port)
 		default:
-			return nil, fmt.Errorf("request Host [%s] invalid", host)
+			return nil, "", fmt.Errorf("request Host [%s] invalid", host)
+		}
+	}
+
+	if u == nil {
+		return nil, "", fmt.Errorf("no matched backends for request [%s]", host)
+	}
+
+	return u, backend, nil
+}
+
+// lookup a proper backend of the resolved upstream according by request, the affinity
+// cookie is issued to the client pinned by the new session if the sticky cookie set.
+func (p *HTTPProxy) lookup(w http.ResponseWriter, r *http.Request, u *upstream.Upstream, backend string) (*upstream.BackendCombined, error) {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("request RemoteAddr [%s] unrecognized", r.RemoteAddr)
+	}
+
+	var (
+		client = &upstream.Client{IP: remoteIP, Header: r.Header}
+		sc     = u.StickyCookie
+		issue  bool
+	)
+	if u.Sticky && sc != nil {
+		if c, err := r.Cookie(sc.CookieName()); err == nil && c.Value != "" && len(c.Value) <= 128 {
+			client.Cookie = c.Value
+		} else {
+			client.Cookie, issue = utils.NewUUID(), true
 		}
 	}
 
+	selected, err := upstream.Lookup(client, u, backend)
+	if err != nil {
+		return nil, err
+	}
+
 	if selected == nil {
-		return nil, fmt.Errorf("no matched backends for request [%s]", host)
+		return nil, fmt.Errorf("no matched backends for request [%s]", r.Host)
+	}
+
+	if issue {
+		w.Header().Add("Set-Cookie", sc.SetCookie(client.Cookie))
 	}
 
 	log.Debugf("[HTTP] proxy redirecting request [%s] -> [%s-%s] -> [%s-%s]",
@@ -80,39 +139,130 @@ func (p *HTTPProxy) lookup(r *http.Request) (*upstream.BackendCombined, error) {
 	return selected, nil
 }
 
+// redirect the plain http request to https
+func redirectHTTPS(w http.ResponseWriter, r *http.Request, rd *upstream.Redirect) {
+	var (
+		host = strings.Split(r.Host, ":")[0]
+		code = rd.Code
+	)
+
+	if rd.Port != 0 && rd.Port != 443 {
+		host = fmt.Sprintf("%s:%d", host, rd.Port)
+	}
+
+	if code == 0 {
+		code = http.StatusMovedPermanently
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+}
+
 // implements http.Handler interface
 func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var (
-		err  error
-		in   int64           // received bytes
-		out  int64           // transmitted bytes
-		dGlb *stats.DeltaGlb // delta global
+		err      error
+		in       int64           // received bytes
+		out      int64           // transmitted bytes
+		dGlb     *stats.DeltaGlb // delta global
+		u        *upstream.Upstream
+		selected *upstream.BackendCombined
+		start    = time.Now()
+		host     = r.Host      // the client's one, before overridden by the upstream's host_header
+		sw       *statusWriter // records the response code if access logging enabled
 	)
 
+	// tag the request id, which is forwarded to the backend
+	r, reqID := utils.TagRequestID(r)
+	w.Header().Set(utils.RequestIDHeader, reqID)
+
+	if accessLogs.enabled() {
+		sw = &statusWriter{ResponseWriter: w}
+		w = sw
+	}
+
 	defer func() {
 		if err != nil {
-			log.Errorf("[HTTP] proxy serve error: %v, received:%d, transmitted:%d", err, in, out)
+			log.Errorf("[HTTP] [%s] proxy serve error: %v, received:%d, transmitted:%d", reqID, err, in, out)
 			dGlb = &stats.DeltaGlb{uint64(in), uint64(out), 1, 1}
 		} else {
-			log.Printf("[HTTP] proxy serve succeed: received:%d, transmitted:%d", in, out)
+			log.Printf("[HTTP] [%s] proxy serve succeed: received:%d, transmitted:%d", reqID, in, out)
 			dGlb = &stats.DeltaGlb{uint64(in), uint64(out), 1, 0}
 		}
 		stats.Incr(nil, dGlb)
+
+		if sw != nil {
+			e := &accessEntry{
+				Time:        start,
+				RequestID:   reqID,
+				Client:      r.RemoteAddr,
+				Method:      r.Method,
+				Host:        host,
+				URI:         r.RequestURI,
+				Status:      sw.code,
+				Received:    in,
+				Transmitted: out,
+				Duration:    time.Since(start),
+			}
+			if u != nil {
+				e.Upstream = u.Name
+			}
+			if selected != nil {
+				e.Backend = selected.Backend.ID
+			}
+			if err != nil {
+				e.Error = err.Error()
+			}
+			logAccess(e)
+		}
 	}()
 
-	// lookup a proper backend according by request
-	selected, err := p.lookup(r)
+	// resolve the upstream according by request
+	u, specified, err := p.resolve(r)
 	if err != nil {
 		http.Error(w, err.Error(), 404)
 		return
 	}
 
+	// redirect the plain http requests to https if required
+	if r.TLS == nil && u.Redirect != nil {
+		redirectHTTPS(w, r, u.Redirect)
+		return
+	}
+
+	// enforce the basic auth before looking up any backends
+	if u.BasicAuth != nil && !authenticate(r, u.BasicAuth) {
+		challenge(w, u.BasicAuth)
+		return
+	}
+
+	// verify the jwt bearer token and forward the selected claims
+	if u.JWT != nil {
+		claims, err := authorizeJWT(r, u.JWT)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=\"invalid_token\", error_description=%q", err.Error()))
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		forwardClaims(r, claims, u.JWT.ForwardClaims)
+	}
+
+	// lookup a proper backend according by request
+	selected, err = p.lookup(w, r, u, specified)
+	if err != nil {
+		code := 404
+		if err == upstream.ErrNoHealthyBackends || err == upstream.ErrRateLimited {
+			code = 503
+		}
+		proxyError(w, u, err.Error(), code)
+		return
+	}
+
 	// detect & update backend scheme
 	if selected.Backend.Scheme == "" {
-		https, err := detectHTTPs(selected.Addr())
+		https, err := detectHTTPs(selected.Addr(), selected.Upstream.DialTimeout())
 		if err != nil {
 			err = fmt.Errorf("detect selected scheme error: %v", err)
-			http.Error(w, err.Error(), 500)
+			proxyError(w, u, err.Error(), 500)
 			return
 		}
 
@@ -132,55 +282,340 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		backend = selected.Backend.ID
 	)
 
+	// wait for an in-flight slot of the upstream if limited
+	release, err := upstream.Acquire(r.Context(), selected)
+	if err != nil {
+		proxyError(w, u, err.Error(), 503)
+		return
+	}
+	defer release()
+
+	rewriteHost(r, selected.Upstream, addr)
+	selected.Upstream.RequestHeaders.Apply(r.Header)
+
+	// race the slow idempotent request with the hedged one to another backend
+	if hedged(r, selected.Upstream) {
+		in, out, selected, err = p.doHedgedProxy(w, r, selected)
+		return
+	}
+
+	// do proxy
+	var rt time.Duration
+	stats.Incr(&stats.DeltaBackend{ups, backend, 1, 0, 0, 1, 0}, nil) // conn, active
+	upstream.Begin(selected)
+	if pooled(r, selected.Upstream) {
+		in, out, rt, err = p.doPooledProxy(w, r, sche, addr, selected.Upstream)
+	} else {
+		in, out, rt, err = p.doHijackedProxy(w, r, sche, addr, selected.Upstream)
+	}
+	if _, ok := err.(*connectError); ok {
+		upstream.Eject(selected, ejectDuration)
+	}
+	upstream.Done(selected, rt)
+	upstream.Report(selected, rt >= 0)
+	stats.Incr(&stats.DeltaBackend{ups, backend, -1, uint64(in), uint64(out), 0, rt}, nil) // disconnect
+	stats.Observe(ups, stats.LatencyFirstByte, rt)
+}
+
+// rewriteHost overrides the Host header toward the backend as the upstream's host_header if set,
+// the client's one is kept by X-Forwarded-Host.
+func rewriteHost(r *http.Request, u *upstream.Upstream, addr string) {
+	host := u.HostHeader
+	if host == "" {
+		return
+	}
+	if host == upstream.HostBackend {
+		host = addr
+	}
+
+	if r.Header.Get("X-Forwarded-Host") == "" {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+	}
+	r.Host = host
+}
+
+// proxyError replies the proxy failure by the upstream's custom error page of the code,
+// or else the plain error message.
+func proxyError(w http.ResponseWriter, u *upstream.Upstream, msg string, code int) {
+	page := u.ErrorPages.Get(code)
+	if page == nil {
+		http.Error(w, msg, code)
+		return
+	}
+
+	w.Header().Set("Content-Type", page.Type())
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	io.WriteString(w, page.Body)
+}
+
+// rawError is similar as proxyError, but writes the 500 response on the hijacked connection
+func rawError(conn net.Conn, u *upstream.Upstream, msg string) {
+	page := u.ErrorPages.Get(500)
+	if page == nil {
+		conn.Write([]byte("HTTP/1.0 500 Internal Server Error\r\n\r\n" + msg + "\r\n"))
+		return
+	}
+
+	fmt.Fprintf(conn, "HTTP/1.0 500 Internal Server Error\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s",
+		page.Type(), len(page.Body), page.Body)
+}
+
+// pooled reports whether the request could be proxied through the pooled backend
+// connections, the upgrade requests (eg: websocket) and the backends requiring the
+// PROXY header per client connection are proxied by the hijacked connection.
+func pooled(r *http.Request, u *upstream.Upstream) bool {
+	if !pools.enabled() || u.SendProxy != "" {
+		return false
+	}
+	if r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	for _, v := range r.Header["Connection"] {
+		if strings.Contains(strings.ToLower(v), "upgrade") {
+			return false
+		}
+	}
+	return true
+}
+
+// hop-by-hop headers, which should not be forwarded, see RFC 2616 section 13.5.1
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				h.Del(f)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+// doPooledProxy forwards the request through the keep-alive connection pool of the backend,
+// the response time is measured as the time to the response header received.
+func (p *HTTPProxy) doPooledProxy(w http.ResponseWriter, req *http.Request, sche, addr string, u *upstream.Upstream) (int64, int64, time.Duration, error) {
+	in := httpRequestLen(req)
+
+	resp, release, rt, err := p.roundTrip(req, sche, addr, u)
+	if err != nil {
+		proxyError(w, u, err.Error(), 500)
+		return in, 0, rt, err
+	}
+	defer release()
+
+	out, err := writeResponse(w, resp, addr, u)
+	return in, out, rt, err
+}
+
+// roundTrip sends the request through the connection pool of the backend and returns the
+// response with the time to the response header received, the returned release func closes
+// the response body and must be called once the response consumed.
+func (p *HTTPProxy) roundTrip(req *http.Request, sche, addr string, u *upstream.Upstream) (*http.Response, func(), time.Duration, error) {
+	pool := pools.get(addr, u.TLS, u.DialTimeout())
+
+	if err := pool.acquire(req.Context()); err != nil {
+		return nil, nil, -1, fmt.Errorf("waiting for connection to %s error: %v", addr, err)
+	}
+
+	target := *req.URL
+	target.Scheme, target.Host = sche, addr
+
+	outreq := req.WithContext(req.Context())
+	outreq.URL = &target
+	outreq.RequestURI = ""
+	outreq.Close = false
+	if req.ContentLength == 0 {
+		outreq.Body = nil
+	}
+
+	reqThrottle, _ := upstream.Throttles(u)
+	if outreq.Body != nil && reqThrottle != nil {
+		outreq.Body = &throttledBody{reqThrottle.Reader(outreq.Body), outreq.Body}
+	}
+
+	outreq.Header = make(http.Header, len(req.Header))
+	for k, vs := range req.Header {
+		outreq.Header[k] = append([]string(nil), vs...)
+	}
+	removeHopHeaders(outreq.Header)
+
+	// append the client ip to the forwarding header
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior := outreq.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+		outreq.Header.Set("X-Forwarded-For", ip)
+	}
+
+	start := time.Now()
+	resp, err := pool.transport.RoundTrip(outreq)
+	if err != nil {
+		pool.release()
+		if _, ok := err.(*connectError); !ok {
+			err = fmt.Errorf("proxying request to %s error: %v", addr, err)
+		}
+		return nil, nil, -1, err
+	}
+
+	release := func() {
+		resp.Body.Close()
+		pool.release()
+	}
+	return resp, release, time.Since(start), nil
+}
+
+// writeResponse copies the backend's response to the client, returns the transmitted bytes
+func writeResponse(w http.ResponseWriter, resp *http.Response, addr string, u *upstream.Upstream) (int64, error) {
+	var out int64
+
+	removeHopHeaders(resp.Header)
+	resp.Header.Del(utils.RequestIDHeader) // already responded by the proxy
+	u.ResponseHeaders.Apply(resp.Header)
+	for k, vs := range resp.Header {
+		out += int64(len(k) + 3)
+		for _, v := range vs {
+			w.Header().Add(k, v)
+			out += int64(len(v))
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	_, respThrottle := upstream.Throttles(u)
+
+	var dst io.Writer = w
+	if f, ok := w.(http.Flusher); ok && streaming(resp, u) {
+		f.Flush() // the headers go first, eg: the event stream opened
+		dst = &flushWriter{w, f}
+	}
+	dst = respThrottle.Writer(dst) // each throttled piece is flushed if streaming
+
+	n, err := io.Copy(dst, resp.Body)
+	out += n
+	if err != nil {
+		return out, fmt.Errorf("copying response from %s error: %v", addr, err)
+	}
+	return out, nil
+}
+
+// streaming reports whether the response should be flushed to the client incrementally rather
+// than buffered, which is the event stream, or without the content length, eg: chunked, or forced
+// by the upstream.
+func streaming(resp *http.Response, u *upstream.Upstream) bool {
+	if u.Streaming || resp.ContentLength < 0 {
+		return true
+	}
+	typ, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return typ == "text/event-stream"
+}
+
+// flushWriter flushes each of the writes to the client
+type flushWriter struct {
+	w io.Writer
+	f http.Flusher
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.f.Flush()
+	return n, err
+}
+
+// throttledBody is the request body read by the bandwidth throttle
+type throttledBody struct {
+	io.Reader
+	io.Closer
+}
+
+// doHijackedProxy takes over the client connection and copies the raw bytes between the client and backend
+func (p *HTTPProxy) doHijackedProxy(w http.ResponseWriter, req *http.Request, sche, addr string, u *upstream.Upstream) (int64, int64, time.Duration, error) {
 	// obtian the underlying net.Conn
 	hj, ok := w.(http.Hijacker)
 	if !ok {
-		err = fmt.Errorf("not support http hijack: %T", w)
-		http.Error(w, err.Error(), 500)
-		return
+		err := fmt.Errorf("not support http hijack: %T", w)
+		proxyError(w, u, err.Error(), 500)
+		return 0, 0, -1, err
 	}
 
 	conn, _, err := hj.Hijack()
 	if err != nil {
 		err = fmt.Errorf("hijack tcp conn error: %v", err)
-		http.Error(w, err.Error(), 500)
-		return
+		proxyError(w, u, err.Error(), 500)
+		return 0, 0, -1, err
 	}
 	defer conn.Close()
 
-	// do proxy
-	stats.Incr(&stats.DeltaBackend{ups, backend, 1, 0, 0, 1}, nil) // conn, active
-	in, out, err = p.doRawProxy(conn, r, sche, addr)
-	stats.Incr(&stats.DeltaBackend{ups, backend, -1, uint64(in), uint64(out), 0}, nil) // disconnect
+	return p.doRawProxy(conn, req, sche, addr, u)
 }
 
-func (p *HTTPProxy) doRawProxy(src net.Conn, req *http.Request, sche, addr string) (int64, int64, error) {
-	var in, out int64
+// doRawProxy returns the received & transmitted bytes, and the response time which is
+// measured as the time to the first byte of the response, -1 if the response not received.
+func (p *HTTPProxy) doRawProxy(src net.Conn, req *http.Request, sche, addr string, u *upstream.Upstream) (int64, int64, time.Duration, error) {
+	var (
+		in, out int64
+		rt      = time.Duration(-1)
+	)
 
 	// dial backend
-	dst, err := net.DialTimeout("tcp", addr, time.Second*60)
+	dst, err := net.DialTimeout("tcp", addr, u.DialTimeout())
 	if err != nil {
-		err = fmt.Errorf("cannot connect to upstream %s: %v", addr, err)
-		src.Write([]byte("HTTP/1.0 500 Internal Server Error\r\n\r\n" + err.Error() + "\r\n"))
-		return in, out, err
+		err = &connectError{addr, err}
+		rawError(src, u, err.Error())
+		return in, out, rt, err
 	}
 	defer dst.Close()
 
+	// emit the PROXY header to preserve the client address
+	if u.SendProxy != "" {
+		if err = writeProxyHeader(dst, u.SendProxy, src.RemoteAddr(), src.LocalAddr()); err != nil {
+			err = fmt.Errorf("writing PROXY header to %s error: %v", addr, err)
+			rawError(src, u, err.Error())
+			return in, out, rt, err
+		}
+	}
+
 	// tls wrap and try handshake
 	if sche == "https" {
-		dst, err = wrapWithTLS(dst)
+		dst, err = wrapWithTLS(dst, addr, u.TLS)
 		if err != nil {
 			err = fmt.Errorf("tls handshake with upstream %s error: %v", addr, err)
-			src.Write([]byte("HTTP/1.0 500 Internal Server Error\r\n\r\n" + err.Error() + "\r\n"))
-			return in, out, err
+			rawError(src, u, err.Error())
+			return in, out, rt, err
+		}
+	}
+
+	// append the client ip to the forwarding header
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
 		}
+		req.Header.Set("X-Forwarded-For", ip)
 	}
 
+	reqThrottle, respThrottle := upstream.Throttles(u)
+	if req.Body != nil && reqThrottle != nil {
+		req.Body = &throttledBody{reqThrottle.Reader(req.Body), req.Body}
+	}
+
+	start := time.Now()
 	err = req.WriteProxy(dst) // send original request
 	if err != nil {
 		err = fmt.Errorf("copying request to %s error: %v", addr, err)
-		src.Write([]byte("HTTP/1.0 500 Internal Server Error\r\n\r\n" + err.Error() + "\r\n"))
-		return in, out, err
+		rawError(src, u, err.Error())
+		return in, out, rt, err
 	}
 	in += httpRequestLen(req)
 
@@ -196,16 +631,33 @@ func (p *HTTPProxy) doRawProxy(src net.Conn, req *http.Request, sche, addr strin
 		errc <- err
 	}
 
-	go cp(dst, src, &in)
-	cp(src, dst, &out) // note: hanging wait while copying the response
+	go cp(dst, reqThrottle.Reader(src), &in)
+	cp(src, respThrottle.Reader(&firstByteReader{Reader: dst, start: start, rt: &rt}), &out) // note: hanging wait while copying the response
 
 	err = <-errc
 	if err != nil && err != io.EOF {
 		err = fmt.Errorf("io copy error: %v", err)
-		src.Write([]byte("HTTP/1.0 500 Internal Server Error\r\n\r\n" + err.Error() + "\r\n"))
-		return in, out, err
+		rawError(src, u, err.Error())
+		return in, out, rt, err
 	}
-	return in, out, nil
+	return in, out, rt, nil
+}
+
+// firstByteReader records the elapsed time since start on the first read
+type firstByteReader struct {
+	io.Reader
+	start time.Time
+	rt    *time.Duration
+	read  bool
+}
+
+func (r *firstByteReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && !r.read {
+		r.read = true
+		*r.rt = time.Since(r.start)
+	}
+	return n, err
 }
 
 // try hard to obtain the size of initial raw HTTP request according by RFC7231.
@@ -229,8 +681,8 @@ func httpRequestLen(r *http.Request) int64 {
 	return n
 }
 
-func detectHTTPs(addr string) (https bool, err error) {
-	conn, err := net.DialTimeout("tcp", addr, time.Second*60)
+func detectHTTPs(addr string, timeout time.Duration) (https bool, err error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
 	if err != nil {
 		return
 	}
@@ -251,9 +703,14 @@ func detectHTTPs(addr string) (https bool, err error) {
 	return
 }
 
-// wrap a plain net.Conn with tls and try tls handshake
-func wrapWithTLS(plainConn net.Conn) (net.Conn, error) {
-	tlsConn := tls.Client(plainConn, &tls.Config{InsecureSkipVerify: true})
+// wrap a plain net.Conn with tls by the upstream tls setup and try tls handshake
+func wrapWithTLS(plainConn net.Conn, addr string, cfg *upstream.TLSConfig) (net.Conn, error) {
+	tlsCfg, err := backendTLSConfig(cfg, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(plainConn, tlsCfg)
 
 	errCh := make(chan error, 2)
 	timer := time.AfterFunc(time.Second*10, func() {
@@ -0,0 +1,683 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+	"github.com/Dataman-Cloud/swan/utils"
+)
+
+func TestRequestIDForwarded(t *testing.T) {
+	var forwarded = make(chan string, 1)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- r.Header.Get(utils.RequestIDHeader)
+		w.Header().Set(utils.RequestIDHeader, "echoed-by-backend")
+	}))
+	defer backend.Close()
+
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	name := "reqid.default.bbk.dataman"
+	cmb := &upstream.BackendCombined{
+		Upstream: &upstream.Upstream{Name: name, Alias: name},
+		Backend:  &upstream.Backend{ID: "0." + name, IP: host, Port: uint64(p), Scheme: "http", Weight: 100},
+	}
+	if _, err := upstream.UpsertBackend(cmb); err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.RemoveBackend(cmb)
+	defer ClosePool(backend.Listener.Addr().String())
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		inbound string
+	}{
+		{name: "generated when absent"},
+		{name: "preserved when present", inbound: "45c3cb36-6a6b-4d3e-9d40-7f3a4a1b8e21"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			req.Host = name
+			if tt.inbound != "" {
+				req.Header.Set(utils.RequestIDHeader, tt.inbound)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+			resp.Body.Close()
+
+			var (
+				got = resp.Header.Get(utils.RequestIDHeader)
+				fwd = <-forwarded
+			)
+			if got == "" || fwd != got {
+				t.Fatalf("responded request id %q, forwarded %q, want the same non-empty", got, fwd)
+			}
+			if tt.inbound != "" && got != tt.inbound {
+				t.Errorf("request id = %q, want inbound %q", got, tt.inbound)
+			}
+			if len(resp.Header[http.CanonicalHeaderKey(utils.RequestIDHeader)]) != 1 {
+				t.Errorf("request id headers = %v, want only one", resp.Header[http.CanonicalHeaderKey(utils.RequestIDHeader)])
+			}
+		})
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	name := "connect-timeout.default.bbk.dataman"
+	cmb := &upstream.BackendCombined{
+		Upstream: &upstream.Upstream{Name: name, Alias: name, ConnectTimeout: time.Millisecond * 200},
+		// non-routable, dialing blocks until the timeout
+		Backend: &upstream.Backend{ID: "0." + name, IP: "10.255.255.1", Port: 80, Scheme: "http", Weight: 100},
+	}
+	if _, err := upstream.UpsertBackend(cmb); err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.RemoveBackend(cmb)
+	defer ClosePool("10.255.255.1:80")
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Host = name
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error = %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("code = %d, want 500", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("failed in %s, want within the connect timeout", elapsed)
+	}
+}
+
+func TestHostHeaderOverride(t *testing.T) {
+	defer SetPoolOptions(PoolOptions{MaxIdle: 32, IdleTimeout: time.Second * 90})
+
+	type received struct{ host, forwardedHost string }
+	var got = make(chan received, 1)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- received{r.Host, r.Header.Get("X-Forwarded-Host")}
+	}))
+	defer backend.Close()
+
+	var (
+		addr         = backend.Listener.Addr().String()
+		host, port   = splitHostPort(addr)
+		name         = "host-header.default.bbk.dataman"
+		proxyHandler = NewHTTPProxyHandler("swan.com")
+		// the hijacked client connection is tunneled to the backend after the first request
+		client = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	)
+
+	srv := httptest.NewServer(proxyHandler)
+	defer srv.Close()
+	defer ClosePool(addr)
+
+	tests := []struct {
+		name          string
+		hostHeader    string
+		wantHost      string
+		forwardedHost string
+	}{
+		{name: "pass through", hostHeader: "", wantHost: name},
+		{name: "fixed", hostHeader: "internal.example.com", wantHost: "internal.example.com", forwardedHost: name},
+		{name: "backend address", hostHeader: upstream.HostBackend, wantHost: addr, forwardedHost: name},
+	}
+
+	for _, pooling := range []bool{true, false} {
+		opts := PoolOptions{}
+		if pooling {
+			opts = PoolOptions{MaxIdle: 8, IdleTimeout: time.Minute}
+		}
+		SetPoolOptions(opts)
+
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s pooling %v", tt.name, pooling), func(t *testing.T) {
+				cmb := &upstream.BackendCombined{
+					Upstream: &upstream.Upstream{Name: name, Alias: name, HostHeader: tt.hostHeader},
+					Backend:  &upstream.Backend{ID: "0." + name, IP: host, Port: port, Scheme: "http", Weight: 100},
+				}
+				if _, err := upstream.UpsertBackend(cmb); err != nil {
+					t.Fatal(err)
+				}
+				defer upstream.RemoveBackend(cmb)
+
+				req, _ := http.NewRequest("GET", srv.URL, nil)
+				req.Host = name
+
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("request error = %v", err)
+				}
+				resp.Body.Close()
+
+				r := <-got
+				if r.host != tt.wantHost {
+					t.Errorf("backend received Host %q, want %q", r.host, tt.wantHost)
+				}
+				if r.forwardedHost != tt.forwardedHost {
+					t.Errorf("backend received X-Forwarded-Host %q, want %q", r.forwardedHost, tt.forwardedHost)
+				}
+			})
+		}
+	}
+}
+
+func splitHostPort(addr string) (string, uint64) {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	return host, uint64(p)
+}
+
+func TestResponseHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.10")
+		w.Header().Set("X-Cache", "miss")
+	}))
+	defer backend.Close()
+
+	var (
+		addr       = backend.Listener.Addr().String()
+		host, port = splitHostPort(addr)
+		name       = "response-headers.default.bbk.dataman"
+	)
+
+	cmb := &upstream.BackendCombined{
+		Upstream: &upstream.Upstream{Name: name, Alias: name, ResponseHeaders: upstream.HeaderRules{
+			{Op: upstream.HeaderRemove, Name: "Server"},
+			{Op: upstream.HeaderAdd, Name: "Strict-Transport-Security", Value: "max-age=31536000"},
+			{Op: upstream.HeaderSet, Name: "X-Cache", Value: "hit"},
+		}},
+		Backend: &upstream.Backend{ID: "0." + name, IP: host, Port: port, Scheme: "http", Weight: 100},
+	}
+	if _, err := upstream.UpsertBackend(cmb); err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.RemoveBackend(cmb)
+	defer ClosePool(addr)
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Host = name
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error = %v", err)
+	}
+	resp.Body.Close()
+
+	if v := resp.Header.Get("Server"); v != "" {
+		t.Errorf("Server = %q, want removed", v)
+	}
+	if v := resp.Header.Get("Strict-Transport-Security"); v != "max-age=31536000" {
+		t.Errorf("Strict-Transport-Security = %q, want added", v)
+	}
+	if v := resp.Header["X-Cache"]; !reflect.DeepEqual(v, []string{"hit"}) {
+		t.Errorf("X-Cache = %v, want [hit]", v)
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	defer SetPoolOptions(PoolOptions{MaxIdle: 32, IdleTimeout: time.Second * 90})
+
+	var got = make(chan http.Header, 1)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header
+	}))
+	defer backend.Close()
+
+	var (
+		addr       = backend.Listener.Addr().String()
+		host, port = splitHostPort(addr)
+		name       = "request-headers.default.bbk.dataman"
+		client     = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	)
+
+	cmb := &upstream.BackendCombined{
+		Upstream: &upstream.Upstream{Name: name, Alias: name, RequestHeaders: upstream.HeaderRules{
+			{Op: upstream.HeaderRemove, Name: "X-Internal-Trust"},
+			{Op: upstream.HeaderSet, Name: "X-Internal-Auth", Value: "s3cret"},
+			{Op: upstream.HeaderAdd, Name: "X-Via", Value: "swan"},
+		}},
+		Backend: &upstream.Backend{ID: "0." + name, IP: host, Port: port, Scheme: "http", Weight: 100},
+	}
+	if _, err := upstream.UpsertBackend(cmb); err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.RemoveBackend(cmb)
+	defer ClosePool(addr)
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+
+	for _, pooling := range []bool{true, false} {
+		t.Run(fmt.Sprintf("pooling %v", pooling), func(t *testing.T) {
+			opts := PoolOptions{}
+			if pooling {
+				opts = PoolOptions{MaxIdle: 8, IdleTimeout: time.Minute}
+			}
+			SetPoolOptions(opts)
+
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			req.Host = name
+			req.Header.Set("X-Internal-Trust", "yes")
+			req.Header.Set("X-Internal-Auth", "forged")
+			req.Header.Set("X-Via", "client")
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+			resp.Body.Close()
+
+			h := <-got
+			if v, ok := h["X-Internal-Trust"]; ok {
+				t.Errorf("X-Internal-Trust = %v, want stripped", v)
+			}
+			if v := h["X-Internal-Auth"]; !reflect.DeepEqual(v, []string{"s3cret"}) {
+				t.Errorf("X-Internal-Auth = %v, want injected [s3cret]", v)
+			}
+			if v := h["X-Via"]; !reflect.DeepEqual(v, []string{"client", "swan"}) {
+				t.Errorf("X-Via = %v, want [client swan]", v)
+			}
+		})
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	defer SetPoolOptions(PoolOptions{MaxIdle: 32, IdleTimeout: time.Second * 90})
+
+	// nothing listening on the port, connecting is refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	host, port := splitHostPort(addr)
+
+	pages := upstream.ErrorPages{
+		500: {ContentType: "application/json", Body: `{"error":"backend failure"}`},
+		503: {Body: "<h1>maintenance</h1>"},
+	}
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+	defer ClosePool(addr)
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	tests := []struct {
+		name     string
+		pooling  bool
+		pages    upstream.ErrorPages
+		wantCode int
+		wantType string
+		wantBody string
+	}{
+		{name: "pooled failure", pooling: true, pages: pages, wantCode: 500, wantType: "application/json", wantBody: `{"error":"backend failure"}`},
+		{name: "hijacked failure", pooling: false, pages: pages, wantCode: 500, wantType: "application/json", wantBody: `{"error":"backend failure"}`},
+		{name: "default without page", pooling: true, pages: upstream.ErrorPages{503: pages[503]}, wantCode: 500, wantType: "text/plain; charset=utf-8"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := PoolOptions{}
+			if tt.pooling {
+				opts = PoolOptions{MaxIdle: 8, IdleTimeout: time.Minute}
+			}
+			SetPoolOptions(opts)
+
+			name := fmt.Sprintf("error-pages-%d.default.bbk.dataman", i)
+			cmb := &upstream.BackendCombined{
+				Upstream: &upstream.Upstream{Name: name, Alias: name, ErrorPages: tt.pages},
+				Backend:  &upstream.Backend{ID: "0." + name, IP: host, Port: port, Scheme: "http", Weight: 100},
+			}
+			if _, err := upstream.UpsertBackend(cmb); err != nil {
+				t.Fatal(err)
+			}
+			defer upstream.RemoveBackend(cmb)
+
+			do := func() (int, string, string) {
+				req, _ := http.NewRequest("GET", srv.URL, nil)
+				req.Host = name
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("request error = %v", err)
+				}
+				defer resp.Body.Close()
+				body, _ := ioutil.ReadAll(resp.Body)
+				return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+			}
+
+			code, typ, body := do()
+			if code != tt.wantCode || typ != tt.wantType {
+				t.Errorf("response = %d %q, want %d %q", code, typ, tt.wantCode, tt.wantType)
+			}
+			if tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+
+			// the backend ejected on the failure, no healthy backends then
+			if code, typ, body := do(); code != 503 || typ != "text/html; charset=utf-8" || body != "<h1>maintenance</h1>" {
+				t.Errorf("response after ejected = %d %q %q, want the 503 page", code, typ, body)
+			}
+		})
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	tests := []struct {
+		name      string
+		streaming bool
+		header    http.Header
+	}{
+		{name: "event stream", header: http.Header{"Content-Type": {"text/event-stream"}}},
+		{name: "chunked", header: http.Header{}},
+		{name: "forced with content length", streaming: true, header: http.Header{"Content-Length": {"12"}}},
+	}
+
+	chunks := []string{"data: 1\n\n", "abc"}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := make(chan struct{})
+
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, vs := range tt.header {
+					w.Header()[k] = vs
+				}
+				for _, chunk := range chunks {
+					w.Write([]byte(chunk))
+					w.(http.Flusher).Flush()
+					<-next // the next chunk is written once the client got this one
+				}
+			}))
+			defer backend.Close()
+
+			var (
+				addr       = backend.Listener.Addr().String()
+				host, port = splitHostPort(addr)
+				name       = fmt.Sprintf("streaming-%d.default.bbk.dataman", i)
+			)
+
+			cmb := &upstream.BackendCombined{
+				Upstream: &upstream.Upstream{Name: name, Alias: name, Streaming: tt.streaming},
+				Backend:  &upstream.Backend{ID: "0." + name, IP: host, Port: port, Scheme: "http", Weight: 100},
+			}
+			if _, err := upstream.UpsertBackend(cmb); err != nil {
+				t.Fatal(err)
+			}
+			defer upstream.RemoveBackend(cmb)
+			defer ClosePool(addr)
+
+			srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+			defer srv.Close()
+
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			req.Host = name
+
+			// the headers never arrive if the proxy buffered the response
+			client := &http.Client{Timeout: time.Second * 5}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				close(next)
+				t.Fatalf("request error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			for _, chunk := range chunks {
+				got := make(chan string, 1)
+				go func() {
+					buf := make([]byte, len(chunk))
+					io.ReadFull(resp.Body, buf)
+					got <- string(buf)
+				}()
+
+				select {
+				case s := <-got:
+					if s != chunk {
+						t.Fatalf("chunk = %q, want %q", s, chunk)
+					}
+				case <-time.After(time.Second * 2):
+					close(next)
+					t.Fatalf("chunk %q not received before the next written, buffered", chunk)
+				}
+				next <- struct{}{}
+			}
+		})
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	const (
+		rate  = 512 * 1024
+		burst = 32 * 1024
+		size  = 256 * 1024
+	)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			n, _ := io.Copy(ioutil.Discard, r.Body)
+			fmt.Fprint(w, n)
+			return
+		}
+
+		// streamed by pieces
+		w.Header().Set("Content-Type", "text/event-stream")
+		piece := make([]byte, 16*1024)
+		for i := 0; i < size/len(piece); i++ {
+			w.Write(piece)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer backend.Close()
+
+	var (
+		addr       = backend.Listener.Addr().String()
+		host, port = splitHostPort(addr)
+		name       = "bandwidth.default.bbk.dataman"
+	)
+
+	cmb := &upstream.BackendCombined{
+		Upstream: &upstream.Upstream{Name: name, Alias: name, Bandwidth: &upstream.Bandwidth{Request: rate, Response: rate, Burst: burst}},
+		Backend:  &upstream.Backend{ID: "0." + name, IP: host, Port: port, Scheme: "http", Weight: 100},
+	}
+	if _, err := upstream.UpsertBackend(cmb); err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.RemoveBackend(cmb)
+	defer ClosePool(addr)
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+
+	// the burst passes at once, the rest at the rate
+	want := time.Duration(float64(size-burst) / rate * float64(time.Second))
+
+	tests := []struct {
+		name   string
+		method string
+		pooled bool
+	}{
+		{name: "pooled response", method: "GET", pooled: true},
+		{name: "pooled request", method: "POST", pooled: true},
+		{name: "hijacked response", method: "GET"},
+		{name: "hijacked request", method: "POST"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.pooled {
+				SetPoolOptions(PoolOptions{MaxIdle: 8, IdleTimeout: time.Minute})
+			} else {
+				SetPoolOptions(PoolOptions{})
+			}
+			defer SetPoolOptions(PoolOptions{MaxIdle: 32, IdleTimeout: time.Second * 90})
+
+			var body io.Reader
+			if tt.method == "POST" {
+				body = bytes.NewReader(make([]byte, size))
+			}
+			req, _ := http.NewRequest(tt.method, srv.URL, body)
+			req.Host = name
+
+			client := &http.Client{
+				Timeout:   time.Second * 10, // never hangs even if deadlocked
+				Transport: &http.Transport{DisableKeepAlives: true},
+			}
+
+			start := time.Now()
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+			b, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			elapsed := time.Since(start)
+			if err != nil {
+				t.Fatalf("read response error = %v", err)
+			}
+
+			if tt.method == "POST" && string(b) != strconv.Itoa(size) {
+				t.Fatalf("backend received %s bytes, want %d", b, size)
+			}
+			if tt.method == "GET" && len(b) != size {
+				t.Fatalf("client received %d bytes, want %d", len(b), size)
+			}
+
+			if elapsed < want*8/10 || elapsed > want*3 {
+				t.Errorf("transferred %d bytes in %s, want about %s", size, elapsed, want)
+			}
+		})
+	}
+}
+
+func TestStickyCookie(t *testing.T) {
+	var backends []*httptest.Server
+	for i := 0; i < 2; i++ {
+		id := strconv.Itoa(i)
+		backends = append(backends, httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(id))
+		})))
+	}
+	defer func() {
+		for _, b := range backends {
+			b.Close()
+		}
+	}()
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+
+	no := false
+
+	tests := []struct {
+		name   string
+		cookie *upstream.StickyCookie
+		want   string
+	}{
+		{
+			name:   "defaults",
+			cookie: &upstream.StickyCookie{},
+			want:   "SWAN_STICKY=%s; Path=/; HttpOnly; Secure; SameSite=Lax",
+		},
+		{
+			name:   "configured",
+			cookie: &upstream.StickyCookie{Name: "route", Path: "/app", MaxAge: 3600, Secure: &no, HTTPOnly: &no, SameSite: upstream.SameSiteStrict},
+			want:   "route=%s; Path=/app; Max-Age=3600; SameSite=Strict",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "cookie-" + tt.name + ".default.bbk.dataman"
+
+			var cmbs []*upstream.BackendCombined
+			for i, b := range backends {
+				host, port := splitHostPort(b.Listener.Addr().String())
+				cmb := &upstream.BackendCombined{
+					Upstream: &upstream.Upstream{Name: name, Alias: name, Sticky: true, StickyCookie: tt.cookie},
+					Backend:  &upstream.Backend{ID: fmt.Sprintf("%d.%s", i, name), IP: host, Port: port, Scheme: "http", Weight: 100},
+				}
+				if _, err := upstream.UpsertBackend(cmb); err != nil {
+					t.Fatal(err)
+				}
+				cmbs = append(cmbs, cmb)
+			}
+			defer func() {
+				for _, cmb := range cmbs {
+					upstream.RemoveBackend(cmb)
+				}
+			}()
+
+			get := func(cookie string) (string, string) {
+				req, _ := http.NewRequest("GET", srv.URL+"/app/", nil)
+				req.Host = name
+				if cookie != "" {
+					req.Header.Set("Cookie", cookie)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("request error = %v", err)
+				}
+				defer resp.Body.Close()
+				body, _ := ioutil.ReadAll(resp.Body)
+				return string(body), resp.Header.Get("Set-Cookie")
+			}
+
+			first, set := get("")
+			if set == "" {
+				t.Fatal("Set-Cookie missing on the first request")
+			}
+			pair := set[:strings.Index(set, ";")]
+			value := pair[strings.Index(pair, "=")+1:]
+			if want := fmt.Sprintf(tt.want, value); set != want {
+				t.Errorf("Set-Cookie = %q, want %q", set, want)
+			}
+
+			// pinned by the cookie, not issued again
+			for i := 0; i < 4; i++ {
+				got, set := get(pair)
+				if got != first {
+					t.Errorf("request with cookie got backend %s, want pinned %s", got, first)
+				}
+				if set != "" {
+					t.Errorf("Set-Cookie = %q with the cookie carried, want none", set)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+var (
+	// the cached jwks are refreshed after a while, or on unknown key id
+	jwksRefreshInterval = time.Minute * 5
+	// the least interval between two fetches of the same jwks
+	jwksMinInterval = time.Second * 10
+	// the failed fetch is not retried within the interval, the failure is returned meanwhile
+	jwksRetryInterval = time.Second * 10
+
+	jwksKeys = &jwksCache{
+		entries:  make(map[string]*jwksEntry),
+		fetching: make(map[string]*jwksFetch),
+	}
+)
+
+// authorizeJWT verify the bearer token carried by the request, and
+// returns the token claims if valid.
+func authorizeJWT(r *http.Request, cfg *upstream.JWT) (map[string]interface{}, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, errors.New("bearer token required")
+	}
+
+	return verifyJWT(strings.TrimPrefix(auth, "Bearer "), cfg, time.Now())
+}
+
+// verifyJWT verify the signature, expiry and audience of the token,
+// supported algorithms: HS256 by the secret, RS256 by the jwks.
+func verifyJWT(token string, cfg *upstream.JWT, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token malformed")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("token header malformed: %v", err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("token signature malformed: %v", err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256":
+		if cfg.Secret == "" {
+			return nil, errors.New("HS256 token not accepted")
+		}
+		mac := hmac.New(sha256.New, []byte(cfg.Secret))
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errors.New("token signature invalid")
+		}
+
+	case "RS256":
+		if cfg.JWKSURL == "" {
+			return nil, errors.New("RS256 token not accepted")
+		}
+		key, err := jwksKeys.key(cfg.JWKSURL, header.Kid)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+			return nil, errors.New("token signature invalid")
+		}
+
+	default:
+		return nil, fmt.Errorf("token algorithm [%s] not supported", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("token claims malformed: %v", err)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok && cfg.RequireExp {
+		return nil, errors.New("token expiry required")
+	}
+	if ok && now.Unix() >= int64(exp) {
+		return nil, errors.New("token expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Unix() < int64(nbf) {
+		return nil, errors.New("token not valid yet")
+	}
+
+	if cfg.Issuer != "" && claims["iss"] != cfg.Issuer {
+		return nil, errors.New("token issuer mismatched")
+	}
+
+	if cfg.Audience != "" && !hasAudience(claims["aud"], cfg.Audience) {
+		return nil, errors.New("token audience mismatched")
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// the aud claim is either a string or an array of strings
+func hasAudience(aud interface{}, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []interface{}:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// forwardClaims sets the selected claims to the request headers, the headers
+// carried by the client are always dropped to avoid spoofing.
+func forwardClaims(r *http.Request, claims map[string]interface{}, forward map[string]string) {
+	for claim, header := range forward {
+		r.Header.Del(header)
+		if v, ok := claims[claim]; ok {
+			if s, ok := v.(string); ok {
+				r.Header.Set(header, s)
+			} else {
+				b, _ := json.Marshal(v)
+				r.Header.Set(header, string(b))
+			}
+		}
+	}
+}
+
+// jwksCache caches the rsa public keys of the jwks urls
+type jwksCache struct {
+	sync.Mutex
+	entries  map[string]*jwksEntry // url -> keys
+	fetching map[string]*jwksFetch // url -> the in-flight fetch
+}
+
+type jwksEntry struct {
+	keys      map[string]*rsa.PublicKey // kid -> key, the stale ones kept on fetching failure
+	fetchedAt time.Time
+	failedAt  time.Time // the last fetching failure, zero if the last fetch succeeded
+	err       error
+}
+
+// jwksFetch is one in-flight fetch of the jwks, shared by the concurrent lookups of the same url
+type jwksFetch struct {
+	done chan struct{} // closed once fetched
+	keys map[string]*rsa.PublicKey
+	err  error
+}
+
+// key returns the cached key of the kid, the jwks is fetched again if expired, or the kid not
+// found which probably means the keys rotated. the fetch is done without holding the lock, so a
+// slow jwks url doesn't block the lookups of the others.
+func (c *jwksCache) key(url, kid string) (*rsa.PublicKey, error) {
+	c.Lock()
+
+	e, ok := c.entries[url]
+	if ok {
+		age := time.Since(e.fetchedAt)
+		if key, found := e.keys[kid]; found && age < jwksRefreshInterval {
+			c.Unlock()
+			return key, nil
+		}
+		if e.err != nil && time.Since(e.failedAt) < jwksRetryInterval {
+			c.Unlock()
+			if key, found := e.keys[kid]; found {
+				return key, nil
+			}
+			return nil, e.err
+		}
+		if age < jwksMinInterval {
+			c.Unlock()
+			if key, found := e.keys[kid]; found {
+				return key, nil
+			}
+			return nil, fmt.Errorf("token key [%s] not found", kid)
+		}
+	}
+
+	f, fetching := c.fetching[url]
+	if !fetching {
+		f = &jwksFetch{done: make(chan struct{})}
+		c.fetching[url] = f
+	}
+	c.Unlock()
+
+	if fetching {
+		<-f.done
+	} else {
+		f.keys, f.err = fetchJWKS(url)
+
+		c.Lock()
+		if f.err == nil {
+			c.entries[url] = &jwksEntry{keys: f.keys, fetchedAt: time.Now()}
+		} else if ok {
+			c.entries[url] = &jwksEntry{keys: e.keys, fetchedAt: e.fetchedAt, failedAt: time.Now(), err: f.err}
+		} else {
+			c.entries[url] = &jwksEntry{failedAt: time.Now(), err: f.err}
+		}
+		delete(c.fetching, url)
+		c.Unlock()
+
+		close(f.done)
+	}
+
+	if f.err != nil {
+		if ok {
+			if key, found := e.keys[kid]; found {
+				return key, nil // keep using the stale keys on fetching failure
+			}
+		}
+		return nil, f.err
+	}
+
+	if key, found := f.keys[kid]; found {
+		return key, nil
+	}
+	return nil, fmt.Errorf("token key [%s] not found", kid)
+}
+
+func fetchJWKS(url string) (map[string]*rsa.PublicKey, error) {
+	client := &http.Client{Timeout: time.Second * 10}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks error: %s", resp.Status)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("decode jwks error: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,382 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+func encodeSegment(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signHS256(secret string, claims map[string]interface{}) string {
+	signed := encodeSegment(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encodeSegment(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signRS256(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := encodeSegment(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}) + "." + encodeSegment(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	var (
+		now = time.Now()
+		cfg = &upstream.JWT{Secret: "secret", Audience: "api"}
+	)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			token: signHS256("secret", map[string]interface{}{"sub": "bob", "aud": "api", "exp": now.Add(time.Minute).Unix()}),
+		},
+		{
+			name:  "valid audience array",
+			token: signHS256("secret", map[string]interface{}{"sub": "bob", "aud": []string{"web", "api"}, "exp": now.Add(time.Minute).Unix()}),
+		},
+		{
+			name:    "expired",
+			token:   signHS256("secret", map[string]interface{}{"sub": "bob", "aud": "api", "exp": now.Add(-time.Minute).Unix()}),
+			wantErr: true,
+		},
+		{
+			name:    "not valid yet",
+			token:   signHS256("secret", map[string]interface{}{"sub": "bob", "aud": "api", "nbf": now.Add(time.Minute).Unix()}),
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			token:   signHS256("secret", map[string]interface{}{"sub": "bob", "aud": "web", "exp": now.Add(time.Minute).Unix()}),
+			wantErr: true,
+		},
+		{
+			name:    "wrong secret",
+			token:   signHS256("guess", map[string]interface{}{"sub": "bob", "aud": "api", "exp": now.Add(time.Minute).Unix()}),
+			wantErr: true,
+		},
+		{
+			name:    "alg none",
+			token:   encodeSegment(map[string]string{"alg": "none"}) + "." + encodeSegment(map[string]interface{}{"aud": "api"}) + ".",
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			token:   "not-a-token",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyJWT(tt.token, cfg, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyJWT() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// the token without expiry is accepted unless required
+	token := signHS256("secret", map[string]interface{}{"sub": "bob", "aud": "api"})
+	if _, err := verifyJWT(token, cfg, now); err != nil {
+		t.Errorf("verifyJWT() without exp error = %v", err)
+	}
+	if _, err := verifyJWT(token, &upstream.JWT{Secret: "secret", Audience: "api", RequireExp: true}, now); err == nil {
+		t.Error("verifyJWT() without exp accepted, want rejected by require_exp")
+	}
+}
+
+func TestVerifyJWTByJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwks.Close()
+
+	var (
+		now = time.Now()
+		cfg = &upstream.JWT{JWKSURL: jwks.URL, Audience: "api"}
+	)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			token: signRS256(key, "k1", map[string]interface{}{"aud": "api", "exp": now.Add(time.Minute).Unix()}),
+		},
+		{
+			name:    "expired",
+			token:   signRS256(key, "k1", map[string]interface{}{"aud": "api", "exp": now.Add(-time.Minute).Unix()}),
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			token:   signRS256(key, "k1", map[string]interface{}{"aud": "web", "exp": now.Add(time.Minute).Unix()}),
+			wantErr: true,
+		},
+		{
+			name:    "unknown key",
+			token:   signRS256(key, "k2", map[string]interface{}{"aud": "api", "exp": now.Add(time.Minute).Unix()}),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyJWT(tt.token, cfg, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyJWT() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// the jwks is cached, the unknown key doesn't lead to refetch within the least interval
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("jwks fetched %d times, want 1", n)
+	}
+}
+
+func TestJWKSFetchFailure(t *testing.T) {
+	var fetches int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer jwks.Close()
+
+	c := &jwksCache{
+		entries:  make(map[string]*jwksEntry),
+		fetching: make(map[string]*jwksFetch),
+	}
+
+	// the failure is returned without refetch within the retry interval
+	for i := 0; i < 3; i++ {
+		if _, err := c.key(jwks.URL, "k1"); err == nil {
+			t.Fatal("key() of the failed jwks got no error")
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("failed jwks fetched %d times, want 1", n)
+	}
+
+	// retried once the interval elapsed
+	defer func(interval time.Duration) { jwksRetryInterval = interval }(jwksRetryInterval)
+	jwksRetryInterval = 0
+
+	if _, err := c.key(jwks.URL, "k1"); err == nil {
+		t.Fatal("key() of the failed jwks got no error")
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("failed jwks fetched %d times, want 2 after the retry interval", n)
+	}
+}
+
+func TestJWKSFetchUnlocked(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwksOf := func(w http.ResponseWriter) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}
+
+	var (
+		fetches int32
+		release = make(chan struct{})
+	)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		jwksOf(w)
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwksOf(w)
+	}))
+	defer fast.Close()
+
+	c := &jwksCache{
+		entries:  make(map[string]*jwksEntry),
+		fetching: make(map[string]*jwksFetch),
+	}
+
+	// the concurrent lookups of the slow jwks
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := c.key(slow.URL, "k1")
+			errs <- err
+		}()
+	}
+
+	// the others are not blocked meanwhile
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.key(fast.URL, "k1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("fast jwks key() error = %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("fast jwks blocked by the slow one")
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("slow jwks key() error = %v", err)
+		}
+	}
+
+	// fetched once, the lookups came later hit the cache
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("slow jwks fetched %d times, want 1", n)
+	}
+}
+
+func TestJWTProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-User-ID"))
+	}))
+	defer backend.Close()
+
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	cmb := &upstream.BackendCombined{
+		Upstream: &upstream.Upstream{
+			Name:  "api.example.com",
+			Alias: "api.example.com",
+			JWT: &upstream.JWT{
+				Secret:        "secret",
+				Audience:      "api",
+				ForwardClaims: map[string]string{"sub": "X-User-ID"},
+			},
+		},
+		Backend: &upstream.Backend{ID: "0.api", IP: host, Port: uint64(p), Scheme: "http", Weight: 100},
+	}
+	if _, err := upstream.UpsertBackend(cmb); err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.RemoveBackend(cmb)
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+
+	var (
+		client = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		exp    = time.Now().Add(time.Minute).Unix()
+	)
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "missing",
+			wantCode: 401,
+		},
+		{
+			name:     "expired",
+			token:    signHS256("secret", map[string]interface{}{"sub": "bob", "aud": "api", "exp": time.Now().Add(-time.Minute).Unix()}),
+			wantCode: 401,
+		},
+		{
+			name:     "wrong audience",
+			token:    signHS256("secret", map[string]interface{}{"sub": "bob", "aud": "web", "exp": exp}),
+			wantCode: 401,
+		},
+		{
+			name:     "valid",
+			token:    signHS256("secret", map[string]interface{}{"sub": "bob", "aud": "api", "exp": exp}),
+			wantCode: 200,
+			wantBody: "bob",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			req.Host = "api.example.com"
+			req.Header.Set("X-User-ID", "spoofed")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status code = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+
+			if tt.wantCode == 401 && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Errorf("WWW-Authenticate header missing")
+			}
+
+			if tt.wantBody != "" {
+				if body, _ := ioutil.ReadAll(resp.Body); string(body) != tt.wantBody {
+					t.Errorf("body = %s, want %s", body, tt.wantBody)
+				}
+			}
+		})
+	}
+}
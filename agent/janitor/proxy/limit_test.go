@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+func TestUpstreamLimit(t *testing.T) {
+	var (
+		entered = make(chan struct{}, 8)
+		unblock = make(chan struct{})
+	)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-unblock
+		fmt.Fprint(w, "done")
+	}))
+	defer backend.Close()
+
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	cmb := &upstream.BackendCombined{
+		Upstream: &upstream.Upstream{
+			Name:  "fragile.example.com",
+			Alias: "fragile.example.com",
+			Limit: &upstream.Limit{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Millisecond * 100},
+		},
+		Backend: &upstream.Backend{ID: "0.fragile", IP: host, Port: uint64(p), Scheme: "http", Weight: 100},
+	}
+	if _, err := upstream.UpsertBackend(cmb); err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.RemoveBackend(cmb)
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func() (int, error) {
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req.Host = "fragile.example.com"
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	// occupy the only in-flight slot
+	first := make(chan int, 1)
+	go func() {
+		code, _ := get()
+		first <- code
+	}()
+	<-entered
+
+	// the queued one times out, the one beyond the queue is rejected at once
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			code, _ := get()
+			codes <- code
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != 503 {
+			t.Errorf("request beyond the limit code = %d, want 503", code)
+		}
+	}
+
+	status := upstream.LimitStats()["fragile.example.com"]
+	if status == nil || status.InFlight != 1 || status.Queued != 0 || status.Rejected != 2 {
+		t.Errorf("limit stats = %+v, want 1 in-flight, 0 queued, 2 rejected", status)
+	}
+
+	close(unblock)
+	if code := <-first; code != 200 {
+		t.Errorf("first request code = %d, want 200", code)
+	}
+
+	// the slot is released after the request done
+	if code, err := get(); err != nil || code != 200 {
+		t.Errorf("request after release code = %d, err = %v, want 200", code, err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for upstream.LimitStats()["fragile.example.com"].InFlight != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if status := upstream.LimitStats()["fragile.example.com"]; status.InFlight != 0 {
+		t.Errorf("in-flight after done = %d, want 0", status.InFlight)
+	}
+}
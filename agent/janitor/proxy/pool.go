@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+var pools = &connPools{
+	entries: make(map[string]*connPool),
+	opts:    PoolOptions{MaxIdle: 32, IdleTimeout: time.Second * 90},
+}
+
+// PoolOptions is the sizing of the keep-alive connection pool of each backend
+type PoolOptions struct {
+	MaxIdle     int           // max idle connections kept, 0 to disable pooling
+	MaxConns    int           // max concurrent connections, 0 for unlimited
+	IdleTimeout time.Duration // idle connections are closed after the timeout
+}
+
+// SetPoolOptions setup the sizing of the connection pools, the existing pools are dropped
+// once the sizing changed, the in-flight requests on them are not affected.
+func SetPoolOptions(opts PoolOptions) {
+	pools.Lock()
+	defer pools.Unlock()
+
+	if pools.opts == opts {
+		return
+	}
+	pools.opts = opts
+
+	for addr, p := range pools.entries {
+		p.transport.CloseIdleConnections()
+		delete(pools.entries, addr)
+	}
+}
+
+// ClosePool closes the idle pooled connections of the backend and drops the pool,
+// it should be called once the backend is removed or draining.
+func ClosePool(addr string) {
+	pools.Lock()
+	p, ok := pools.entries[addr]
+	delete(pools.entries, addr)
+	pools.Unlock()
+
+	if ok {
+		p.transport.CloseIdleConnections()
+	}
+}
+
+// connPools holds the keep-alive connection pools keyed by backend addr
+type connPools struct {
+	sync.Mutex
+	entries map[string]*connPool // backend addr -> pool
+	opts    PoolOptions
+}
+
+type connPool struct {
+	transport *http.Transport
+	tls       *upstream.TLSConfig // the tls setup the transport dials with
+	timeout   time.Duration       // the connect timeout the transport dials with
+	sem       chan struct{}       // nil for unlimited connections
+}
+
+func (c *connPools) enabled() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.opts.MaxIdle > 0
+}
+
+// get returns the pool of the backend, the pool is rebuilt if the tls setup or connect timeout changed
+func (c *connPools) get(addr string, tlsCfg *upstream.TLSConfig, timeout time.Duration) *connPool {
+	c.Lock()
+	defer c.Unlock()
+
+	if p, ok := c.entries[addr]; ok {
+		if p.tls == tlsCfg && p.timeout == timeout {
+			return p
+		}
+		p.transport.CloseIdleConnections()
+	}
+
+	p := newConnPool(addr, tlsCfg, timeout, c.opts)
+	c.entries[addr] = p
+	return p
+}
+
+func newConnPool(addr string, tlsCfg *upstream.TLSConfig, timeout time.Duration, opts PoolOptions) *connPool {
+	dial := func(network, _ string) (net.Conn, error) {
+		conn, err := net.DialTimeout(network, addr, timeout)
+		if err != nil {
+			return nil, &connectError{addr, err}
+		}
+		return conn, nil
+	}
+
+	p := &connPool{
+		transport: &http.Transport{
+			Dial: dial,
+			DialTLS: func(network, _ string) (net.Conn, error) {
+				conn, err := dial(network, addr)
+				if err != nil {
+					return nil, err
+				}
+				tlsConn, err := wrapWithTLS(conn, addr, tlsCfg)
+				if err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return tlsConn, nil
+			},
+			MaxIdleConns:        opts.MaxIdle,
+			MaxIdleConnsPerHost: opts.MaxIdle,
+			IdleConnTimeout:     opts.IdleTimeout,
+			DisableCompression:  true, // pass through the client's Accept-Encoding as is
+		},
+		tls:     tlsCfg,
+		timeout: timeout,
+	}
+
+	if opts.MaxConns > 0 {
+		p.sem = make(chan struct{}, opts.MaxConns)
+	}
+
+	return p
+}
+
+// acquire waits for a connection slot, until the request canceled
+func (p *connPool) acquire(ctx context.Context) error {
+	if p.sem == nil {
+		return nil
+	}
+
+	select {
+	case p.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *connPool) release() {
+	if p.sem != nil {
+		<-p.sem
+	}
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+// setupPoolUpstream runs a backend counting the new connections, and a proxy in front of it
+func setupPoolUpstream(t testing.TB, name string) (proxyURL string, newConns, closedConns *int64, cleanup func()) {
+	newConns, closedConns = new(int64), new(int64)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		fmt.Fprint(w, "pooled")
+	}))
+	backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt64(newConns, 1)
+		case http.StateClosed:
+			atomic.AddInt64(closedConns, 1)
+		}
+	}
+	backend.Start()
+
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	cmb := &upstream.BackendCombined{
+		Upstream: &upstream.Upstream{Name: name, Alias: name},
+		Backend:  &upstream.Backend{ID: "0." + name, IP: host, Port: uint64(p), Scheme: "http", Weight: 100},
+	}
+	if _, err := upstream.UpsertBackend(cmb); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+
+	return srv.URL, newConns, closedConns, func() {
+		srv.Close()
+		upstream.RemoveBackend(cmb)
+		ClosePool(backend.Listener.Addr().String())
+		backend.Close()
+	}
+}
+
+func doPoolRequests(t testing.TB, client *http.Client, url, host string, n int) {
+	for i := 0; i < n; i++ {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Host = host
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request error = %v", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != 200 || string(body) != "pooled" {
+			t.Fatalf("response = %d %s, want 200 pooled", resp.StatusCode, body)
+		}
+	}
+}
+
+func TestConnPoolReuse(t *testing.T) {
+	defer SetPoolOptions(PoolOptions{MaxIdle: 32, IdleTimeout: time.Second * 90})
+
+	tests := []struct {
+		name         string
+		opts         PoolOptions
+		requests     int
+		wantNewConns int64
+	}{
+		{
+			name:         "pooled",
+			opts:         PoolOptions{MaxIdle: 8, IdleTimeout: time.Minute},
+			requests:     20,
+			wantNewConns: 1,
+		},
+		{
+			name:         "pooling disabled",
+			opts:         PoolOptions{MaxIdle: 0},
+			requests:     20,
+			wantNewConns: 20,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetPoolOptions(tt.opts)
+
+			url, newConns, _, cleanup := setupPoolUpstream(t, "pool.example.com")
+			defer cleanup()
+
+			// short-lived client connections, each of them used to dial a new backend connection
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			doPoolRequests(t, client, url, "pool.example.com", tt.requests)
+
+			if got := atomic.LoadInt64(newConns); got != tt.wantNewConns {
+				t.Errorf("backend new connections = %d, want %d", got, tt.wantNewConns)
+			}
+		})
+	}
+}
+
+func TestClosePool(t *testing.T) {
+	defer SetPoolOptions(PoolOptions{MaxIdle: 32, IdleTimeout: time.Second * 90})
+	SetPoolOptions(PoolOptions{MaxIdle: 8, IdleTimeout: time.Minute})
+
+	url, newConns, closedConns, cleanup := setupPoolUpstream(t, "closepool.example.com")
+	defer cleanup()
+
+	doPoolRequests(t, &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}, url, "closepool.example.com", 3)
+
+	b := upstream.GetBackend(upstream.GetUpstream("closepool.example.com"), "0.closepool.example.com")
+	ClosePool(b.Addr())
+
+	// the idle pooled connection is closed by the proxy
+	deadline := time.Now().Add(time.Second * 2)
+	for atomic.LoadInt64(closedConns) != atomic.LoadInt64(newConns) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if c, n := atomic.LoadInt64(closedConns), atomic.LoadInt64(newConns); c != n {
+		t.Errorf("backend closed connections = %d, want %d", c, n)
+	}
+}
+
+func TestConnPoolMaxConns(t *testing.T) {
+	p := newConnPool("127.0.0.1:0", nil, time.Second, PoolOptions{MaxIdle: 1, MaxConns: 1})
+
+	if err := p.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := p.acquire(ctx); err == nil {
+		t.Fatalf("acquire beyond max conns expected error")
+	}
+
+	p.release()
+	if err := p.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire after release error = %v", err)
+	}
+}
+
+func benchmarkProxy(b *testing.B, opts PoolOptions) {
+	defer SetPoolOptions(PoolOptions{MaxIdle: 32, IdleTimeout: time.Second * 90})
+	SetPoolOptions(opts)
+
+	url, newConns, _, cleanup := setupPoolUpstream(b, "bench.example.com")
+	defer cleanup()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			doPoolRequests(b, client, url, "bench.example.com", 1)
+		}
+	})
+	b.StopTimer()
+
+	b.Logf("%d requests, %d backend connections established", b.N, atomic.LoadInt64(newConns))
+}
+
+func BenchmarkProxyPooled(b *testing.B) {
+	benchmarkProxy(b, PoolOptions{MaxIdle: 64, IdleTimeout: time.Minute})
+}
+
+func BenchmarkProxyUnpooled(b *testing.B) {
+	benchmarkProxy(b, PoolOptions{MaxIdle: 0})
+}
@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// the signature of PROXY protocol v2 header
+	proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	// the inbound connection is dropped if the PROXY header not received in time
+	proxyHeaderTimeout = time.Second * 5
+)
+
+const (
+	proxyV1MaxLen = 107 // the max length of PROXY protocol v1 header line
+)
+
+// NewProxyProtocolListener wraps the listener to require the PROXY protocol (v1 / v2) header on each
+// accepted connection, the client address carried by the header is used as the connection's RemoteAddr.
+func NewProxyProtocolListener(l net.Listener) net.Listener {
+	return &proxyListener{l}
+}
+
+type proxyListener struct {
+	net.Listener
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn parses the PROXY header lazily on the first Read or RemoteAddr,
+// so that the accepting is never blocked by slow clients.
+type proxyConn struct {
+	net.Conn
+	r    *bufio.Reader
+	once sync.Once
+	src  net.Addr // nil if the header carries no address, eg: LOCAL / UNKNOWN
+	err  error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.src, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+
+		if c.err != nil {
+			log.Errorf("reject connection from %s: %v", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// proxyHeaderError returns the error on parsing the PROXY header of the connection, if any
+func proxyHeaderError(conn net.Conn) error {
+	if c, ok := conn.(*proxyConn); ok {
+		c.init()
+		return c.err
+	}
+	return nil
+}
+
+// readProxyHeader reads the PROXY protocol v1 or v2 header and returns the source address.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	b, err := r.Peek(len(proxyV2Sig))
+	if err != nil {
+		return nil, fmt.Errorf("read PROXY header error: %v", err)
+	}
+
+	if bytes.Equal(b, proxyV2Sig) {
+		return readProxyV2(r)
+	}
+
+	if bytes.HasPrefix(b, []byte("PROXY ")) {
+		return readProxyV1(r)
+	}
+
+	return nil, errors.New("PROXY header missing")
+}
+
+// v1: PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read PROXY v1 header error: %v", err)
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY v1 header malformed: not terminated by CRLF")
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("PROXY v1 header malformed: %q", line)
+	}
+
+	if fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, fmt.Errorf("PROXY v1 header protocol [%s] not supported", fields[1])
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || net.ParseIP(fields[3]) == nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("PROXY v1 header address malformed: %q", line)
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("PROXY v1 header port malformed: %q", line)
+	}
+	if _, err := strconv.ParseUint(fields[5], 10, 16); err != nil {
+		return nil, fmt.Errorf("PROXY v1 header port malformed: %q", line)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// v2: signature(12) + ver_cmd(1) + family(1) + len(2) + addresses + tlvs
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("read PROXY v2 header error: %v", err)
+	}
+
+	var (
+		ver    = hdr[12] >> 4
+		cmd    = hdr[12] & 0x0f
+		family = hdr[13]
+		length = binary.BigEndian.Uint16(hdr[14:16])
+	)
+
+	if ver != 2 {
+		return nil, fmt.Errorf("PROXY v2 header version [%d] invalid", ver)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("read PROXY v2 header error: %v", err)
+	}
+
+	switch cmd {
+	case 0x0: // LOCAL, eg: health check of the load balancer itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("PROXY v2 header command [%d] invalid", cmd)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, errors.New("PROXY v2 header address truncated")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, errors.New("PROXY v2 header address truncated")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	case 0x00: // UNSPEC
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("PROXY v2 header family [%#x] not supported", family)
+	}
+}
+
+// writeProxyHeader emits the PROXY protocol header of the version (v1 / v2) toward the backend
+func writeProxyHeader(w io.Writer, version string, src, dst net.Addr) error {
+	var (
+		s, sok = src.(*net.TCPAddr)
+		d, dok = dst.(*net.TCPAddr)
+		known  = sok && dok && (s.IP.To4() != nil) == (d.IP.To4() != nil)
+		buf    bytes.Buffer
+	)
+
+	switch version {
+	case "v1":
+		switch {
+		case !known:
+			buf.WriteString("PROXY UNKNOWN\r\n")
+		case s.IP.To4() != nil:
+			fmt.Fprintf(&buf, "PROXY TCP4 %s %s %d %d\r\n", s.IP.To4(), d.IP.To4(), s.Port, d.Port)
+		default:
+			fmt.Fprintf(&buf, "PROXY TCP6 %s %s %d %d\r\n", s.IP.To16(), d.IP.To16(), s.Port, d.Port)
+		}
+
+	case "v2":
+		buf.Write(proxyV2Sig)
+		switch {
+		case !known:
+			buf.Write([]byte{0x20, 0x00, 0x00, 0x00}) // LOCAL, UNSPEC
+		case s.IP.To4() != nil:
+			buf.Write([]byte{0x21, 0x11, 0x00, 12})
+			buf.Write(s.IP.To4())
+			buf.Write(d.IP.To4())
+			binary.Write(&buf, binary.BigEndian, uint16(s.Port))
+			binary.Write(&buf, binary.BigEndian, uint16(d.Port))
+		default:
+			buf.Write([]byte{0x21, 0x21, 0x00, 36})
+			buf.Write(s.IP.To16())
+			buf.Write(d.IP.To16())
+			binary.Write(&buf, binary.BigEndian, uint16(s.Port))
+			binary.Write(&buf, binary.BigEndian, uint16(d.Port))
+		}
+
+	default:
+		return fmt.Errorf("PROXY protocol version [%s] not supported", version)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		wantAddr string // empty if no address carried
+		wantErr  bool
+	}{
+		{
+			name:     "v1 tcp4",
+			header:   "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n",
+			wantAddr: "192.168.0.1:56324",
+		},
+		{
+			name:     "v1 tcp6",
+			header:   "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n",
+			wantAddr: "[2001:db8::1]:56324",
+		},
+		{
+			name:   "v1 unknown",
+			header: "PROXY UNKNOWN\r\n",
+		},
+		{
+			name: "v2 tcp4",
+			header: "\r\n\r\n\x00\r\nQUIT\n" + "\x21\x11\x00\x0c" +
+				"\xc0\xa8\x00\x01" + "\xc0\xa8\x00\x0b" + "\xdc\x04" + "\x01\xbb",
+			wantAddr: "192.168.0.1:56324",
+		},
+		{
+			name: "v2 tcp6 with tlv",
+			header: "\r\n\r\n\x00\r\nQUIT\n" + "\x21\x21\x00\x29" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02" +
+				"\xdc\x04" + "\x01\xbb" + "\x04\x00\x02ok",
+			wantAddr: "[2001:db8::1]:56324",
+		},
+		{
+			name:   "v2 local",
+			header: "\r\n\r\n\x00\r\nQUIT\n" + "\x20\x00\x00\x00",
+		},
+		{
+			name:    "missing",
+			header:  "GET / HTTP/1.1\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "v1 not terminated",
+			header:  "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443" + strings.Repeat(" ", 100),
+			wantErr: true,
+		},
+		{
+			name:    "v1 bad address",
+			header:  "PROXY TCP4 192.168.0.300 192.168.0.11 56324 443\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "v1 family mismatched",
+			header:  "PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "v1 bad port",
+			header:  "PROXY TCP4 192.168.0.1 192.168.0.11 99999 443\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "v2 bad version",
+			header:  "\r\n\r\n\x00\r\nQUIT\n" + "\x11\x11\x00\x0c" + strings.Repeat("\x00", 12),
+			wantErr: true,
+		},
+		{
+			name:    "v2 truncated",
+			header:  "\r\n\r\n\x00\r\nQUIT\n" + "\x21\x11\x00\x0c" + "\xc0\xa8",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := readProxyHeader(bufio.NewReader(strings.NewReader(tt.header)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readProxyHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var got string
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.wantAddr {
+				t.Errorf("readProxyHeader() addr = %s, want %s", got, tt.wantAddr)
+			}
+		})
+	}
+}
+
+func TestWriteProxyHeader(t *testing.T) {
+	var (
+		src4 = &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 56324}
+		dst4 = &net.TCPAddr{IP: net.ParseIP("192.168.0.11"), Port: 443}
+		src6 = &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324}
+		dst6 = &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+	)
+
+	for _, version := range []string{"v1", "v2"} {
+		for _, addrs := range [][2]*net.TCPAddr{{src4, dst4}, {src6, dst6}} {
+			var buf bytes.Buffer
+			if err := writeProxyHeader(&buf, version, addrs[0], addrs[1]); err != nil {
+				t.Fatalf("writeProxyHeader(%s) error = %v", version, err)
+			}
+
+			addr, err := readProxyHeader(bufio.NewReader(&buf))
+			if err != nil {
+				t.Fatalf("readProxyHeader(%s) error = %v", version, err)
+			}
+			if addr.String() != addrs[0].String() {
+				t.Errorf("round trip %s addr = %s, want %s", version, addr, addrs[0])
+			}
+		}
+	}
+
+	if err := writeProxyHeader(ioutil.Discard, "v3", src4, dst4); err == nil {
+		t.Errorf("writeProxyHeader(v3) expected error")
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l = NewProxyProtocolListener(l)
+	defer l.Close()
+
+	go func() {
+		for _, payload := range []string{
+			"PROXY TCP4 10.0.0.1 10.0.0.2 1234 80\r\nhello",
+			"malformed\r\nhello",
+		} {
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				return
+			}
+			c.Write([]byte(payload))
+			c.Close()
+		}
+	}()
+
+	// valid header
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := conn.RemoteAddr().String(); got != "10.0.0.1:1234" {
+		t.Errorf("RemoteAddr() = %s, want 10.0.0.1:1234", got)
+	}
+	if b, _ := ioutil.ReadAll(conn); string(b) != "hello" {
+		t.Errorf("payload = %q, want hello", b)
+	}
+	conn.Close()
+
+	// malformed header
+	conn, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxyHeaderError(conn) == nil {
+		t.Errorf("malformed header expected error")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("read on malformed header expected error")
+	}
+	conn.Close()
+}
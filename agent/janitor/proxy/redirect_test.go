@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "proxied")
+	}))
+	defer backend.Close()
+
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	for _, u := range []*upstream.Upstream{
+		{Name: "secure.example.com", Alias: "secure.example.com", Redirect: &upstream.Redirect{}},
+		{Name: "secure308.example.com", Alias: "secure308.example.com", Redirect: &upstream.Redirect{Code: 308, Port: 8443}},
+		{Name: "plain.example.com", Alias: "plain.example.com"},
+	} {
+		cmb := &upstream.BackendCombined{
+			Upstream: u,
+			Backend:  &upstream.Backend{ID: "0." + u.Name, IP: host, Port: uint64(p), Scheme: "http", Weight: 100},
+		}
+		if _, err := upstream.UpsertBackend(cmb); err != nil {
+			t.Fatal(err)
+		}
+		defer upstream.RemoveBackend(cmb)
+	}
+
+	srv := httptest.NewServer(NewHTTPProxyHandler("swan.com"))
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	tests := []struct {
+		host         string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			host:         "secure.example.com",
+			wantCode:     301,
+			wantLocation: "https://secure.example.com/a/b?x=1&y=2",
+		},
+		{
+			host:         "secure308.example.com",
+			wantCode:     308,
+			wantLocation: "https://secure308.example.com:8443/a/b?x=1&y=2",
+		},
+		{
+			host:     "plain.example.com",
+			wantCode: 200,
+			wantBody: "proxied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", srv.URL+"/a/b?x=1&y=2", nil)
+			req.Host = tt.host + ":80"
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status code = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if loc := resp.Header.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("location = %s, want %s", loc, tt.wantLocation)
+			}
+			if tt.wantBody != "" {
+				if body, _ := ioutil.ReadAll(resp.Body); string(body) != tt.wantBody {
+					t.Errorf("body = %s, want %s", body, tt.wantBody)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+func TestSNIRouting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "janitor-sni")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		ca      = newTestCert(t, "ca", nil, true)
+		def     = newTestCert(t, "default.example.com", ca, false)
+		certDir = dir + "/certs"
+	)
+	if err := os.Mkdir(certDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defCert, defKey := def.write(t, dir, "default")
+
+	// two upstreams aliased by the SNI names, with one backend each
+	for _, name := range []string{"a.example.com", "b.example.com"} {
+		newTestCert(t, name, ca, false).write(t, certDir, name)
+
+		name := name
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, name)
+		}))
+		defer backend.Close()
+
+		host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+		p, _ := strconv.Atoi(port)
+
+		cmb := &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: name, Alias: name, Sticky: true},
+			Backend:  &upstream.Backend{ID: "0." + name, IP: host, Port: uint64(p), Scheme: "http", Weight: 100},
+		}
+		if _, err := upstream.UpsertBackend(cmb); err != nil {
+			t.Fatal(err)
+		}
+		defer upstream.RemoveBackend(cmb)
+	}
+
+	srv := httptest.NewUnstartedServer(NewHTTPProxyHandler("swan.com"))
+	srv.TLS = NewTLSConfig(defCert, defKey, certDir)
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	tests := []struct {
+		sni      string
+		wantCert string
+		wantBody string
+		wantCode int
+	}{
+		{sni: "a.example.com", wantCert: "a.example.com", wantBody: "a.example.com", wantCode: 200},
+		{sni: "b.example.com", wantCert: "b.example.com", wantBody: "b.example.com", wantCode: 200},
+		{sni: "default.example.com", wantCert: "default.example.com", wantCode: 404}, // default certificate, no upstream
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sni, func(t *testing.T) {
+			client := &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig:   &tls.Config{ServerName: tt.sni, RootCAs: pool},
+					DisableKeepAlives: true,
+				},
+			}
+
+			// the Host header doesn't matter, routed by the SNI server name
+			resp, err := client.Get(srv.URL + "/")
+			if err != nil {
+				t.Fatalf("request error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != tt.wantCert {
+				t.Errorf("certificate = %s, want %s", cn, tt.wantCert)
+			}
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status code = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if tt.wantBody == "" {
+				return
+			}
+			if body, _ := ioutil.ReadAll(resp.Body); string(body) != tt.wantBody {
+				t.Errorf("routed to %s, want %s", body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -16,8 +17,9 @@ import (
 
 // generic tcp proxy server
 type TCPProxyServer struct {
-	listenAddr string
-	listener   net.Listener
+	listenAddr    string
+	listener      net.Listener
+	proxyProtocol bool // require the PROXY header on inbound connections
 
 	sync.RWMutex // protect clients
 	clients      map[string]net.Conn
@@ -26,10 +28,11 @@ type TCPProxyServer struct {
 	serving   bool
 }
 
-func NewTCPProxyServer(listen string) *TCPProxyServer {
+func NewTCPProxyServer(listen string, proxyProtocol bool) *TCPProxyServer {
 	return &TCPProxyServer{
-		listenAddr: listen,
-		clients:    make(map[string]net.Conn),
+		listenAddr:    listen,
+		proxyProtocol: proxyProtocol,
+		clients:       make(map[string]net.Conn),
 	}
 }
 
@@ -54,6 +57,10 @@ func (p *TCPProxyServer) Listen() error {
 	if err != nil {
 		return err
 	}
+
+	if p.proxyProtocol {
+		l = NewProxyProtocolListener(l)
+	}
 	p.listener = l
 
 	return nil
@@ -105,7 +112,10 @@ func (p *TCPProxyServer) lookup(conn net.Conn) (*upstream.BackendCombined, error
 
 	listen := ":" + localPort
 
-	selected := upstream.LookupListen(remoteHost, listen)
+	selected, err := upstream.LookupListen(&upstream.Client{IP: remoteHost}, listen)
+	if err != nil {
+		return nil, err
+	}
 	if selected == nil {
 		return nil, fmt.Errorf("no matched backends for request [%s]", listen)
 	}
@@ -122,6 +132,12 @@ func (p *TCPProxyServer) serveTCP(conn net.Conn) {
 		tcpConn.SetKeepAlivePeriod(time.Second * 30)
 	}
 
+	// drop the connections with malformed PROXY header before any backend selected
+	if err := proxyHeaderError(conn); err != nil {
+		conn.Close()
+		return
+	}
+
 	remote := conn.RemoteAddr().String()
 
 	p.Lock()
@@ -167,23 +183,49 @@ func (p *TCPProxyServer) serveTCP(conn net.Conn) {
 		backend = selected.Backend.ID
 	)
 
+	// wait for an in-flight slot of the upstream if limited
+	release, err := upstream.Acquire(context.Background(), selected)
+	if err != nil {
+		return
+	}
+	defer release()
+
 	// do proxy
-	stats.Incr(&stats.DeltaBackend{ups, backend, 1, 0, 0, 1}, nil) // conn, active
-	in, out, err = p.doRawProxy(conn, addr)
-	stats.Incr(&stats.DeltaBackend{ups, backend, -1, uint64(in), uint64(out), 0}, nil) // disconnect
+	var rt time.Duration
+	stats.Incr(&stats.DeltaBackend{ups, backend, 1, 0, 0, 1, 0}, nil) // conn, active
+	upstream.Begin(selected)
+	in, out, rt, err = p.doRawProxy(conn, addr, selected.Upstream)
+	if _, ok := err.(*connectError); ok {
+		upstream.Eject(selected, ejectDuration)
+	}
+	upstream.Done(selected, rt)
+	upstream.Report(selected, rt >= 0)
+	stats.Incr(&stats.DeltaBackend{ups, backend, -1, uint64(in), uint64(out), 0, rt}, nil) // disconnect
+	stats.Observe(ups, stats.LatencyConnect, rt)
 }
 
-func (p *TCPProxyServer) doRawProxy(src net.Conn, addr string) (int64, int64, error) {
+// doRawProxy returns the received & transmitted bytes, and the response time which is
+// measured as the time to connect to the backend, -1 if the connecting failed.
+func (p *TCPProxyServer) doRawProxy(src net.Conn, addr string, u *upstream.Upstream) (int64, int64, time.Duration, error) {
 	var in, out int64
 
 	// dial backend
-	dst, err := net.DialTimeout("tcp", addr, time.Second*60)
+	start := time.Now()
+	dst, err := net.DialTimeout("tcp", addr, u.DialTimeout())
 	if err != nil {
-		err = fmt.Errorf("cannot connect to upstream %s: %v", addr, err)
-		return in, out, err
+		return in, out, -1, &connectError{addr, err}
 	}
 	defer dst.Close()
 
+	rt := time.Since(start)
+
+	// emit the PROXY header to preserve the client address
+	if u.SendProxy != "" {
+		if err := writeProxyHeader(dst, u.SendProxy, src.RemoteAddr(), src.LocalAddr()); err != nil {
+			return in, out, rt, fmt.Errorf("writing PROXY header to %s error: %v", addr, err)
+		}
+	}
+
 	// io copy between src & dst
 	errc := make(chan error, 2)
 	cp := func(w io.WriteCloser, r io.Reader, c *int64) {
@@ -196,13 +238,15 @@ func (p *TCPProxyServer) doRawProxy(src net.Conn, addr string) (int64, int64, er
 		errc <- err
 	}
 
-	go cp(dst, src, &in)
-	cp(src, dst, &out) // note: hanging wait while copying the response
+	reqThrottle, respThrottle := upstream.Throttles(u)
+
+	go cp(dst, reqThrottle.Reader(src), &in)
+	cp(src, respThrottle.Reader(dst), &out) // note: hanging wait while copying the response
 
 	err = <-errc
 	if err != nil && err != io.EOF {
 		err = fmt.Errorf("io copy error: %v", err)
-		return in, out, err
+		return in, out, rt, err
 	}
-	return in, out, nil
+	return in, out, rt, nil
 }
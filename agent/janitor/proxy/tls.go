@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+var tlsFiles = &fileCache{entries: make(map[string]*fileEntry)}
+
+// fileCache caches the parsed certificate files, reloads them once the files modified,
+// so that the certificates could be rotated without restart.
+type fileCache struct {
+	sync.Mutex
+	entries map[string]*fileEntry // files -> parsed entry
+}
+
+type fileEntry struct {
+	modTimes []time.Time
+	value    interface{}
+}
+
+// load returns the cached value of the files, or parse them again by fn if any of them modified
+func (c *fileCache) load(fn func() (interface{}, error), files ...string) (interface{}, error) {
+	modTimes := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	key := fmt.Sprint(files)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[key]; ok && equalTimes(e.modTimes, modTimes) {
+		return e.value, nil
+	}
+
+	v, err := fn()
+	if err != nil {
+		return nil, err
+	}
+
+	c.entries[key] = &fileEntry{modTimes, v}
+	return v, nil
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func loadKeyPair(certFile, keyFile string) (*tls.Certificate, error) {
+	v, err := tlsFiles.load(func() (interface{}, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*tls.Certificate), nil
+}
+
+func loadCAPool(caFile string) (*x509.CertPool, error) {
+	v, err := tlsFiles.load(func() (interface{}, error) {
+		bs, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, errors.New("no valid certificates found in " + caFile)
+		}
+		return pool, nil
+	}, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*x509.CertPool), nil
+}
+
+// backendTLSConfig builds the tls config to the backend addr by the upstream tls setup,
+// the backend certificate is not verified if the CA not specified.
+func backendTLSConfig(cfg *upstream.TLSConfig, addr string) (*tls.Config, error) {
+	if cfg == nil {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+
+	ret := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.CAFile == "",
+	}
+
+	if ret.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ret.ServerName = host
+	}
+
+	if cfg.CAFile != "" {
+		pool, err := loadCAPool(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load CA file error: %v", err)
+		}
+		ret.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := loadKeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate error: %v", err)
+		}
+		ret.Certificates = []tls.Certificate{*cert}
+	}
+
+	return ret, nil
+}
+
+// NewTLSConfig returns the tls config of the tls listener, which selects the certificate by
+// SNI server name from certDir: {server name}.crt & {server name}.key, or the default one
+// if not found. all of the certificates are reloaded once modified.
+func NewTLSConfig(certFile, keyFile, certDir string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if name := hello.ServerName; certDir != "" && name != "" && !strings.ContainsAny(name, `/\`) {
+				var (
+					crt = filepath.Join(certDir, name+".crt")
+					key = filepath.Join(certDir, name+".key")
+				)
+				if cert, err := loadKeyPair(crt, key); err == nil {
+					return cert, nil
+				}
+			}
+
+			return loadKeyPair(certFile, keyFile)
+		},
+	}
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert issues a certificate signed by the parent, self-signed if parent is nil
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{cn},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert, key, der}
+}
+
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// write the certificate & key as pem files into dir
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	return
+}
+
+// writeFile writes the file and bumps the modification time, so that the reloading is not
+// missed within the file system timestamp granularity.
+func writeFile(t *testing.T, file string, data []byte) {
+	var mtime time.Time
+	if info, err := os.Stat(file); err == nil {
+		mtime = info.ModTime().Add(time.Second)
+	} else {
+		mtime = time.Now()
+	}
+
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveMTLS starts a tls backend which requires the client certificate signed by ca
+func serveMTLS(t *testing.T, server, ca *testCert) net.Listener {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCert()},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MaxVersion:   tls.VersionTLS12, // fail the handshake on client side if client certificate rejected
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	return l
+}
+
+func TestWrapWithTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "janitor-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		ca       = newTestCert(t, "ca", nil, true)
+		badCA    = newTestCert(t, "bad-ca", nil, true)
+		server   = newTestCert(t, "backend.local", ca, false)
+		client   = newTestCert(t, "janitor", ca, false)
+		stranger = newTestCert(t, "stranger", badCA, false)
+	)
+
+	l := serveMTLS(t, server, ca)
+	defer l.Close()
+
+	var (
+		caFile, _            = ca.write(t, dir, "ca")
+		badCAFile, _         = badCA.write(t, dir, "bad-ca")
+		certFile, keyFile    = client.write(t, dir, "client")
+		strangerCert, strKey = stranger.write(t, dir, "stranger")
+		addr                 = l.Addr().String()
+	)
+
+	tests := []struct {
+		name    string
+		cfg     *upstream.TLSConfig
+		wantErr bool
+	}{
+		{
+			name: "verified",
+			cfg:  &upstream.TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: caFile},
+		},
+		{
+			name: "server name override",
+			cfg:  &upstream.TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, ServerName: "backend.local"},
+		},
+		{
+			name:    "server name mismatch",
+			cfg:     &upstream.TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, ServerName: "other.local"},
+			wantErr: true,
+		},
+		{
+			name:    "bad CA",
+			cfg:     &upstream.TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: badCAFile},
+			wantErr: true,
+		},
+		{
+			name:    "client certificate missing",
+			cfg:     &upstream.TLSConfig{CAFile: caFile},
+			wantErr: true,
+		},
+		{
+			name:    "client certificate rejected",
+			cfg:     &upstream.TLSConfig{CertFile: strangerCert, KeyFile: strKey, CAFile: caFile},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := handshake(addr, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("wrapWithTLS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// reload: rotate the client certificate files with the rejected one
+	cfg := &upstream.TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}
+	if err := handshake(addr, cfg); err != nil {
+		t.Fatalf("handshake before rotating error = %v", err)
+	}
+
+	stranger.write(t, dir, "client")
+	if err := handshake(addr, cfg); err == nil {
+		t.Errorf("handshake after rotating to a rejected certificate should fail")
+	}
+
+	client.write(t, dir, "client")
+	if err := handshake(addr, cfg); err != nil {
+		t.Errorf("handshake after rotating back error = %v", err)
+	}
+}
+
+func handshake(addr string, cfg *upstream.TLSConfig) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	tlsConn, err := wrapWithTLS(conn, addr, cfg)
+	if err != nil {
+		return err
+	}
+	return tlsConn.Close()
+}
@@ -0,0 +1,329 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/stats"
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+// the max size of the datagrams relayed
+const maxDatagramSize = 64 * 1024
+
+// generic udp proxy server, the datagrams from one client address go to the same backend
+// and the replies are relayed back to the client, until idle for the session timeout.
+type UDPProxyServer struct {
+	listenAddr  string
+	conn        *net.UDPConn
+	maxSessions int // the datagrams of the new clients beyond are dropped, 0 for unlimited
+
+	sync.RWMutex                        // protect sessions & stopped
+	sessions     map[string]*udpSession // client addr -> session
+	stopped      bool
+
+	startedAt time.Time
+	serving   bool
+}
+
+func NewUDPProxyServer(listen string, maxSessions int) *UDPProxyServer {
+	return &UDPProxyServer{
+		listenAddr:  listen,
+		maxSessions: maxSessions,
+		sessions:    make(map[string]*udpSession),
+	}
+}
+
+func (p *UDPProxyServer) MarshalJSON() ([]byte, error) {
+	p.RLock()
+	n := len(p.sessions)
+	p.RUnlock()
+
+	m := map[string]interface{}{
+		"uptime":          time.Now().Sub(p.startedAt).String(),
+		"listen":          p.listenAddr,
+		"serving":         p.serving,
+		"active_sessions": n,
+	}
+	return json.Marshal(m)
+}
+
+func (p *UDPProxyServer) Listen() error {
+	p.startedAt = time.Now()
+
+	addr, err := net.ResolveUDPAddr("udp", p.listenAddr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	p.conn = conn
+
+	return nil
+}
+
+func (p *UDPProxyServer) Serve() {
+	defer func() {
+		p.serving = false
+	}()
+
+	p.serving = true
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, client, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			log.Errorf("[UDP] listener :%s Read error: %v", p.listenAddr, err)
+			return
+		}
+
+		s, err := p.session(client)
+		if err != nil {
+			log.Errorf("[UDP] proxy session for %s error: %v", client, err)
+			stats.Incr(nil, &stats.DeltaGlb{Rx: uint64(n), Req: 1, Fail: 1})
+			continue
+		}
+
+		s.forward(buf[:n])
+	}
+}
+
+func (p *UDPProxyServer) Stop() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+
+	p.Lock()
+	p.stopped = true
+	for _, s := range p.sessions {
+		s.backend.Close()
+	}
+	p.Unlock()
+}
+
+// session returns the session of the client address, a new one is created on a proper
+// backend selected according by the client ip if absent, unless the max sessions reached.
+// it's only called by Serve, so no session is created meanwhile.
+func (p *UDPProxyServer) session(client *net.UDPAddr) (*udpSession, error) {
+	key := client.String()
+
+	p.RLock()
+	s, ok := p.sessions[key]
+	if ok {
+		s.touch() // kept from expiring, see expire
+	}
+	n := len(p.sessions)
+	p.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	if p.maxSessions > 0 && n >= p.maxSessions {
+		return nil, fmt.Errorf("max sessions %d reached", p.maxSessions)
+	}
+
+	selected, err := p.lookup(client)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", selected.Addr())
+	if err != nil {
+		return nil, err
+	}
+	backend, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		upstream.Eject(selected, ejectDuration)
+		upstream.Report(selected, false)
+		return nil, &connectError{selected.Addr(), err}
+	}
+
+	s = &udpSession{
+		client:   client,
+		selected: selected,
+		backend:  backend,
+		timeout:  selected.Upstream.UDPSessionTimeout(),
+		started:  time.Now(),
+	}
+	s.touch()
+
+	p.Lock()
+	p.sessions[key] = s
+	p.Unlock()
+
+	stats.Incr(&stats.DeltaBackend{Uid: selected.Upstream.Name, Bid: selected.Backend.ID, Ac: 1, Req: 1}, nil) // conn, active
+	upstream.Begin(selected)
+
+	go p.relay(key, s)
+
+	return s, nil
+}
+
+func (p *UDPProxyServer) lookup(client *net.UDPAddr) (*upstream.BackendCombined, error) {
+	_, port, err := net.SplitHostPort(p.listenAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	listen := ":" + port
+
+	selected, err := upstream.LookupListen(&upstream.Client{IP: client.IP.String()}, listen)
+	if err != nil {
+		return nil, err
+	}
+	if selected == nil {
+		return nil, fmt.Errorf("no matched backends for request [%s]", listen)
+	}
+
+	log.Debugf("[UDP]: proxy redirecting datagrams [%s] -> [%s] -> [%s-%s]",
+		client, listen, selected.Backend.ID, selected.Addr(),
+	)
+	return selected, nil
+}
+
+// relay the replies of the backend back to the client until the session idle for the
+// timeout, then the session is closed and the next datagram of the client starts a new one.
+func (p *UDPProxyServer) relay(key string, s *udpSession) {
+	var (
+		err error
+		rt  time.Duration = -1 // time to the first reply, -1 if never replied
+		buf               = make([]byte, maxDatagramSize)
+	)
+
+	defer func() {
+		p.Lock()
+		if p.sessions[key] == s {
+			delete(p.sessions, key)
+		}
+		p.Unlock()
+		s.backend.Close()
+
+		var (
+			in  = atomic.LoadInt64(&s.in)
+			out = atomic.LoadInt64(&s.out)
+			ups = s.selected.Upstream.Name
+			bid = s.selected.Backend.ID
+		)
+
+		if err != nil {
+			log.Errorf("[UDP] proxy session %s error: %v, received:%d, transmitted:%d", key, err, in, out)
+			stats.Incr(nil, &stats.DeltaGlb{Rx: uint64(in), Tx: uint64(out), Req: 1, Fail: 1})
+		} else {
+			log.Printf("[UDP] proxy session %s closed: received:%d, transmitted:%d", key, in, out)
+			stats.Incr(nil, &stats.DeltaGlb{Rx: uint64(in), Tx: uint64(out), Req: 1})
+		}
+
+		_, unreachable := err.(*connectError)
+		upstream.Done(s.selected, rt)
+		upstream.Report(s.selected, !unreachable)
+		if rt < 0 {
+			stats.Incr(&stats.DeltaBackend{Uid: ups, Bid: bid, Ac: -1, Rx: uint64(in), Tx: uint64(out)}, nil) // disconnect
+			return
+		}
+		stats.Incr(&stats.DeltaBackend{Uid: ups, Bid: bid, Ac: -1, Rx: uint64(in), Tx: uint64(out), Rt: rt}, nil)
+		stats.Observe(ups, stats.LatencyFirstByte, rt)
+	}()
+
+	for {
+		s.backend.SetReadDeadline(s.deadline())
+
+		n, rerr := s.backend.Read(buf)
+		if rerr != nil {
+			if ne, ok := rerr.(net.Error); ok && ne.Timeout() {
+				if !p.expire(key, s) {
+					continue // the client sent meanwhile
+				}
+				return
+			}
+			if refused(rerr) {
+				upstream.Eject(s.selected, ejectDuration)
+				err = &connectError{s.selected.Addr(), rerr}
+				return
+			}
+			p.RLock()
+			if !p.stopped {
+				err = rerr
+			}
+			p.RUnlock()
+			return
+		}
+
+		if rt < 0 {
+			rt = time.Since(s.started)
+		}
+		s.touch()
+
+		if _, werr := p.conn.WriteToUDP(buf[:n], s.client); werr != nil {
+			err = werr
+			return
+		}
+		atomic.AddInt64(&s.out, int64(n))
+	}
+}
+
+// expire removes the session idle for the timeout, the datagrams arrived afterwards
+// start a new session.
+func (p *UDPProxyServer) expire(key string, s *udpSession) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	if time.Now().Before(s.deadline()) {
+		return false
+	}
+	delete(p.sessions, key)
+	return true
+}
+
+// udpSession is the relay between one client address and the selected backend
+type udpSession struct {
+	client   *net.UDPAddr
+	selected *upstream.BackendCombined
+	backend  *net.UDPConn // connected to the backend
+	timeout  time.Duration
+	started  time.Time
+	last     int64 // unix nano of the last activity, atomic
+	in       int64 // received bytes, atomic
+	out      int64 // transmitted bytes, atomic
+}
+
+func (s *udpSession) touch() {
+	atomic.StoreInt64(&s.last, time.Now().UnixNano())
+}
+
+func (s *udpSession) deadline() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.last)).Add(s.timeout)
+}
+
+func (s *udpSession) forward(b []byte) {
+	s.touch()
+
+	n, err := s.backend.Write(b)
+	if err != nil {
+		log.Errorf("[UDP] proxy forwarding to %s error: %v", s.selected.Addr(), err)
+		return
+	}
+	atomic.AddInt64(&s.in, int64(n))
+}
+
+// refused reports the backend port unreachable, reported by ICMP to the connected socket
+func refused(err error) bool {
+	if oe, ok := err.(*net.OpError); ok {
+		if se, ok := oe.Err.(*os.SyscallError); ok {
+			return se.Err == syscall.ECONNREFUSED
+		}
+	}
+	return false
+}
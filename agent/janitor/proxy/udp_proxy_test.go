@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+// serveUDPEcho replies each datagram prefixed by the id
+func serveUDPEcho(t *testing.T, id string) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP([]byte(id+":"+string(buf[:n])), addr)
+		}
+	}()
+	return conn
+}
+
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func exchangeUDP(t *testing.T, conn *net.UDPConn, msg string) string {
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("write error = %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read reply of %q error = %v", msg, err)
+	}
+	return string(buf[:n])
+}
+
+func TestUDPProxy(t *testing.T) {
+	var (
+		name = "udp.default.bbk.dataman"
+		port = freeUDPPort(t)
+		ups  = &upstream.Upstream{Name: name, Listen: ":" + strconv.Itoa(port), Protocol: upstream.ProtocolUDP, UDPTimeout: time.Millisecond * 200}
+		cmbs []*upstream.BackendCombined
+	)
+
+	for _, id := range []string{"a", "b"} {
+		echo := serveUDPEcho(t, id)
+		defer echo.Close()
+
+		addr := echo.LocalAddr().(*net.UDPAddr)
+		cmb := &upstream.BackendCombined{
+			Upstream: ups,
+			Backend:  &upstream.Backend{ID: id + "." + name, IP: "127.0.0.1", Port: uint64(addr.Port), Weight: 1},
+		}
+		if _, err := upstream.UpsertBackend(cmb); err != nil {
+			t.Fatal(err)
+		}
+		cmbs = append(cmbs, cmb)
+	}
+	defer func() {
+		for _, cmb := range cmbs {
+			upstream.RemoveBackend(cmb)
+		}
+	}()
+
+	p := NewUDPProxyServer("127.0.0.1:"+strconv.Itoa(port), 0)
+	if err := p.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve()
+	defer p.Stop()
+
+	dial := func() *net.UDPConn {
+		conn, err := net.DialUDP("udp", nil, p.conn.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	client := dial()
+	defer client.Close()
+
+	// the datagrams of the client stay on one backend, balanced otherwise
+	var pinned string
+	for i := 0; i < 6; i++ {
+		msg := "ping-" + strconv.Itoa(i)
+		reply := exchangeUDP(t, client, msg)
+
+		fields := strings.SplitN(reply, ":", 2)
+		if len(fields) != 2 || fields[1] != msg {
+			t.Fatalf("reply = %q, want echoed %q", reply, msg)
+		}
+		if pinned == "" {
+			pinned = fields[0]
+		} else if fields[0] != pinned {
+			t.Errorf("datagram %d went to %s, want pinned on %s", i, fields[0], pinned)
+		}
+	}
+
+	other := dial()
+	defer other.Close()
+	if reply := exchangeUDP(t, other, "hello"); !strings.HasSuffix(reply, ":hello") {
+		t.Errorf("reply of another client = %q, want echoed", reply)
+	}
+
+	p.RLock()
+	n := len(p.sessions)
+	p.RUnlock()
+	if n != 2 {
+		t.Errorf("sessions = %d, want 2", n)
+	}
+
+	// the idle sessions are closed after the timeout, the client is served by a new one
+	time.Sleep(time.Millisecond * 500)
+
+	p.RLock()
+	n = len(p.sessions)
+	p.RUnlock()
+	if n != 0 {
+		t.Errorf("sessions = %d after idle, want expired", n)
+	}
+
+	if reply := exchangeUDP(t, client, "again"); !strings.HasSuffix(reply, ":again") {
+		t.Errorf("reply after expired = %q, want echoed", reply)
+	}
+}
+
+func TestUDPProxyNoBackend(t *testing.T) {
+	p := NewUDPProxyServer("127.0.0.1:"+strconv.Itoa(freeUDPPort(t)), 0)
+	if err := p.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve()
+	defer p.Stop()
+
+	conn, err := net.DialUDP("udp", nil, p.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	if _, err := conn.Read(make([]byte, 16)); err == nil {
+		t.Error("replied without any backend, want dropped")
+	}
+
+	p.RLock()
+	defer p.RUnlock()
+	if len(p.sessions) != 0 {
+		t.Errorf("sessions = %d, want none", len(p.sessions))
+	}
+}
+
+func TestUDPProxyMaxSessions(t *testing.T) {
+	var (
+		name = "udpmax.default.bbk.dataman"
+		port = freeUDPPort(t)
+		ups  = &upstream.Upstream{Name: name, Listen: ":" + strconv.Itoa(port), Protocol: upstream.ProtocolUDP, UDPTimeout: time.Millisecond * 200}
+		echo = serveUDPEcho(t, "a")
+		cmb  = &upstream.BackendCombined{
+			Upstream: ups,
+			Backend:  &upstream.Backend{ID: "a." + name, IP: "127.0.0.1", Port: uint64(echo.LocalAddr().(*net.UDPAddr).Port), Weight: 1},
+		}
+	)
+	defer echo.Close()
+
+	if _, err := upstream.UpsertBackend(cmb); err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.RemoveBackend(cmb)
+
+	p := NewUDPProxyServer("127.0.0.1:"+strconv.Itoa(port), 2)
+	if err := p.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve()
+	defer p.Stop()
+
+	dial := func() *net.UDPConn {
+		conn, err := net.DialUDP("udp", nil, p.conn.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	first, second, third := dial(), dial(), dial()
+	defer first.Close()
+	defer second.Close()
+	defer third.Close()
+
+	for _, conn := range []*net.UDPConn{first, second} {
+		if reply := exchangeUDP(t, conn, "hello"); reply != "a:hello" {
+			t.Fatalf("reply = %q, want echoed", reply)
+		}
+	}
+
+	// the new client beyond the max sessions is dropped, the existing ones are still served
+	third.Write([]byte("hello"))
+	third.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	if _, err := third.Read(make([]byte, 16)); err == nil {
+		t.Error("replied beyond the max sessions, want dropped")
+	}
+	if reply := exchangeUDP(t, first, "again"); reply != "a:again" {
+		t.Errorf("reply of the existing session = %q, want echoed", reply)
+	}
+
+	p.RLock()
+	n := len(p.sessions)
+	p.RUnlock()
+	if n != 2 {
+		t.Errorf("sessions = %d, want 2 at most", n)
+	}
+
+	// served once the idle sessions expired
+	time.Sleep(time.Millisecond * 500)
+	if reply := exchangeUDP(t, third, "later"); reply != "a:later" {
+		t.Errorf("reply after the sessions expired = %q, want echoed", reply)
+	}
+}
@@ -0,0 +1,233 @@
+package janitor
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/proxy"
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+	"github.com/Dataman-Cloud/swan/config"
+)
+
+// ReloadResult reports the changed settings on reloading
+type ReloadResult struct {
+	Applied         []string `json:"applied"`          // settings applied to the live upstreams
+	RestartRequired []string `json:"restart_required"` // settings changed but only take effect after restart
+	Rebalanced      []string `json:"rebalanced"`       // upstreams switched to the new default balancer
+}
+
+// Reload applies the new janitor settings to the live upstreams without dropping connections,
+// the sessions and in-flight requests are kept. the listeners are replaced gracefully if the
+// listen addresses or tls settings changed. the settings can't change live (eg: domain) are
+// reported as restart required and stay unchanged until restart.
+func (s *JanitorServer) Reload(cfg *config.Janitor) (*ReloadResult, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	var (
+		cur = s.config
+		ret = &ReloadResult{
+			Applied:         []string{},
+			RestartRequired: []string{},
+			Rebalanced:      []string{},
+		}
+	)
+
+	// settings only take effect after restart
+	for name, changed := range map[string]bool{
+		"enabled":        cfg.Enabled != cur.Enabled,
+		"domain":         cfg.Domain != cur.Domain,
+		"advertiseIP":    cfg.AdvertiseIP != cur.AdvertiseIP,
+		"consulEnabled":  cfg.ConsulEnabled != cur.ConsulEnabled,
+		"consulAddr":     cfg.ConsulAddr != cur.ConsulAddr,
+		"etcdAddrs":      !reflect.DeepEqual(cfg.EtcdAddrs, cur.EtcdAddrs),
+		"etcdPrefix":     cfg.EtcdPrefix != cur.EtcdPrefix,
+		"proxyProtocol":  cfg.ProxyProtocol != cur.ProxyProtocol,
+		"udpMaxSessions": cfg.UDPMaxSessions != cur.UDPMaxSessions,
+	} {
+		if changed {
+			ret.RestartRequired = append(ret.RestartRequired, name)
+		}
+	}
+
+	// the listeners go first, nothing changed if failed, eg: the new address in use
+	relistened, err := s.relisten(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ret.Applied = append(ret.Applied, relistened...)
+
+	// settings applied live
+	if cfg.DrainTimeout != cur.DrainTimeout {
+		cur.DrainTimeout = cfg.DrainTimeout
+		ret.Applied = append(ret.Applied, "drainTimeout")
+	}
+
+	if cfg.NamingPolicy != cur.NamingPolicy {
+		cur.NamingPolicy = cfg.NamingPolicy
+		ret.Applied = append(ret.Applied, "namingPolicy")
+	}
+
+	if cfg.Balance != cur.Balance {
+		ret.Rebalanced = upstream.ReplaceBalance(cur.Balance, cfg.Balance)
+		cur.Balance = cfg.Balance
+		ret.Applied = append(ret.Applied, "balance")
+	}
+
+	if cfg.SlowStart != cur.SlowStart {
+		upstream.SetSlowStart(cfg.SlowStart)
+		cur.SlowStart = cfg.SlowStart
+		ret.Applied = append(ret.Applied, "slowStart")
+	}
+
+	for name, changed := range map[string]bool{
+		"poolMaxIdle":     cfg.PoolMaxIdle != cur.PoolMaxIdle,
+		"poolMaxConns":    cfg.PoolMaxConns != cur.PoolMaxConns,
+		"poolIdleTimeout": cfg.PoolIdleTimeout != cur.PoolIdleTimeout,
+	} {
+		if changed {
+			ret.Applied = append(ret.Applied, name)
+		}
+	}
+	cur.PoolMaxIdle, cur.PoolMaxConns, cur.PoolIdleTimeout = cfg.PoolMaxIdle, cfg.PoolMaxConns, cfg.PoolIdleTimeout
+	proxy.SetPoolOptions(proxy.PoolOptions{ // no-op if unchanged
+		MaxIdle:     cur.PoolMaxIdle,
+		MaxConns:    cur.PoolMaxConns,
+		IdleTimeout: cur.PoolIdleTimeout,
+	})
+
+	for name, changed := range map[string]bool{
+		"accessLog":      cfg.AccessLog != cur.AccessLog,
+		"accessLogSinks": !reflect.DeepEqual(cfg.AccessLogSinks, cur.AccessLogSinks),
+	} {
+		if changed {
+			ret.Applied = append(ret.Applied, name)
+		}
+	}
+	cur.AccessLog, cur.AccessLogSinks = cfg.AccessLog, cfg.AccessLogSinks
+	proxy.SetAccessLogs(proxy.AccessLogOptions{
+		Default: cur.AccessLog,
+		Sinks:   cur.AccessLogSinks,
+	})
+
+	sort.Strings(ret.Applied)
+	sort.Strings(ret.RestartRequired)
+
+	log.Printf("proxy configs reloaded, applied: %v, restart required: %v", ret.Applied, ret.RestartRequired)
+
+	return ret, nil
+}
+
+// relisten replaces the listeners of the changed listen addresses or tls settings without
+// dropping the established connections: the new server takes over the new connections, on
+// the same socket if the address unchanged, and the previous one drains within the drain
+// timeout. must be called under protection of mutex lock.
+func (s *JanitorServer) relisten(cfg *config.Janitor) ([]string, error) {
+	var (
+		cur      = s.config
+		changed  []string
+		httpd    = cfg.ListenAddr != cur.ListenAddr
+		httpdTLS bool
+	)
+
+	for _, v := range []struct {
+		name    string
+		changed bool
+	}{
+		{"listenAddr", httpd},
+		{"tlsListenAddr", cfg.TLSListenAddr != cur.TLSListenAddr},
+		{"tlsCertFile", cfg.TLSCertFile != cur.TLSCertFile},
+		{"tlsKeyFile", cfg.TLSKeyFile != cur.TLSKeyFile},
+		{"tlsCertDir", cfg.TLSCertDir != cur.TLSCertDir},
+	} {
+		if v.changed {
+			changed = append(changed, v.name)
+			httpdTLS = httpdTLS || v.name != "listenAddr"
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	next := *cur
+	next.ListenAddr, next.TLSListenAddr = cfg.ListenAddr, cfg.TLSListenAddr
+	next.TLSCertFile, next.TLSKeyFile, next.TLSCertDir = cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCertDir
+
+	// not started yet, the new servers serve on start
+	if s.httpl == nil {
+		if httpd {
+			s.httpd = newHTTPServer(&next)
+		}
+		if httpdTLS {
+			s.httpdTLS = newHTTPSServer(&next)
+		}
+		*cur = next
+		return changed, nil
+	}
+
+	// open the new sockets first
+	var (
+		httpl    = s.httpl
+		httplTLS = s.httplTLS
+	)
+	if httpd {
+		l, err := s.listen(next.ListenAddr)
+		if err != nil {
+			return nil, err
+		}
+		httpl = newHandoffListener(l)
+	}
+	if httpdTLS && next.TLSListenAddr != cur.TLSListenAddr {
+		httplTLS = nil
+		if next.TLSListenAddr != "" {
+			l, err := s.listen(next.TLSListenAddr)
+			if err != nil {
+				if httpl != s.httpl {
+					httpl.Close()
+				}
+				return nil, err
+			}
+			httplTLS = newHandoffListener(l)
+		}
+	}
+
+	if httpd {
+		srv := newHTTPServer(&next)
+		go s.serve(srv, httpl.handoff())
+		retire(s.httpd, s.httpl, httpl, cfg.DrainTimeout)
+		s.httpd, s.httpl = srv, httpl
+	}
+
+	if httpdTLS {
+		srv := newHTTPSServer(&next)
+		if srv != nil {
+			go s.serve(srv, httplTLS.handoff())
+		}
+		if s.httpdTLS != nil {
+			retire(s.httpdTLS, s.httplTLS, httplTLS, cfg.DrainTimeout)
+		}
+		s.httpdTLS, s.httplTLS = srv, httplTLS
+	}
+
+	*cur = next
+
+	log.Printf("proxy listeners replaced: %v, draining the previous ones", changed)
+
+	return changed, nil
+}
+
+// retire drains the replaced server, and closes its socket if not reused by the new one
+func retire(srv *http.Server, sock, reused *handoffListener, timeout time.Duration) {
+	if sock != reused {
+		sock.Close()
+	}
+	go drain(srv, timeout)
+}
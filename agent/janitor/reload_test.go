@@ -0,0 +1,238 @@
+package janitor
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/proxy"
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+	"github.com/Dataman-Cloud/swan/config"
+)
+
+func TestReload(t *testing.T) {
+	var (
+		cfg = &config.Janitor{ListenAddr: "0.0.0.0:80", Domain: "swan.com", Balance: upstream.BalancerWRR}
+		s   = NewJanitorServer(cfg)
+		a   = &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: "reload.default.bbk.dataman", Sticky: true},
+			Backend:  &upstream.Backend{ID: "a.reload.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 1},
+		}
+		b = &upstream.BackendCombined{
+			Upstream: &upstream.Upstream{Name: "reload.default.bbk.dataman", Sticky: true},
+			Backend:  &upstream.Backend{ID: "b.reload.default.bbk.dataman", IP: "192.168.1.102", Port: 31001, Weight: 1},
+		}
+		c = &upstream.BackendCombined{ // upserted after reloading
+			Upstream: &upstream.Upstream{Name: "reload.default.bbk.dataman", Sticky: true},
+			Backend:  &upstream.Backend{ID: "c.reload.default.bbk.dataman", IP: "192.168.1.103", Port: 31002, Weight: 1},
+		}
+	)
+
+	for _, cmb := range []*upstream.BackendCombined{a, b} {
+		if err := s.UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		s.RemoveBackend(a)
+		s.RemoveBackend(b)
+		s.RemoveBackend(c)
+	}()
+
+	u := upstream.GetUpstream(a.Upstream.Name)
+
+	pinned, err := upstream.Lookup(&upstream.Client{IP: "10.0.0.1"}, u, "")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+
+	// invalid settings are rejected as a whole
+	next := *cfg
+	next.Balance = "random"
+	if _, err := s.Reload(&next); err == nil {
+		t.Fatalf("Reload() with invalid balance expected error")
+	}
+
+	next = *cfg
+	next.Balance = upstream.BalancerSmoothWRR
+	next.ListenAddr = "0.0.0.0:8080"
+	next.Domain = "swan.io"
+
+	ret, err := s.Reload(&next)
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+
+	want := &ReloadResult{
+		Applied:         []string{"balance", "listenAddr"},
+		RestartRequired: []string{"domain"},
+		Rebalanced:      []string{a.Upstream.Name},
+	}
+	if !reflect.DeepEqual(ret, want) {
+		t.Errorf("Reload() = %+v, want %+v", ret, want)
+	}
+
+	if s.config.Domain != "swan.com" {
+		t.Errorf("domain = %s, should stay unchanged until restart", s.config.Domain)
+	}
+	if s.config.ListenAddr != "0.0.0.0:8080" || s.httpd.Addr != "0.0.0.0:8080" {
+		t.Errorf("listen addr = %s, want served on 0.0.0.0:8080 once started", s.config.ListenAddr)
+	}
+
+	// new selections are made by the new balancer, the existing session persists
+	if u.Balance != upstream.BalancerSmoothWRR {
+		t.Errorf("upstream balance = %s, want %s", u.Balance, upstream.BalancerSmoothWRR)
+	}
+
+	cmb, err := upstream.Lookup(&upstream.Client{IP: "10.0.0.1"}, u, "")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if cmb.Backend.ID != pinned.Backend.ID {
+		t.Errorf("sticky client got %s, want %s", cmb.Backend.ID, pinned.Backend.ID)
+	}
+
+	// the backends upserted afterwards follow the new default balancer as well
+	if err := s.UpsertBackend(c); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	if u.Balance != upstream.BalancerSmoothWRR {
+		t.Errorf("upstream balance after upsert = %s, want %s", u.Balance, upstream.BalancerSmoothWRR)
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestReloadListenAddr(t *testing.T) {
+	defer proxy.SetPoolOptions(proxy.PoolOptions{MaxIdle: 32, IdleTimeout: time.Second * 90})
+
+	var (
+		arrived = make(chan struct{})
+		release = make(chan struct{})
+	)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(arrived)
+			<-release
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer backend.Close()
+
+	host, p, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	port, _ := strconv.Atoi(p)
+
+	var (
+		name       = "handoff.default.bbk.dataman"
+		prev, next = freeAddr(t), freeAddr(t)
+		cfg        = &config.Janitor{ListenAddr: prev, Domain: "swan.com", PoolMaxIdle: 32, PoolIdleTimeout: time.Second * 90, DrainTimeout: time.Second * 5}
+		s          = NewJanitorServer(cfg)
+	)
+
+	cmb := &upstream.BackendCombined{
+		Upstream: &upstream.Upstream{Name: name, Alias: name},
+		Backend:  &upstream.Backend{ID: "0." + name, IP: host, Port: uint64(port), Scheme: "http", Weight: 100},
+	}
+	if err := s.UpsertBackend(cmb); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	defer s.RemoveBackend(cmb)
+
+	startErr := make(chan error, 1)
+	go func() { startErr <- s.Start() }()
+
+	get := func(addr, path string) (string, error) {
+		client := &http.Client{Timeout: time.Second * 5, Transport: &http.Transport{DisableKeepAlives: true}}
+		req, _ := http.NewRequest("GET", "http://"+addr+path, nil)
+		req.Host = name
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	for i := 0; ; i++ {
+		if _, err := get(prev, "/"); err == nil {
+			break
+		} else if i > 100 {
+			t.Fatalf("proxy not serving on %s: %v", prev, err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// an active connection on the previous listener
+	slow := make(chan string, 1)
+	go func() {
+		body, err := get(prev, "/slow")
+		if err != nil {
+			body = err.Error()
+		}
+		slow <- body
+	}()
+	<-arrived
+
+	reloaded := *cfg
+	reloaded.ListenAddr = next
+	ret, err := s.Reload(&reloaded)
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if !reflect.DeepEqual(ret.Applied, []string{"listenAddr"}) || len(ret.RestartRequired) != 0 {
+		t.Errorf("Reload() = %+v, want listenAddr applied", ret)
+	}
+
+	// the new connections go to the new listener
+	if body, err := get(next, "/new"); err != nil || body != "/new" {
+		t.Errorf("request on the new listener got %q, %v", body, err)
+	}
+	if _, err := net.DialTimeout("tcp", prev, time.Second); err == nil {
+		t.Errorf("dial the previous listener succeeded, want closed")
+	}
+
+	// the established one finishes
+	close(release)
+	select {
+	case body := <-slow:
+		if body != "/slow" {
+			t.Errorf("active request on the previous listener got %q, want finished", body)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("active request on the previous listener not finished")
+	}
+
+	// binding an address in use changes nothing
+	inuse, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inuse.Close()
+
+	reloaded.ListenAddr = inuse.Addr().String()
+	if _, err := s.Reload(&reloaded); err == nil {
+		t.Error("Reload() onto an address in use succeeded, want error")
+	}
+	if body, err := get(next, "/kept"); err != nil || body != "/kept" {
+		t.Errorf("request after the failed reload got %q, %v", body, err)
+	}
+
+	select {
+	case err := <-startErr:
+		t.Errorf("Start() returned %v by the reload, want still serving", err)
+	default:
+	}
+}
@@ -0,0 +1,87 @@
+package janitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+// saveSessions writes the sticky sessions of all upstreams to the file, through a temporary
+// file renamed at last so that a crash never leaves a partial one.
+func saveSessions(file string) error {
+	data, err := json.Marshal(upstream.SessionsSnapshot())
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+// loadSessions restores the sticky sessions from the file onto the rebuilt upstreams,
+// nothing to restore if the file not exists.
+func loadSessions(file string) (restored, pruned int, err error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+
+	var snap map[string]map[string]*upstream.SessionRecord
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, 0, err
+	}
+
+	restored, pruned = upstream.RestoreSessions(snap)
+	return restored, pruned, nil
+}
+
+// persistSessions restores the sticky sessions saved by the previous run, then saves them
+// periodically. it should be started after the upstreams are rebuilt by the full sync.
+func (s *JanitorServer) persistSessions() {
+	var (
+		file     = s.config.SessionsFile
+		interval = s.config.SessionsInterval
+	)
+
+	restored, pruned, err := loadSessions(file)
+	if err != nil {
+		log.Errorf("restore sticky sessions from %s error: %v", file, err)
+	} else {
+		log.Printf("restored %d sticky sessions from %s, %d stale pruned", restored, file, pruned)
+	}
+
+	if interval <= 0 {
+		interval = time.Second * 30
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := saveSessions(file); err != nil {
+			log.Errorf("save sticky sessions to %s error: %v", file, err)
+		}
+	}
+}
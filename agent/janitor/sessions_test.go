@@ -0,0 +1,174 @@
+package janitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+	"github.com/Dataman-Cloud/swan/config"
+)
+
+func TestSessionsPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		s    = NewJanitorServer(&config.Janitor{})
+		file = filepath.Join(dir, "sessions.json")
+		ups  = &upstream.Upstream{Name: "nginx.default.bbk.dataman", Alias: "g.cn", Sticky: true}
+		a    = &upstream.BackendCombined{Upstream: ups, Backend: &upstream.Backend{ID: "0.nginx.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 100}}
+		b    = &upstream.BackendCombined{Upstream: ups, Backend: &upstream.Backend{ID: "1.nginx.default.bbk.dataman", IP: "192.168.1.102", Port: 31001, Weight: 100}}
+	)
+
+	cleanup := func() {
+		for _, cmb := range upstream.Flatten(upstream.Snapshot()) {
+			s.RemoveBackend(cmb)
+		}
+	}
+	defer cleanup()
+
+	for _, cmb := range []*upstream.BackendCombined{a, b} {
+		if err := s.UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend(%s) error = %v", cmb, err)
+		}
+	}
+
+	// nothing saved yet
+	if restored, pruned, err := loadSessions(file); err != nil || restored != 0 || pruned != 0 {
+		t.Fatalf("loadSessions() without file = %d %d %v, want nothing", restored, pruned, err)
+	}
+
+	pinned := make(map[string]string) // client ip -> backend id
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		cmb, err := upstream.Lookup(&upstream.Client{IP: ip}, upstream.GetUpstream(ups.Name), "")
+		if err != nil {
+			t.Fatalf("Lookup(%s) error = %v", ip, err)
+		}
+		pinned[ip] = cmb.Backend.ID
+	}
+
+	if err := saveSessions(file); err != nil {
+		t.Fatalf("saveSessions() error = %v", err)
+	}
+
+	// restarted, while the backend b is gone after the upstreams rebuilt
+	cleanup()
+	if err := s.UpsertBackend(a); err != nil {
+		t.Fatalf("UpsertBackend(%s) error = %v", a, err)
+	}
+
+	restored, pruned, err := loadSessions(file)
+	if err != nil {
+		t.Fatalf("loadSessions() error = %v", err)
+	}
+	if restored != 2 || pruned != 2 {
+		t.Errorf("loadSessions() restored %d pruned %d, want 2 & 2", restored, pruned)
+	}
+
+	got := upstream.SessionsSnapshot()[upstream.SessionsKey(ups.Name, ups.Target)]
+	if len(got) != 2 {
+		t.Fatalf("restored sessions = %v, want 2", got)
+	}
+	for ip, backend := range pinned {
+		rec, ok := got[ip]
+		if backend == a.Backend.ID && (!ok || rec.Backend != backend) {
+			t.Errorf("session of %s = %+v, want restored on %s", ip, rec, backend)
+		}
+		if backend != a.Backend.ID && ok {
+			t.Errorf("session of %s = %+v, want pruned with the stale backend", ip, rec)
+		}
+	}
+
+	// the restored sessions are honored
+	for ip, backend := range pinned {
+		if backend != a.Backend.ID {
+			continue
+		}
+		cmb, _ := upstream.Lookup(&upstream.Client{IP: ip}, upstream.GetUpstream(ups.Name), "")
+		if cmb.Backend.ID != backend {
+			t.Errorf("client %s got %s, want sticky to %s", ip, cmb.Backend.ID, backend)
+		}
+	}
+}
+
+func TestSessionsPersistenceTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the multi-port app, each task is the backend of both upstreams
+	var (
+		s     = NewJanitorServer(&config.Janitor{})
+		file  = filepath.Join(dir, "sessions.json")
+		web   = &upstream.Upstream{Name: "multi.default.bbk.dataman", Target: "80", Sticky: true}
+		admin = &upstream.Upstream{Name: "multi.default.bbk.dataman", Target: "8080", Sticky: true}
+		cmbs  = []*upstream.BackendCombined{
+			{Upstream: web, Backend: &upstream.Backend{ID: "0.multi.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 100}},
+			{Upstream: web, Backend: &upstream.Backend{ID: "1.multi.default.bbk.dataman", IP: "192.168.1.102", Port: 31000, Weight: 100}},
+			{Upstream: admin, Backend: &upstream.Backend{ID: "0.multi.default.bbk.dataman", IP: "192.168.1.101", Port: 31001, Weight: 100}},
+			{Upstream: admin, Backend: &upstream.Backend{ID: "1.multi.default.bbk.dataman", IP: "192.168.1.102", Port: 31001, Weight: 100}},
+		}
+	)
+
+	upsert := func() {
+		for _, cmb := range cmbs {
+			if err := s.UpsertBackend(cmb); err != nil {
+				t.Fatalf("UpsertBackend(%s) error = %v", cmb, err)
+			}
+		}
+	}
+	cleanup := func() {
+		for _, cmb := range upstream.Flatten(upstream.Snapshot()) {
+			s.RemoveBackend(cmb)
+		}
+	}
+	defer cleanup()
+	upsert()
+
+	pinned := make(map[string]map[string]string) // target -> client ip -> backend id
+	for _, u := range []*upstream.Upstream{web, admin} {
+		pinned[u.Target] = make(map[string]string)
+		for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+			cmb, err := upstream.Lookup(&upstream.Client{IP: ip}, upstream.GetUpstreamByTarget(u.Name, u.Target), "")
+			if err != nil {
+				t.Fatalf("Lookup(%s, %s) error = %v", u.Target, ip, err)
+			}
+			pinned[u.Target][ip] = cmb.Backend.ID
+		}
+	}
+
+	if err := saveSessions(file); err != nil {
+		t.Fatalf("saveSessions() error = %v", err)
+	}
+
+	cleanup()
+	upsert()
+
+	restored, pruned, err := loadSessions(file)
+	if err != nil {
+		t.Fatalf("loadSessions() error = %v", err)
+	}
+	if restored != 8 || pruned != 0 {
+		t.Errorf("loadSessions() restored %d pruned %d, want 8 & 0", restored, pruned)
+	}
+
+	// each target gets its own sessions back
+	for _, u := range []*upstream.Upstream{web, admin} {
+		if got := upstream.SessionsSnapshot()[upstream.SessionsKey(u.Name, u.Target)]; len(got) != 4 {
+			t.Errorf("restored sessions of target %s = %v, want 4", u.Target, got)
+		}
+		for ip, backend := range pinned[u.Target] {
+			cmb, _ := upstream.Lookup(&upstream.Client{IP: ip}, upstream.GetUpstreamByTarget(u.Name, u.Target), "")
+			if cmb.Backend.ID != backend {
+				t.Errorf("client %s on target %s got %s, want sticky to %s", ip, u.Target, cmb.Backend.ID, backend)
+			}
+		}
+	}
+}
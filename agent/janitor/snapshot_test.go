@@ -0,0 +1,141 @@
+package janitor
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+	"github.com/Dataman-Cloud/swan/config"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	var (
+		s   = NewJanitorServer(&config.Janitor{})
+		ups = &upstream.Upstream{Name: "nginx.default.bbk.dataman", Alias: "g.cn", Sticky: true}
+		web = &upstream.Upstream{Name: "web.default.bbk.dataman", Alias: "web.g.cn"}
+	)
+
+	for _, cmb := range []*upstream.BackendCombined{
+		{Upstream: ups, Backend: &upstream.Backend{ID: "0.nginx.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 100}},
+		{Upstream: ups, Backend: &upstream.Backend{ID: "1.nginx.default.bbk.dataman", IP: "192.168.1.102", Port: 31001, Weight: 50}},
+		{Upstream: web, Backend: &upstream.Backend{ID: "0.web.default.bbk.dataman", IP: "192.168.1.103", Port: 31002, Weight: 100}},
+	} {
+		if err := s.UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend(%s) error = %v", cmb, err)
+		}
+	}
+
+	// export through json, then drop everything
+	data, err := json.Marshal(upstream.Snapshot())
+	if err != nil {
+		t.Fatalf("marshal snapshot error = %v", err)
+	}
+
+	for _, cmb := range upstream.Flatten(upstream.Snapshot()) {
+		s.RemoveBackend(cmb)
+	}
+	if n := len(upstream.AllUpstreams()); n != 0 {
+		t.Fatalf("upstreams not cleaned up, got %d", n)
+	}
+
+	var snap []*upstream.Upstream
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatalf("unmarshal snapshot error = %v", err)
+	}
+
+	if err := s.Restore(snap); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	defer func() {
+		for _, cmb := range upstream.Flatten(upstream.Snapshot()) {
+			s.RemoveBackend(cmb)
+		}
+	}()
+
+	if got := upstream.Snapshot(); !reflect.DeepEqual(got, snap) {
+		gotData, _ := json.Marshal(got)
+		t.Errorf("restored snapshot = %s, want %s", gotData, data)
+	}
+
+	// the balancers & sessions stores are rebuilt
+	for _, name := range []string{ups.Name, web.Name} {
+		u := upstream.GetUpstream(name)
+		if u == nil {
+			t.Fatalf("upstream %s not restored", name)
+		}
+		if cmb, err := upstream.Lookup(&upstream.Client{IP: "127.0.0.1"}, u, ""); err != nil || cmb == nil {
+			t.Errorf("upstream %s lookup got no backend: %v", name, err)
+		}
+	}
+	if sess := upstream.AllSessions(); sess[ups.Name] == nil {
+		t.Errorf("upstream %s sessions store not restored", ups.Name)
+	}
+}
+
+func TestRestoreConflicts(t *testing.T) {
+	s := NewJanitorServer(&config.Janitor{})
+
+	existing := &upstream.BackendCombined{
+		Upstream: &upstream.Upstream{Name: "nginx.default.bbk.dataman", Alias: "g.cn"},
+		Backend:  &upstream.Backend{ID: "0.nginx.default.bbk.dataman", IP: "192.168.1.101", Port: 31000},
+	}
+	if err := s.UpsertBackend(existing); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	defer func() {
+		for _, cmb := range upstream.Flatten(upstream.Snapshot()) {
+			s.RemoveBackend(cmb)
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		snap    []*upstream.Upstream
+		wantErr bool
+	}{
+		{
+			name: "update existing upstream",
+			snap: []*upstream.Upstream{
+				{Name: "nginx.default.bbk.dataman", Alias: "g.cn", Backends: []*upstream.Backend{
+					{ID: "1.nginx.default.bbk.dataman", IP: "192.168.1.102", Port: 31001},
+				}},
+			},
+		},
+		{
+			name: "alias conflict with existing upstream",
+			snap: []*upstream.Upstream{
+				{Name: "web.default.bbk.dataman", Alias: "g.cn", Backends: []*upstream.Backend{
+					{ID: "0.web.default.bbk.dataman", IP: "192.168.1.103", Port: 31002},
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "alias conflict within snapshot",
+			snap: []*upstream.Upstream{
+				{Name: "web.default.bbk.dataman", Alias: "web.g.cn", Backends: []*upstream.Backend{
+					{ID: "0.web.default.bbk.dataman", IP: "192.168.1.103", Port: 31002},
+				}},
+				{Name: "api.default.bbk.dataman", Alias: "web.g.cn", Backends: []*upstream.Backend{
+					{ID: "0.api.default.bbk.dataman", IP: "192.168.1.104", Port: 31003},
+				}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.Restore(tt.snap)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Restore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// nothing applied on conflicts
+			if tt.wantErr && len(upstream.AllUpstreams()) != 1 {
+				t.Errorf("upstreams changed on conflicts: %d", len(upstream.AllUpstreams()))
+			}
+		})
+	}
+}
@@ -9,16 +9,20 @@ var (
 	stats         *Stats
 	rateFreshIntv = time.Second * 2  // rate calculation interval
 	gcIntv        = time.Second * 10 // interval to scan & clean up removal-marked backend counter
+	rtAlpha       = 0.3              // smoothing factor of the response time moving average
 )
 
 func init() {
 	stats = &Stats{
 		Global:       &GlobalCounter{startedAt: time.Now()},
 		Upstream:     make(UpstreamCounter),
+		Latency:      make(LatencyCounter),
 		inGlbCh:      make(chan *DeltaGlb, 1024),
 		inBackendCh:  make(chan *DeltaBackend, 1024),
+		inLatencyCh:  make(chan *DeltaLatency, 1024),
 		delBackendCh: make(chan *DeltaBackend, 128),
 		queryCh:      make(chan chan Stats),
+		percentileCh: make(chan *percentileQuery),
 	}
 
 	go stats.runCounters()
@@ -28,11 +32,14 @@ func init() {
 type Stats struct {
 	Global   *GlobalCounter  `json:"global"`   // global counter
 	Upstream UpstreamCounter `json:"upstream"` // upstream -> backend -> counter
+	Latency  LatencyCounter  `json:"latency"`  // upstream -> kind -> latency histogram
 
 	inGlbCh      chan *DeltaGlb     // new global counter delta received
 	inBackendCh  chan *DeltaBackend // new upstream/backend counter delta received
+	inLatencyCh  chan *DeltaLatency // new upstream latency observed
 	delBackendCh chan *DeltaBackend // removal signal upstream->backend counter delta
 	queryCh      chan chan Stats
+	percentileCh chan *percentileQuery // query the latency percentile of an upstream
 }
 
 // GlobalCounter hold current global statistics
@@ -73,13 +80,14 @@ type UpstreamCounter map[string]map[string]*BackendCounter
 
 // BackendCounter hold one upstream-backend's current statistics
 type BackendCounter struct {
-	ActiveClients uint   `json:"active_clients"` // active clients
-	RxBytes       uint64 `json:"rx_bytes"`       // nb of received bytes
-	TxBytes       uint64 `json:"tx_bytes"`       // nb of transmitted bytes
-	Requests      uint64 `json:"requests"`       // nb of requests
-	RxRate        uint   `json:"rx_rate"`        // received bytes / second
-	TxRate        uint   `json:"tx_rate"`        // transmitted bytes / second
-	ReqRate       uint   `json:"requests_rate"`  // requests / second
+	ActiveClients uint    `json:"active_clients"`   // active clients
+	RxBytes       uint64  `json:"rx_bytes"`         // nb of received bytes
+	TxBytes       uint64  `json:"tx_bytes"`         // nb of transmitted bytes
+	Requests      uint64  `json:"requests"`         // nb of requests
+	RxRate        uint    `json:"rx_rate"`          // received bytes / second
+	TxRate        uint    `json:"tx_rate"`          // transmitted bytes / second
+	ReqRate       uint    `json:"requests_rate"`    // requests / second
+	ResponseTime  float64 `json:"response_time_ms"` // moving average of response time in milliseconds
 
 	lastRx  uint64 // used for calculate rate per second
 	lastTx  uint64
@@ -111,6 +119,7 @@ type DeltaBackend struct {
 	Rx  uint64
 	Tx  uint64
 	Req uint64
+	Rt  time.Duration // observed response time, 0 if not observed
 }
 
 type DeltaGlb struct {
@@ -162,10 +171,16 @@ func (c *Stats) runCounters() {
 			c.updateBackend(d)
 		case d := <-c.inGlbCh:
 			c.updateGlb(d)
+		case d := <-c.inLatencyCh:
+			c.updateLatency(d)
 		case d := <-c.delBackendCh:
 			c.removeBackend(d)
+		case q := <-c.percentileCh:
+			q.ret <- c.Latency.percentile(q.uid, q.kind, q.q)
 		case ch := <-c.queryCh:
-			ch <- *c
+			cp := *c
+			cp.Latency = c.Latency.copy() // the histograms keep updating
+			ch <- cp
 		}
 	}
 }
@@ -254,6 +269,7 @@ func (c *Stats) gc() {
 
 		if len(ups) == 0 {
 			delete(c.Upstream, uid)
+			delete(c.Latency, uid)
 		}
 	}
 }
@@ -302,6 +318,14 @@ func (c *Stats) updateBackend(d *DeltaBackend) {
 	if n := d.Req; n > 0 {
 		backend.Requests += n
 	}
+	if rt := d.Rt; rt > 0 {
+		ms := float64(rt) / float64(time.Millisecond)
+		if backend.ResponseTime == 0 {
+			backend.ResponseTime = ms
+		} else {
+			backend.ResponseTime = rtAlpha*ms + (1-rtAlpha)*backend.ResponseTime
+		}
+	}
 
 	backend.freshed = true
 }
@@ -0,0 +1,173 @@
+package stats
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// the kinds of the latency observed by the proxies
+const (
+	LatencyConnect   = "connect"    // time to connect to the backend, by the tcp proxy
+	LatencyFirstByte = "first_byte" // time to the first response byte, by the http proxy
+)
+
+// the upper bounds of the latency buckets in milliseconds, the observations
+// beyond the last one are counted by an extra bucket.
+var latencyBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// LatencyCounter holds the latency histograms of the upstreams: upstream -> kind -> histogram,
+// the backends of an upstream share the histograms to keep the cardinality bounded.
+type LatencyCounter map[string]map[string]*Histogram
+
+func (c LatencyCounter) copy() LatencyCounter {
+	ret := make(LatencyCounter, len(c))
+	for uid, kinds := range c {
+		m := make(map[string]*Histogram, len(kinds))
+		for kind, h := range kinds {
+			cp := *h
+			cp.counts = append([]uint64(nil), h.counts...)
+			m[kind] = &cp
+		}
+		ret[uid] = m
+	}
+	return ret
+}
+
+// Histogram is the latency distribution within the fixed buckets
+type Histogram struct {
+	count  uint64
+	sum    float64  // milliseconds
+	counts []uint64 // nb of observations within each of the buckets
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{
+		counts: make([]uint64, len(latencyBuckets)+1),
+	}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+
+	i := 0
+	for i < len(latencyBuckets) && ms > latencyBuckets[i] {
+		i++
+	}
+
+	h.counts[i]++
+	h.count++
+	h.sum += ms
+}
+
+// Percentile returns the estimated q-quantile (0-1) of the latency in milliseconds, which is
+// interpolated linearly within the bucket it falls in. 0 if nothing observed.
+func (h *Histogram) Percentile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	var (
+		rank  = q * float64(h.count)
+		cum   float64
+		lower float64
+	)
+
+	for i, n := range h.counts {
+		if i == len(latencyBuckets) {
+			return lower // beyond the last bound, no upper to interpolate
+		}
+
+		upper := latencyBuckets[i]
+		if n > 0 && cum+float64(n) >= rank {
+			return lower + (upper-lower)*(rank-cum)/float64(n)
+		}
+
+		cum += float64(n)
+		lower = upper
+	}
+
+	return lower
+}
+
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	buckets := make(map[string]uint64, len(h.counts))
+	for i, n := range h.counts {
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = strconv.FormatFloat(latencyBuckets[i], 'f', -1, 64)
+		}
+		buckets[le] = n
+	}
+
+	var mean float64
+	if h.count > 0 {
+		mean = h.sum / float64(h.count)
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"count":   h.count,
+		"mean_ms": mean,
+		"p50_ms":  h.Percentile(0.5),
+		"p90_ms":  h.Percentile(0.9),
+		"p99_ms":  h.Percentile(0.99),
+		"buckets": buckets, // upper bound in milliseconds -> nb of observations within
+	})
+}
+
+type DeltaLatency struct {
+	Uid  string
+	Kind string
+	D    time.Duration
+}
+
+// Observe feeds the latency of the kind observed on the upstream, ignored if not positive
+func Observe(ups, kind string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	stats.inLatencyCh <- &DeltaLatency{Uid: ups, Kind: kind, D: d}
+}
+
+type percentileQuery struct {
+	uid  string
+	kind string
+	q    float64
+	ret  chan float64
+}
+
+// Percentile returns the estimated q-quantile (0-1) of the latency of the kind observed on the
+// upstream, 0 if nothing observed. it's cheap to query per request rather than copying by Get.
+func Percentile(ups, kind string, q float64) time.Duration {
+	query := &percentileQuery{uid: ups, kind: kind, q: q, ret: make(chan float64, 1)}
+	stats.percentileCh <- query
+	return time.Duration(<-query.ret * float64(time.Millisecond))
+}
+
+func (c LatencyCounter) percentile(uid, kind string, q float64) float64 {
+	h, ok := c[uid][kind]
+	if !ok {
+		return 0
+	}
+	return h.Percentile(q)
+}
+
+func (c *Stats) updateLatency(d *DeltaLatency) {
+	if d.Uid == "" {
+		return
+	}
+
+	kinds, ok := c.Latency[d.Uid]
+	if !ok {
+		kinds = make(map[string]*Histogram)
+		c.Latency[d.Uid] = kinds
+	}
+
+	h, ok := kinds[d.Kind]
+	if !ok {
+		h = newHistogram()
+		kinds[d.Kind] = h
+	}
+
+	h.observe(d.D)
+}
@@ -0,0 +1,107 @@
+package stats
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	tests := []struct {
+		name string
+		obs  []time.Duration
+		q    float64
+		want float64 // milliseconds
+	}{
+		{name: "nothing observed", q: 0.5, want: 0},
+		{name: "single bucket", obs: []time.Duration{time.Millisecond * 3, time.Millisecond * 4}, q: 0.5, want: 3.5},
+		{
+			name: "p90 of uniform",
+			obs:  repeat(map[time.Duration]int{time.Millisecond * 8: 50, time.Millisecond * 80: 40, time.Millisecond * 800: 10}),
+			q:    0.9,
+			want: 100,
+		},
+		{
+			name: "p99 within the slow tail",
+			obs:  repeat(map[time.Duration]int{time.Millisecond * 8: 98, time.Millisecond * 800: 2}),
+			q:    0.99,
+			want: 750,
+		},
+		{name: "beyond the last bucket", obs: []time.Duration{time.Minute}, q: 0.99, want: 10000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHistogram()
+			for _, d := range tt.obs {
+				h.observe(d)
+			}
+			if got := h.Percentile(tt.q); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Percentile(%v) = %v, want %v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func repeat(m map[time.Duration]int) []time.Duration {
+	var ret []time.Duration
+	for d, n := range m {
+		for i := 0; i < n; i++ {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+func TestObserve(t *testing.T) {
+	ups := "latency.default.bbk.dataman" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	for i := 1; i <= 100; i++ {
+		Observe(ups, LatencyFirstByte, time.Duration(i)*time.Millisecond)
+	}
+	Observe(ups, LatencyConnect, time.Millisecond*3)
+	Observe(ups, LatencyConnect, -1) // not observed
+
+	// the observations are counted asynchronously
+	var s *Stats
+	for i := 0; i < 100; i++ {
+		s = Get()
+		if c, fb := s.Latency[ups][LatencyConnect], s.Latency[ups][LatencyFirstByte]; c != nil && fb != nil && fb.count == 100 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	fb := s.Latency[ups][LatencyFirstByte]
+	if fb == nil || fb.count != 100 {
+		t.Fatalf("first byte histogram = %+v, want 100 observed", fb)
+	}
+	if p50 := fb.Percentile(0.5); p50 < 25 || p50 > 50 {
+		t.Errorf("first byte p50 = %v, want within the 25-50ms bucket", p50)
+	}
+	if p99 := fb.Percentile(0.99); p99 < 50 || p99 > 100 {
+		t.Errorf("first byte p99 = %v, want within the 50-100ms bucket", p99)
+	}
+
+	if c := s.Latency[ups][LatencyConnect]; c == nil || c.count != 1 || c.counts[2] != 1 {
+		t.Errorf("connect histogram = %+v, want one within the 2-5ms bucket", c)
+	}
+
+	data, err := json.Marshal(s.Latency[ups])
+	if err != nil {
+		t.Fatalf("marshal latency error = %v", err)
+	}
+	var got map[string]struct {
+		Count   uint64            `json:"count"`
+		P99     float64           `json:"p99_ms"`
+		Buckets map[string]uint64 `json:"buckets"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal latency %s error = %v", data, err)
+	}
+	if v := got[LatencyConnect]; v.Count != 1 || v.Buckets["5"] != 1 || v.Buckets["+Inf"] != 0 {
+		t.Errorf("connect latency json = %s, want one within the 5ms bucket", data)
+	}
+}
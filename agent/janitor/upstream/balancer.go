@@ -13,6 +13,34 @@ type Balancer interface {
 	Next([]*Backend) *Backend
 }
 
+// balancer names
+const (
+	BalancerWRR               = "wrr"            // weighted round-robin (default)
+	BalancerSmoothWRR         = "swrr"           // smooth weighted round-robin
+	BalancerLeastTime         = "leasttime"      // least response time
+	BalancerLeastTimeConn     = "leasttime_conn" // least response time weighted by active connections
+	BalancerWeightedLeastConn = "wlc"            // least active connections per weight
+)
+
+// newBalancer returns the balancer by name, any unknown name falls back to the default one.
+func newBalancer(name string) Balancer {
+	switch name {
+	case BalancerSmoothWRR:
+		return newSwrrBalancer()
+	case BalancerLeastTime:
+		return newLeastTimeBalancer(false)
+	case BalancerLeastTimeConn:
+		return newLeastTimeBalancer(true)
+	case BalancerWeightedLeastConn:
+		return newWeightedLeastConnBalancer()
+	default:
+		return &wrrBalancer{
+			index: -1,
+			cw:    0,
+		}
+	}
+}
+
 type rrBalancer struct {
 	current int
 }
@@ -40,9 +68,9 @@ func (b *weightBalancer) Next(bs []*Backend) *Backend {
 
 	ranges := []float64{0}
 	sum := float64(0)
-	for _, t := range bs {
-		ranges = append(ranges, sum+t.Weight*100)
-		sum += t.Weight * 100
+	for _, w := range effectiveWeights(bs) {
+		ranges = append(ranges, sum+w*100)
+		sum += w * 100
 	}
 
 	rValue := rand.Float64() * sum
@@ -54,3 +82,22 @@ func (b *weightBalancer) Next(bs []*Backend) *Backend {
 
 	return nil
 }
+
+// ReplaceBalance switches the upstreams balanced by `from` to the balancer `to` at once,
+// the sessions are kept so the sticky clients stay on their backends, only the new
+// selections are made by the new balancer. returns the names of the switched upstreams.
+func ReplaceBalance(from, to string) []string {
+	mgr.Lock()
+	defer mgr.Unlock()
+
+	ret := make([]string, 0)
+	for _, u := range mgr.Upstreams {
+		if u.Balance != from || from == to {
+			continue
+		}
+		u.Balance = to
+		u.balancer = newBalancer(to)
+		ret = append(ret, u.Name)
+	}
+	return ret
+}
@@ -0,0 +1,451 @@
+package upstream
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSmoothWRRBalancer(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends []*Backend
+		want     string // selection sequence of backend ids
+	}{
+		{
+			name: "5:1:1",
+			backends: []*Backend{
+				{ID: "a", Weight: 5},
+				{ID: "b", Weight: 1},
+				{ID: "c", Weight: 1},
+			},
+			want: "aabacaa" + "aabacaa",
+		},
+		{
+			name: "5:1",
+			backends: []*Backend{
+				{ID: "a", Weight: 5},
+				{ID: "b", Weight: 1},
+			},
+			want: "aaabaa",
+		},
+		{
+			name: "equal",
+			backends: []*Backend{
+				{ID: "a", Weight: 100},
+				{ID: "b", Weight: 100},
+				{ID: "c", Weight: 100},
+			},
+			want: "abcabc",
+		},
+		{
+			name: "zero weight skipped",
+			backends: []*Backend{
+				{ID: "a", Weight: 1},
+				{ID: "b", Weight: 0},
+			},
+			want: "aaa",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBalancer(BalancerSmoothWRR)
+
+			got := make([]string, 0, len(tt.want))
+			for range tt.want {
+				next := b.Next(tt.backends)
+				if next == nil {
+					t.Fatalf("Next() got nil backend")
+				}
+				got = append(got, next.ID)
+			}
+
+			if seq := strings.Join(got, ""); seq != tt.want {
+				t.Errorf("selection sequence = %s, want %s", seq, tt.want)
+			}
+		})
+	}
+}
+
+func TestSmoothWRRBalancerConcurrency(t *testing.T) {
+	var (
+		b        = newBalancer(BalancerSmoothWRR)
+		backends = []*Backend{{ID: "a", Weight: 5}, {ID: "b", Weight: 1}, {ID: "c", Weight: 1}}
+		counts   = make(map[string]int)
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+	)
+
+	for i := 0; i < 7; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id := b.Next(backends).ID
+				mu.Lock()
+				counts[id]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 700 selections are exact 100 rounds of the 5:1:1 weighting
+	if counts["a"] != 500 || counts["b"] != 100 || counts["c"] != 100 {
+		t.Errorf("selection counts = %v, want a:500 b:100 c:100", counts)
+	}
+}
+
+func TestSmoothWRRBalancerRemoved(t *testing.T) {
+	var (
+		b = newSwrrBalancer()
+		a = &Backend{ID: "a", Weight: 1}
+		c = &Backend{ID: "c", Weight: 3}
+	)
+
+	b.Next([]*Backend{a, c})
+	b.Next([]*Backend{a})
+
+	if _, ok := b.current["c"]; ok {
+		t.Errorf("state of removed backend should be dropped")
+	}
+}
+
+func TestLeastTimeBalancer(t *testing.T) {
+	var (
+		a = &Backend{ID: "a", Weight: 1}
+		b = &Backend{ID: "b", Weight: 1}
+		c = &Backend{ID: "c", Weight: 1}
+	)
+
+	tests := []struct {
+		name     string
+		byConns  bool
+		backends []*Backend
+		feed     map[string][]time.Duration // backend id -> synthetic latencies
+		active   map[string]int             // backend id -> nb of in flight requests
+		want     string
+	}{
+		{
+			name:     "lowest ewma",
+			backends: []*Backend{a, b},
+			feed: map[string][]time.Duration{
+				"a": {time.Millisecond * 50, time.Millisecond * 40},
+				"b": {time.Millisecond * 10, time.Millisecond * 20},
+			},
+			want: "b",
+		},
+		{
+			name:     "ewma follows recent latencies",
+			backends: []*Backend{a, b},
+			feed: map[string][]time.Duration{
+				"a": {time.Millisecond * 10, time.Millisecond * 100, time.Millisecond * 100, time.Millisecond * 100},
+				"b": {time.Millisecond * 50, time.Millisecond * 50},
+			},
+			want: "b",
+		},
+		{
+			name:     "new backend probed",
+			backends: []*Backend{a, b, c},
+			feed: map[string][]time.Duration{
+				"a": {time.Millisecond * 10},
+				"b": {time.Millisecond * 20},
+			},
+			want: "c",
+		},
+		{
+			name:     "ignore active connections",
+			backends: []*Backend{a, b},
+			feed: map[string][]time.Duration{
+				"a": {time.Millisecond * 10},
+				"b": {time.Millisecond * 15},
+			},
+			active: map[string]int{"a": 3},
+			want:   "a",
+		},
+		{
+			name:     "weighted by active connections",
+			byConns:  true,
+			backends: []*Backend{a, b},
+			feed: map[string][]time.Duration{
+				"a": {time.Millisecond * 10},
+				"b": {time.Millisecond * 15},
+			},
+			active: map[string]int{"a": 3},
+			want:   "b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := newLeastTimeBalancer(tt.byConns)
+			for id, rts := range tt.feed {
+				for _, rt := range rts {
+					lb.begin(id)
+					lb.done(id, rt)
+				}
+			}
+			for id, n := range tt.active {
+				for i := 0; i < n; i++ {
+					lb.begin(id)
+				}
+			}
+
+			for i := 0; i < 3; i++ { // stable regardless of the round-robin offset
+				if got := lb.Next(tt.backends); got == nil || got.ID != tt.want {
+					t.Fatalf("Next() = %v, want %s", got, tt.want)
+				}
+				if tt.want == "c" {
+					break // probed only once
+				}
+			}
+		})
+	}
+}
+
+func TestLeastTimeBalancerSlowStart(t *testing.T) {
+	var (
+		lb = newLeastTimeBalancer(false)
+		a  = &Backend{ID: "a", Weight: 1}
+		b  = &Backend{ID: "b", Weight: 1}
+		bs = []*Backend{a, b}
+	)
+
+	lb.begin("a")
+	lb.done("a", time.Millisecond*10)
+
+	// only one probing request for the new backend at a time
+	if got := lb.Next(bs); got.ID != "b" {
+		t.Fatalf("first Next() = %s, want the new backend b", got.ID)
+	}
+	for i := 0; i < 5; i++ {
+		if got := lb.Next(bs); got.ID != "a" {
+			t.Fatalf("Next() while probing = %s, want a", got.ID)
+		}
+	}
+
+	// the probe turns out faster
+	lb.done("b", time.Millisecond*5)
+	if got := lb.Next(bs); got.ID != "b" {
+		t.Errorf("Next() after probed = %s, want b", got.ID)
+	}
+}
+
+func TestWeightedLeastConnBalancer(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends []*Backend
+		active   map[string]int // active connections before selecting
+		want     string
+	}{
+		{
+			name:     "idle heavier first",
+			backends: []*Backend{{ID: "a", Weight: 1}, {ID: "b", Weight: 3}},
+			want:     "b",
+		},
+		{
+			name:     "least per weight",
+			backends: []*Backend{{ID: "a", Weight: 1}, {ID: "b", Weight: 3}},
+			active:   map[string]int{"a": 1, "b": 4},
+			want:     "a", // 1/1 < 4/3
+		},
+		{
+			name:     "heavier takes more",
+			backends: []*Backend{{ID: "a", Weight: 1}, {ID: "b", Weight: 3}},
+			active:   map[string]int{"a": 1, "b": 2},
+			want:     "b", // 2/3 < 1/1
+		},
+		{
+			name:     "tie by the heavier",
+			backends: []*Backend{{ID: "a", Weight: 1}, {ID: "b", Weight: 2}},
+			active:   map[string]int{"a": 1, "b": 2},
+			want:     "b",
+		},
+		{
+			name:     "tie by the order",
+			backends: []*Backend{{ID: "a", Weight: 2}, {ID: "b", Weight: 2}, {ID: "c", Weight: 2}},
+			active:   map[string]int{"a": 1, "b": 0, "c": 0},
+			want:     "b",
+		},
+		{
+			name:     "draining skipped",
+			backends: []*Backend{{ID: "a", Weight: 0}, {ID: "b", Weight: 1}},
+			active:   map[string]int{"b": 10},
+			want:     "b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBalancer(BalancerWeightedLeastConn)
+			o := b.(observer)
+			for id, n := range tt.active {
+				for i := 0; i < n; i++ {
+					o.begin(id)
+				}
+			}
+
+			if got := b.Next(tt.backends); got == nil || got.ID != tt.want {
+				t.Errorf("Next() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// the connections are spread by the weights 1:3 while held
+	var (
+		b        = newBalancer(BalancerWeightedLeastConn)
+		backends = []*Backend{{ID: "a", Weight: 1}, {ID: "b", Weight: 3}}
+		got      = make(map[string]int)
+	)
+	for i := 0; i < 8; i++ {
+		next := b.Next(backends)
+		b.(observer).begin(next.ID)
+		got[next.ID]++
+	}
+	if got["a"] != 2 || got["b"] != 6 {
+		t.Errorf("held connections = %v, want a 2 and b 6", got)
+	}
+
+	// the finished ones are released
+	for i := 0; i < 6; i++ {
+		b.(observer).done("b", time.Millisecond)
+	}
+	if next := b.Next(backends); next.ID != "b" {
+		t.Errorf("Next() after released = %s, want b", next.ID)
+	}
+}
+
+func TestSlowStart(t *testing.T) {
+	SetSlowStart(time.Second * 10)
+	defer SetSlowStart(0)
+
+	share := func(balance string, elapsed time.Duration) float64 {
+		var (
+			lb    = newBalancer(balance)
+			old   = &Backend{ID: "old", Weight: 100}
+			fresh = &Backend{ID: "fresh", Weight: 100, addedAt: time.Now().Add(-elapsed)}
+			bs    = []*Backend{old, fresh}
+			hits  int
+		)
+
+		for i := 0; i < 1000; i++ {
+			if lb.Next(bs).ID == "fresh" {
+				hits++
+			}
+		}
+		return float64(hits) / 1000
+	}
+
+	for _, balance := range []string{BalancerWRR, BalancerSmoothWRR} {
+		t.Run(balance, func(t *testing.T) {
+			var (
+				begin = share(balance, 0)
+				mid   = share(balance, time.Second*5)
+				after = share(balance, time.Second*20)
+			)
+
+			if begin <= 0 || begin > 0.05 {
+				t.Errorf("share at the beginning = %.3f, want near-zero but positive", begin)
+			}
+			if mid <= begin || mid >= after {
+				t.Errorf("share in the middle = %.3f, want between %.3f and %.3f", mid, begin, after)
+			}
+			if after != 0.5 {
+				t.Errorf("share after the window = %.3f, want 0.5", after)
+			}
+		})
+	}
+}
+
+func TestEffectiveWeight(t *testing.T) {
+	SetSlowStart(time.Second * 10)
+	defer SetSlowStart(0)
+
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		backend *Backend
+		want    float64
+	}{
+		{name: "not ramping", backend: &Backend{Weight: 100}, want: 100},
+		{name: "just added", backend: &Backend{Weight: 100, addedAt: now}, want: 1},
+		{name: "half way", backend: &Backend{Weight: 100, addedAt: now.Add(-time.Second * 5)}, want: 50},
+		{name: "after window", backend: &Backend{Weight: 100, addedAt: now.Add(-time.Minute)}, want: 100},
+		{name: "small weight", backend: &Backend{Weight: 0.5, addedAt: now}, want: 0.5},
+		{name: "zero weight", backend: &Backend{Weight: 0, addedAt: now}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.backend.effectiveWeight(now); got != tt.want {
+				t.Errorf("effectiveWeight() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceBalance(t *testing.T) {
+	var (
+		ups = &Upstream{Name: "reload.default.bbk.dataman", Sticky: true, Balance: BalancerWRR}
+		a   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "a.reload.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 1}}
+		b   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "b.reload.default.bbk.dataman", IP: "192.168.1.102", Port: 31001, Weight: 1}}
+	)
+
+	for _, cmb := range []*BackendCombined{a, b} {
+		if _, err := UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		RemoveBackend(a)
+		RemoveBackend(b)
+	}()
+
+	u := GetUpstream(ups.Name)
+
+	pinned, err := Lookup(&Client{IP: "10.0.0.1"}, u, "")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+
+	if got := ReplaceBalance(BalancerLeastTime, BalancerSmoothWRR); len(got) != 0 {
+		t.Errorf("ReplaceBalance() from unused balancer switched %v", got)
+	}
+
+	got := ReplaceBalance(BalancerWRR, BalancerSmoothWRR)
+	if len(got) != 1 || got[0] != ups.Name {
+		t.Fatalf("ReplaceBalance() = %v, want [%s]", got, ups.Name)
+	}
+
+	if _, ok := u.balancer.(*swrrBalancer); !ok || u.Balance != BalancerSmoothWRR {
+		t.Fatalf("balancer = %s %T, want swrr", u.Balance, u.balancer)
+	}
+
+	// the existing session persists
+	for i := 0; i < 5; i++ {
+		cmb, err := Lookup(&Client{IP: "10.0.0.1"}, u, "")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if cmb.Backend.ID != pinned.Backend.ID {
+			t.Errorf("#%d sticky client got %s, want %s", i, cmb.Backend.ID, pinned.Backend.ID)
+		}
+	}
+
+	// the new clients are balanced by the new balancer: a, b alternately for equal weights
+	seen := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		cmb, err := Lookup(&Client{IP: "10.0.1." + strconv.Itoa(i)}, u, "")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		seen[cmb.Backend.ID]++
+	}
+	if seen[a.Backend.ID] != 2 || seen[b.Backend.ID] != 2 {
+		t.Errorf("new clients distribution = %v, want 2 on each", seen)
+	}
+}
@@ -0,0 +1,177 @@
+package upstream
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// the default max bytes transferred at once by the throttled copy
+var defaultBandwidthBurst int64 = 32 * 1024
+
+// Bandwidth is the setup of the bytes/sec transferred between the clients and the backends of
+// an upstream, shared by all of its connections, eg: to protect the shared links.
+type Bandwidth struct {
+	Request  int64 `json:"request"`  // bytes/sec of the request bodies toward the backends, 0 for unlimited
+	Response int64 `json:"response"` // bytes/sec of the responses toward the clients, 0 for unlimited
+	Burst    int64 `json:"burst"`    // max bytes transferred at once, default 32KB
+}
+
+func (bw *Bandwidth) valid() error {
+	if bw == nil {
+		return nil
+	}
+	if bw.Request < 0 || bw.Response < 0 || bw.Burst < 0 {
+		return errors.New("upstream bandwidth should not be negative")
+	}
+	return nil
+}
+
+func (bw *Bandwidth) equal(o *Bandwidth) bool {
+	if bw == nil || o == nil {
+		return bw == o
+	}
+	return *bw == *o
+}
+
+func (bw *Bandwidth) burst() int64 {
+	if bw.Burst > 0 {
+		return bw.Burst
+	}
+	return defaultBandwidthBurst
+}
+
+// throttles are the runtime token buckets of the upstream bandwidth
+type throttles struct {
+	request  *Throttle // nil for unlimited
+	response *Throttle // nil for unlimited
+}
+
+func newThrottles(bw *Bandwidth) *throttles {
+	if bw == nil {
+		return nil
+	}
+	return &throttles{
+		request:  newThrottle(bw.Request, bw.burst()),
+		response: newThrottle(bw.Response, bw.burst()),
+	}
+}
+
+// Throttle is the token bucket on the byte stream, the tokens are reserved by each piece of
+// the stream and the caller sleeps out of the lock for the reserved ones, so the concurrent
+// streams share the rate fairly and never block each other while waiting.
+type Throttle struct {
+	sync.Mutex
+	rate   float64 // bytes/sec
+	burst  int64
+	tokens float64
+	last   time.Time
+}
+
+func newThrottle(rate, burst int64) *Throttle {
+	if rate <= 0 {
+		return nil
+	}
+	return &Throttle{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n tokens and returns how long to wait until they're available
+func (t *Throttle) reserve(n int) time.Duration {
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > float64(t.burst) {
+		t.tokens = float64(t.burst)
+	}
+	t.last = now
+
+	t.tokens -= float64(n)
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+func (t *Throttle) wait(n int) {
+	if d := t.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Reader wraps the reader to be rate limited, the reader itself if unlimited
+func (t *Throttle) Reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &throttledReader{r, t}
+}
+
+// Writer wraps the writer to be rate limited, the writer itself if unlimited. each piece
+// within the burst is written once its tokens available, eg: flushed to the streaming client.
+func (t *Throttle) Writer(w io.Writer) io.Writer {
+	if t == nil {
+		return w
+	}
+	return &throttledWriter{w, t}
+}
+
+type throttledReader struct {
+	r io.Reader
+	t *Throttle
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > tr.t.burst {
+		p = p[:tr.t.burst]
+	}
+	n, err := tr.r.Read(p)
+	if n > 0 {
+		tr.t.wait(n)
+	}
+	return n, err
+}
+
+type throttledWriter struct {
+	w io.Writer
+	t *Throttle
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		piece := p
+		if int64(len(piece)) > tw.t.burst {
+			piece = piece[:tw.t.burst]
+		}
+
+		tw.t.wait(len(piece))
+
+		n, err := tw.w.Write(piece)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Throttles returns the bandwidth throttles of the upstream's request bodies and responses,
+// nil for unlimited, which are nil safe to wrap the readers & writers.
+func Throttles(u *Upstream) (request, response *Throttle) {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	if u == nil || u.throttles == nil {
+		return nil, nil
+	}
+	return u.throttles.request, u.throttles.response
+}
@@ -0,0 +1,115 @@
+package upstream
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottleRate(t *testing.T) {
+	const (
+		rate  = 1024 * 1024 // 1MB/s
+		burst = 64 * 1024
+		size  = 512 * 1024
+	)
+
+	// the burst passes at once, the rest at the rate
+	want := time.Duration(float64(size-burst) / rate * float64(time.Second))
+
+	tests := []struct {
+		name string
+		copy func(th *Throttle, src io.Reader, dst io.Writer) (int64, error)
+	}{
+		{
+			name: "reader",
+			copy: func(th *Throttle, src io.Reader, dst io.Writer) (int64, error) {
+				return io.Copy(dst, th.Reader(src))
+			},
+		},
+		{
+			name: "writer",
+			copy: func(th *Throttle, src io.Reader, dst io.Writer) (int64, error) {
+				return io.Copy(th.Writer(dst), src)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newThrottle(rate, burst)
+
+			start := time.Now()
+			n, err := tt.copy(th, bytes.NewReader(make([]byte, size)), ioutil.Discard)
+			elapsed := time.Since(start)
+
+			if err != nil || n != size {
+				t.Fatalf("copied %d error = %v, want %d", n, err, size)
+			}
+			if elapsed < want*8/10 || elapsed > want*2 {
+				t.Errorf("copied in %s, want about %s", elapsed, want)
+			}
+		})
+	}
+}
+
+func TestThrottleShared(t *testing.T) {
+	const (
+		rate  = 1024 * 1024
+		burst = 32 * 1024
+		size  = 128 * 1024
+		n     = 4
+	)
+
+	// the concurrent streams share the rate and never block each other
+	var (
+		th    = newThrottle(rate, burst)
+		wg    sync.WaitGroup
+		start = time.Now()
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(th.Writer(ioutil.Discard), bytes.NewReader(make([]byte, size)))
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("concurrent throttled streams not finished")
+	}
+
+	want := time.Duration(float64(n*size-burst) / rate * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < want*8/10 {
+		t.Errorf("copied in %s, want at least about %s", elapsed, want)
+	}
+}
+
+func TestThrottleUnlimited(t *testing.T) {
+	var (
+		th  = newThrottle(0, defaultBandwidthBurst)
+		src = bytes.NewReader(nil)
+		dst = &bytes.Buffer{}
+	)
+	if th != nil {
+		t.Fatalf("throttle = %+v, want nil for unlimited", th)
+	}
+	if th.Reader(src) != io.Reader(src) || th.Writer(dst) != io.Writer(dst) {
+		t.Error("unlimited throttle wrapped the reader or writer")
+	}
+
+	bw := &Bandwidth{Request: -1}
+	if err := bw.valid(); err == nil {
+		t.Error("negative bandwidth valid, want error")
+	}
+}
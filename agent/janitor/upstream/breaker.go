@@ -0,0 +1,252 @@
+package upstream
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// the states of the backend circuit
+const (
+	CircuitClosed   = "closed"    // passing the traffic
+	CircuitOpen     = "open"      // no traffic until the cooldown elapsed
+	CircuitHalfOpen = "half-open" // a single probe passes, closed on its success or reopened on its failure
+)
+
+// the default time the circuit stays open before probing
+var defaultBreakerCooldown = time.Second * 10
+
+// Breaker is the setup of the circuit breaker on each backend of an upstream, which goes beyond
+// the ejection: the circuit opens after the consecutive failures and takes no traffic, then it
+// moves to half-open after the cooldown to pass a single probe request, closed on the probe's
+// success or reopened on its failure.
+type Breaker struct {
+	Failures int           `json:"failures"` // consecutive failures to open the circuit
+	Cooldown time.Duration `json:"cooldown"` // time the circuit stays open before probing, default 10s
+}
+
+func (br *Breaker) valid() error {
+	if br == nil {
+		return nil
+	}
+	if br.Failures <= 0 {
+		return errors.New("upstream breaker failures should be positive")
+	}
+	if br.Cooldown < 0 {
+		return errors.New("upstream breaker cooldown should not be negative")
+	}
+	return nil
+}
+
+func (br *Breaker) cooldown() time.Duration {
+	if br.Cooldown > 0 {
+		return br.Cooldown
+	}
+	return defaultBreakerCooldown
+}
+
+// circuit is the runtime breaker state of a backend, nil for closed without any failure
+type circuit struct {
+	sync.Mutex
+	state    string    // empty for closed
+	failures int       // consecutive failures while closed
+	openedAt time.Time // the last time opened
+	probedAt time.Time // the half-open probe in flight since, zero if none
+}
+
+func (c *circuit) current() string {
+	if c.state == "" {
+		return CircuitClosed
+	}
+	return c.state
+}
+
+// probing reports whether the half-open probe is in flight, the probe never reported
+// (eg: rejected by the upstream limit before proxied) is given up after the cooldown.
+func (c *circuit) probing(now time.Time, cooldown time.Duration) bool {
+	return !c.probedAt.IsZero() && now.Before(c.probedAt.Add(cooldown))
+}
+
+// passes reports whether the circuit could take a request, without claiming the probe
+func (c *circuit) passes(now time.Time, cooldown time.Duration) bool {
+	if c == nil {
+		return true
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	switch c.current() {
+	case CircuitOpen:
+		return !now.Before(c.openedAt.Add(cooldown))
+	case CircuitHalfOpen:
+		return !c.probing(now, cooldown)
+	}
+	return true
+}
+
+// claim takes the circuit for the selected request, which is the probe if the circuit
+// is due to half-open. false if open, or the probe already claimed by another request.
+func (c *circuit) claim(now time.Time, cooldown time.Duration) bool {
+	if c == nil {
+		return true
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	switch c.current() {
+	case CircuitOpen:
+		if now.Before(c.openedAt.Add(cooldown)) {
+			return false
+		}
+		c.state = CircuitHalfOpen
+	case CircuitHalfOpen:
+		if c.probing(now, cooldown) {
+			return false
+		}
+	default:
+		return true
+	}
+
+	c.probedAt = now
+	return true
+}
+
+// report the result of a request, returns the states before & after
+func (c *circuit) report(ok bool, threshold int, now time.Time) (string, string) {
+	c.Lock()
+	defer c.Unlock()
+
+	from := c.current()
+	switch from {
+	case CircuitClosed:
+		if ok {
+			c.failures = 0
+			break
+		}
+		if c.failures++; c.failures >= threshold {
+			c.state, c.openedAt = CircuitOpen, now
+		}
+	case CircuitHalfOpen:
+		if ok {
+			c.state, c.failures = CircuitClosed, 0
+		} else {
+			c.state, c.openedAt = CircuitOpen, now
+		}
+		c.probedAt = time.Time{}
+	}
+	// the ones admitted before opened are ignored while open
+
+	return from, c.current()
+}
+
+// passing filters out the backends the circuit open, must be called under protection of mutex lock
+func (u *Upstream) passing(bs []*Backend, now time.Time) []*Backend {
+	if u.Breaker == nil {
+		return bs
+	}
+
+	ret := make([]*Backend, 0, len(bs))
+	for _, b := range bs {
+		if b.circuit.passes(now, u.Breaker.cooldown()) {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
+
+// claim the circuit of the selected backend, must be called under protection of mutex lock
+func (u *Upstream) claim(b *Backend, now time.Time) bool {
+	if u.Breaker == nil {
+		return true
+	}
+	return b.circuit.claim(now, u.Breaker.cooldown())
+}
+
+// admit is the same as claim but for the backend out of the balancing, eg: the sticky session
+func (u *Upstream) admit(b *Backend, now time.Time) bool {
+	mgr.RLock()
+	defer mgr.RUnlock()
+	return u.claim(b, now)
+}
+
+// Report the result of the request proxied to the selected backend to its circuit breaker,
+// ok is false if the request failed, eg: connecting to the backend failed.
+func Report(cmb *BackendCombined, ok bool) {
+	mgr.Lock()
+	if cmb == nil || cmb.Upstream == nil || cmb.Backend == nil || cmb.Upstream.Breaker == nil {
+		mgr.Unlock()
+		return
+	}
+
+	var (
+		threshold = cmb.Upstream.Breaker.Failures
+		c         = cmb.Backend.circuit
+	)
+	if c == nil {
+		c = new(circuit)
+		cmb.Backend.circuit = c
+	}
+	mgr.Unlock()
+
+	if from, to := c.report(ok, threshold, time.Now()); from != to {
+		log.Warnf("upstream %s backend %s circuit %s -> %s", cmb.Upstream.Name, cmb.Backend.ID, from, to)
+	}
+}
+
+// BreakerStatus is the circuit of a backend
+type BreakerStatus struct {
+	State    string     `json:"state"`               // closed / open / half-open
+	Failures int        `json:"failures"`            // consecutive failures while closed
+	OpenedAt *time.Time `json:"opened_at,omitempty"` // the last time opened, nil if never
+}
+
+// BreakerStats returns the circuits of the upstreams with the breaker set, by upstream & backend
+func BreakerStats() map[string]map[string]*BreakerStatus {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	ret := make(map[string]map[string]*BreakerStatus)
+	for _, u := range mgr.Upstreams {
+		if u.Breaker == nil {
+			continue
+		}
+
+		m := make(map[string]*BreakerStatus, len(u.Backends))
+		for _, b := range u.Backends {
+			m[b.ID] = b.circuit.status()
+		}
+		ret[u.Name] = m
+	}
+	return ret
+}
+
+func (c *circuit) status() *BreakerStatus {
+	if c == nil {
+		return &BreakerStatus{State: CircuitClosed}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	st := &BreakerStatus{State: c.current(), Failures: c.failures}
+	if !c.openedAt.IsZero() {
+		openedAt := c.openedAt
+		st.OpenedAt = &openedAt
+	}
+	return st
+}
+
+// without returns a copy of the backends without the excluded one, matched by the id
+func without(bs []*Backend, exclude *Backend) []*Backend {
+	ret := make([]*Backend, 0, len(bs))
+	for _, b := range bs {
+		if b.ID != exclude.ID {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
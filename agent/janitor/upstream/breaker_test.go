@@ -0,0 +1,149 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerStates(t *testing.T) {
+	const cooldown = time.Millisecond * 100
+
+	var (
+		ups = &Upstream{Name: "breaker.default.bbk.dataman", Breaker: &Breaker{Failures: 3, Cooldown: cooldown}}
+		a   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "a", IP: "192.168.1.101", Port: 31000, Weight: 1}}
+		b   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "b", IP: "192.168.1.102", Port: 31000, Weight: 1}}
+	)
+	for _, cmb := range []*BackendCombined{a, b} {
+		if _, err := UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		RemoveBackend(a)
+		RemoveBackend(b)
+	}()
+
+	var (
+		u    = GetUpstream(ups.Name)
+		live = &BackendCombined{Upstream: u, Backend: GetBackend(u, "a")}
+	)
+
+	state := func() string {
+		return BreakerStats()[ups.Name]["a"].State
+	}
+
+	// selections of a within n lookups
+	selections := func(n int) int {
+		var got int
+		for i := 0; i < n; i++ {
+			cmb, err := Lookup(&Client{IP: "10.0.0.1"}, u, "")
+			if err != nil {
+				t.Fatalf("Lookup() error = %v", err)
+			}
+			if cmb.Backend.ID == "a" {
+				got++
+			}
+		}
+		return got
+	}
+
+	// closed: the failures below the threshold keep passing
+	Report(live, false)
+	Report(live, false)
+	if got := state(); got != CircuitClosed {
+		t.Fatalf("state = %s after 2 failures, want closed", got)
+	}
+	if got := selections(10); got == 0 {
+		t.Error("a never selected while closed")
+	}
+
+	// a success resets the consecutive failures
+	Report(live, true)
+	Report(live, false)
+	Report(live, false)
+	if st := BreakerStats()[ups.Name]["a"]; st.State != CircuitClosed || st.Failures != 2 {
+		t.Fatalf("status = %+v, want closed with 2 failures", st)
+	}
+
+	// open: no traffic
+	Report(live, false)
+	if got := state(); got != CircuitOpen {
+		t.Fatalf("state = %s after 3 failures, want open", got)
+	}
+	if got := selections(10); got != 0 {
+		t.Errorf("a selected %d times while open, want none", got)
+	}
+
+	// half-open: a single probe after the cooldown, reopened on its failure
+	time.Sleep(cooldown)
+	if got := selections(10); got != 1 {
+		t.Fatalf("a selected %d times after the cooldown, want the single probe", got)
+	}
+	if got := state(); got != CircuitHalfOpen {
+		t.Fatalf("state = %s while probing, want half-open", got)
+	}
+
+	Report(live, false)
+	if got := state(); got != CircuitOpen {
+		t.Fatalf("state = %s after the probe failed, want open", got)
+	}
+	if got := selections(10); got != 0 {
+		t.Errorf("a selected %d times after reopened, want none", got)
+	}
+
+	// closed on the probe's success
+	time.Sleep(cooldown)
+	if got := selections(10); got != 1 {
+		t.Fatalf("a selected %d times after the cooldown, want the single probe", got)
+	}
+	Report(live, true)
+	if st := BreakerStats()[ups.Name]["a"]; st.State != CircuitClosed || st.Failures != 0 || st.OpenedAt == nil {
+		t.Fatalf("status = %+v after the probe succeeded, want closed", st)
+	}
+	if got := selections(10); got < 3 {
+		t.Errorf("a selected %d times after closed, want balanced", got)
+	}
+}
+
+func TestBreakerSession(t *testing.T) {
+	var (
+		ups = &Upstream{Name: "breaker-sticky.default.bbk.dataman", Sticky: true, Breaker: &Breaker{Failures: 1, Cooldown: time.Minute}}
+		a   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "a", IP: "192.168.1.101", Port: 31000, Weight: 1}}
+		b   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "b", IP: "192.168.1.102", Port: 31000, Weight: 1}}
+	)
+	for _, cmb := range []*BackendCombined{a, b} {
+		if _, err := UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		RemoveBackend(a)
+		RemoveBackend(b)
+	}()
+
+	u := GetUpstream(ups.Name)
+	pinned, err := Lookup(&Client{IP: "10.0.0.1"}, u, "")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+
+	// the session on the open circuit is skipped
+	Report(pinned, false)
+	cmb, err := Lookup(&Client{IP: "10.0.0.1"}, u, "")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if cmb.Backend.ID == pinned.Backend.ID {
+		t.Errorf("Lookup() = %s, want the other one than the open circuit", cmb.Backend.ID)
+	}
+
+	// none passes
+	Report(cmb, false)
+	if _, err := Lookup(&Client{IP: "10.0.0.2"}, u, ""); err != ErrNoHealthyBackends {
+		t.Errorf("Lookup() error = %v, want %v", err, ErrNoHealthyBackends)
+	}
+
+	if err := (&Upstream{Name: "bad", Breaker: &Breaker{}}).valid(); err == nil {
+		t.Error("breaker without failures threshold valid, want error")
+	}
+}
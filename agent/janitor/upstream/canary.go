@@ -0,0 +1,78 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// ErrUpstreamNotFound is returned by the runtime setup if no such upstream
+var ErrUpstreamNotFound = errors.New("no such upstream")
+
+// Canary is the traffic split between the canary version and the other backends of an upstream,
+// adjusted at runtime to nudge the canary traffic without redeploying.
+type Canary struct {
+	Version string  `json:"version"` // version of the canary backends
+	Percent float64 `json:"percent"` // 0-100, percentage of the new sessions routed to the canary backends
+}
+
+func (c *Canary) valid() error {
+	if c == nil {
+		return nil
+	}
+	if c.Version == "" {
+		return errors.New("canary version required")
+	}
+	if !(c.Percent >= 0 && c.Percent <= 100) { // NaN excluded
+		return fmt.Errorf("canary percent [%v] invalid, should be 0-100", c.Percent)
+	}
+	return nil
+}
+
+// split picks either the canary or the other backends by the percentage, all of the backends
+// are kept if either side has none available, eg: the canary ones are all unhealthy.
+func (c *Canary) split(bs []*Backend) []*Backend {
+	if c == nil {
+		return bs
+	}
+
+	var canary, others []*Backend
+	for _, b := range bs {
+		if b.Version == c.Version {
+			canary = append(canary, b)
+		} else {
+			others = append(others, b)
+		}
+	}
+
+	if len(canary) == 0 || len(others) == 0 {
+		return bs
+	}
+	if rand.Float64()*100 < c.Percent {
+		return canary
+	}
+	return others
+}
+
+// SetCanary updates the canary traffic split of the upstreams by name, nil to remove the split.
+// it takes effect for the new sessions immediately, the existing sticky sessions are kept.
+func SetCanary(name string, c *Canary) error {
+	if err := c.valid(); err != nil {
+		return err
+	}
+
+	mgr.Lock()
+	defer mgr.Unlock()
+
+	var found bool
+	for _, u := range mgr.Upstreams {
+		if u.Name == name {
+			u.Canary = c
+			found = true
+		}
+	}
+	if !found {
+		return ErrUpstreamNotFound
+	}
+	return nil
+}
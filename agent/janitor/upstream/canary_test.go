@@ -0,0 +1,145 @@
+package upstream
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCanarySplit(t *testing.T) {
+	var (
+		ups = &Upstream{Name: "canary.default.bbk.dataman"}
+		bs  = []*Backend{
+			{ID: "v1-0", IP: "192.168.1.101", Port: 31000, Weight: 1, Version: "v1"},
+			{ID: "v1-1", IP: "192.168.1.102", Port: 31000, Weight: 1, Version: "v1"},
+			{ID: "v2-0", IP: "192.168.1.103", Port: 31000, Weight: 1, Version: "v2"},
+		}
+	)
+	for _, b := range bs {
+		if _, err := UpsertBackend(&BackendCombined{Upstream: ups, Backend: b}); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		for _, b := range bs {
+			RemoveBackend(&BackendCombined{Upstream: ups, Backend: b})
+		}
+	}()
+
+	const n = 2000
+
+	tests := []struct {
+		percent float64
+		want    float64 // expected ratio of the canary
+	}{
+		{percent: 0, want: 0},
+		{percent: 10, want: 0.1},
+		{percent: 50, want: 0.5},
+		{percent: 100, want: 1},
+	}
+
+	u := GetUpstream(ups.Name)
+	for _, tt := range tests {
+		if err := SetCanary(ups.Name, &Canary{Version: "v2", Percent: tt.percent}); err != nil {
+			t.Fatalf("SetCanary() error = %v", err)
+		}
+
+		var canary int
+		for i := 0; i < n; i++ {
+			cmb, err := Lookup(&Client{IP: "10.0.0.1"}, u, "")
+			if err != nil {
+				t.Fatalf("Lookup() error = %v", err)
+			}
+			if cmb.Backend.Version == "v2" {
+				canary++
+			}
+		}
+
+		if got := float64(canary) / n; math.Abs(got-tt.want) > 0.05 {
+			t.Errorf("canary percent %v got ratio %.3f, want about %.2f", tt.percent, got, tt.want)
+		}
+	}
+
+	// removed, balanced evenly on all of the backends
+	if err := SetCanary(ups.Name, nil); err != nil {
+		t.Fatalf("SetCanary() error = %v", err)
+	}
+	if u.Canary != nil {
+		t.Errorf("canary = %+v after removed, want nil", u.Canary)
+	}
+}
+
+func TestCanaryKeepSessions(t *testing.T) {
+	var (
+		ups = &Upstream{Name: "canary-sticky.default.bbk.dataman", Sticky: true}
+		v1  = &Backend{ID: "v1", IP: "192.168.1.101", Port: 31000, Weight: 1, Version: "v1"}
+		v2  = &Backend{ID: "v2", IP: "192.168.1.102", Port: 31000, Weight: 1, Version: "v2"}
+	)
+	for _, b := range []*Backend{v1, v2} {
+		if _, err := UpsertBackend(&BackendCombined{Upstream: ups, Backend: b}); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		RemoveBackend(&BackendCombined{Upstream: ups, Backend: v1})
+		RemoveBackend(&BackendCombined{Upstream: ups, Backend: v2})
+	}()
+
+	u := GetUpstream(ups.Name)
+
+	// pin a client on v1
+	SetCanary(ups.Name, &Canary{Version: "v2", Percent: 0})
+	if cmb, err := Lookup(&Client{IP: "10.0.0.1"}, u, ""); err != nil || cmb.Backend.ID != "v1" {
+		t.Fatalf("Lookup() = %v, %v, want v1", cmb, err)
+	}
+
+	SetCanary(ups.Name, &Canary{Version: "v2", Percent: 100})
+
+	// the existing session is kept, the new one goes to the canary
+	if cmb, err := Lookup(&Client{IP: "10.0.0.1"}, u, ""); err != nil || cmb.Backend.ID != "v1" {
+		t.Errorf("Lookup() = %v, %v, want the pinned v1", cmb, err)
+	}
+	if cmb, err := Lookup(&Client{IP: "10.0.0.2"}, u, ""); err != nil || cmb.Backend.ID != "v2" {
+		t.Errorf("Lookup() = %v, %v, want the canary v2", cmb, err)
+	}
+
+	// the canary split survives the registration updates
+	if _, err := UpsertBackend(&BackendCombined{Upstream: ups, Backend: v1}); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	if u.Canary == nil || u.Canary.Percent != 100 {
+		t.Errorf("canary = %+v after registration updated, want kept", u.Canary)
+	}
+}
+
+func TestSetCanaryInvalid(t *testing.T) {
+	ups := &Upstream{Name: "canary-invalid.default.bbk.dataman"}
+	b := &Backend{ID: "v1", IP: "192.168.1.101", Port: 31000, Weight: 1, Version: "v1"}
+	if _, err := UpsertBackend(&BackendCombined{Upstream: ups, Backend: b}); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	defer RemoveBackend(&BackendCombined{Upstream: ups, Backend: b})
+
+	tests := []struct {
+		name   string
+		ups    string
+		canary *Canary
+	}{
+		{name: "negative", ups: ups.Name, canary: &Canary{Version: "v2", Percent: -1}},
+		{name: "above 100", ups: ups.Name, canary: &Canary{Version: "v2", Percent: 100.1}},
+		{name: "nan", ups: ups.Name, canary: &Canary{Version: "v2", Percent: math.NaN()}},
+		{name: "no version", ups: ups.Name, canary: &Canary{Percent: 10}},
+		{name: "no such upstream", ups: "none", canary: &Canary{Version: "v2", Percent: 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetCanary(tt.ups, tt.canary); err == nil {
+				t.Errorf("SetCanary(%s, %+v) succeeded, want error", tt.ups, tt.canary)
+			}
+		})
+	}
+
+	if u := GetUpstream(ups.Name); u.Canary != nil {
+		t.Errorf("canary = %+v, want unchanged", u.Canary)
+	}
+}
@@ -0,0 +1,90 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// the default name of the sticky cookie
+const defaultStickyCookieName = "SWAN_STICKY"
+
+// the SameSite attributes of the sticky cookie
+const (
+	SameSiteLax    = "lax"
+	SameSiteStrict = "strict"
+	SameSiteNone   = "none"
+)
+
+// StickyCookie is the affinity cookie pinning the clients by the session issued by the proxy,
+// the attributes are safe by default to comply with the site policy.
+type StickyCookie struct {
+	Name     string `json:"name"`      // default SWAN_STICKY
+	Path     string `json:"path"`      // default /
+	MaxAge   int    `json:"max_age"`   // seconds, 0 for the browser session
+	Secure   *bool  `json:"secure"`    // default true
+	HTTPOnly *bool  `json:"http_only"` // default true
+	SameSite string `json:"same_site"` // lax(default) / strict / none
+}
+
+func (sc *StickyCookie) valid() error {
+	if sc == nil {
+		return nil
+	}
+	if strings.ContainsAny(sc.Name, "()<>@,;:\\\"/[]?={} \t\r\n") {
+		return fmt.Errorf("sticky cookie name [%s] invalid", sc.Name)
+	}
+	if sc.MaxAge < 0 {
+		return errors.New("sticky cookie max_age should not be negative")
+	}
+	switch sc.SameSite {
+	case "", SameSiteLax, SameSiteStrict:
+	case SameSiteNone:
+		if !sc.secure() {
+			return errors.New("sticky cookie same_site none requires secure")
+		}
+	default:
+		return fmt.Errorf("sticky cookie same_site [%s] invalid, should be lax, strict or none", sc.SameSite)
+	}
+	return nil
+}
+
+// CookieName returns the name of the affinity cookie
+func (sc *StickyCookie) CookieName() string {
+	if sc.Name != "" {
+		return sc.Name
+	}
+	return defaultStickyCookieName
+}
+
+func (sc *StickyCookie) secure() bool {
+	return sc.Secure == nil || *sc.Secure
+}
+
+func (sc *StickyCookie) httpOnly() bool {
+	return sc.HTTPOnly == nil || *sc.HTTPOnly
+}
+
+// SetCookie returns the Set-Cookie header value issuing the affinity cookie of the session
+func (sc *StickyCookie) SetCookie(value string) string {
+	path := sc.Path
+	if path == "" {
+		path = "/"
+	}
+
+	c := &http.Cookie{
+		Name:     sc.CookieName(),
+		Value:    value,
+		Path:     path,
+		MaxAge:   sc.MaxAge,
+		Secure:   sc.secure(),
+		HttpOnly: sc.httpOnly(),
+	}
+
+	sameSite := sc.SameSite
+	if sameSite == "" {
+		sameSite = SameSiteLax
+	}
+	return c.String() + "; SameSite=" + strings.Title(sameSite)
+}
@@ -0,0 +1,29 @@
+package upstream
+
+import "testing"
+
+func TestStickyCookieValid(t *testing.T) {
+	no := false
+
+	tests := []struct {
+		name    string
+		cookie  *StickyCookie
+		wantErr bool
+	}{
+		{name: "nil", cookie: nil},
+		{name: "defaults", cookie: &StickyCookie{}},
+		{name: "same site none secure", cookie: &StickyCookie{SameSite: SameSiteNone}},
+		{name: "same site none insecure", cookie: &StickyCookie{SameSite: SameSiteNone, Secure: &no}, wantErr: true},
+		{name: "unknown same site", cookie: &StickyCookie{SameSite: "loose"}, wantErr: true},
+		{name: "negative max age", cookie: &StickyCookie{MaxAge: -1}, wantErr: true},
+		{name: "invalid name", cookie: &StickyCookie{Name: "route id"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cookie.valid(); (err != nil) != tt.wantErr {
+				t.Errorf("valid() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package upstream
+
+import (
+	"fmt"
+)
+
+const defaultErrorPageType = "text/html; charset=utf-8"
+
+// ErrorPage is the custom response of the proxy failures, eg: the branded 503 page or json
+type ErrorPage struct {
+	ContentType string `json:"content_type"` // default text/html; charset=utf-8
+	Body        string `json:"body"`
+}
+
+// Type returns the content type of the error page
+func (p *ErrorPage) Type() string {
+	if p.ContentType != "" {
+		return p.ContentType
+	}
+	return defaultErrorPageType
+}
+
+// ErrorPages are the custom error pages keyed by the status code
+type ErrorPages map[int]*ErrorPage
+
+func (ps ErrorPages) valid() error {
+	for code, p := range ps {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("error page code [%d] invalid, should be within 400-599", code)
+		}
+		if p == nil {
+			return fmt.Errorf("error page of code [%d] required", code)
+		}
+	}
+	return nil
+}
+
+// Get returns the custom error page of the code, nil to fall back to the default
+func (ps ErrorPages) Get(code int) *ErrorPage {
+	return ps[code]
+}
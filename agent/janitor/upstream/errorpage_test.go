@@ -0,0 +1,26 @@
+package upstream
+
+import (
+	"testing"
+)
+
+func TestErrorPagesValid(t *testing.T) {
+	tests := []struct {
+		name    string
+		pages   ErrorPages
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "valid", pages: ErrorPages{502: {Body: "bad gateway"}, 503: {ContentType: "application/json", Body: "{}"}}},
+		{name: "not an error code", pages: ErrorPages{200: {Body: "ok"}}, wantErr: true},
+		{name: "nil page", pages: ErrorPages{503: nil}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.pages.valid(); (err != nil) != tt.wantErr {
+				t.Errorf("valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
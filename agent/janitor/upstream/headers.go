@@ -0,0 +1,51 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// the header rewriting operations
+const (
+	HeaderAdd    = "add"    // append the value to the header
+	HeaderSet    = "set"    // replace the header with the value
+	HeaderRemove = "remove" // delete the header
+)
+
+// HeaderRule is one rewriting operation of the headers
+type HeaderRule struct {
+	Op    string `json:"op"`    // add / set / remove
+	Name  string `json:"name"`  // header name
+	Value string `json:"value"` // ignored by remove
+}
+
+// HeaderRules are applied one by one in order, eg: remove then add the same header
+type HeaderRules []*HeaderRule
+
+func (rs HeaderRules) valid() error {
+	for i, r := range rs {
+		if r == nil || r.Name == "" {
+			return fmt.Errorf("header rule #%d invalid, name required", i)
+		}
+		switch r.Op {
+		case HeaderAdd, HeaderSet, HeaderRemove:
+		default:
+			return fmt.Errorf("header rule #%d op [%s] invalid, should be add, set or remove", i, r.Op)
+		}
+	}
+	return nil
+}
+
+// Apply rewrites the headers by the rules
+func (rs HeaderRules) Apply(h http.Header) {
+	for _, r := range rs {
+		switch r.Op {
+		case HeaderAdd:
+			h.Add(r.Name, r.Value)
+		case HeaderSet:
+			h.Set(r.Name, r.Value)
+		case HeaderRemove:
+			h.Del(r.Name)
+		}
+	}
+}
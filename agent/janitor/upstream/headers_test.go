@@ -0,0 +1,74 @@
+package upstream
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderRulesApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules HeaderRules
+		want  http.Header
+	}{
+		{
+			name:  "remove",
+			rules: HeaderRules{{Op: HeaderRemove, Name: "Server"}},
+			want:  http.Header{"X-Powered-By": {"php"}},
+		},
+		{
+			name:  "add appends",
+			rules: HeaderRules{{Op: HeaderAdd, Name: "X-Powered-By", Value: "swan"}},
+			want:  http.Header{"Server": {"nginx"}, "X-Powered-By": {"php", "swan"}},
+		},
+		{
+			name:  "set replaces",
+			rules: HeaderRules{{Op: HeaderSet, Name: "server", Value: "swan"}},
+			want:  http.Header{"Server": {"swan"}, "X-Powered-By": {"php"}},
+		},
+		{
+			name: "in order",
+			rules: HeaderRules{
+				{Op: HeaderRemove, Name: "Server"},
+				{Op: HeaderRemove, Name: "X-Powered-By"},
+				{Op: HeaderAdd, Name: "Strict-Transport-Security", Value: "max-age=31536000"},
+				{Op: HeaderAdd, Name: "X-Powered-By", Value: "swan"},
+				{Op: HeaderSet, Name: "X-Powered-By", Value: "swan-proxy"},
+			},
+			want: http.Header{"Strict-Transport-Security": {"max-age=31536000"}, "X-Powered-By": {"swan-proxy"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{"Server": {"nginx"}, "X-Powered-By": {"php"}}
+			tt.rules.Apply(h)
+			if !reflect.DeepEqual(h, tt.want) {
+				t.Errorf("Apply() = %v, want %v", h, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderRulesValid(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   HeaderRules
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "valid", rules: HeaderRules{{Op: HeaderSet, Name: "X-Frame-Options", Value: "DENY"}, {Op: HeaderRemove, Name: "Server"}}},
+		{name: "without name", rules: HeaderRules{{Op: HeaderAdd, Value: "v"}}, wantErr: true},
+		{name: "unknown op", rules: HeaderRules{{Op: "replace", Name: "Server"}}, wantErr: true},
+		{name: "nil rule", rules: HeaderRules{nil}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rules.valid(); (err != nil) != tt.wantErr {
+				t.Errorf("valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package upstream
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNoHealthyBackends is returned by lookup if none of the upstream backends is available
+	ErrNoHealthyBackends = errors.New("no healthy backends")
+)
+
+// Eject takes the backend out of the balancing for a while, eg: on connecting failure
+func Eject(cmb *BackendCombined, d time.Duration) {
+	mgr.Lock()
+	defer mgr.Unlock()
+
+	if cmb == nil || cmb.Backend == nil {
+		return
+	}
+
+	cmb.Backend.ejectedUntil = time.Now().Add(d)
+}
+
+// healthy reports whether the backend is not ejected or warming currently
+func healthy(b *Backend) bool {
+	mgr.RLock()
+	defer mgr.RUnlock()
+	return !b.ejected(time.Now()) && !b.warming
+}
+
+func (b *Backend) ejected(now time.Time) bool {
+	return now.Before(b.ejectedUntil)
+}
+
+// draining reports whether the backend receives no new requests
+func (b *Backend) draining() bool {
+	return b.Weight <= 0
+}
+
+// available filters out the ejected, warming & draining backends,
+// must be called under protection of mutex lock
+func available(bs []*Backend, now time.Time) []*Backend {
+	ret := make([]*Backend, 0, len(bs))
+	for _, b := range bs {
+		if b.ejected(now) || b.warming || b.draining() {
+			continue
+		}
+		ret = append(ret, b)
+	}
+	return ret
+}
+
+// DrainStatus describes the draining progress of a backend
+type DrainStatus struct {
+	Draining bool `json:"draining"` // weight is 0, no new requests except the existing sessions
+	Sessions int  `json:"sessions"` // nb of existing sessions on the backend
+}
+
+// GetDrainStatus returns the draining status of the backend, nil if not found
+func GetDrainStatus(ups, backend string) *DrainStatus {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	_, u := getUpstreamByName(ups)
+	if u == nil {
+		return nil
+	}
+
+	_, b := u.search(backend)
+	if b == nil {
+		return nil
+	}
+
+	return &DrainStatus{
+		Draining: b.draining(),
+		Sessions: u.sessions.count(b.ID),
+	}
+}
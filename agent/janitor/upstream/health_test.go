@@ -0,0 +1,169 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextBackendHealthAware(t *testing.T) {
+	var (
+		now     = time.Now()
+		ejected = now.Add(time.Minute)
+	)
+
+	tests := []struct {
+		name     string
+		backends []*Backend
+		want     map[string]bool // the backends could be selected
+		wantErr  error
+	}{
+		{
+			name: "all healthy",
+			backends: []*Backend{
+				{ID: "a", Weight: 1},
+				{ID: "b", Weight: 1},
+			},
+			want: map[string]bool{"a": true, "b": true},
+		},
+		{
+			name: "mixed",
+			backends: []*Backend{
+				{ID: "a", Weight: 1, ejectedUntil: ejected},
+				{ID: "b", Weight: 1},
+				{ID: "c", Weight: 0},
+				{ID: "d", Weight: 1, ejectedUntil: now.Add(-time.Second)}, // ejection expired
+			},
+			want: map[string]bool{"b": true, "d": true},
+		},
+		{
+			name: "none healthy",
+			backends: []*Backend{
+				{ID: "a", Weight: 1, ejectedUntil: ejected},
+				{ID: "b", Weight: 0},
+			},
+			wantErr: ErrNoHealthyBackends,
+		},
+		{
+			name:     "empty",
+			backends: []*Backend{},
+			wantErr:  ErrNoHealthyBackends,
+		},
+	}
+
+	balances := []string{BalancerWRR, BalancerSmoothWRR, BalancerLeastTime, BalancerLeastTimeConn, BalancerWeightedLeastConn}
+
+	for _, tt := range tests {
+		for _, balance := range balances {
+			t.Run(tt.name+"/"+balance, func(t *testing.T) {
+				u := &Upstream{Name: "test", Backends: tt.backends, balancer: newBalancer(balance)}
+
+				for i := 0; i < 20; i++ {
+					b, err := nextBackend(u, nil)
+					if err != tt.wantErr {
+						t.Fatalf("nextBackend() error = %v, want %v", err, tt.wantErr)
+					}
+					if err != nil {
+						return
+					}
+					if !tt.want[b.ID] {
+						t.Fatalf("nextBackend() selected unavailable backend %s", b.ID)
+					}
+					if o, ok := u.balancer.(observer); ok {
+						o.begin(b.ID)
+						o.done(b.ID, time.Millisecond)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestLookupSkipUnhealthySession(t *testing.T) {
+	var (
+		a = &Backend{ID: "a", Weight: 1}
+		b = &Backend{ID: "b", Weight: 1}
+		u = &Upstream{Name: "test", Sticky: true, Backends: []*Backend{a, b}, sessions: newSessions(), balancer: newBalancer(BalancerWRR)}
+	)
+	defer u.sessions.stop()
+
+	u.sessions.update("127.0.0.1", a)
+	a.ejectedUntil = time.Now().Add(time.Minute)
+
+	cmb, err := Lookup(&Client{IP: "127.0.0.1"}, u, "")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if cmb.Backend.ID != "b" {
+		t.Errorf("Lookup() = %s, want the healthy backend b rather than the session on ejected a", cmb.Backend.ID)
+	}
+
+	b.ejectedUntil = time.Now().Add(time.Minute)
+	if _, err := Lookup(&Client{IP: "127.0.0.2"}, u, ""); err != ErrNoHealthyBackends {
+		t.Errorf("Lookup() error = %v, want %v", err, ErrNoHealthyBackends)
+	}
+}
+
+func TestDrainByZeroWeight(t *testing.T) {
+	var (
+		ups = &Upstream{Name: "drain.default.bbk.dataman", Sticky: true}
+		a   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "a.drain.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 1}}
+		b   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "b.drain.default.bbk.dataman", IP: "192.168.1.102", Port: 31001, Weight: 1}}
+	)
+
+	for _, cmb := range []*BackendCombined{a, b} {
+		if _, err := UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		RemoveBackend(a)
+		RemoveBackend(b)
+	}()
+
+	u := GetUpstream(ups.Name)
+
+	// pin a client on each backend
+	pinned := make(map[string]string) // backend id -> client ip
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		cmb, err := Lookup(&Client{IP: ip}, u, "")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		pinned[cmb.Backend.ID] = ip
+	}
+	if len(pinned) != 2 {
+		t.Fatalf("clients should be balanced on both backends, got %v", pinned)
+	}
+
+	// hot update the weight of a to zero
+	drain := &BackendCombined{Upstream: ups, Backend: &Backend{ID: a.Backend.ID, IP: a.Backend.IP, Port: a.Backend.Port, Weight: 0}}
+	if _, err := UpsertBackend(drain); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+
+	if status := GetDrainStatus(ups.Name, a.Backend.ID); status == nil || !status.Draining || status.Sessions != 1 {
+		t.Errorf("GetDrainStatus() = %+v, want draining with 1 session", status)
+	}
+
+	// the existing session is still honored
+	for i := 0; i < 3; i++ {
+		cmb, err := Lookup(&Client{IP: pinned[a.Backend.ID]}, u, "")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if cmb.Backend.ID != a.Backend.ID {
+			t.Errorf("session on draining backend got %s, want %s", cmb.Backend.ID, a.Backend.ID)
+		}
+	}
+
+	// no new clients selected on the drained backend
+	for _, ip := range []string{"10.0.1.1", "10.0.1.2", "10.0.1.3", "10.0.1.4"} {
+		cmb, err := Lookup(&Client{IP: ip}, u, "")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if cmb.Backend.ID == a.Backend.ID {
+			t.Errorf("new client %s selected the draining backend", ip)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package upstream
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// the defaults of the hedged requests
+var (
+	defaultHedgePercentile  = 95.0
+	defaultHedgeDelay       = time.Millisecond * 10
+	defaultHedgeMaxInFlight = 10
+)
+
+// Hedge is the setup of the hedged requests of an upstream: the idempotent request not
+// responded within the percentile of the upstream's response time is sent to another
+// backend as well, the first response wins and the other one is canceled.
+type Hedge struct {
+	Percentile  float64       `json:"percentile"`    // percentile (0-100) of the response time to hedge after, default 95
+	Delay       time.Duration `json:"delay"`         // min delay to hedge after, also used before any response time observed, default 10ms
+	MaxInFlight int           `json:"max_in_flight"` // max in-flight hedged requests of the upstream, default 10
+}
+
+func (h *Hedge) valid() error {
+	if h == nil {
+		return nil
+	}
+	if h.Percentile < 0 || h.Percentile >= 100 {
+		return errors.New("upstream hedge percentile should be within [0, 100)")
+	}
+	if h.Delay < 0 || h.MaxInFlight < 0 {
+		return errors.New("upstream hedge should not be negative")
+	}
+	return nil
+}
+
+// Quantile returns the quantile (0-1) of the response time to hedge after
+func (h *Hedge) Quantile() float64 {
+	if h.Percentile > 0 {
+		return h.Percentile / 100
+	}
+	return defaultHedgePercentile / 100
+}
+
+// MinDelay returns the min delay to hedge after
+func (h *Hedge) MinDelay() time.Duration {
+	if h.Delay > 0 {
+		return h.Delay
+	}
+	return defaultHedgeDelay
+}
+
+func (h *Hedge) maxInFlight() int64 {
+	if h.MaxInFlight > 0 {
+		return int64(h.MaxInFlight)
+	}
+	return int64(defaultHedgeMaxInFlight)
+}
+
+// AcquireHedge takes an in-flight hedge slot of the upstream, false if the hedging disabled
+// or all of the slots taken. the returned release func must be called once the hedged
+// request done.
+func AcquireHedge(u *Upstream) (release func(), ok bool) {
+	mgr.RLock()
+	h := u.Hedge
+	mgr.RUnlock()
+
+	if h == nil {
+		return nil, false
+	}
+
+	if atomic.AddInt64(&u.hedging, 1) > h.maxInFlight() {
+		atomic.AddInt64(&u.hedging, -1)
+		return nil, false
+	}
+	return func() { atomic.AddInt64(&u.hedging, -1) }, true
+}
+
+// LookupHedge selects another backend than the first selected one for the hedged request by
+// the balancer, the sticky session stays on the first one. ErrNoHealthyBackends if none other.
+func LookupHedge(c *Client, first *BackendCombined) (*BackendCombined, error) {
+	u := first.Upstream
+
+	sel, err := u.selector(c)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := nextBackendExcept(u, sel, first.Backend)
+	if err != nil {
+		return nil, err
+	}
+	b.selected(time.Now())
+
+	return &BackendCombined{Upstream: u, Backend: b}, nil
+}
@@ -0,0 +1,62 @@
+package upstream
+
+import (
+	"testing"
+)
+
+func TestLookupHedge(t *testing.T) {
+	var (
+		ups = &Upstream{Name: "hedge.default.bbk.dataman", Hedge: &Hedge{MaxInFlight: 2}}
+		a   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "a", IP: "192.168.1.101", Port: 31000, Weight: 1}}
+		b   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "b", IP: "192.168.1.102", Port: 31000, Weight: 1}}
+	)
+	for _, cmb := range []*BackendCombined{a, b} {
+		if _, err := UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		RemoveBackend(a)
+		RemoveBackend(b)
+	}()
+
+	u := GetUpstream(ups.Name)
+
+	// the other one than the first is always selected
+	for _, first := range []string{"a", "b", "a", "b"} {
+		cmb, err := LookupHedge(&Client{IP: "10.0.0.1"}, &BackendCombined{Upstream: u, Backend: GetBackend(u, first)})
+		if err != nil {
+			t.Fatalf("LookupHedge() error = %v", err)
+		}
+		if cmb.Backend.ID == first {
+			t.Errorf("LookupHedge() = %s, want the other one", first)
+		}
+	}
+
+	// none other
+	RemoveBackend(b)
+	if _, err := LookupHedge(&Client{IP: "10.0.0.1"}, &BackendCombined{Upstream: u, Backend: GetBackend(u, "a")}); err != ErrNoHealthyBackends {
+		t.Errorf("LookupHedge() error = %v, want %v", err, ErrNoHealthyBackends)
+	}
+
+	// the in-flight hedges are bounded
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, ok := AcquireHedge(u)
+		if !ok {
+			t.Fatalf("AcquireHedge() %d failed, want acquired", i)
+		}
+		releases = append(releases, release)
+	}
+	if _, ok := AcquireHedge(u); ok {
+		t.Error("AcquireHedge() beyond the max in-flight acquired, want rejected")
+	}
+	releases[0]()
+	if _, ok := AcquireHedge(u); !ok {
+		t.Error("AcquireHedge() after released failed, want acquired")
+	}
+
+	if err := (&Upstream{Name: "bad", Hedge: &Hedge{Percentile: 100}}).valid(); err == nil {
+		t.Error("hedge percentile 100 valid, want error")
+	}
+}
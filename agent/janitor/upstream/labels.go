@@ -0,0 +1,46 @@
+package upstream
+
+import (
+	"fmt"
+
+	"github.com/Dataman-Cloud/swan/utils/labels"
+)
+
+// selector parses the label selector of the request by the upstream's label_header,
+// nil if not set, which matches all of the backends.
+func (u *Upstream) selector(c *Client) (labels.Selector, error) {
+	if u.LabelHeader == "" || c == nil || c.Header == nil {
+		return nil, nil
+	}
+
+	v := c.Header.Get(u.LabelHeader)
+	if v == "" {
+		return nil, nil
+	}
+
+	sel, err := labels.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("label selector [%s] invalid: %v", v, err)
+	}
+	return sel, nil
+}
+
+// matches reports whether the backend labels are matched by the selector, nil matches all
+func (b *Backend) matches(sel labels.Selector) bool {
+	return sel == nil || sel.Matches(labels.Set(b.Labels))
+}
+
+// matched filters out the backends not matched by the selector
+func matched(bs []*Backend, sel labels.Selector) []*Backend {
+	if sel == nil {
+		return bs
+	}
+
+	ret := make([]*Backend, 0, len(bs))
+	for _, b := range bs {
+		if b.matches(sel) {
+			ret = append(ret, b)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,61 @@
+package upstream
+
+import (
+	"sync"
+	"time"
+)
+
+// weightedLeastConnBalancer selects the backend with the least active connections per weight,
+// so the heavier backends take proportionally more connections, but the busy ones are avoided.
+// the weights are the effective ones during slow start, the ties are broken by the heavier
+// weight, then by the order of the backends, so the selection is deterministic.
+type weightedLeastConnBalancer struct {
+	sync.Mutex                // protect active
+	active     map[string]int // backend id -> nb of active connections, only the busy ones kept
+}
+
+func newWeightedLeastConnBalancer() *weightedLeastConnBalancer {
+	return &weightedLeastConnBalancer{
+		active: make(map[string]int),
+	}
+}
+
+func (b *weightedLeastConnBalancer) Next(bs []*Backend) *Backend {
+	b.Lock()
+	defer b.Unlock()
+
+	var (
+		best               *Backend
+		bestConns, bestWgt float64
+	)
+
+	for i, w := range effectiveWeights(bs) {
+		if w <= 0 {
+			continue
+		}
+
+		conns := float64(b.active[bs[i].ID])
+
+		// conns/w < bestConns/bestWgt, compared without the division
+		if best == nil || conns*bestWgt < bestConns*w || (conns*bestWgt == bestConns*w && w > bestWgt) {
+			best, bestConns, bestWgt = bs[i], conns, w
+		}
+	}
+
+	return best
+}
+
+func (b *weightedLeastConnBalancer) begin(backend string) {
+	b.Lock()
+	b.active[backend]++
+	b.Unlock()
+}
+
+func (b *weightedLeastConnBalancer) done(backend string, rt time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.active[backend]--; b.active[backend] <= 0 {
+		delete(b.active, backend) // the removed backends leave nothing behind
+	}
+}
@@ -0,0 +1,152 @@
+package upstream
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	ewmaAlpha = 0.3 // smoothing factor of the response time moving average
+)
+
+// observer is implemented by the balancers which need the feedback of each request
+type observer interface {
+	begin(backend string)                  // request proxied to the backend
+	done(backend string, rt time.Duration) // request finished with observed response time, rt < 0 if failed
+}
+
+// leastTimeBalancer selects the backend with the lowest exponentially-weighted
+// moving average of response time, optionally multiplied by the nb of active
+// connections plus one, so that the busy backends are less favored.
+// the backends without any samples yet are given a fair initial chance (slow start):
+// one probing request at a time until the first sample observed, rather than a flood.
+type leastTimeBalancer struct {
+	byConns bool // weighted by active connections or not
+
+	sync.Mutex                     // protect states & next
+	states     map[string]*rtState // backend id -> response time state
+	next       int                 // round-robin offset to break the ties
+}
+
+type rtState struct {
+	ewma    float64 // moving average response time in ms
+	samples uint64  // nb of observed samples
+	active  int     // nb of active connections
+	probing bool    // a probing request in flight before any samples
+}
+
+func newLeastTimeBalancer(byConns bool) *leastTimeBalancer {
+	return &leastTimeBalancer{
+		byConns: byConns,
+		states:  make(map[string]*rtState),
+	}
+}
+
+func (b *leastTimeBalancer) Next(bs []*Backend) *Backend {
+	b.Lock()
+	defer b.Unlock()
+
+	var (
+		sampled int
+		alive   = make(map[string]bool, len(bs))
+	)
+
+	for _, backend := range bs {
+		alive[backend.ID] = true
+		if s, ok := b.states[backend.ID]; ok && s.samples > 0 {
+			sampled++
+		}
+	}
+
+	// drop the states of removed backends
+	for id := range b.states {
+		if !alive[id] {
+			delete(b.states, id)
+		}
+	}
+
+	var (
+		best      *Backend
+		bestScore float64
+		n         = len(bs)
+	)
+
+	b.next++
+
+	// probe the new backends firstly
+	for i := 0; i < n; i++ {
+		backend := bs[(b.next+i)%n]
+		if backend.Weight <= 0 {
+			continue
+		}
+
+		if s := b.state(backend.ID); s.samples == 0 && !s.probing {
+			s.probing = true
+			return backend
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		backend := bs[(b.next+i)%n]
+		if backend.Weight <= 0 {
+			continue
+		}
+
+		s := b.state(backend.ID)
+
+		// skip the probing backends, unless none of backends sampled yet
+		if s.samples == 0 && sampled > 0 {
+			continue
+		}
+
+		score := s.ewma
+		if b.byConns {
+			score *= float64(s.active + 1)
+		}
+
+		if best == nil || score < bestScore {
+			best, bestScore = backend, score
+		}
+	}
+
+	return best
+}
+
+// state returns the backend's state, must be called under protection of mutex lock
+func (b *leastTimeBalancer) state(backend string) *rtState {
+	s, ok := b.states[backend]
+	if !ok {
+		s = &rtState{}
+		b.states[backend] = s
+	}
+	return s
+}
+
+func (b *leastTimeBalancer) begin(backend string) {
+	b.Lock()
+	b.state(backend).active++
+	b.Unlock()
+}
+
+func (b *leastTimeBalancer) done(backend string, rt time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+
+	s := b.state(backend)
+	if s.active > 0 {
+		s.active--
+	}
+
+	if rt < 0 {
+		s.probing = false // failed, probe again
+		return
+	}
+
+	ms := float64(rt) / float64(time.Millisecond)
+	if s.samples == 0 {
+		s.ewma = ms
+	} else {
+		s.ewma = ewmaAlpha*ms + (1-ewmaAlpha)*s.ewma
+	}
+	s.samples++
+}
@@ -0,0 +1,147 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueFull    = errors.New("upstream busy: too many requests queued")
+	ErrQueueTimeout = errors.New("upstream busy: timeout on waiting for an in-flight slot")
+
+	// the default wait timeout of the queued requests
+	defaultQueueTimeout = time.Second
+)
+
+// Limit is the setup of max in-flight requests per upstream, the requests beyond
+// the limit wait in a bounded queue for a while before getting rejected.
+type Limit struct {
+	MaxInFlight  int           `json:"max_in_flight"` // max in-flight requests, 0 for unlimited
+	MaxQueue     int           `json:"max_queue"`     // max requests waiting for a slot, 0 to reject at once
+	QueueTimeout time.Duration `json:"queue_timeout"` // max wait time of queued requests, default 1s
+}
+
+func (l *Limit) valid() error {
+	if l == nil {
+		return nil
+	}
+	if l.MaxInFlight < 0 || l.MaxQueue < 0 || l.QueueTimeout < 0 {
+		return errors.New("upstream limit should not be negative")
+	}
+	return nil
+}
+
+func (l *Limit) equal(o *Limit) bool {
+	if l == nil || o == nil {
+		return l == o
+	}
+	return *l == *o
+}
+
+// LimitStatus is the current in-flight & queued requests of an upstream
+type LimitStatus struct {
+	InFlight    int    `json:"in_flight"`
+	Queued      int64  `json:"queued"`
+	Rejected    uint64 `json:"rejected"` // nb of requests rejected by full queue or timeout
+	MaxInFlight int    `json:"max_in_flight"`
+	MaxQueue    int    `json:"max_queue"`
+}
+
+type limiter struct {
+	slots    chan struct{} // in-flight slots
+	maxQueue int64
+	timeout  time.Duration
+	queued   int64  // atomic
+	rejected uint64 // atomic
+}
+
+func newLimiter(l *Limit) *limiter {
+	if l == nil || l.MaxInFlight <= 0 {
+		return nil
+	}
+
+	timeout := l.QueueTimeout
+	if timeout == 0 {
+		timeout = defaultQueueTimeout
+	}
+
+	return &limiter{
+		slots:    make(chan struct{}, l.MaxInFlight),
+		maxQueue: int64(l.MaxQueue),
+		timeout:  timeout,
+	}
+}
+
+// acquire takes an in-flight slot, or waits in the queue for one until timeout
+func (l *limiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if atomic.AddInt64(&l.queued, 1) > l.maxQueue {
+		atomic.AddInt64(&l.queued, -1)
+		atomic.AddUint64(&l.rejected, 1)
+		return ErrQueueFull
+	}
+	defer atomic.AddInt64(&l.queued, -1)
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		atomic.AddUint64(&l.rejected, 1)
+		return ErrQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) release() {
+	<-l.slots
+}
+
+// Acquire takes an in-flight slot of the backend's upstream before proxying, the returned
+// release func must be called once the proxying done, on any return paths.
+func Acquire(ctx context.Context, cmb *BackendCombined) (release func(), err error) {
+	mgr.RLock()
+	l := cmb.Upstream.limiter
+	mgr.RUnlock()
+
+	if l == nil {
+		return func() {}, nil
+	}
+
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+	return l.release, nil
+}
+
+// LimitStats returns the current in-flight & queued requests of the upstreams with limit enabled
+func LimitStats() map[string]*LimitStatus {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	ret := make(map[string]*LimitStatus)
+	for _, u := range mgr.Upstreams {
+		l := u.limiter
+		if l == nil {
+			continue
+		}
+		ret[u.Name] = &LimitStatus{
+			InFlight:    len(l.slots),
+			Queued:      atomic.LoadInt64(&l.queued),
+			Rejected:    atomic.LoadUint64(&l.rejected),
+			MaxInFlight: cap(l.slots),
+			MaxQueue:    int(l.maxQueue),
+		}
+	}
+	return ret
+}
@@ -0,0 +1,94 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(&Limit{MaxInFlight: 2, MaxQueue: 1, QueueTimeout: time.Millisecond * 50})
+
+	for i := 0; i < 2; i++ {
+		if err := l.acquire(context.Background()); err != nil {
+			t.Fatalf("#%d acquire within limit error = %v", i, err)
+		}
+	}
+
+	// the queued request gets the slot released in time
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		l.release()
+	}()
+	if err := l.acquire(context.Background()); err != nil {
+		t.Fatalf("queued acquire error = %v", err)
+	}
+
+	// drive concurrency past the limit: one queued then timeout, the others rejected at once
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(map[error]int)
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := l.acquire(context.Background())
+			mu.Lock()
+			errs[err]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if errs[ErrQueueTimeout] != 1 || errs[ErrQueueFull] != 3 {
+		t.Errorf("acquire errors = %v, want 1 %v & 3 %v", errs, ErrQueueTimeout, ErrQueueFull)
+	}
+
+	if n := len(l.slots); n != 2 {
+		t.Errorf("in-flight = %d, want 2", n)
+	}
+	if n := l.queued; n != 0 {
+		t.Errorf("queued = %d, want 0", n)
+	}
+	if n := l.rejected; n != 4 {
+		t.Errorf("rejected = %d, want 4", n)
+	}
+
+	// canceled while queued
+	l = newLimiter(&Limit{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Minute})
+	l.acquire(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.acquire(ctx); err != context.Canceled {
+		t.Errorf("canceled acquire error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       *Limit
+		wantNil     bool
+		wantTimeout time.Duration
+	}{
+		{name: "nil", limit: nil, wantNil: true},
+		{name: "unlimited", limit: &Limit{MaxQueue: 10}, wantNil: true},
+		{name: "default timeout", limit: &Limit{MaxInFlight: 1}, wantTimeout: defaultQueueTimeout},
+		{name: "timeout", limit: &Limit{MaxInFlight: 1, QueueTimeout: time.Second * 3}, wantTimeout: time.Second * 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter(tt.limit)
+			if (l == nil) != tt.wantNil {
+				t.Fatalf("newLimiter() = %v, wantNil %v", l, tt.wantNil)
+			}
+			if l != nil && l.timeout != tt.wantTimeout {
+				t.Errorf("newLimiter() timeout = %v, want %v", l.timeout, tt.wantTimeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package upstream
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRateLimited is returned by lookup if all of the available backends are over their rate limit
+	ErrRateLimited = errors.New("upstream busy: backends over the rate limit")
+)
+
+// RateLimit is the setup of the max requests/sec proxied to a backend, eg: to protect the fragile
+// shared service registered as one backend. the backend over its rate is skipped by the balancer
+// in favor of the others, distinct from the upstream's in-flight limit.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`  // max requests/sec
+	Burst int     `json:"burst"` // max requests at once beyond the rate, default 1
+}
+
+func (rl *RateLimit) valid() error {
+	if rl == nil {
+		return nil
+	}
+	if math.IsNaN(rl.Rate) || math.IsInf(rl.Rate, 0) || rl.Rate <= 0 {
+		return errors.New("backend rate limit should be a finite positive number")
+	}
+	if rl.Burst < 0 {
+		return errors.New("backend rate limit burst should not be negative")
+	}
+	return nil
+}
+
+func (rl *RateLimit) equal(o *RateLimit) bool {
+	if rl == nil || o == nil {
+		return rl == o
+	}
+	return *rl == *o
+}
+
+func (rl *RateLimit) burst() float64 {
+	if rl.Burst > 0 {
+		return float64(rl.Burst)
+	}
+	return 1
+}
+
+// bucket is the runtime token bucket of the backend rate limit, one token per request
+type bucket struct {
+	sync.Mutex
+	rate    float64 // tokens/sec
+	burst   float64
+	tokens  float64
+	last    time.Time
+	skipped uint64 // nb of times skipped as over the rate
+}
+
+func newBucket(rl *RateLimit) *bucket {
+	if rl == nil {
+		return nil
+	}
+	return &bucket{
+		rate:   rl.Rate,
+		burst:  rl.burst(),
+		tokens: rl.burst(),
+		last:   time.Now(),
+	}
+}
+
+// take a token for the selected request, false if over the rate. nil for unlimited.
+func (bk *bucket) take(now time.Time) bool {
+	if bk == nil {
+		return true
+	}
+
+	bk.Lock()
+	defer bk.Unlock()
+
+	if now.After(bk.last) {
+		bk.tokens = math.Min(bk.burst, bk.tokens+now.Sub(bk.last).Seconds()*bk.rate)
+		bk.last = now
+	}
+
+	if bk.tokens < 1 {
+		bk.skipped++
+		return false
+	}
+	bk.tokens--
+	return true
+}
+
+// allow takes a token of the backend rate limit, must be called under protection of mutex lock
+func (b *Backend) allow(now time.Time) bool {
+	return b.bucket.take(now)
+}
+
+// allowed is the same as allow but for the backend out of the balancing, eg: the sticky session
+func (b *Backend) allowed(now time.Time) bool {
+	mgr.RLock()
+	defer mgr.RUnlock()
+	return b.allow(now)
+}
+
+// RateLimitStatus is the rate limit of a backend
+type RateLimitStatus struct {
+	Rate    float64 `json:"rate"`    // max requests/sec
+	Burst   int     `json:"burst"`   // max requests at once
+	Skipped uint64  `json:"skipped"` // nb of times skipped as over the rate
+}
+
+// RateLimitStats returns the configured rate of the backends with the rate limit set, by upstream & backend
+func RateLimitStats() map[string]map[string]*RateLimitStatus {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	ret := make(map[string]map[string]*RateLimitStatus)
+	for _, u := range mgr.Upstreams {
+		for _, b := range u.Backends {
+			bk := b.bucket
+			if bk == nil {
+				continue
+			}
+
+			m, ok := ret[u.Name]
+			if !ok {
+				m = make(map[string]*RateLimitStatus)
+				ret[u.Name] = m
+			}
+
+			bk.Lock()
+			m[b.ID] = &RateLimitStatus{Rate: bk.rate, Burst: int(bk.burst), Skipped: bk.skipped}
+			bk.Unlock()
+		}
+	}
+	return ret
+}
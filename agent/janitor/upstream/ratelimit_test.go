@@ -0,0 +1,87 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	var (
+		ups  = &Upstream{Name: "ratelimit.default.bbk.dataman"}
+		slow = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "legacy", IP: "192.168.1.101", Port: 31000, Weight: 100, RateLimit: &RateLimit{Rate: 5, Burst: 2}}}
+		fast = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "fast", IP: "192.168.1.102", Port: 31000, Weight: 1}}
+	)
+	for _, cmb := range []*BackendCombined{slow, fast} {
+		if _, err := UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		RemoveBackend(slow)
+		RemoveBackend(fast)
+	}()
+
+	u := GetUpstream(ups.Name)
+
+	// selections of the backends within n lookups
+	selections := func(n int) map[string]int {
+		got := make(map[string]int)
+		for i := 0; i < n; i++ {
+			cmb, err := Lookup(&Client{IP: "10.0.0.1"}, u, "")
+			if err != nil {
+				t.Fatalf("Lookup() error = %v", err)
+			}
+			got[cmb.Backend.ID]++
+		}
+		return got
+	}
+
+	// the heavy legacy one takes the burst only, the others are skipped to the fast one
+	if got := selections(20); got["legacy"] != 2 || got["fast"] != 18 {
+		t.Errorf("selections = %v, want legacy 2 and fast 18", got)
+	}
+
+	// refilled by the rate
+	time.Sleep(time.Millisecond * 250)
+	if got := selections(20); got["legacy"] == 0 || got["legacy"] > 2 {
+		t.Errorf("selections after refilled = %v, want legacy by the rate", got)
+	}
+
+	st := RateLimitStats()[ups.Name]["legacy"]
+	if st == nil || st.Rate != 5 || st.Burst != 2 || st.Skipped == 0 {
+		t.Errorf("rate limit status = %+v, want rate 5, burst 2 and skipped", st)
+	}
+	if _, ok := RateLimitStats()[ups.Name]["fast"]; ok {
+		t.Error("the unlimited backend listed in the rate limit stats")
+	}
+
+	// all over the rate
+	RemoveBackend(fast)
+	time.Sleep(time.Millisecond * 400)
+	selections(2)
+	if _, err := Lookup(&Client{IP: "10.0.0.1"}, u, ""); err != ErrRateLimited {
+		t.Errorf("Lookup() error = %v, want %v", err, ErrRateLimited)
+	}
+	if _, err := Lookup(&Client{IP: "10.0.0.1"}, u, "legacy"); err != ErrRateLimited {
+		t.Errorf("Lookup() specified error = %v, want %v", err, ErrRateLimited)
+	}
+
+	tests := []struct {
+		name string
+		rl   *RateLimit
+		ok   bool
+	}{
+		{name: "unlimited", rl: nil, ok: true},
+		{name: "rate", rl: &RateLimit{Rate: 0.5}, ok: true},
+		{name: "zero rate", rl: &RateLimit{}, ok: false},
+		{name: "negative burst", rl: &RateLimit{Rate: 1, Burst: -1}, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Backend{ID: "a", IP: "192.168.1.101", Port: 31000, RateLimit: tt.rl}
+			if err := b.valid(); (err == nil) != tt.ok {
+				t.Errorf("valid() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
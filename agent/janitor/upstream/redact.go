@@ -0,0 +1,99 @@
+package upstream
+
+import (
+	"fmt"
+)
+
+// Redacted replaces the secrets of the upstreams shown by the apis, eg: the basic auth
+// password hashes, the jwt shared secret.
+const Redacted = "******"
+
+// Redact returns a copy of the basic auth with the password hashes redacted, the users kept.
+func (a *BasicAuth) Redact() *BasicAuth {
+	if a == nil {
+		return nil
+	}
+
+	cp := *a
+	cp.Users = make(map[string]string, len(a.Users))
+	for user := range a.Users {
+		cp.Users[user] = Redacted
+	}
+	return &cp
+}
+
+// Redact returns a copy of the jwt with the shared secret redacted.
+func (j *JWT) Redact() *JWT {
+	if j == nil {
+		return nil
+	}
+
+	cp := *j
+	if cp.Secret != "" {
+		cp.Secret = Redacted
+	}
+	return &cp
+}
+
+// Redact redacts the secrets of the upstream copy in place, the upstream must not be
+// the registered one, eg: the copies returned by Snapshot.
+func (u *Upstream) Redact() {
+	u.BasicAuth = u.BasicAuth.Redact()
+	u.JWT = u.JWT.Redact()
+}
+
+// Unredact fills the redacted secrets of the upstream, eg: restored from the exported
+// snapshot, back from the registered upstream of the same name & target, it fails if
+// any redacted secret is not known by the registered one.
+func (u *Upstream) Unredact() error {
+	if u == nil {
+		return nil
+	}
+
+	var (
+		auth = u.BasicAuth
+		jwt  = u.JWT
+	)
+
+	redacted := jwt != nil && jwt.Secret == Redacted
+	if auth != nil {
+		for _, hash := range auth.Users {
+			redacted = redacted || hash == Redacted
+		}
+	}
+	if !redacted {
+		return nil
+	}
+
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	_, reg := getUpstreamByNameAndTarget(u.Name, u.Target)
+
+	if auth != nil {
+		users := make(map[string]string, len(auth.Users))
+		for user, hash := range auth.Users {
+			if hash == Redacted {
+				if reg == nil || reg.BasicAuth == nil || reg.BasicAuth.Users[user] == "" {
+					return fmt.Errorf("upstream %s basic auth user %s password is redacted", u.Name, user)
+				}
+				hash = reg.BasicAuth.Users[user]
+			}
+			users[user] = hash
+		}
+		cp := *auth
+		cp.Users = users
+		u.BasicAuth = &cp
+	}
+
+	if jwt != nil && jwt.Secret == Redacted {
+		if reg == nil || reg.JWT == nil || reg.JWT.Secret == "" {
+			return fmt.Errorf("upstream %s jwt secret is redacted", u.Name)
+		}
+		cp := *jwt
+		cp.Secret = reg.JWT.Secret
+		u.JWT = &cp
+	}
+
+	return nil
+}
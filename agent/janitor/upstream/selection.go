@@ -0,0 +1,27 @@
+package upstream
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// selected records a selection of the backend, it's called on each lookup so
+// the counters are updated atomically rather than under the upstreams lock.
+func (b *Backend) selected(now time.Time) {
+	atomic.AddUint64(&b.selections, 1)
+	atomic.StoreInt64(&b.lastSelected, now.UnixNano())
+}
+
+// Selections returns the nb of times the backend selected and the last selected time,
+// the time is zero if never selected.
+func (b *Backend) Selections() (uint64, time.Time) {
+	var (
+		n    = atomic.LoadUint64(&b.selections)
+		last = atomic.LoadInt64(&b.lastSelected)
+	)
+
+	if last == 0 {
+		return n, time.Time{}
+	}
+	return n, time.Unix(0, last)
+}
@@ -0,0 +1,67 @@
+package upstream
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSelections(t *testing.T) {
+	var (
+		a = &Backend{ID: "a", Weight: 1}
+		b = &Backend{ID: "b", Weight: 1}
+		u = &Upstream{
+			Name:     "test",
+			Sticky:   true,
+			Backends: []*Backend{a, b},
+			sessions: newSessions(),
+			balancer: newBalancer(BalancerWRR),
+		}
+	)
+	defer u.sessions.stop()
+
+	if n, last := a.Selections(); n != 0 || !last.IsZero() {
+		t.Fatalf("Selections() before lookup = %d, %v, want 0 & zero time", n, last)
+	}
+
+	start := time.Now()
+
+	// new selections by the balancer
+	first, err := Lookup(&Client{IP: "10.0.0.1"}, u, "")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+
+	// selections by the session and the specified backend count as well
+	for i := 0; i < 3; i++ {
+		if _, err := Lookup(&Client{IP: "10.0.0.1"}, u, ""); err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+	}
+	if _, err := Lookup(&Client{IP: "10.0.0.2"}, u, first.Backend.ID); err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+
+	n, last := first.Backend.Selections()
+	if n != 5 {
+		t.Errorf("Selections() count = %d, want 5", n)
+	}
+	if last.Before(start) {
+		t.Errorf("Selections() last = %v, want after %v", last, start)
+	}
+
+	// concurrent selections are all counted
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Lookup(&Client{IP: "10.0.0.1"}, u, "")
+		}()
+	}
+	wg.Wait()
+
+	if n, _ := first.Backend.Selections(); n != 55 {
+		t.Errorf("Selections() count after concurrent lookups = %d, want 55", n)
+	}
+}
@@ -1,7 +1,12 @@
 package upstream
 
 import (
+	"container/list"
 	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,8 +15,10 @@ import (
 
 // Sessions
 type Sessions struct {
-	m            map[string]*session // ip -> session
-	sync.RWMutex                     // protect m
+	m            map[string]*session // session key (client ip or header value) -> session
+	lru          *list.List          // sessions by recency, the most recently used at front
+	max          int                 // max nb of sessions, the least recently used evicted beyond, 0 for unlimited
+	sync.RWMutex                     // protect m & lru
 	stopCh       chan struct{}       // quit
 	gcInterval   time.Duration       // gc interval
 	timeout      time.Duration       // session timeout
@@ -20,11 +27,15 @@ type Sessions struct {
 type session struct {
 	*Backend
 	UpdatedAt time.Time `json:"updated_at"`
+
+	key  string        // session key
+	elem *list.Element // within the lru
 }
 
 func newSessions() *Sessions {
 	b := &Sessions{
 		m:          make(map[string]*session),
+		lru:        list.New(),
 		stopCh:     make(chan struct{}),
 		gcInterval: time.Second * 10,
 		timeout:    time.Hour * 1,
@@ -34,31 +45,148 @@ func newSessions() *Sessions {
 	return b
 }
 
+// setupSessions allocates the sessions store once sticky enabled, and releases it once
+// disabled to save memory, must be called under protection of mutex lock
+func (u *Upstream) setupSessions() {
+	switch {
+	case u.Sticky && u.sessions == nil:
+		u.sessions = newSessions()
+	case !u.Sticky && u.sessions != nil:
+		u.sessions.stop()
+		u.sessions = nil
+	}
+
+	if u.sessions != nil {
+		u.sessions.resize(u.MaxSessions)
+	}
+}
+
+func getSessions(u *Upstream) *Sessions {
+	mgr.RLock()
+	defer mgr.RUnlock()
+	return u.sessions
+}
+
+// maskIP returns the client subnet by the upstream's sticky masks, so that all of the
+// clients within the same subnet share the session. the unparsable ip is returned as is.
+func (u *Upstream) maskIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		if u.StickyMask == 0 || u.StickyMask == 32 {
+			return ip
+		}
+		return v4.Mask(net.CIDRMask(u.StickyMask, 32)).String() + "/" + strconv.Itoa(u.StickyMask)
+	}
+
+	if u.StickyMask6 == 0 || u.StickyMask6 == 128 {
+		return ip
+	}
+	return parsed.Mask(net.CIDRMask(u.StickyMask6, 128)).String() + "/" + strconv.Itoa(u.StickyMask6)
+}
+
 func (s *Sessions) MarshalJSON() ([]byte, error) {
 	return json.Marshal(s.m)
 }
 
-func (s *Sessions) get(ip string) *Backend {
+func (s *Sessions) get(key string) *Backend {
 	s.RLock()
 	defer s.RUnlock()
-	sess, ok := s.m[ip]
+	sess, ok := s.m[key]
 	if !ok {
 		return nil
 	}
 	return sess.Backend
 }
 
-func (s *Sessions) update(ip string, b *Backend) {
+// update records the session of the key on the backend and marks it the most recently used,
+// the least recently used one is evicted once beyond the max, which gets rebalanced next time.
+func (s *Sessions) update(key string, b *Backend) {
 	s.Lock()
-	s.m[ip] = &session{b, time.Now()}
+	defer s.Unlock()
+
+	if sess, ok := s.m[key]; ok {
+		sess.Backend, sess.UpdatedAt = b, time.Now()
+		s.lru.MoveToFront(sess.elem)
+		return
+	}
+
+	sess := &session{Backend: b, UpdatedAt: time.Now(), key: key}
+	sess.elem = s.lru.PushFront(sess)
+	s.m[key] = sess
+
+	s.evict()
+}
+
+// resize sets the max nb of sessions, 0 for unlimited, and evicts the exceeded at once
+func (s *Sessions) resize(max int) {
+	s.Lock()
+	s.max = max
+	s.evict()
 	s.Unlock()
 }
 
+// evict drops the least recently used sessions beyond the max, must be called with lock held
+func (s *Sessions) evict() {
+	for s.max > 0 && len(s.m) > s.max {
+		s.delete(s.lru.Back().Value.(*session))
+	}
+}
+
+// delete must be called with lock held
+func (s *Sessions) delete(sess *session) {
+	s.lru.Remove(sess.elem)
+	delete(s.m, sess.key)
+}
+
+// restore records the session of the key on the backend as updated at the time, it's skipped if
+// outdated already or the key exists. the sessions are restored the least recently used first.
+func (s *Sessions) restore(key string, b *Backend, updatedAt time.Time) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.m[key]; ok || updatedAt.Before(time.Now().Add(-s.timeout)) {
+		return false
+	}
+
+	sess := &session{Backend: b, UpdatedAt: updatedAt, key: key}
+	sess.elem = s.lru.PushFront(sess)
+	s.m[key] = sess
+
+	s.evict()
+	return true
+}
+
+// count returns the nb of sessions on the backend
+func (s *Sessions) count(backend string) int {
+	if s == nil {
+		return 0
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	n := 0
+	for _, v := range s.m {
+		if v.Backend.ID == backend {
+			n++
+		}
+	}
+	return n
+}
+
 func (s *Sessions) remove(backend string) {
+	if s == nil {
+		return
+	}
+
 	s.Lock()
-	for k, v := range s.m {
+	for _, v := range s.m {
 		if v.Backend.ID == backend {
-			delete(s.m, k)
+			s.delete(v)
 		}
 	}
 	s.Unlock()
@@ -76,7 +204,7 @@ func (s *Sessions) gc() {
 			for key, session := range s.m {
 				if session.UpdatedAt.Before(time.Now().Add(-s.timeout)) {
 					log.Printf("clean up outdated session: %s -> %s", key, session.Backend.ID)
-					delete(s.m, key)
+					s.delete(session)
 				}
 			}
 			s.Unlock()
@@ -89,6 +217,100 @@ func (s *Sessions) gc() {
 
 // stop gc and clean up
 func (s *Sessions) stop() {
+	if s == nil {
+		return
+	}
+
 	close(s.stopCh)
+
+	s.Lock()
 	s.m = map[string]*session{} // gc friendly
+	s.lru = list.New()
+	s.Unlock()
+}
+
+// SessionRecord is the persisted sticky session
+type SessionRecord struct {
+	Backend   string    `json:"backend"` // backend id
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SessionsKey is the key of the upstream's sticky sessions within the snapshot, the upstreams of the
+// multi-port app share the name, so they are told apart by the target, eg: `nginx.default.bbk.dataman:80`.
+func SessionsKey(name, target string) string {
+	return name + ":" + target
+}
+
+// parseSessionsKey splits the key into the upstream name & target, the key saved by the previous
+// versions is the name only, ok is false for it.
+func parseSessionsKey(key string) (name, target string, ok bool) {
+	i := strings.LastIndex(key, ":")
+	if i < 0 {
+		return key, "", false
+	}
+	return key[:i], key[i+1:], true
+}
+
+// SessionsSnapshot returns the sticky sessions of all upstreams: upstream key -> session key -> record,
+// see SessionsKey for the upstream key.
+func SessionsSnapshot() map[string]map[string]*SessionRecord {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	ret := make(map[string]map[string]*SessionRecord)
+	for _, u := range mgr.Upstreams {
+		if u.sessions == nil {
+			continue
+		}
+
+		u.sessions.RLock()
+		if len(u.sessions.m) > 0 {
+			recs := make(map[string]*SessionRecord, len(u.sessions.m))
+			for key, sess := range u.sessions.m {
+				recs[key] = &SessionRecord{Backend: sess.Backend.ID, UpdatedAt: sess.UpdatedAt}
+			}
+			ret[SessionsKey(u.Name, u.Target)] = recs
+		}
+		u.sessions.RUnlock()
+	}
+	return ret
+}
+
+// RestoreSessions restores the sticky sessions of the snapshot onto the rebuilt upstreams, the
+// ones whose upstream or backend no longer exists, or already outdated, are pruned.
+func RestoreSessions(snap map[string]map[string]*SessionRecord) (restored, pruned int) {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	for uk, recs := range snap {
+		var u *Upstream
+		if name, target, ok := parseSessionsKey(uk); ok {
+			_, u = getUpstreamByNameAndTarget(name, target)
+		} else {
+			_, u = getUpstreamByName(name)
+		}
+
+		if u == nil || u.sessions == nil {
+			pruned += len(recs)
+			continue
+		}
+
+		// the least recently used first, so that the lru order is kept
+		keys := make([]string, 0, len(recs))
+		for key := range recs {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return recs[keys[i]].UpdatedAt.Before(recs[keys[j]].UpdatedAt) })
+
+		for _, key := range keys {
+			rec := recs[key]
+			if _, b := u.search(rec.Backend); b != nil && u.sessions.restore(key, b, rec.UpdatedAt) {
+				restored++
+				continue
+			}
+			pruned++
+		}
+	}
+
+	return
 }
@@ -0,0 +1,326 @@
+package upstream
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStickyHeader(t *testing.T) {
+	var (
+		a = &Backend{ID: "a", Weight: 1}
+		b = &Backend{ID: "b", Weight: 1}
+		c = &Backend{ID: "c", Weight: 1}
+		u = &Upstream{
+			Name:         "test",
+			Sticky:       true,
+			StickyHeader: "X-User-ID",
+			Backends:     []*Backend{a, b, c},
+			sessions:     newSessions(),
+			balancer:     newBalancer(BalancerWRR),
+		}
+	)
+	defer u.sessions.stop()
+
+	client := func(ip, user string) *Client {
+		h := make(http.Header)
+		if user != "" {
+			h.Set("X-User-ID", user)
+		}
+		return &Client{IP: ip, Header: h}
+	}
+
+	lookup := func(c *Client) string {
+		cmb, err := Lookup(c, u, "")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		return cmb.Backend.ID
+	}
+
+	var (
+		alice = lookup(client("10.0.0.1", "alice"))
+		bob   = lookup(client("10.0.0.1", "bob"))
+	)
+
+	if alice == bob {
+		t.Fatalf("distinct header values from the same ip should be balanced, both got %s", alice)
+	}
+
+	// stable on the header value, regardless of the client ip
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if got := lookup(client(ip, "alice")); got != alice {
+			t.Errorf("#%d alice got %s, want %s", i, got, alice)
+		}
+		if got := lookup(client(ip, "bob")); got != bob {
+			t.Errorf("#%d bob got %s, want %s", i, got, bob)
+		}
+	}
+
+	// missing header falls back to the balancer without recording session
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		seen[lookup(client("10.0.0.1", ""))] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("requests without header should be balanced, got %v", seen)
+	}
+
+	if n := len(u.sessions.m); n != 2 {
+		t.Errorf("nb of sessions = %d, want 2", n)
+	}
+}
+
+func TestStickyMask(t *testing.T) {
+	tests := []struct {
+		name  string
+		mask  int
+		mask6 int
+		a, b  string // client ips
+		share bool   // share the session key or not
+	}{
+		{name: "default v4", a: "10.0.0.1", b: "10.0.0.2"},
+		{name: "explicit /32", mask: 32, a: "10.0.0.1", b: "10.0.0.2"},
+		{name: "/24 same subnet", mask: 24, a: "10.0.0.1", b: "10.0.0.254", share: true},
+		{name: "/24 other subnet", mask: 24, a: "10.0.0.1", b: "10.0.1.1"},
+		{name: "/16 same subnet", mask: 16, a: "10.0.0.1", b: "10.0.200.1", share: true},
+		{name: "default v6", a: "2001:db8::1", b: "2001:db8::2"},
+		{name: "/64 same subnet", mask6: 64, a: "2001:db8::1", b: "2001:db8::ffff:1", share: true},
+		{name: "/64 other subnet", mask6: 64, a: "2001:db8::1", b: "2001:db8:0:1::1"},
+		{name: "v4 mask not applied to v6", mask: 24, a: "2001:db8::1", b: "2001:db8::2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &Upstream{Name: "test", Sticky: true, StickyMask: tt.mask, StickyMask6: tt.mask6}
+			ka, kb := u.sessionKey(&Client{IP: tt.a}), u.sessionKey(&Client{IP: tt.b})
+			if (ka == kb) != tt.share {
+				t.Errorf("session keys %s & %s, want shared %v", ka, kb, tt.share)
+			}
+		})
+	}
+}
+
+func TestStickyMaskShareBackend(t *testing.T) {
+	var (
+		a = &Backend{ID: "a", Weight: 1}
+		b = &Backend{ID: "b", Weight: 1}
+		u = &Upstream{
+			Name:       "test",
+			Sticky:     true,
+			StickyMask: 24,
+			Backends:   []*Backend{a, b},
+			sessions:   newSessions(),
+			balancer:   newBalancer(BalancerWRR),
+		}
+	)
+	defer u.sessions.stop()
+
+	lookup := func(ip string) string {
+		cmb, err := Lookup(&Client{IP: ip}, u, "")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		return cmb.Backend.ID
+	}
+
+	var (
+		first = lookup("192.168.10.1")
+		other = lookup("192.168.11.1") // another subnet, balanced to the other backend
+	)
+	if first == other {
+		t.Fatalf("clients of distinct subnets should be balanced, both got %s", first)
+	}
+
+	for _, ip := range []string{"192.168.10.2", "192.168.10.100", "192.168.10.254"} {
+		if got := lookup(ip); got != first {
+			t.Errorf("client %s got %s, want %s shared within the subnet", ip, got, first)
+		}
+	}
+}
+
+func TestSessionsLRU(t *testing.T) {
+	var (
+		a = &Backend{ID: "a"}
+		b = &Backend{ID: "b"}
+		s = newSessions()
+	)
+	defer s.stop()
+	s.resize(3)
+
+	s.update("c1", a)
+	s.update("c2", b)
+	s.update("c3", a)
+	s.update("c1", b) // c1 most recently used, c2 least
+	s.update("c4", a)
+
+	if n := len(s.m); n != 3 {
+		t.Fatalf("nb of sessions = %d, want capped at 3", n)
+	}
+	if s.get("c2") != nil {
+		t.Errorf("least recently used c2 should be evicted")
+	}
+	for _, key := range []string{"c1", "c3", "c4"} {
+		if s.get(key) == nil {
+			t.Errorf("session %s should be kept", key)
+		}
+	}
+	if got := s.get("c1"); got != b {
+		t.Errorf("session c1 on %v, want updated to b", got)
+	}
+
+	// shrinking evicts the least recently used at once
+	s.resize(1)
+	if n := len(s.m); n != 1 || s.get("c4") == nil {
+		t.Errorf("sessions after shrinking = %d, want only c4 kept", n)
+	}
+
+	// removing the backend drops its sessions from the lru too
+	s.remove("a")
+	if n := s.lru.Len(); n != 0 || len(s.m) != 0 {
+		t.Errorf("lru len = %d, sessions = %d after removed, want 0", n, len(s.m))
+	}
+
+	s.resize(0)
+	for i := 0; i < 10; i++ {
+		s.update(string('a'+rune(i)), a)
+	}
+	if n := len(s.m); n != 10 {
+		t.Errorf("nb of sessions = %d, want unlimited 10", n)
+	}
+}
+
+func TestMaxSessionsRebalance(t *testing.T) {
+	var (
+		a = &Backend{ID: "a", Weight: 1}
+		b = &Backend{ID: "b", Weight: 1}
+		u = &Upstream{
+			Name:        "test",
+			Sticky:      true,
+			MaxSessions: 1,
+			Backends:    []*Backend{a, b},
+			sessions:    newSessions(),
+			balancer:    newBalancer(BalancerWRR),
+		}
+	)
+	defer u.sessions.stop()
+	u.sessions.resize(u.MaxSessions)
+
+	lookup := func(ip string) string {
+		cmb, err := Lookup(&Client{IP: ip}, u, "")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		return cmb.Backend.ID
+	}
+
+	first := lookup("10.0.0.1")
+	if got := lookup("10.0.0.1"); got != first {
+		t.Fatalf("sticky client got %s, want %s", got, first)
+	}
+
+	lookup("10.0.0.2") // evicts 10.0.0.1
+	if _, ok := u.sessions.m["10.0.0.1"]; ok || len(u.sessions.m) != 1 {
+		t.Errorf("sessions = %v, want 10.0.0.1 evicted", u.sessions.m)
+	}
+
+	// the evicted client is simply rebalanced with a new session
+	lookup("10.0.0.1")
+	if _, ok := u.sessions.m["10.0.0.1"]; !ok || len(u.sessions.m) != 1 {
+		t.Errorf("sessions = %v, want only 10.0.0.1 recorded again", u.sessions.m)
+	}
+}
+
+func TestSessionsRestore(t *testing.T) {
+	var (
+		a   = &Backend{ID: "a"}
+		s   = newSessions()
+		now = time.Now()
+	)
+	defer s.stop()
+	s.resize(2)
+
+	if s.restore("outdated", a, now.Add(-s.timeout-time.Minute)) {
+		t.Errorf("outdated session should not be restored")
+	}
+
+	// restored the least recently used first
+	for i, key := range []string{"c1", "c2", "c3"} {
+		if !s.restore(key, a, now.Add(time.Duration(i-3)*time.Minute)) {
+			t.Errorf("session %s should be restored", key)
+		}
+	}
+	if s.restore("c3", a, now) {
+		t.Errorf("existing session should not be overwritten")
+	}
+
+	if s.get("c1") != nil || s.get("c2") == nil || s.get("c3") == nil {
+		t.Errorf("sessions = %v, want the least recently used c1 evicted", s.m)
+	}
+	if got := s.m["c2"].UpdatedAt; !got.Equal(now.Add(-2 * time.Minute)) {
+		t.Errorf("restored c2 updated at %s, want kept as %s", got, now.Add(-2*time.Minute))
+	}
+}
+
+func TestStickyDisabled(t *testing.T) {
+	var (
+		ups = &Upstream{Name: "stateless.default.bbk.dataman"}
+		a   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "a.stateless.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 1}}
+		b   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "b.stateless.default.bbk.dataman", IP: "192.168.1.102", Port: 31001, Weight: 1}}
+	)
+
+	for _, cmb := range []*BackendCombined{a, b} {
+		if _, err := UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		RemoveBackend(a)
+		RemoveBackend(b)
+	}()
+
+	u := GetUpstream(ups.Name)
+
+	seen := func() map[string]bool {
+		ret := make(map[string]bool)
+		for i := 0; i < 4; i++ {
+			cmb, err := Lookup(&Client{IP: "10.0.0.1"}, u, "")
+			if err != nil {
+				t.Fatalf("Lookup() error = %v", err)
+			}
+			ret[cmb.Backend.ID] = true
+		}
+		return ret
+	}
+
+	// no sessions allocated, the same client is balanced
+	if getSessions(u) != nil {
+		t.Errorf("sessions allocated for the non-sticky upstream")
+	}
+	if _, ok := AllSessions()[ups.Name]; ok {
+		t.Errorf("AllSessions() includes the non-sticky upstream")
+	}
+	if got := seen(); len(got) != 2 {
+		t.Errorf("non-sticky client got %v, want balanced on both", got)
+	}
+
+	// hot enabled
+	sticky := &Upstream{Name: ups.Name, Sticky: true}
+	if _, err := UpsertBackend(&BackendCombined{Upstream: sticky, Backend: a.Backend}); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	if got := seen(); len(got) != 1 {
+		t.Errorf("sticky client got %v, want pinned on one", got)
+	}
+
+	// hot disabled again, the sessions released
+	if _, err := UpsertBackend(&BackendCombined{Upstream: ups, Backend: a.Backend}); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	if getSessions(u) != nil {
+		t.Errorf("sessions not released once sticky disabled")
+	}
+	if got := seen(); len(got) != 2 {
+		t.Errorf("non-sticky client got %v, want balanced on both", got)
+	}
+}
@@ -0,0 +1,54 @@
+package upstream
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	slowStart   time.Duration // slow start window of newly added backends, 0 to disable
+	slowStartMu sync.RWMutex  // protect slowStart
+)
+
+// SetSlowStart setup the slow start window, during which the effective weight of a newly
+// added backend ramps linearly from near-zero to its configured weight.
+func SetSlowStart(window time.Duration) {
+	slowStartMu.Lock()
+	slowStart = window
+	slowStartMu.Unlock()
+}
+
+func getSlowStart() time.Duration {
+	slowStartMu.RLock()
+	defer slowStartMu.RUnlock()
+	return slowStart
+}
+
+// effectiveWeight returns the weight taking the slow start into account, it's never
+// less than min(weight, 1) so a ramping backend always has a chance to be selected.
+func (b *Backend) effectiveWeight(now time.Time) float64 {
+	window := getSlowStart()
+	if window <= 0 || b.addedAt.IsZero() || b.Weight <= 0 {
+		return b.Weight
+	}
+
+	elapsed := now.Sub(b.addedAt)
+	if elapsed >= window {
+		return b.Weight
+	}
+
+	w := b.Weight * float64(elapsed) / float64(window)
+	return math.Max(w, math.Min(b.Weight, 1))
+}
+
+func effectiveWeights(bs []*Backend) []float64 {
+	var (
+		now = time.Now()
+		ws  = make([]float64, len(bs))
+	)
+	for i, b := range bs {
+		ws[i] = b.effectiveWeight(now)
+	}
+	return ws
+}
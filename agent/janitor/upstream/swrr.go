@@ -0,0 +1,64 @@
+package upstream
+
+import (
+	"sync"
+	"time"
+)
+
+// swrrBalancer is the smooth weighted round-robin balancer as nginx does:
+// on each selection, every backend's current weight is increased by its
+// effective weight, the one with the highest current weight is selected
+// and its current weight is decreased by the total weight.
+// so that a 5:1:1 weighting is interleaved as `a a b a c a a` rather than clustering.
+type swrrBalancer struct {
+	sync.Mutex                    // protect current
+	current    map[string]float64 // backend id -> current weight
+}
+
+func newSwrrBalancer() *swrrBalancer {
+	return &swrrBalancer{
+		current: make(map[string]float64),
+	}
+}
+
+func (b *swrrBalancer) Next(bs []*Backend) *Backend {
+	b.Lock()
+	defer b.Unlock()
+
+	var (
+		best  *Backend
+		total float64
+		alive = make(map[string]bool, len(bs))
+	)
+
+	now := time.Now()
+	for _, backend := range bs {
+		alive[backend.ID] = true
+
+		w := backend.effectiveWeight(now)
+		if w <= 0 {
+			continue
+		}
+
+		b.current[backend.ID] += w
+		total += w
+
+		if best == nil || b.current[backend.ID] > b.current[best.ID] {
+			best = backend
+		}
+	}
+
+	// drop the states of removed backends
+	for id := range b.current {
+		if !alive[id] {
+			delete(b.current, id)
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	b.current[best.ID] -= total
+	return best
+}
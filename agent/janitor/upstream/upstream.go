@@ -3,12 +3,24 @@ package upstream
 import (
 	"errors"
 	"fmt"
+	"math"
+	"net/http"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/utils/labels"
+
+	log "github.com/Sirupsen/logrus"
 )
 
 var mgr *UpsManager
 
+var defaultConnectTimeout = time.Second * 5
+
+// the default idle timeout of the udp sessions
+var defaultUDPTimeout = time.Second * 30
+
 func init() {
 	mgr = &UpsManager{
 		Upstreams: make([]*Upstream, 0, 0),
@@ -23,35 +35,149 @@ type UpsManager struct {
 }
 
 type Upstream struct {
-	Name     string     `json:"name"`     // uniq name
-	Alias    string     `json:"alias"`    // advertised url
-	Listen   string     `json:"listen"`   // listen addr
-	Target   string     `json:"target"`   // target addr
-	Sticky   bool       `json:"sticky"`   // session sticky enabled (default no)
-	Backends []*Backend `json:"backends"` // backend servers
+	Name            string        `json:"name"`                       // uniq name
+	Alias           string        `json:"alias"`                      // advertised url
+	Listen          string        `json:"listen"`                     // listen addr
+	Protocol        string        `json:"protocol"`                   // protocol proxied on the listen addr: tcp (default) / udp
+	UDPTimeout      time.Duration `json:"udp_timeout"`                // udp session closed after idle for the timeout, default 30s
+	Target          string        `json:"target"`                     // target addr
+	Sticky          bool          `json:"sticky"`                     // session sticky enabled (default no)
+	StickyHeader    string        `json:"sticky_header"`              // session sticky by the request header value rather than client ip, eg: X-User-ID
+	StickyCookie    *StickyCookie `json:"sticky_cookie,omitempty"`    // session sticky by the affinity cookie issued by the proxy, http only
+	StickyMask      int           `json:"sticky_mask"`                // session sticky by the client ipv4 subnet of the prefix length, eg: 24, 0 for 32
+	StickyMask6     int           `json:"sticky_mask6"`               // session sticky by the client ipv6 subnet of the prefix length, eg: 64, 0 for 128
+	MaxSessions     int           `json:"max_sessions"`               // max nb of sticky sessions, the least recently used evicted beyond, 0 for unlimited
+	Balance         string        `json:"balance"`                    // balancer name: wrr (default) / swrr / leasttime / leasttime_conn / wlc
+	TLS             *TLSConfig    `json:"tls,omitempty"`              // tls setup to the https backends, nil to skip verify
+	Redirect        *Redirect     `json:"redirect,omitempty"`         // redirect the plain http requests to https, nil to disable
+	BasicAuth       *BasicAuth    `json:"basic_auth,omitempty"`       // enforce http basic auth in front of the backends, nil to disable
+	JWT             *JWT          `json:"jwt,omitempty"`              // enforce jwt bearer token in front of the backends, nil to disable
+	SendProxy       string        `json:"send_proxy"`                 // emit the PROXY protocol header toward backends: v1 / v2, empty to disable
+	Limit           *Limit        `json:"limit,omitempty"`            // max in-flight requests with a bounded wait queue, nil for unlimited
+	Warmup          *Warmup       `json:"warmup,omitempty"`           // warmup requests to the newly added backends before the live traffic, nil to disable
+	ConnectTimeout  time.Duration `json:"connect_timeout"`            // timeout of connecting to the backends, default 5s
+	HostHeader      string        `json:"host_header"`                // the Host header toward the backends: $backend for the backend address, empty to pass through
+	RequestHeaders  HeaderRules   `json:"request_headers,omitempty"`  // rewriting rules of the request headers toward the backends
+	ResponseHeaders HeaderRules   `json:"response_headers,omitempty"` // rewriting rules of the backends' response headers
+	ErrorPages      ErrorPages    `json:"error_pages,omitempty"`      // custom responses of the proxy failures by the status code
+	Streaming       bool          `json:"streaming"`                  // flush all of the responses to the clients incrementally, besides the detected streaming ones
+	Bandwidth       *Bandwidth    `json:"bandwidth,omitempty"`        // max bytes/sec transferred between the clients and the backends, nil for unlimited
+	Duplicates      string        `json:"duplicates"`                 // policy on the backends registered by the same ip:port: reject / merge, empty to allow
+	LabelHeader     string        `json:"label_header"`               // request header carrying the label selector to route by the backend labels, http only
+	Canary          *Canary       `json:"canary,omitempty"`           // traffic split of the canary version, runtime adjusted by api, nil for none
+	Breaker         *Breaker      `json:"breaker,omitempty"`          // circuit breaker on each backend by the consecutive failures, nil to disable
+	Hedge           *Hedge        `json:"hedge,omitempty"`            // hedge the slow idempotent requests to another backend, http only, nil to disable
+	Backends        []*Backend    `json:"backends"`                   // backend servers
+
+	sessions  *Sessions  // runtime, nil if sticky disabled
+	balancer  Balancer   // runtime
+	limiter   *limiter   // runtime, nil for unlimited
+	throttles *throttles // runtime, nil for unlimited bandwidth
+	hedging   int64      // runtime, in-flight hedged requests, atomic
+}
+
+// TLSConfig is the tls setup to the https backends, the files are reloaded on changes
+type TLSConfig struct {
+	CertFile   string `json:"cert_file"`   // client certificate presented to backends, optional
+	KeyFile    string `json:"key_file"`    // client certificate key
+	CAFile     string `json:"ca_file"`     // CA bundle to verify the backends certificate, empty to skip verify
+	ServerName string `json:"server_name"` // override the server name to verify, default the backend ip
+}
 
-	sessions *Sessions // runtime
-	balancer Balancer  // runtime
+// BasicAuth is the http basic auth setup enforced by the proxy
+type BasicAuth struct {
+	Realm       string            `json:"realm"`        // realm advertised in the challenge
+	Users       map[string]string `json:"users"`        // user -> password hash: bcrypt / {SHA256}base64 / {SHA}base64
+	ExemptPaths []string          `json:"exempt_paths"` // paths pass without credentials, eg: /health
+}
+
+// JWT is the bearer token verification setup enforced by the proxy
+type JWT struct {
+	Secret        string            `json:"secret"`         // shared secret to verify HS256 tokens
+	JWKSURL       string            `json:"jwks_url"`       // url of the JWKS to verify RS256 tokens
+	Audience      string            `json:"audience"`       // required audience, empty to skip verify
+	Issuer        string            `json:"issuer"`         // required issuer, empty to skip verify
+	RequireExp    bool              `json:"require_exp"`    // reject the tokens without exp claim
+	ForwardClaims map[string]string `json:"forward_claims"` // claim -> request header forwarded to backends, eg: sub -> X-User-ID
+}
+
+// Redirect is the setup to redirect the plain http requests to https
+type Redirect struct {
+	Code int `json:"code"` // redirect status code: 301 (default) / 308
+	Port int `json:"port"` // https port, default 443
 }
 
 func (u *Upstream) String() string {
-	return fmt.Sprintf("name=%s, alias=%s, listen=%s, sticky=%v", u.Name, u.Alias, u.Listen, u.Sticky)
+	return fmt.Sprintf("name=%s, alias=%s, listen=%s, sticky=%v, sticky_header=%s, balance=%s", u.Name, u.Alias, u.Listen, u.Sticky, u.StickyHeader, u.Balance)
 }
 
 func newUpstream(first *BackendCombined) *Upstream {
-	return &Upstream{
-		Name:     first.Upstream.Name,
-		Alias:    first.Upstream.Alias,
-		Listen:   first.Upstream.Listen,
-		Target:   first.Upstream.Target,
-		Sticky:   first.Upstream.Sticky,
-		Backends: []*Backend{first.Backend},
-		sessions: newSessions(), // sessions store
-		balancer: &wrrBalancer{
-			index: -1,
-			cw:    0,
-		}, // default balancer
+	u := &Upstream{
+		Name:            first.Upstream.Name,
+		Alias:           first.Upstream.Alias,
+		Listen:          first.Upstream.Listen,
+		Protocol:        first.Upstream.Protocol,
+		UDPTimeout:      first.Upstream.UDPTimeout,
+		Target:          first.Upstream.Target,
+		Sticky:          first.Upstream.Sticky,
+		StickyHeader:    first.Upstream.StickyHeader,
+		StickyCookie:    first.Upstream.StickyCookie,
+		StickyMask:      first.Upstream.StickyMask,
+		StickyMask6:     first.Upstream.StickyMask6,
+		MaxSessions:     first.Upstream.MaxSessions,
+		Balance:         first.Upstream.Balance,
+		TLS:             first.Upstream.TLS,
+		Redirect:        first.Upstream.Redirect,
+		BasicAuth:       first.Upstream.BasicAuth,
+		JWT:             first.Upstream.JWT,
+		SendProxy:       first.Upstream.SendProxy,
+		Limit:           first.Upstream.Limit,
+		Warmup:          first.Upstream.Warmup,
+		ConnectTimeout:  first.Upstream.ConnectTimeout,
+		HostHeader:      first.Upstream.HostHeader,
+		RequestHeaders:  first.Upstream.RequestHeaders,
+		ResponseHeaders: first.Upstream.ResponseHeaders,
+		ErrorPages:      first.Upstream.ErrorPages,
+		Streaming:       first.Upstream.Streaming,
+		Bandwidth:       first.Upstream.Bandwidth,
+		Duplicates:      first.Upstream.Duplicates,
+		LabelHeader:     first.Upstream.LabelHeader,
+		Breaker:         first.Upstream.Breaker,
+		Hedge:           first.Upstream.Hedge,
+		Canary:          first.Upstream.Canary,
+		Backends:        []*Backend{first.Backend},
+		balancer:        newBalancer(first.Upstream.Balance),    // balancer
+		limiter:         newLimiter(first.Upstream.Limit),       // in-flight limiter
+		throttles:       newThrottles(first.Upstream.Bandwidth), // bandwidth throttles
 	}
+	u.setupSessions() // sessions store
+
+	return u
+}
+
+// sessionKey returns the sessions key of the client, empty if sticky disabled or
+// the sticky cookie / header missing, which falls back to the balancer.
+func (u *Upstream) sessionKey(c *Client) string {
+	if !u.Sticky || c == nil {
+		return ""
+	}
+
+	if u.StickyCookie != nil {
+		if c.Cookie != "" {
+			return "cookie:" + c.Cookie
+		}
+		return ""
+	}
+
+	if u.StickyHeader == "" {
+		return u.maskIP(c.IP)
+	}
+
+	if v := c.Header.Get(u.StickyHeader); v != "" {
+		return "header:" + v
+	}
+
+	return ""
 }
 
 func (u *Upstream) valid() error {
@@ -61,9 +187,86 @@ func (u *Upstream) valid() error {
 	if u.Name == "" {
 		return errors.New("upstream name required")
 	}
+	switch u.SendProxy {
+	case "", "v1", "v2":
+	default:
+		return fmt.Errorf("upstream send proxy [%s] invalid, should be v1 or v2", u.SendProxy)
+	}
+	if u.StickyMask < 0 || u.StickyMask > 32 {
+		return fmt.Errorf("upstream sticky mask [%d] invalid, should be within 0-32", u.StickyMask)
+	}
+	if u.StickyMask6 < 0 || u.StickyMask6 > 128 {
+		return fmt.Errorf("upstream sticky mask6 [%d] invalid, should be within 0-128", u.StickyMask6)
+	}
+	if u.MaxSessions < 0 {
+		return fmt.Errorf("upstream max sessions [%d] invalid, should not be negative", u.MaxSessions)
+	}
+	if err := u.Limit.valid(); err != nil {
+		return err
+	}
+	if err := u.Warmup.valid(); err != nil {
+		return err
+	}
+	if u.ConnectTimeout < 0 {
+		return fmt.Errorf("upstream connect timeout [%s] invalid, should not be negative", u.ConnectTimeout)
+	}
+	if err := u.RequestHeaders.valid(); err != nil {
+		return fmt.Errorf("upstream request headers: %v", err)
+	}
+	if err := u.ResponseHeaders.valid(); err != nil {
+		return fmt.Errorf("upstream response headers: %v", err)
+	}
+	if err := u.ErrorPages.valid(); err != nil {
+		return fmt.Errorf("upstream %v", err)
+	}
+	if err := u.Bandwidth.valid(); err != nil {
+		return err
+	}
+	if err := u.Canary.valid(); err != nil {
+		return err
+	}
+	if err := u.StickyCookie.valid(); err != nil {
+		return err
+	}
+	if err := u.Breaker.valid(); err != nil {
+		return err
+	}
+	if err := u.Hedge.valid(); err != nil {
+		return err
+	}
+	switch u.Protocol {
+	case "", ProtocolTCP, ProtocolUDP:
+	default:
+		return fmt.Errorf("upstream protocol [%s] invalid, should be tcp or udp", u.Protocol)
+	}
+	if u.UDPTimeout < 0 {
+		return fmt.Errorf("upstream udp timeout [%s] invalid, should not be negative", u.UDPTimeout)
+	}
+	switch u.Duplicates {
+	case "", DuplicateReject, DuplicateMerge:
+	default:
+		return fmt.Errorf("upstream duplicates [%s] invalid, should be reject or merge", u.Duplicates)
+	}
 	return nil
 }
 
+// DialTimeout returns the timeout of connecting to the backends, so that the unreachable
+// ones fail fast rather than blocking for the system default.
+func (u *Upstream) DialTimeout() time.Duration {
+	if u.ConnectTimeout > 0 {
+		return u.ConnectTimeout
+	}
+	return defaultConnectTimeout
+}
+
+// UDPSessionTimeout returns the idle timeout of the udp sessions
+func (u *Upstream) UDPSessionTimeout() time.Duration {
+	if u.UDPTimeout > 0 {
+		return u.UDPTimeout
+	}
+	return defaultUDPTimeout
+}
+
 func (u *Upstream) search(name string) (int, *Backend) {
 	for i, v := range u.Backends {
 		if v.ID == name || v.CleanName == name {
@@ -73,6 +276,44 @@ func (u *Upstream) search(name string) (int, *Backend) {
 	return -1, nil
 }
 
+// searchAddr returns the backend other than the excluded one by the endpoint ip:port
+func (u *Upstream) searchAddr(ip string, port uint64, exclude *Backend) (int, *Backend) {
+	for i, v := range u.Backends {
+		if v != exclude && v.IP == ip && v.Port == port {
+			return i, v
+		}
+	}
+	return -1, nil
+}
+
+// duplicated applies the registered duplicates policy on the backend registered by the endpoint
+// of another one, eg: two task ids resolved to the same ip:port by a registration bug. it returns
+// the index of the other one to be merged, -1 if nothing to merge, or error if rejected.
+// note: must be called under protection of mutex lock
+func (u *Upstream) duplicated(cmb *BackendCombined, exclude *Backend) (int, error) {
+	policy := cmb.Upstream.Duplicates
+	if policy == "" {
+		return -1, nil
+	}
+
+	idx, dup := u.searchAddr(cmb.Backend.IP, cmb.Backend.Port, exclude)
+	if dup == nil {
+		return -1, nil
+	}
+
+	log.Warnf("upstream %s backend %s endpoint %s conflicts with backend %s, %s",
+		u.Name, cmb.Backend.ID, cmb.Backend.Addr(), dup.ID, policy)
+
+	if policy == DuplicateReject {
+		return -1, fmt.Errorf("backend %s endpoint %s duplicated with backend %s", cmb.Backend.ID, cmb.Backend.Addr(), dup.ID)
+	}
+	return idx, nil
+}
+
+func (u *Upstream) same(o *Upstream) bool {
+	return u.Name == o.Name && u.Target == o.Target
+}
+
 func (u *Upstream) tcpListen() string {
 	if u.Listen == "" {
 		return ""
@@ -88,14 +329,24 @@ func (u *Upstream) tcpListen() string {
 
 // Backend
 type Backend struct {
-	ID         string  `json:"id"`          // backend server id(name)
-	IP         string  `json:"ip"`          // backend server ip
-	Port       uint64  `json:"port"`        // backend server port
-	TargetPort uint64  `json:"target_port"` // target port
-	Scheme     string  `json:"scheme"`      // http / https, auto detect & setup by httpProxy
-	Version    string  `json:"version"`
-	Weight     float64 `json:"weihgt"`
-	CleanName  string  `json:"clean_name"` // backend server clean id(name)
+	ID         string            `json:"id"`          // backend server id(name)
+	IP         string            `json:"ip"`          // backend server ip
+	Port       uint64            `json:"port"`        // backend server port
+	TargetPort uint64            `json:"target_port"` // target port
+	Scheme     string            `json:"scheme"`      // http / https, auto detect & setup by httpProxy
+	Version    string            `json:"version"`
+	Weight     float64           `json:"weihgt"`
+	CleanName  string            `json:"clean_name"`           // backend server clean id(name)
+	Labels     map[string]string `json:"labels,omitempty"`     // routed by the upstream's label_header selector
+	RateLimit  *RateLimit        `json:"rate_limit,omitempty"` // max requests/sec proxied to the backend, nil for unlimited
+
+	addedAt      time.Time // runtime, when the backend added, for slow start
+	ejectedUntil time.Time // runtime, taken out of the balancing until
+	warming      bool      // runtime, taken out of the balancing until warmed up
+	selections   uint64    // runtime, atomic, nb of times selected by lookup
+	lastSelected int64     // runtime, atomic, unix nano of the last selection
+	circuit      *circuit  // runtime, nil until the breaker reported
+	bucket       *bucket   // runtime, nil for unlimited rate
 }
 
 func (b *Backend) String() string {
@@ -115,24 +366,81 @@ func (b *Backend) valid() error {
 	if b.Port == 0 {
 		return errors.New("backend port required")
 	}
-	return nil
+	// zero weight means draining, see draining()
+	if math.IsNaN(b.Weight) || math.IsInf(b.Weight, 0) || b.Weight < 0 {
+		return fmt.Errorf("backend weight [%v] invalid, should be a finite non-negative number", b.Weight)
+	}
+	return b.RateLimit.valid()
 }
 
 func (b *Backend) Addr() string {
 	return fmt.Sprintf("%s:%d", b.IP, b.Port)
 }
 
+// naming validation policies of the backend id
+const (
+	NamingStrict  = "strict"  // backend id must be suffixed by ".{upstream name}"
+	NamingRelaxed = "relaxed" // only non-empty upstream name & backend id required
+)
+
+// Client describes the requesting client, for sessions sticky
+type Client struct {
+	IP     string      // client ip
+	Header http.Header // request header, nil for tcp
+	Cookie string      // affinity cookie value, issued by the proxy if the sticky cookie set
+}
+
 // BackendCombined
 type BackendCombined struct {
 	*Upstream `json:"upstream"`
 	*Backend  `json:"backend"`
+
+	change string // the target change applied by upserting or removing
+}
+
+// target changes
+const (
+	TargetAdd    = "add"
+	TargetUpdate = "update"
+	TargetDel    = "del"
+)
+
+// TargetChangeHeader is the response header carrying the target change applied
+const TargetChangeHeader = "X-Target-Change"
+
+// the protocols proxied on the upstream's listen addr
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+// the policies on the backends registered by the same ip:port
+const (
+	DuplicateReject = "reject" // reject the later registered one
+	DuplicateMerge  = "merge"  // the later registered one takes over the endpoint from the earlier one
+)
+
+// HostBackend is the upstream's host_header to send the backend address as the Host header
+const HostBackend = "$backend"
+
+// Change returns the target change applied by the last upserting or removing
+// of the backend combined, empty if nothing changed.
+func (cmb *BackendCombined) Change() string {
+	return cmb.change
 }
 
 func (cmb *BackendCombined) String() string {
 	return fmt.Sprintf("upstream: [%s], backend: [%s]", cmb.Upstream, cmb.Backend)
 }
 
+// Valid verify the backend combined with the strict naming policy
 func (cmb *BackendCombined) Valid() error {
+	return cmb.ValidNaming(NamingStrict)
+}
+
+// ValidNaming verify the backend combined with the specified naming policy,
+// any unknown policy is treated as strict.
+func (cmb *BackendCombined) ValidNaming(policy string) error {
 	if cmb == nil {
 		return errors.New("nil backend combined")
 	}
@@ -142,7 +450,7 @@ func (cmb *BackendCombined) Valid() error {
 	if err := cmb.Backend.valid(); err != nil {
 		return err
 	}
-	if !strings.HasSuffix(cmb.Backend.ID, "."+cmb.Upstream.Name) {
+	if policy != NamingRelaxed && !strings.HasSuffix(cmb.Backend.ID, "."+cmb.Upstream.Name) {
 		return errors.New("backend name must be suffixed by upstream name")
 	}
 	return nil
@@ -173,11 +481,161 @@ func AllSessions() map[string]*Sessions {
 
 	ret := make(map[string]*Sessions)
 	for _, u := range mgr.Upstreams {
-		ret[u.Name] = u.sessions
+		if u.sessions != nil {
+			ret[u.Name] = u.sessions
+		}
+	}
+	return ret
+}
+
+// Snapshot returns a copy of all upstreams with their backends, the runtime
+// sessions & balancers are not included.
+func Snapshot() []*Upstream {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	ret := make([]*Upstream, 0, len(mgr.Upstreams))
+	for _, u := range mgr.Upstreams {
+		cp := &Upstream{
+			Name:            u.Name,
+			Alias:           u.Alias,
+			Listen:          u.Listen,
+			Protocol:        u.Protocol,
+			UDPTimeout:      u.UDPTimeout,
+			Target:          u.Target,
+			Sticky:          u.Sticky,
+			StickyHeader:    u.StickyHeader,
+			StickyCookie:    u.StickyCookie,
+			StickyMask:      u.StickyMask,
+			StickyMask6:     u.StickyMask6,
+			MaxSessions:     u.MaxSessions,
+			Balance:         u.Balance,
+			TLS:             u.TLS,
+			Redirect:        u.Redirect,
+			BasicAuth:       u.BasicAuth,
+			JWT:             u.JWT,
+			SendProxy:       u.SendProxy,
+			Limit:           u.Limit,
+			Warmup:          u.Warmup,
+			ConnectTimeout:  u.ConnectTimeout,
+			HostHeader:      u.HostHeader,
+			RequestHeaders:  u.RequestHeaders,
+			ResponseHeaders: u.ResponseHeaders,
+			ErrorPages:      u.ErrorPages,
+			Streaming:       u.Streaming,
+			Bandwidth:       u.Bandwidth,
+			Duplicates:      u.Duplicates,
+			LabelHeader:     u.LabelHeader,
+			Breaker:         u.Breaker,
+			Hedge:           u.Hedge,
+			Canary:          u.Canary,
+			Backends:        make([]*Backend, 0, len(u.Backends)),
+		}
+		for _, b := range u.Backends {
+			bcp := *b
+			bcp.addedAt, bcp.ejectedUntil, bcp.warming = time.Time{}, time.Time{}, false
+			bcp.selections, bcp.lastSelected, bcp.circuit, bcp.bucket = 0, 0, nil, nil
+			cp.Backends = append(cp.Backends, &bcp)
+		}
+		ret = append(ret, cp)
 	}
 	return ret
 }
 
+// Flatten expands the snapshot upstreams into backend combineds, which
+// could be used to rebuild the upstreams by upserting one by one.
+func Flatten(ups []*Upstream) []*BackendCombined {
+	ret := make([]*BackendCombined, 0)
+	for _, u := range ups {
+		if u == nil {
+			continue
+		}
+		for _, b := range u.Backends {
+			b := *b
+			ret = append(ret, &BackendCombined{
+				Upstream: &Upstream{
+					Name:            u.Name,
+					Alias:           u.Alias,
+					Listen:          u.Listen,
+					Protocol:        u.Protocol,
+					UDPTimeout:      u.UDPTimeout,
+					Target:          u.Target,
+					Sticky:          u.Sticky,
+					StickyHeader:    u.StickyHeader,
+					StickyCookie:    u.StickyCookie,
+					StickyMask:      u.StickyMask,
+					StickyMask6:     u.StickyMask6,
+					MaxSessions:     u.MaxSessions,
+					Balance:         u.Balance,
+					TLS:             u.TLS,
+					Redirect:        u.Redirect,
+					BasicAuth:       u.BasicAuth,
+					JWT:             u.JWT,
+					SendProxy:       u.SendProxy,
+					Limit:           u.Limit,
+					Warmup:          u.Warmup,
+					ConnectTimeout:  u.ConnectTimeout,
+					HostHeader:      u.HostHeader,
+					RequestHeaders:  u.RequestHeaders,
+					ResponseHeaders: u.ResponseHeaders,
+					ErrorPages:      u.ErrorPages,
+					Streaming:       u.Streaming,
+					Bandwidth:       u.Bandwidth,
+					Duplicates:      u.Duplicates,
+					LabelHeader:     u.LabelHeader,
+					Breaker:         u.Breaker,
+					Hedge:           u.Hedge,
+					Canary:          u.Canary,
+				},
+				Backend: &b,
+			})
+		}
+	}
+	return ret
+}
+
+// CheckConflicts verify the snapshot upstreams won't conflict with each
+// other or with the current upstreams on the alias / listen address.
+func CheckConflicts(ups []*Upstream) error {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	var (
+		aliases = make(map[string]*Upstream) // alias -> snapshot upstream
+		listens = make(map[string]*Upstream) // listen -> snapshot upstream
+	)
+
+	for _, u := range ups {
+		if err := u.valid(); err != nil {
+			return err
+		}
+
+		listen := u.tcpListen()
+
+		if prev, ok := aliases[u.Alias]; ok && u.Alias != "" && !prev.same(u) {
+			return fmt.Errorf("alias address [%s] conflict between upstream %s and %s", u.Alias, prev.Name, u.Name)
+		}
+		if prev, ok := listens[listen]; ok && listen != "" && !prev.same(u) {
+			return fmt.Errorf("listen address [%s] conflict between upstream %s and %s", listen, prev.Name, u.Name)
+		}
+		aliases[u.Alias] = u
+		listens[listen] = u
+
+		// existing upstream would be updated rather than created, same as UpsertBackend
+		if _, exist := getUpstreamByNameAndTarget(u.Name, u.Target); exist != nil {
+			continue
+		}
+		if _, exist := getUpstreamByAlias(u.Alias); exist != nil {
+			return fmt.Errorf("alias address [%s] conflict with upstream %s", u.Alias, exist.Name)
+		}
+		if _, exist := getUpstreamByListen(listen); exist != nil {
+			return fmt.Errorf("listen address [%s] conflict with upstream %s", listen, exist.Name)
+		}
+	}
+
+	return nil
+}
+
 func GetUpstream(ups string) *Upstream {
 	mgr.RLock()
 	defer mgr.RUnlock()
@@ -198,9 +656,15 @@ func UpsertBackend(cmb *BackendCombined) (onFirst bool, err error) {
 		backend = cmb.Backend.ID
 	)
 
+	if cmb.Backend.bucket == nil {
+		cmb.Backend.bucket = newBucket(cmb.Backend.RateLimit)
+	}
+
 	_, u := getUpstreamByNameAndTarget(name, target)
 	// add new upstream
 	if u == nil {
+		cmb.Backend.addedAt = time.Now()
+
 		onFirst = true
 
 		if i, _ := getUpstreamByAlias(alias); i >= 0 {
@@ -212,7 +676,10 @@ func UpsertBackend(cmb *BackendCombined) (onFirst bool, err error) {
 			return
 		}
 
-		mgr.Upstreams = append(mgr.Upstreams, newUpstream(cmb))
+		u = newUpstream(cmb)
+		mgr.Upstreams = append(mgr.Upstreams, u)
+		startWarmup(u, cmb.Backend)
+		cmb.change = TargetAdd
 		return
 	}
 
@@ -220,20 +687,97 @@ func UpsertBackend(cmb *BackendCombined) (onFirst bool, err error) {
 
 	// add new backend
 	if b == nil {
+		var idx int
+		if idx, err = u.duplicated(cmb, nil); err != nil {
+			return
+		}
+
+		// take over the endpoint, no more slow start if already warmed up
+		if idx >= 0 {
+			dup := u.Backends[idx]
+			cmb.Backend.addedAt = dup.addedAt
+			u.Backends[idx] = cmb.Backend
+			u.sessions.remove(dup.ID)
+			if dup.warming {
+				startWarmup(u, cmb.Backend) // the previous warmup quits as replaced
+			}
+			cmb.change = TargetUpdate
+			return
+		}
+
+		cmb.Backend.addedAt = time.Now()
 		u.Backends = append(u.Backends, cmb.Backend)
+		startWarmup(u, cmb.Backend)
+		cmb.change = TargetAdd
 		return
 	}
 
+	// the backend moved onto the endpoint of another one
+	idx, err := u.duplicated(cmb, b)
+	if err != nil {
+		return
+	}
+	if idx >= 0 {
+		u.sessions.remove(u.Backends[idx].ID)
+		u.Backends = append(u.Backends[:idx], u.Backends[idx+1:]...)
+	}
+
+	cmb.change = TargetUpdate
+
 	// update upstream
 	u.Alias = cmb.Upstream.Alias
 	u.Sticky = cmb.Upstream.Sticky
+	u.StickyHeader = cmb.Upstream.StickyHeader
+	u.StickyCookie = cmb.Upstream.StickyCookie
+	u.StickyMask = cmb.Upstream.StickyMask
+	u.StickyMask6 = cmb.Upstream.StickyMask6
+	u.MaxSessions = cmb.Upstream.MaxSessions
+	u.setupSessions()
+	u.TLS = cmb.Upstream.TLS
+	u.Redirect = cmb.Upstream.Redirect
+	u.BasicAuth = cmb.Upstream.BasicAuth
+	u.JWT = cmb.Upstream.JWT
+	u.SendProxy = cmb.Upstream.SendProxy
+	u.Warmup = cmb.Upstream.Warmup
+	u.ConnectTimeout = cmb.Upstream.ConnectTimeout
+	u.HostHeader = cmb.Upstream.HostHeader
+	u.RequestHeaders = cmb.Upstream.RequestHeaders
+	u.ResponseHeaders = cmb.Upstream.ResponseHeaders
+	u.ErrorPages = cmb.Upstream.ErrorPages
+	u.Streaming = cmb.Upstream.Streaming
+	u.Duplicates = cmb.Upstream.Duplicates
+	u.LabelHeader = cmb.Upstream.LabelHeader
+	u.Breaker = cmb.Upstream.Breaker
+	u.Hedge = cmb.Upstream.Hedge
+	u.UDPTimeout = cmb.Upstream.UDPTimeout // the protocol kept as the listener
+	// the canary split is kept as adjusted by api, the registrations don't carry it
+	if !u.Limit.equal(cmb.Upstream.Limit) {
+		u.Limit = cmb.Upstream.Limit
+		u.limiter = newLimiter(u.Limit) // the in-flight requests release to the previous one
+	}
+	if !u.Bandwidth.equal(cmb.Upstream.Bandwidth) {
+		u.Bandwidth = cmb.Upstream.Bandwidth
+		u.throttles = newThrottles(u.Bandwidth)
+	}
+	if u.Balance != cmb.Upstream.Balance {
+		u.Balance = cmb.Upstream.Balance
+		u.balancer = newBalancer(u.Balance)
+	}
 
 	// update backend
+	if !b.draining() && cmb.Backend.draining() {
+		log.Printf("upstream backend %s draining, no new requests except the existing sessions", b.ID)
+	}
 	b.IP = cmb.Backend.IP
 	b.Port = cmb.Backend.Port
 	b.Scheme = cmb.Backend.Scheme
 	b.Version = cmb.Backend.Version
 	b.Weight = cmb.Backend.Weight
+	b.Labels = cmb.Backend.Labels
+	if !b.RateLimit.equal(cmb.Backend.RateLimit) {
+		b.RateLimit = cmb.Backend.RateLimit
+		b.bucket = cmb.Backend.bucket
+	}
 
 	return
 }
@@ -268,6 +812,7 @@ func RemoveBackend(cmb *BackendCombined) (onLast bool) {
 	// remove backend & session
 	u.Backends = append(u.Backends[:idxb], u.Backends[idxb+1:]...)
 	u.sessions.remove(backend)
+	cmb.change = TargetDel
 
 	// remove empty upstream & stop sessions gc
 	if len(u.Backends) == 0 {
@@ -279,56 +824,86 @@ func RemoveBackend(cmb *BackendCombined) (onLast bool) {
 	return
 }
 
-// similar as lookup, but by upstream alias
-func LookupAlias(remoteIP, alias string) *BackendCombined {
+func GetUpstreamByAlias(alias string) *Upstream {
 	mgr.RLock()
+	defer mgr.RUnlock()
+
 	_, u := getUpstreamByAlias(alias)
-	mgr.RUnlock()
+	return u
+}
+
+func GetUpstreamByTarget(name, port string) *Upstream {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	var up *Upstream
+	for _, u := range mgr.Upstreams {
+		if u.Name == name && u.Target == port {
+			up = u
+		}
+	}
+	return up
+}
+
+// similar as lookup, but by upstream alias
+func LookupAlias(c *Client, alias string) (*BackendCombined, error) {
+	u := GetUpstreamByAlias(alias)
 
 	if u == nil {
-		return nil
+		return nil, nil
 	}
 
-	return Lookup(remoteIP, u, "")
+	return Lookup(c, u, "")
 }
 
 // similar as lookup, but by upstream listen
-func LookupListen(remoteIP, listen string) *BackendCombined {
+func LookupListen(c *Client, listen string) (*BackendCombined, error) {
 	mgr.RLock()
 	_, u := getUpstreamByListen(listen)
 	mgr.RUnlock()
 
 	if u == nil {
-		return nil
+		return nil, nil
 	}
 
-	return Lookup(remoteIP, u, "")
+	return Lookup(c, u, "")
 }
 
-func LookupUpstream(remoteIP, name, port, backend string) *BackendCombined {
-	var up *Upstream
-	mgr.RLock()
-	for _, u := range mgr.Upstreams {
-		if u.Name == name && u.Target == port {
-			up = u
-		}
-	}
-	mgr.RUnlock()
+func LookupUpstream(c *Client, name, port, backend string) (*BackendCombined, error) {
+	up := GetUpstreamByTarget(name, port)
 
 	if up == nil {
-		return nil
+		return nil, nil
 	}
 
-	return Lookup(remoteIP, up, backend)
+	return Lookup(c, up, backend)
 }
 
-// lookup select a suitable backend according by sessions & balancer
-func Lookup(remoteIP string, u *Upstream, backend string) *BackendCombined {
-	var b *Backend
+// lookup select a suitable backend according by sessions & balancer,
+// it returns nil without error if the specified backend not found,
+// or ErrNoHealthyBackends if none of the backends is available.
+func Lookup(c *Client, u *Upstream, backend string) (*BackendCombined, error) {
+	var (
+		b        *Backend
+		key      = u.sessionKey(c)
+		sessions = getSessions(u)
+	)
+
+	if sessions == nil {
+		key = "" // sticky disabled
+	}
+
+	sel, err := u.selector(c)
+	if err != nil {
+		return nil, err
+	}
 
 	defer func() {
-		if u.Sticky && b != nil {
-			u.sessions.update(remoteIP, b)
+		if b != nil {
+			b.selected(time.Now())
+		}
+		if key != "" && b != nil {
+			sessions.update(key, b)
 		}
 	}()
 
@@ -336,35 +911,97 @@ func Lookup(remoteIP string, u *Upstream, backend string) *BackendCombined {
 	if backend != "" {
 		b = GetBackend(u, backend)
 		if b == nil {
-			return nil
+			return nil, nil
+		}
+		if !b.allowed(time.Now()) {
+			b = nil
+			return nil, ErrRateLimited
 		}
-		return &BackendCombined{u, b}
+		return &BackendCombined{Upstream: u, Backend: b}, nil
 	}
 
-	// obtain session by remoteIP
-	if u.Sticky {
-		if b = u.sessions.get(remoteIP); b != nil {
-			return &BackendCombined{u, b}
+	// obtain session by client, skip the session on unhealthy or unmatched backend
+	if key != "" {
+		if b = sessions.get(key); b != nil && healthy(b) && b.matches(sel) && b.allowed(time.Now()) && u.admit(b, time.Now()) {
+			return &BackendCombined{Upstream: u, Backend: b}, nil
 		}
 	}
 
 	// use balancer to obtain a new backend
-	if b = nextBackend(u); b != nil {
-		return &BackendCombined{u, b}
+	b, err = nextBackend(u, sel)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &BackendCombined{Upstream: u, Backend: b}, nil
 }
 
-func nextBackend(u *Upstream) *Backend {
+// nextBackend pass only the available backends matched by the selector to the balancer
+func nextBackend(u *Upstream, sel labels.Selector) (*Backend, error) {
+	return nextBackendExcept(u, sel, nil)
+}
+
+// nextBackendExcept is the same as nextBackend but the excluded backend never selected
+func nextBackendExcept(u *Upstream, sel labels.Selector, exclude *Backend) (*Backend, error) {
 	mgr.RLock()
 	defer mgr.RUnlock()
 
 	if u == nil {
+		return nil, ErrNoHealthyBackends
+	}
+
+	now := time.Now()
+	bs := matched(u.passing(available(u.Backends, now), now), sel)
+	if exclude != nil {
+		bs = without(bs, exclude)
+	}
+	bs = u.Canary.split(bs)
+
+	var limited bool
+	for len(bs) > 0 {
+		b := u.balancer.Next(bs)
+		if b == nil {
+			break
+		}
+		if !b.allow(now) {
+			limited = true
+		} else if u.claim(b, now) {
+			return b, nil
+		}
+		bs = without(bs, b) // over its rate, or the half-open probe claimed by another request meanwhile
+	}
+
+	if limited {
+		return nil, ErrRateLimited
+	}
+	return nil, ErrNoHealthyBackends
+}
+
+// Begin notify the upstream balancer that a request is proxied to the selected backend
+func Begin(cmb *BackendCombined) {
+	if o := getObserver(cmb); o != nil {
+		o.begin(cmb.Backend.ID)
+	}
+}
+
+// Done notify the upstream balancer that a request to the selected backend finished,
+// with the observed response time, rt < 0 if the request failed.
+func Done(cmb *BackendCombined, rt time.Duration) {
+	if o := getObserver(cmb); o != nil {
+		o.done(cmb.Backend.ID, rt)
+	}
+}
+
+func getObserver(cmb *BackendCombined) observer {
+	mgr.RLock()
+	defer mgr.RUnlock()
+
+	if cmb == nil || cmb.Upstream == nil || cmb.Backend == nil {
 		return nil
 	}
 
-	return u.balancer.Next(u.Backends)
+	o, _ := cmb.Upstream.balancer.(observer)
+	return o
 }
 
 // note: must be called under protection of mutext lock
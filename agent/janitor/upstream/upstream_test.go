@@ -0,0 +1,241 @@
+package upstream
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDuplicates(t *testing.T) {
+	tests := []struct {
+		policy    string
+		wantErr   bool
+		wantIDs   []string
+		wantAddrs []string
+	}{
+		{policy: "", wantIDs: []string{"a", "b"}},
+		{policy: DuplicateReject, wantErr: true, wantIDs: []string{"a"}},
+		{policy: DuplicateMerge, wantIDs: []string{"b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run("policy "+tt.policy, func(t *testing.T) {
+			var (
+				ups = &Upstream{Name: "dup.default.bbk.dataman", Sticky: true, Duplicates: tt.policy}
+				a   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "a", IP: "192.168.1.101", Port: 31000, Weight: 1}}
+				b   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "b", IP: "192.168.1.101", Port: 31000, Weight: 1}}
+			)
+
+			if _, err := UpsertBackend(a); err != nil {
+				t.Fatalf("UpsertBackend(a) error = %v", err)
+			}
+			defer func() {
+				RemoveBackend(a)
+				RemoveBackend(b)
+			}()
+
+			// a client pinned on a
+			u := GetUpstream(ups.Name)
+			if _, err := Lookup(&Client{IP: "10.0.0.1"}, u, ""); err != nil {
+				t.Fatalf("Lookup() error = %v", err)
+			}
+
+			_, err := UpsertBackend(b)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "a") || !strings.Contains(err.Error(), "192.168.1.101:31000") {
+					t.Errorf("UpsertBackend(b) error = %v, want duplicated with a", err)
+				}
+			} else if err != nil {
+				t.Fatalf("UpsertBackend(b) error = %v", err)
+			}
+
+			var ids []string
+			for _, v := range GetUpstream(ups.Name).Backends {
+				ids = append(ids, v.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("backends = %v, want %v", ids, tt.wantIDs)
+			}
+
+			// the session follows the endpoint
+			cmb, err := Lookup(&Client{IP: "10.0.0.1"}, u, "")
+			if err != nil {
+				t.Fatalf("Lookup() error = %v", err)
+			}
+			if tt.policy == DuplicateMerge && cmb.Backend.ID != "b" {
+				t.Errorf("Lookup() got %s after merged, want b", cmb.Backend.ID)
+			}
+		})
+	}
+}
+
+func TestDuplicatesMoved(t *testing.T) {
+	var (
+		ups = &Upstream{Name: "moved.default.bbk.dataman", Duplicates: DuplicateReject}
+		a   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "a", IP: "192.168.1.101", Port: 31000, Weight: 1}}
+		b   = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "b", IP: "192.168.1.102", Port: 31000, Weight: 1}}
+	)
+
+	for _, cmb := range []*BackendCombined{a, b} {
+		if _, err := UpsertBackend(cmb); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		RemoveBackend(a)
+		RemoveBackend(b)
+	}()
+
+	// b updated onto the endpoint of a
+	moved := &BackendCombined{Upstream: ups, Backend: &Backend{ID: "b", IP: "192.168.1.101", Port: 31000, Weight: 1}}
+	if _, err := UpsertBackend(moved); err == nil {
+		t.Error("UpsertBackend() moved onto a duplicated endpoint, want error")
+	}
+	if got := GetBackend(GetUpstream(ups.Name), "b"); got.IP != "192.168.1.102" {
+		t.Errorf("backend b ip = %s, want unchanged", got.IP)
+	}
+
+	// merged
+	ups.Duplicates = DuplicateMerge
+	if _, err := UpsertBackend(moved); err != nil {
+		t.Fatalf("UpsertBackend() error = %v", err)
+	}
+	u := GetUpstream(ups.Name)
+	if len(u.Backends) != 1 || u.Backends[0].ID != "b" || u.Backends[0].IP != "192.168.1.101" {
+		t.Errorf("backends = %+v, want b taken over a", u.Backends)
+	}
+
+	bad := &Upstream{Name: "bad", Duplicates: "ignore"}
+	if err := bad.valid(); err == nil {
+		t.Error("invalid duplicates policy valid, want error")
+	}
+}
+
+func TestBackendWeight(t *testing.T) {
+	tests := []struct {
+		name    string
+		weight  float64
+		wantErr bool
+	}{
+		{name: "positive", weight: 100},
+		{name: "fraction", weight: 0.5},
+		{name: "zero drains", weight: 0},
+		{name: "negative", weight: -1, wantErr: true},
+		{name: "nan", weight: math.NaN(), wantErr: true},
+		{name: "infinite", weight: math.Inf(1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmb := &BackendCombined{
+				Upstream: &Upstream{Name: "weight.default.bbk.dataman"},
+				Backend:  &Backend{ID: "a.weight.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: tt.weight},
+			}
+			if err := cmb.Valid(); (err != nil) != tt.wantErr {
+				t.Errorf("Valid() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLabelRouting(t *testing.T) {
+	var (
+		ups = &Upstream{Name: "labels.default.bbk.dataman", LabelHeader: "X-Route-Labels"}
+		bs  = []*Backend{
+			{ID: "gold-1", IP: "192.168.1.101", Port: 31000, Weight: 1, Labels: map[string]string{"tier": "gold", "zone": "bj"}},
+			{ID: "gold-2", IP: "192.168.1.102", Port: 31000, Weight: 1, Labels: map[string]string{"tier": "gold", "zone": "sh"}},
+			{ID: "silver", IP: "192.168.1.103", Port: 31000, Weight: 1, Labels: map[string]string{"tier": "silver", "zone": "bj"}},
+			{ID: "none", IP: "192.168.1.104", Port: 31000, Weight: 1},
+		}
+	)
+
+	for _, b := range bs {
+		if _, err := UpsertBackend(&BackendCombined{Upstream: ups, Backend: b}); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		for _, b := range bs {
+			RemoveBackend(&BackendCombined{Upstream: ups, Backend: b})
+		}
+	}()
+
+	tests := []struct {
+		selector string
+		want     []string
+		wantErr  error
+	}{
+		{selector: "", want: []string{"gold-1", "gold-2", "silver", "none"}},
+		{selector: "tier=gold", want: []string{"gold-1", "gold-2"}},
+		{selector: "tier=gold,zone=bj", want: []string{"gold-1"}},
+		{selector: "tier!=gold", want: []string{"silver", "none"}},
+		{selector: "tier in (gold,silver),zone=bj", want: []string{"gold-1", "silver"}},
+		{selector: "tier=platinum", wantErr: ErrNoHealthyBackends},
+	}
+
+	u := GetUpstream(ups.Name)
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			header := http.Header{}
+			if tt.selector != "" {
+				header.Set("X-Route-Labels", tt.selector)
+			}
+
+			got := make(map[string]bool)
+			for i := 0; i < 20; i++ {
+				cmb, err := Lookup(&Client{IP: "10.0.0.1", Header: header}, u, "")
+				if err != tt.wantErr {
+					t.Fatalf("Lookup() error = %v, want %v", err, tt.wantErr)
+				}
+				if err == nil {
+					got[cmb.Backend.ID] = true
+				}
+			}
+
+			if len(got) != len(tt.want) {
+				t.Errorf("selected %v, want %v", got, tt.want)
+			}
+			for _, id := range tt.want {
+				if !got[id] {
+					t.Errorf("%s never selected by %q, got %v", id, tt.selector, got)
+				}
+			}
+		})
+	}
+
+	header := http.Header{"X-Route-Labels": []string{"tier in gold"}}
+	if _, err := Lookup(&Client{IP: "10.0.0.1", Header: header}, u, ""); err == nil {
+		t.Error("Lookup() by invalid selector, want error")
+	}
+}
+
+func TestLabelRoutingSticky(t *testing.T) {
+	var (
+		ups  = &Upstream{Name: "sticky-labels.default.bbk.dataman", Sticky: true, LabelHeader: "X-Route-Labels"}
+		gold = &Backend{ID: "gold", IP: "192.168.1.101", Port: 31000, Weight: 1, Labels: map[string]string{"tier": "gold"}}
+		base = &Backend{ID: "base", IP: "192.168.1.102", Port: 31000, Weight: 1, Labels: map[string]string{"tier": "base"}}
+	)
+	for _, b := range []*Backend{gold, base} {
+		if _, err := UpsertBackend(&BackendCombined{Upstream: ups, Backend: b}); err != nil {
+			t.Fatalf("UpsertBackend() error = %v", err)
+		}
+	}
+	defer func() {
+		RemoveBackend(&BackendCombined{Upstream: ups, Backend: gold})
+		RemoveBackend(&BackendCombined{Upstream: ups, Backend: base})
+	}()
+
+	u := GetUpstream(ups.Name)
+	baseHeader := http.Header{"X-Route-Labels": []string{"tier=base"}}
+	goldHeader := http.Header{"X-Route-Labels": []string{"tier=gold"}}
+
+	if cmb, err := Lookup(&Client{IP: "10.0.0.1", Header: baseHeader}, u, ""); err != nil || cmb.Backend.ID != "base" {
+		t.Fatalf("Lookup() = %v, %v, want base", cmb, err)
+	}
+
+	// the session on the unmatched backend is skipped
+	if cmb, err := Lookup(&Client{IP: "10.0.0.1", Header: goldHeader}, u, ""); err != nil || cmb.Backend.ID != "gold" {
+		t.Errorf("Lookup() = %v, %v, want gold though pinned on base", cmb, err)
+	}
+}
@@ -0,0 +1,144 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// the defaults of the warmup
+var (
+	defaultWarmupTimeout  = time.Second
+	defaultWarmupInterval = time.Second * 5
+)
+
+// Warmup is the setup of the synthetic requests sent to a newly added backend before it
+// receives the live traffic, so that its JIT & caches are primed.
+type Warmup struct {
+	Path     string        `json:"path"`     // request path, default /
+	Count    int           `json:"count"`    // nb of requests, all of them must get 2xx, default 1
+	Timeout  time.Duration `json:"timeout"`  // timeout of each request, default 1s
+	Interval time.Duration `json:"interval"` // retry interval on failure, default 5s
+}
+
+func (w *Warmup) valid() error {
+	if w == nil {
+		return nil
+	}
+	if w.Count < 0 || w.Timeout < 0 || w.Interval < 0 {
+		return errors.New("upstream warmup should not be negative")
+	}
+	return nil
+}
+
+func (w *Warmup) count() int {
+	if w.Count > 0 {
+		return w.Count
+	}
+	return 1
+}
+
+func (w *Warmup) timeout() time.Duration {
+	if w.Timeout > 0 {
+		return w.Timeout
+	}
+	return defaultWarmupTimeout
+}
+
+func (w *Warmup) interval() time.Duration {
+	if w.Interval > 0 {
+		return w.Interval
+	}
+	return defaultWarmupInterval
+}
+
+func (w *Warmup) url(b *Backend) string {
+	scheme := b.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	path := w.Path
+	if path == "" || path[0] != '/' {
+		path = "/" + path
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, b.Addr(), path)
+}
+
+// warmupOnce sends the warmup requests to the backend, error on any of them not 2xx
+func warmupOnce(b *Backend, w *Warmup) error {
+	client := &http.Client{
+		Timeout: w.timeout(),
+		Transport: &http.Transport{
+			// the synthetic requests carry nothing of the clients, the backend
+			// certificate is verified by the proxy on the live traffic.
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+
+	url := w.url(b)
+	for i := 0; i < w.count(); i++ {
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("warmup request %s got %d", url, resp.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+// warmup keeps the backend out of the selection until the warmup requests succeed, the failed
+// warmup is retried until succeed or the backend is removed. it's started by adding the backend.
+func warmup(name string, b *Backend, w *Warmup) {
+	for {
+		err := warmupOnce(b, w)
+
+		mgr.Lock()
+		_, u := getUpstreamByName(name)
+		if u == nil {
+			mgr.Unlock()
+			return
+		}
+		if _, cur := u.search(b.ID); cur != b {
+			mgr.Unlock()
+			return
+		}
+
+		if err == nil {
+			b.warming = false
+			b.addedAt = time.Now() // slow start since warmed up
+			mgr.Unlock()
+			log.Printf("upstream backend %s warmed up, receiving the live traffic", b.ID)
+			return
+		}
+		mgr.Unlock()
+
+		log.Warnf("upstream backend %s warmup failed, retry in %s: %v", b.ID, w.interval(), err)
+		time.Sleep(w.interval())
+	}
+}
+
+// startWarmup marks the newly added backend warming and starts the warmup if enabled,
+// must be called under protection of mutex lock
+func startWarmup(u *Upstream, b *Backend) {
+	if u.Warmup == nil {
+		return
+	}
+
+	b.warming = true
+	go warmup(u.Name, b, u.Warmup)
+}
@@ -0,0 +1,111 @@
+package upstream
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32 // nb of the warmup requests failed before succeed
+		wantHits int32 // nb of the warmup requests received till warmed up
+	}{
+		{name: "succeed", failures: 0, wantHits: 2},
+		{name: "failed & retried", failures: 3, wantHits: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/ping" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if atomic.AddInt32(&hits, 1) <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer srv.Close()
+
+			host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+			p, _ := strconv.ParseUint(port, 10, 64)
+
+			var (
+				ups = &Upstream{
+					Name:   "warmup.default.bbk.dataman",
+					Warmup: &Warmup{Path: "/ping", Count: 2, Interval: time.Millisecond * 10},
+				}
+				a = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "a.warmup.default.bbk.dataman", IP: "192.168.1.101", Port: 31000, Weight: 1}}
+				b = &BackendCombined{Upstream: ups, Backend: &Backend{ID: "b.warmup.default.bbk.dataman", IP: host, Port: p, Weight: 1}}
+			)
+
+			// a is warming forever as nothing is listening
+			for _, cmb := range []*BackendCombined{a, b} {
+				if _, err := UpsertBackend(cmb); err != nil {
+					t.Fatalf("UpsertBackend() error = %v", err)
+				}
+			}
+			defer func() {
+				RemoveBackend(a)
+				RemoveBackend(b)
+			}()
+
+			u := GetUpstream(ups.Name)
+
+			var (
+				b0  *Backend
+				err error
+			)
+			for i := 0; i < 200; i++ {
+				if b0, err = nextBackend(u, nil); err == nil {
+					break
+				}
+				if err != ErrNoHealthyBackends {
+					t.Fatalf("nextBackend() error = %v, want %v while warming", err, ErrNoHealthyBackends)
+				}
+				time.Sleep(time.Millisecond * 10)
+			}
+			if err != nil || b0.ID != b.Backend.ID {
+				t.Fatalf("nextBackend() = %v, %v, want the warmed up %s", b0, err, b.Backend.ID)
+			}
+			if got := atomic.LoadInt32(&hits); got != tt.wantHits {
+				t.Errorf("warmup requests = %d, want %d", got, tt.wantHits)
+			}
+
+			// the warming one stays out
+			for i := 0; i < 5; i++ {
+				if b0, err = nextBackend(u, nil); err != nil || b0.ID != b.Backend.ID {
+					t.Errorf("nextBackend() = %v, %v, want only %s", b0, err, b.Backend.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestWarmupURL(t *testing.T) {
+	tests := []struct {
+		name string
+		w    *Warmup
+		b    *Backend
+		want string
+	}{
+		{name: "default", w: &Warmup{}, b: &Backend{IP: "10.0.0.1", Port: 80}, want: "http://10.0.0.1:80/"},
+		{name: "relative path", w: &Warmup{Path: "ping"}, b: &Backend{IP: "10.0.0.1", Port: 80}, want: "http://10.0.0.1:80/ping"},
+		{name: "https", w: &Warmup{Path: "/ping?deep=1"}, b: &Backend{IP: "10.0.0.1", Port: 443, Scheme: "https"}, want: "https://10.0.0.1:443/ping?deep=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.w.url(tt.b); got != tt.want {
+				t.Errorf("url() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -10,9 +10,11 @@ func (b *wrrBalancer) Next(bs []*Backend) *Backend {
 		return nil
 	}
 
-	gcd := getGcd(bs)
+	ws := effectiveWeights(bs)
 
-	max := getMaxWeight(bs)
+	gcd := getGcd(ws)
+
+	max := getMaxWeight(ws)
 
 	for {
 		b.index = (b.index + 1) % len(bs)
@@ -26,16 +28,16 @@ func (b *wrrBalancer) Next(bs []*Backend) *Backend {
 			}
 		}
 
-		if weight := bs[b.index].Weight; int(weight) >= b.cw {
+		if weight := ws[b.index]; int(weight) >= b.cw {
 			return bs[b.index]
 		}
 	}
 }
 
-func getMaxWeight(backends []*Backend) int {
+func getMaxWeight(ws []float64) int {
 	max := 0
-	for _, w := range backends {
-		if weight := w.Weight; int(weight) >= max {
+	for _, weight := range ws {
+		if int(weight) >= max {
 			max = int(weight)
 		}
 	}
@@ -43,13 +45,13 @@ func getMaxWeight(backends []*Backend) int {
 	return max
 }
 
-func getGcd(backends []*Backend) int {
+func getGcd(ws []float64) int {
 	divisor := -1
-	for _, b := range backends {
+	for _, weight := range ws {
 		if divisor == -1 {
-			divisor = int(b.Weight)
+			divisor = int(weight)
 		} else {
-			divisor = gcd(divisor, int(b.Weight))
+			divisor = gcd(divisor, int(weight))
 		}
 	}
 	return divisor
@@ -0,0 +1,239 @@
+package watcher
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+var (
+	watchRetryDelay = time.Second * 3 // delay before re-watch on failure
+)
+
+// Handler applies the upstream backend changes, implemented by janitor.JanitorServer
+type Handler interface {
+	UpsertBackend(*upstream.BackendCombined) error
+	RemoveBackend(*upstream.BackendCombined)
+}
+
+// Reconciler is optionally implemented by the handler, which reconciles the states derived
+// from the backends, eg: the consul registrations, once the full sync done.
+type Reconciler interface {
+	ReconcileRegistry()
+}
+
+// EtcdWatcher watches an etcd prefix for upstream backend changes and applies them
+// to the janitor, so that multiple janitors could share a single source of truth.
+// each key under the prefix holds one upstream.BackendCombined json.
+type EtcdWatcher struct {
+	kapi    etcd.KeysAPI
+	prefix  string
+	handler Handler
+
+	sync.Mutex                                      // protect known
+	known      map[string]*upstream.BackendCombined // etcd key -> applied backend
+	index      uint64                               // last seen etcd index
+}
+
+func NewEtcdWatcher(addrs []string, prefix string, handler Handler) (*EtcdWatcher, error) {
+	cfg := etcd.Config{
+		Endpoints:               addrs,
+		Transport:               etcd.DefaultTransport,
+		HeaderTimeoutPerRequest: time.Second * 3,
+	}
+
+	etcdc, err := etcd.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return newEtcdWatcher(etcd.NewKeysAPI(etcdc), prefix, handler), nil
+}
+
+func newEtcdWatcher(kapi etcd.KeysAPI, prefix string, handler Handler) *EtcdWatcher {
+	return &EtcdWatcher{
+		kapi:    kapi,
+		prefix:  prefix,
+		handler: handler,
+		known:   make(map[string]*upstream.BackendCombined),
+	}
+}
+
+// Run list & sync all of the backends firstly, then stream the updates until ctx done.
+// on watch failure or the etcd event index cleared (compaction), list & sync again.
+func (w *EtcdWatcher) Run(ctx context.Context) {
+	for {
+		if err := w.sync(ctx); err != nil {
+			log.Errorf("janitor etcd sync on %s error: %v", w.prefix, err)
+		} else if err := w.watch(ctx); err != nil {
+			if isIndexCleared(err) {
+				log.Warnf("janitor etcd watch on %s outdated, resyncing ...", w.prefix)
+				continue
+			}
+			log.Errorf("janitor etcd watch on %s error: %v", w.prefix, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchRetryDelay):
+		}
+	}
+}
+
+// sync lists all of the backends under prefix and converges the janitor to them
+func (w *EtcdWatcher) sync(ctx context.Context) error {
+	resp, err := w.kapi.Get(ctx, w.prefix, &etcd.GetOptions{Recursive: true, Quorum: true})
+	if err != nil {
+		if etcd.IsKeyNotFound(err) {
+			w.converge(nil, 0)
+			w.reconcile()
+			return nil
+		}
+		return err
+	}
+
+	nodes := make(map[string]string)
+	walk(resp.Node, nodes)
+
+	w.converge(nodes, resp.Index)
+	w.reconcile()
+	return nil
+}
+
+func (w *EtcdWatcher) reconcile() {
+	if r, ok := w.handler.(Reconciler); ok {
+		r.ReconcileRegistry()
+	}
+}
+
+func (w *EtcdWatcher) converge(nodes map[string]string, index uint64) {
+	w.Lock()
+	defer w.Unlock()
+
+	for key, cmb := range w.known {
+		if _, ok := nodes[key]; !ok {
+			w.handler.RemoveBackend(cmb)
+			delete(w.known, key)
+		}
+	}
+
+	for key, val := range nodes {
+		w.apply(key, val)
+	}
+
+	w.index = index
+}
+
+// watch streams the updates after the last seen index, it returns on any error
+func (w *EtcdWatcher) watch(ctx context.Context) error {
+	w.Lock()
+	opts := &etcd.WatcherOptions{AfterIndex: w.index, Recursive: true}
+	w.Unlock()
+
+	watcher := w.kapi.Watcher(w.prefix, opts)
+	for {
+		resp, err := watcher.Next(ctx)
+		if err != nil {
+			return err
+		}
+
+		w.handle(resp)
+	}
+}
+
+func (w *EtcdWatcher) handle(resp *etcd.Response) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.index = resp.Index
+
+	node := resp.Node
+	if node == nil {
+		return
+	}
+
+	// the recursive delete or ttl expiry of a dir removes all of the backends under it
+	if node.Dir {
+		switch resp.Action {
+		case "delete", "expire", "compareAndDelete":
+			dir := strings.TrimSuffix(node.Key, "/") + "/"
+			for key, cmb := range w.known {
+				if strings.HasPrefix(key, dir) {
+					w.handler.RemoveBackend(cmb)
+					delete(w.known, key)
+				}
+			}
+		}
+		return
+	}
+
+	switch resp.Action {
+	case "set", "create", "update", "compareAndSwap":
+		w.apply(node.Key, node.Value)
+	case "delete", "expire", "compareAndDelete":
+		if cmb, ok := w.known[node.Key]; ok {
+			w.handler.RemoveBackend(cmb)
+			delete(w.known, node.Key)
+		}
+	}
+}
+
+// apply upsert the backend held by the key, must be called under protection of mutex lock
+func (w *EtcdWatcher) apply(key, val string) {
+	var cmb *upstream.BackendCombined
+	if err := json.NewDecoder(strings.NewReader(val)).Decode(&cmb); err != nil {
+		log.Errorf("janitor etcd key %s holds malformed backend: %v", key, err)
+		return
+	}
+	if cmb == nil {
+		log.Errorf("janitor etcd key %s holds null backend", key)
+		return
+	}
+
+	// the backend has been renamed by the key, remove the previous one
+	if prev, ok := w.known[key]; ok && cmb.Backend != nil && prev.Backend.ID != cmb.Backend.ID {
+		w.handler.RemoveBackend(prev)
+		delete(w.known, key)
+	}
+
+	if err := w.handler.UpsertBackend(cmb); err != nil {
+		log.Errorf("janitor etcd key %s upsert backend error: %v", key, err)
+		return
+	}
+
+	w.known[key] = cmb
+}
+
+// walk collects all of leaf nodes recursively
+func walk(node *etcd.Node, ret map[string]string) {
+	if node == nil {
+		return
+	}
+
+	if !node.Dir {
+		ret[node.Key] = node.Value
+		return
+	}
+
+	for _, n := range node.Nodes {
+		walk(n, ret)
+	}
+}
+
+func isIndexCleared(err error) bool {
+	switch e := err.(type) {
+	case etcd.Error:
+		return e.Code == etcd.ErrorCodeEventIndexCleared
+	case *etcd.Error:
+		return e.Code == etcd.ErrorCodeEventIndexCleared
+	}
+	return false
+}
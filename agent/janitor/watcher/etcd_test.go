@@ -0,0 +1,208 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	etcd "github.com/coreos/etcd/client"
+	"golang.org/x/net/context"
+
+	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
+)
+
+// mockKeysAPI serves Get by the nodes and Watcher by the events
+type mockKeysAPI struct {
+	etcd.KeysAPI
+	nodes  []*etcd.Node
+	index  uint64
+	events chan *etcd.Response
+}
+
+func (m *mockKeysAPI) Get(ctx context.Context, key string, opts *etcd.GetOptions) (*etcd.Response, error) {
+	return &etcd.Response{
+		Action: "get",
+		Node:   &etcd.Node{Key: key, Dir: true, Nodes: m.nodes},
+		Index:  m.index,
+	}, nil
+}
+
+func (m *mockKeysAPI) Watcher(key string, opts *etcd.WatcherOptions) etcd.Watcher {
+	return &mockWatcher{m.events}
+}
+
+type mockWatcher struct {
+	events chan *etcd.Response
+}
+
+func (w *mockWatcher) Next(ctx context.Context) (*etcd.Response, error) {
+	resp, ok := <-w.events
+	if !ok {
+		return nil, errors.New("watcher closed")
+	}
+	return resp, nil
+}
+
+// mockHandler records the applied backends: backend id -> port
+type mockHandler struct {
+	backends map[string]uint64
+}
+
+func (h *mockHandler) UpsertBackend(cmb *upstream.BackendCombined) error {
+	if err := cmb.Valid(); err != nil {
+		return err
+	}
+	h.backends[cmb.Backend.ID] = cmb.Backend.Port
+	return nil
+}
+
+func (h *mockHandler) RemoveBackend(cmb *upstream.BackendCombined) {
+	delete(h.backends, cmb.Backend.ID)
+}
+
+func backendJSON(id string, port int) string {
+	return fmt.Sprintf(`{"upstream":{"name":"nginx.default.bbk.dataman"},"backend":{"id":"%s.nginx.default.bbk.dataman","ip":"192.168.1.101","port":%d}}`, id, port)
+}
+
+func TestEtcdWatcher(t *testing.T) {
+	var (
+		prefix  = "/swan/janitor/backends"
+		kapi    = &mockKeysAPI{events: make(chan *etcd.Response)}
+		handler = &mockHandler{backends: make(map[string]uint64)}
+		w       = newEtcdWatcher(kapi, prefix, handler)
+	)
+
+	kapi.index = 10
+	kapi.nodes = []*etcd.Node{
+		{Key: prefix + "/0", Value: backendJSON("0", 31000)},
+		{Key: prefix + "/1", Value: backendJSON("1", 31001)},
+		{Key: prefix + "/bad", Value: "{xxx"},
+	}
+
+	if err := w.sync(context.Background()); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+
+	assertBackends(t, "initial sync", handler, map[string]uint64{
+		"0.nginx.default.bbk.dataman": 31000,
+		"1.nginx.default.bbk.dataman": 31001,
+	})
+
+	tests := []struct {
+		name string
+		resp *etcd.Response
+		want map[string]uint64
+	}{
+		{
+			name: "add",
+			resp: &etcd.Response{Action: "create", Index: 11, Node: &etcd.Node{Key: prefix + "/2", Value: backendJSON("2", 31002)}},
+			want: map[string]uint64{
+				"0.nginx.default.bbk.dataman": 31000,
+				"1.nginx.default.bbk.dataman": 31001,
+				"2.nginx.default.bbk.dataman": 31002,
+			},
+		},
+		{
+			name: "update",
+			resp: &etcd.Response{Action: "set", Index: 12, Node: &etcd.Node{Key: prefix + "/0", Value: backendJSON("0", 32000)}},
+			want: map[string]uint64{
+				"0.nginx.default.bbk.dataman": 32000,
+				"1.nginx.default.bbk.dataman": 31001,
+				"2.nginx.default.bbk.dataman": 31002,
+			},
+		},
+		{
+			name: "delete",
+			resp: &etcd.Response{Action: "delete", Index: 13, Node: &etcd.Node{Key: prefix + "/1"}},
+			want: map[string]uint64{
+				"0.nginx.default.bbk.dataman": 32000,
+				"2.nginx.default.bbk.dataman": 31002,
+			},
+		},
+		{
+			name: "null on the known key",
+			resp: &etcd.Response{Action: "set", Index: 14, Node: &etcd.Node{Key: prefix + "/0", Value: "null"}},
+			want: map[string]uint64{
+				"0.nginx.default.bbk.dataman": 32000,
+				"2.nginx.default.bbk.dataman": 31002,
+			},
+		},
+		{
+			name: "add in dir",
+			resp: &etcd.Response{Action: "create", Index: 15, Node: &etcd.Node{Key: prefix + "/app/3", Value: backendJSON("3", 31003)}},
+			want: map[string]uint64{
+				"0.nginx.default.bbk.dataman": 32000,
+				"2.nginx.default.bbk.dataman": 31002,
+				"3.nginx.default.bbk.dataman": 31003,
+			},
+		},
+		{
+			name: "add in sibling dir",
+			resp: &etcd.Response{Action: "create", Index: 16, Node: &etcd.Node{Key: prefix + "/application/4", Value: backendJSON("4", 31004)}},
+			want: map[string]uint64{
+				"0.nginx.default.bbk.dataman": 32000,
+				"2.nginx.default.bbk.dataman": 31002,
+				"3.nginx.default.bbk.dataman": 31003,
+				"4.nginx.default.bbk.dataman": 31004,
+			},
+		},
+		{
+			name: "delete dir recursively",
+			resp: &etcd.Response{Action: "delete", Index: 17, Node: &etcd.Node{Key: prefix + "/app", Dir: true}},
+			want: map[string]uint64{
+				"0.nginx.default.bbk.dataman": 32000,
+				"2.nginx.default.bbk.dataman": 31002,
+				"4.nginx.default.bbk.dataman": 31004,
+			},
+		},
+		{
+			name: "expire dir",
+			resp: &etcd.Response{Action: "expire", Index: 18, Node: &etcd.Node{Key: prefix + "/application", Dir: true}},
+			want: map[string]uint64{
+				"0.nginx.default.bbk.dataman": 32000,
+				"2.nginx.default.bbk.dataman": 31002,
+			},
+		},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- w.watch(context.Background())
+	}()
+
+	for _, tt := range tests {
+		kapi.events <- tt.resp
+		kapi.events <- &etcd.Response{Action: "get", Index: tt.resp.Index} // wait for the previous event handled
+		assertBackends(t, tt.name, handler, tt.want)
+	}
+
+	close(kapi.events)
+	if err := <-done; err == nil {
+		t.Errorf("watch() should return on watcher error")
+	}
+
+	// resync after compaction, the backends removed during the outage are cleaned up
+	kapi.index = 20
+	kapi.nodes = []*etcd.Node{
+		{Key: prefix + "/2", Value: backendJSON("2", 31002)},
+	}
+
+	if err := w.sync(context.Background()); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+
+	assertBackends(t, "resync", handler, map[string]uint64{
+		"2.nginx.default.bbk.dataman": 31002,
+	})
+
+	if w.index != 20 {
+		t.Errorf("resync index = %d, want 20", w.index)
+	}
+}
+
+func assertBackends(t *testing.T, name string, h *mockHandler, want map[string]uint64) {
+	if !reflect.DeepEqual(h.backends, want) {
+		t.Errorf("%s: backends = %v, want %v", name, h.backends, want)
+	}
+}
@@ -48,6 +48,10 @@ func (agent *Agent) syncFull(addr string) error {
 		}
 	}
 
+	if agent.config.Janitor.Enabled {
+		agent.janitor.ReconcileRegistry()
+	}
+
 	log.Println("full synced dns & proxy records succeed")
 	return nil
 }
@@ -20,6 +20,8 @@ import (
 )
 
 func (r *Server) createApp(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	if err := checkForJSON(req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -101,10 +103,10 @@ func (r *Server) createApp(w http.ResponseWriter, req *http.Request) {
 		defer func() {
 			if err != nil {
 				log.Errorf("launch app %s error: %v", appId, err)
-				r.memoAppStatus(appId, types.OpStatusNoop, fmt.Sprintf("launch app error: %v", err))
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, fmt.Sprintf("launch app error: %v", err))
 			} else {
 				log.Printf("launch app %s succeed", appId)
-				r.memoAppStatus(appId, types.OpStatusNoop, "")
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, "")
 			}
 		}()
 
@@ -221,6 +223,8 @@ func (r *Server) getApp(w http.ResponseWriter, req *http.Request) {
 }
 
 func (r *Server) deleteApp(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	var (
 		appId = mux.Vars(req)["app_id"]
 	)
@@ -256,8 +260,8 @@ func (r *Server) deleteApp(w http.ResponseWriter, req *http.Request) {
 	log.Debugf("app %s has %d versions", appId, len(versions))
 
 	// mark app op status
-	if err := r.memoAppStatus(appId, types.OpStatusDeleting, ""); err != nil {
-		http.Error(w, fmt.Sprintf("update app opstatus to deleting got error: %v", err), http.StatusInternalServerError)
+	if err := r.memoAppStatus(actor, appId, types.OpStatusDeleting, ""); err != nil {
+		http.Error(w, fmt.Sprintf("update app opstatus to deleting got error: %v", err), memoErrCode(err))
 		return
 	}
 
@@ -268,7 +272,7 @@ func (r *Server) deleteApp(w http.ResponseWriter, req *http.Request) {
 		defer func() {
 			if err != nil {
 				log.Errorf("delete app %s error: %v", appId, err)
-				r.memoAppStatus(appId, types.OpStatusNoop, fmt.Sprintf("delete app error: %v", err))
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, fmt.Sprintf("delete app error: %v", err))
 			} else {
 				log.Printf("delete app %s succeed", appId)
 			}
@@ -283,6 +287,8 @@ func (r *Server) deleteApp(w http.ResponseWriter, req *http.Request) {
 }
 
 func (r *Server) scaleApp(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	appId := mux.Vars(req)["app_id"]
 
 	app, err := r.db.GetApp(appId)
@@ -291,11 +297,6 @@ func (r *Server) scaleApp(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if app.OpStatus != types.OpStatusNoop {
-		http.Error(w, fmt.Sprintf("app status is %s, operation not allowed.", app.OpStatus), http.StatusLocked)
-		return
-	}
-
 	var scale types.Scale
 	if err := decode(req.Body, &scale); err != nil {
 		http.Error(w, fmt.Sprintf("decode scale param error: %v", err), http.StatusBadRequest)
@@ -319,11 +320,33 @@ func (r *Server) scaleApp(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if app.OpStatus != types.OpStatusNoop {
+		to := types.OpStatusScalingUp
+		if goal < current {
+			to = types.OpStatusScalingDown
+		}
+		r.opNotAllowed(w, app.OpStatus, to)
+		return
+	}
+
 	if goal == current {
 		writeJSON(w, http.StatusNotModified, "instances not changed")
 		return
 	}
 
+	if scale.GracePeriod < 0 {
+		http.Error(w, "the grace period can't be negative", http.StatusBadRequest)
+		return
+	}
+
+	var killing []*types.Task
+	if goal < current {
+		if killing, err = drainingTasks(tasks, current-goal, scale.DrainOrder); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	ver, err := r.db.GetVersion(app.ID, app.Version[0])
 	if err != nil {
 		http.Error(w, fmt.Sprintf("get version got error for scale app. %v", err), http.StatusInternalServerError)
@@ -341,10 +364,11 @@ func (r *Server) scaleApp(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if goal < current { // scale dwon
-		if err := r.memoAppStatus(appId, types.OpStatusScalingDown, ""); err != nil {
-			http.Error(w, fmt.Sprintf("update app opstatus to scaling down error: %v", err), http.StatusInternalServerError)
+		if err := r.memoAppStatus(actor, appId, types.OpStatusScalingDown, ""); err != nil {
+			http.Error(w, fmt.Sprintf("update app opstatus to scaling down error: %v", err), memoErrCode(err))
 			return
 		}
+		r.progress.start(appId, types.OpStatusScalingDown, len(killing))
 
 		go func() {
 			var err error
@@ -352,18 +376,19 @@ func (r *Server) scaleApp(w http.ResponseWriter, req *http.Request) {
 			defer func() {
 				if err != nil {
 					log.Errorf("scale down app %s error: %v", appId, err)
-					r.memoAppStatus(appId, types.OpStatusNoop, fmt.Sprintf("scale down app error: %v", err))
+					r.memoAppStatus(actor, appId, types.OpStatusNoop, fmt.Sprintf("scale down app error: %v", err))
 				} else {
-					r.memoAppStatus(appId, types.OpStatusNoop, "")
+					r.memoAppStatus(actor, appId, types.OpStatusNoop, "")
 					log.Printf("scale down app %s succeed", appId)
 				}
 			}()
 
 			log.Printf("Preparing to scale down App %s", appId)
 
-			types.TaskList(tasks).Reverse() // TODO
+			// removed from the proxies before killed
+			r.drainTasks(appId, killing, time.Duration(scale.GracePeriod)*time.Second)
+
 			var (
-				killing = tasks[:current-goal]
 				wg      sync.WaitGroup
 				succeed int64
 			)
@@ -378,6 +403,7 @@ func (r *Server) scaleApp(w http.ResponseWriter, req *http.Request) {
 					}
 
 					atomic.AddInt64(&succeed, 1)
+					r.progress.advance(appId)
 				}(task)
 			}
 			wg.Wait()
@@ -407,10 +433,12 @@ func (r *Server) scaleApp(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	if err := r.memoAppStatus(appId, types.OpStatusScalingUp, ""); err != nil {
-		http.Error(w, fmt.Sprintf("update app opstatus to scaling up error: %v", err), http.StatusInternalServerError)
+	if err := r.memoAppStatus(actor, appId, types.OpStatusScalingUp, ""); err != nil {
+		http.Error(w, fmt.Sprintf("update app opstatus to scaling up error: %v", err), memoErrCode(err))
 		return
 	}
+	r.progress.start(appId, types.OpStatusScalingUp, goal-current)
+
 	go func() {
 		var err error
 
@@ -418,10 +446,10 @@ func (r *Server) scaleApp(w http.ResponseWriter, req *http.Request) {
 		defer func() {
 			if err != nil {
 				log.Errorf("scale up app %s error: %v", appId, err)
-				r.memoAppStatus(appId, types.OpStatusNoop, fmt.Sprintf("scale up app error: %v", err))
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, fmt.Sprintf("scale up app error: %v", err))
 			} else {
 				log.Printf("scale up app %s succeed", appId)
-				r.memoAppStatus(appId, types.OpStatusNoop, "")
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, "")
 			}
 		}()
 
@@ -429,6 +457,7 @@ func (r *Server) scaleApp(w http.ResponseWriter, req *http.Request) {
 
 		var (
 			tasks = []*mesos.Task{}
+			ids   = []string{}
 		)
 
 		// prepare for all of runtime tasks & db tasks
@@ -469,8 +498,12 @@ func (r *Server) scaleApp(w http.ResponseWriter, req *http.Request) {
 				err = fmt.Errorf("create db task failed: %v", err)
 				return
 			}
+			ids = append(ids, id)
 		}
 
+		// completed as soon as each of the new tasks gets running
+		r.progress.launch(appId, ids)
+
 		err = r.driver.LaunchTasks(tasks)
 		if err != nil {
 			err = fmt.Errorf("launch tasks got error: %v", err)
@@ -482,6 +515,8 @@ func (r *Server) scaleApp(w http.ResponseWriter, req *http.Request) {
 }
 
 func (r *Server) updateApp(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	appId := mux.Vars(req)["app_id"]
 
 	app, err := r.db.GetApp(appId)
@@ -491,7 +526,7 @@ func (r *Server) updateApp(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if app.OpStatus != types.OpStatusNoop {
-		http.Error(w, fmt.Sprintf("app status is %s, operation not allowed.", app.OpStatus), http.StatusLocked)
+		r.opNotAllowed(w, app.OpStatus, types.OpStatusUpdating)
 		return
 	}
 
@@ -518,20 +553,27 @@ func (r *Server) updateApp(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if err := r.memoAppStatus(appId, types.OpStatusUpdating, ""); err != nil {
-		http.Error(w, fmt.Sprintf("update app opstatus to rolling-update got error: %v", err), http.StatusInternalServerError)
+	if err := r.memoAppStatus(actor, appId, types.OpStatusUpdating, ""); err != nil {
+		http.Error(w, fmt.Sprintf("update app opstatus to rolling-update got error: %v", err), memoErrCode(err))
 		return
 	}
+	r.progress.start(appId, types.OpStatusUpdating, len(tasks))
 
 	var (
-		delay     = float64(1)
-		onfailure = types.UpdateStop
+		delay       = float64(1)
+		onfailure   = types.UpdateStop
+		deadline    time.Duration // for the new task to be healthy
+		maxFailures = 1
 	)
 
 	policy := newVer.UpdatePolicy
 	if policy != nil {
 		delay = policy.Delay
 		onfailure = policy.OnFailure
+		deadline = time.Duration(policy.HealthDeadline * float64(time.Second))
+		if policy.MaxFailures > 0 {
+			maxFailures = policy.MaxFailures
+		}
 	}
 
 	types.TaskList(tasks).Sort()
@@ -543,15 +585,20 @@ func (r *Server) updateApp(w http.ResponseWriter, req *http.Request) {
 		defer func() {
 			if err != nil {
 				log.Errorf("update app %s error: %v", appId, err)
-				r.memoAppStatus(appId, types.OpStatusNoop, fmt.Sprintf("update app error: %v", err))
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, fmt.Sprintf("update app error: %v", err))
 			} else {
 				log.Printf("update app %s succeed", appId)
-				r.memoAppStatus(appId, types.OpStatusNoop, "")
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, "")
 			}
 		}()
 
 		log.Printf("Preparing to update App %s", appId)
 
+		var (
+			updated  []*updatedTask // to be restored on rollback
+			failures int
+		)
+
 		for i, t := range pending {
 
 			// kill & remove old
@@ -592,6 +639,8 @@ func (r *Server) updateApp(w http.ResponseWriter, req *http.Request) {
 				return
 			}
 
+			updated = append(updated, &updatedTask{idx: i, old: t, new: task})
+
 			// launch runtime new task
 			cfg := types.NewTaskConfig(newVer, i)
 			m := mesos.NewTask(cfg, task.ID, task.Name)
@@ -609,7 +658,20 @@ func (r *Server) updateApp(w http.ResponseWriter, req *http.Request) {
 				if onfailure == types.UpdateStop {
 					return
 				}
+
+				failures++
+			} else if onfailure == types.UpdateRollback && deadline > 0 {
+				if !r.waitTaskHealthy(appId, task.ID, deadline) {
+					log.Errorf("update app %s: new task %s not healthy within %s", appId, task.ID, deadline)
+					failures++
+				}
+			}
+
+			if onfailure == types.UpdateRollback && failures >= maxFailures {
+				err = r.autoRollback(actor, appId, newVer.ID, updated, failures)
+				return
 			}
+			r.progress.advance(appId)
 
 			// notify proxy
 			time.Sleep(time.Duration(delay) * time.Second)
@@ -620,6 +682,8 @@ func (r *Server) updateApp(w http.ResponseWriter, req *http.Request) {
 }
 
 func (s *Server) startApp(w http.ResponseWriter, req *http.Request) {
+	actor := s.actorOf(req)
+
 	appId := mux.Vars(req)["app_id"]
 
 	app, err := s.db.GetApp(appId)
@@ -629,7 +693,7 @@ func (s *Server) startApp(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if app.OpStatus != types.OpStatusNoop {
-		http.Error(w, fmt.Sprintf("app status is %s, operation not allowed.", app.OpStatus), http.StatusLocked)
+		s.opNotAllowed(w, app.OpStatus, types.OpStatusStarting)
 		return
 	}
 
@@ -639,8 +703,8 @@ func (s *Server) startApp(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if err := s.memoAppStatus(appId, types.OpStatusStarting, ""); err != nil {
-		http.Error(w, fmt.Sprintf("update app opstatus to stopping got error: %v", err), http.StatusInternalServerError)
+	if err := s.memoAppStatus(actor, appId, types.OpStatusStarting, ""); err != nil {
+		http.Error(w, fmt.Sprintf("update app opstatus to stopping got error: %v", err), memoErrCode(err))
 		return
 	}
 
@@ -661,10 +725,10 @@ func (s *Server) startApp(w http.ResponseWriter, req *http.Request) {
 		defer func() {
 			if err != nil {
 				log.Errorf("start app %s error: %v", appId, err)
-				s.memoAppStatus(appId, types.OpStatusNoop, fmt.Sprintf("start app error: %v", err))
+				s.memoAppStatus(actor, appId, types.OpStatusNoop, fmt.Sprintf("start app error: %v", err))
 			} else {
 				log.Printf("start app %s succeed", appId)
-				s.memoAppStatus(appId, types.OpStatusNoop, "")
+				s.memoAppStatus(actor, appId, types.OpStatusNoop, "")
 			}
 		}()
 
@@ -717,6 +781,8 @@ func (s *Server) startApp(w http.ResponseWriter, req *http.Request) {
 }
 
 func (s *Server) stopApp(w http.ResponseWriter, req *http.Request) {
+	actor := s.actorOf(req)
+
 	appId := mux.Vars(req)["app_id"]
 
 	app, err := s.db.GetApp(appId)
@@ -726,7 +792,7 @@ func (s *Server) stopApp(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if app.OpStatus != types.OpStatusNoop {
-		http.Error(w, fmt.Sprintf("app status is %s, operation not allowed.", app.OpStatus), http.StatusLocked)
+		s.opNotAllowed(w, app.OpStatus, types.OpStatusStopping)
 		return
 	}
 
@@ -736,8 +802,8 @@ func (s *Server) stopApp(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if err := s.memoAppStatus(appId, types.OpStatusStopping, ""); err != nil {
-		http.Error(w, fmt.Sprintf("update app opstatus to stopping got error: %v", err), http.StatusInternalServerError)
+	if err := s.memoAppStatus(actor, appId, types.OpStatusStopping, ""); err != nil {
+		http.Error(w, fmt.Sprintf("update app opstatus to stopping got error: %v", err), memoErrCode(err))
 		return
 	}
 
@@ -747,10 +813,10 @@ func (s *Server) stopApp(w http.ResponseWriter, req *http.Request) {
 		defer func() {
 			if err != nil {
 				log.Errorf("stop app %s error: %v", appId, err)
-				s.memoAppStatus(appId, types.OpStatusNoop, fmt.Sprintf("stop app error: %v", err))
+				s.memoAppStatus(actor, appId, types.OpStatusNoop, fmt.Sprintf("stop app error: %v", err))
 			} else {
 				log.Printf("stop app %s succeed", appId)
-				s.memoAppStatus(appId, types.OpStatusNoop, "")
+				s.memoAppStatus(actor, appId, types.OpStatusNoop, "")
 			}
 		}()
 
@@ -775,6 +841,8 @@ func (s *Server) stopApp(w http.ResponseWriter, req *http.Request) {
 }
 
 func (r *Server) canaryUpdate(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	appId := mux.Vars(req)["app_id"]
 
 	app, err := r.db.GetApp(appId)
@@ -784,7 +852,7 @@ func (r *Server) canaryUpdate(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if s := app.OpStatus; s != types.OpStatusNoop && s != types.OpStatusCanaryUnfinished {
-		http.Error(w, fmt.Sprintf("app status is %s, operation not allowed.", s), http.StatusLocked)
+		r.opNotAllowed(w, s, types.OpStatusCanaryUpdating)
 		return
 	}
 
@@ -881,8 +949,8 @@ func (r *Server) canaryUpdate(w http.ResponseWriter, req *http.Request) {
 	oldTasks := tasks[goal:]
 
 	// mark app db status
-	if err := r.memoAppStatus(appId, types.OpStatusCanaryUpdating, ""); err != nil {
-		http.Error(w, fmt.Sprintf("update app opstatus to canary-update got error: %v", err), http.StatusInternalServerError)
+	if err := r.memoAppStatus(actor, appId, types.OpStatusCanaryUpdating, ""); err != nil {
+		http.Error(w, fmt.Sprintf("update app opstatus to canary-update got error: %v", err), memoErrCode(err))
 		return
 	}
 
@@ -910,7 +978,7 @@ func (r *Server) canaryUpdate(w http.ResponseWriter, req *http.Request) {
 				opStatus = types.OpStatusNoop
 			}
 
-			r.memoAppStatus(appId, opStatus, errmsg)
+			r.memoAppStatus(actor, appId, opStatus, errmsg)
 		}()
 
 		log.Printf("Preparing to canary update App %s", appId)
@@ -1018,6 +1086,8 @@ func (r *Server) canaryUpdate(w http.ResponseWriter, req *http.Request) {
 }
 
 func (r *Server) rollback(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	if err := req.ParseForm(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -1032,7 +1102,7 @@ func (r *Server) rollback(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if app.OpStatus != types.OpStatusNoop {
-		http.Error(w, fmt.Sprintf("app status is %s, operation not allowed.", app.OpStatus), http.StatusLocked)
+		r.opNotAllowed(w, app.OpStatus, types.OpStatusRollback)
 		return
 	}
 
@@ -1079,8 +1149,8 @@ func (r *Server) rollback(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	if err := r.memoAppStatus(appId, types.OpStatusRollback, ""); err != nil {
-		http.Error(w, fmt.Sprintf("update app opstatus to rolling-back got error: %v", err), http.StatusInternalServerError)
+	if err := r.memoAppStatus(actor, appId, types.OpStatusRollback, ""); err != nil {
+		http.Error(w, fmt.Sprintf("update app opstatus to rolling-back got error: %v", err), memoErrCode(err))
 		return
 	}
 
@@ -1093,10 +1163,10 @@ func (r *Server) rollback(w http.ResponseWriter, req *http.Request) {
 		defer func() {
 			if err != nil {
 				log.Errorf("rollback app %s error: %v", appId, err)
-				r.memoAppStatus(appId, types.OpStatusNoop, fmt.Sprintf("rollback app error: %v", err))
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, fmt.Sprintf("rollback app error: %v", err))
 			} else {
 				log.Printf("rollback app %s succeed", appId)
-				r.memoAppStatus(appId, types.OpStatusNoop, "")
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, "")
 			}
 		}()
 
@@ -1164,6 +1234,8 @@ func (r *Server) rollback(w http.ResponseWriter, req *http.Request) {
 }
 
 func (r *Server) updateWeights(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	var (
 		appId = mux.Vars(req)["app_id"]
 	)
@@ -1177,7 +1249,7 @@ func (r *Server) updateWeights(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if s := app.OpStatus; s != types.OpStatusCanaryUnfinished {
-		http.Error(w, fmt.Sprintf("app status is %s, operation not allowed.", s), http.StatusLocked)
+		r.opNotAllowed(w, s, types.OpStatusWeightUpdating)
 		return
 	}
 
@@ -1241,8 +1313,8 @@ func (r *Server) updateWeights(w http.ResponseWriter, req *http.Request) {
 	)
 
 	// mark app db status
-	if err := r.memoAppStatus(appId, types.OpStatusWeightUpdating, ""); err != nil {
-		http.Error(w, fmt.Sprintf("update app opstatus to weight-updating got error: %v", err), http.StatusInternalServerError)
+	if err := r.memoAppStatus(actor, appId, types.OpStatusWeightUpdating, ""); err != nil {
+		http.Error(w, fmt.Sprintf("update app opstatus to weight-updating got error: %v", err), memoErrCode(err))
 		return
 	}
 
@@ -1258,7 +1330,7 @@ func (r *Server) updateWeights(w http.ResponseWriter, req *http.Request) {
 			} else {
 				log.Errorf("weight-updating app %s succeed", appId)
 			}
-			r.memoAppStatus(appId, opStatus, errmsg)
+			r.memoAppStatus(actor, appId, opStatus, errmsg)
 		}()
 
 		log.Printf("Preparing to weight-updating App %s", appId)
@@ -1596,6 +1668,8 @@ func (r *Server) updateTask(w http.ResponseWriter, req *http.Request) {
 }
 
 func (r *Server) rollbackTask(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	var (
 		vars   = mux.Vars(req)
 		appId  = vars["app_id"]
@@ -1614,7 +1688,7 @@ func (r *Server) rollbackTask(w http.ResponseWriter, req *http.Request) {
 	}
 
 	if app.OpStatus != types.OpStatusNoop {
-		http.Error(w, fmt.Sprintf("app status is %s, operation not allowed.", app.OpStatus), http.StatusLocked)
+		r.opNotAllowed(w, app.OpStatus, types.OpStatusRollback)
 		return
 	}
 
@@ -1624,13 +1698,13 @@ func (r *Server) rollbackTask(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if err := r.memoAppStatus(appId, types.OpStatusRollback, ""); err != nil {
-		http.Error(w, fmt.Sprintf("update app opstatus to rolling-back got error: %v", err), http.StatusInternalServerError)
+	if err := r.memoAppStatus(actor, appId, types.OpStatusRollback, ""); err != nil {
+		http.Error(w, fmt.Sprintf("update app opstatus to rolling-back got error: %v", err), memoErrCode(err))
 		return
 	}
 
 	defer func() { // TODO format as above
-		r.memoAppStatus(appId, types.OpStatusNoop, "")
+		r.memoAppStatus(actor, appId, types.OpStatusNoop, "")
 	}()
 
 	verId := req.Form.Get("version")
@@ -1768,6 +1842,8 @@ func (r *Server) delApp(appId string, tasks []*types.Task, versions []*types.Ver
 	if err := r.db.DeleteApp(appId); err != nil {
 		return fmt.Errorf("Delete app %s got error: %v", appId, err)
 	}
+	r.history.remove(appId)
+	r.progress.remove(appId)
 
 	return nil
 }
@@ -1808,7 +1884,16 @@ func (s *Server) delTask(appId string, task *types.Task) error {
 
 // short hands to memo update App.OpStatus & App.ErrMsg
 // it's the caller responsibility to process the db error.
-func (r *Server) memoAppStatus(appId, op, errmsg string) error {
+//
+// the transitions are serialized, and entering the in-progress op status which the app is already
+// in is rejected by errOpStatusReentered, so that the concurrent or retried operations couldn't
+// begin twice. the other transitions not allowed by the transition table are rejected by
+// errOpStatusNotAllowed, the callers check the op status before holding the lock, so the concurrent
+// operations may both pass their checks. memo noop again is idempotent, only the errmsg is updated.
+func (r *Server) memoAppStatus(actor *Actor, appId, op, errmsg string) error {
+	r.transitMu.Lock()
+	defer r.transitMu.Unlock()
+
 	app, err := r.db.GetApp(appId)
 	if err != nil {
 		log.Errorf("memoAppStatus() get db app %s error: %v", appId, err)
@@ -1819,6 +1904,16 @@ func (r *Server) memoAppStatus(appId, op, errmsg string) error {
 		prevOp = app.OpStatus
 	)
 
+	if prevOp == op && op != types.OpStatusNoop {
+		r.transitions.reject(prevOp, op)
+		return errOpStatusReentered
+	}
+
+	if prevOp != op && !types.CanTransitOpStatus(prevOp, op) {
+		r.transitions.reject(prevOp, op)
+		return errOpStatusNotAllowed
+	}
+
 	app.OpStatus = op
 	app.ErrMsg = errmsg
 	app.UpdatedAt = time.Now()
@@ -1828,6 +1923,38 @@ func (r *Server) memoAppStatus(appId, op, errmsg string) error {
 		return err
 	}
 
+	if prevOp != op {
+		r.transitions.succeed(prevOp, op)
+
+		if tracked(prevOp) {
+			r.progress.finish(appId)
+		}
+
+		// audited before anything else, so that it's always recorded
+		r.audit.record(&AuditRecord{
+			AppID:  appId,
+			From:   prevOp,
+			To:     op,
+			Actor:  actor,
+			Reason: errmsg,
+			Time:   app.UpdatedAt,
+		})
+
+		r.history.record(appId, &types.OpTransition{
+			From:   prevOp,
+			To:     op,
+			Reason: errmsg,
+			Time:   app.UpdatedAt,
+		})
+
+		r.driver.PublishEvent(&types.StateTransitionEvent{
+			AppID:  appId,
+			From:   prevOp,
+			To:     op,
+			ErrMsg: errmsg,
+		})
+	}
+
 	return nil
 }
 
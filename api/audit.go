@@ -0,0 +1,117 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/utils"
+	"github.com/Dataman-Cloud/swan/utils/pagination"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// maxAuditRecords is the max nb of recent audit records kept in memory
+const maxAuditRecords = 1000
+
+// Actor identifies who triggered the op status transitions
+type Actor struct {
+	Name      string `json:"name"` // token:<fingerprint>, tenant:<tenant> or anonymous
+	Remote    string `json:"remote"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// actorOf returns the actor of the request by the authenticated token, the tokens
+// themselves are never recorded but their fingerprints.
+func (s *Server) actorOf(r *http.Request) *Actor {
+	actor := &Actor{
+		Name:      "anonymous",
+		Remote:    r.RemoteAddr,
+		RequestID: utils.RequestID(r.Context()),
+	}
+
+	if scope := s.tenantScope(r); scope != nil {
+		actor.Name = "tenant:" + scope.Tenant
+	} else if token := requestToken(r); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		actor.Name = "token:" + hex.EncodeToString(sum[:4])
+	}
+
+	return actor
+}
+
+// AuditRecord is one op status transition of the app with who triggered it
+type AuditRecord struct {
+	AppID  string    `json:"app_id"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Actor  *Actor    `json:"actor"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// auditLog appends the audit records to the writer as json lines, and keeps
+// the recent ones in memory for querying.
+type auditLog struct {
+	sync.Mutex
+	w      io.Writer // optional
+	recent []*AuditRecord
+}
+
+func newAuditLog(w io.Writer) *auditLog {
+	return &auditLog{w: w}
+}
+
+func (l *auditLog) record(rec *AuditRecord) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.recent = append(l.recent, rec)
+	if len(l.recent) > maxAuditRecords {
+		l.recent = l.recent[len(l.recent)-maxAuditRecords:]
+	}
+
+	if l.w == nil {
+		return
+	}
+
+	if err := json.NewEncoder(l.w).Encode(rec); err != nil {
+		log.Errorf("write audit record of app %s %s -> %s error: %v", rec.AppID, rec.From, rec.To, err)
+	}
+}
+
+// list returns the recent records of the app, the oldest first. all of them if app id is empty.
+func (l *auditLog) list(appID string) []*AuditRecord {
+	l.Lock()
+	defer l.Unlock()
+
+	ret := make([]*AuditRecord, 0)
+	for _, rec := range l.recent {
+		if appID == "" || rec.AppID == appID {
+			ret = append(ret, rec)
+		}
+	}
+
+	return ret
+}
+
+func (r *Server) listAudit(w http.ResponseWriter, req *http.Request) {
+	page, err := pagination.Parse(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ret := r.audit.list(req.URL.Query().Get("app_id"))
+	if page == nil {
+		writeJSON(w, http.StatusOK, ret)
+		return
+	}
+
+	start, end := page.Bounds(len(ret))
+	writeJSON(w, http.StatusOK, page.Page(len(ret), ret[start:end]))
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+func TestActorOf(t *testing.T) {
+	var (
+		s = &Server{cfg: &Config{
+			AuthTokens:        []string{"admin-token"},
+			TenantTokenSecret: "s3cret",
+		}}
+		tenantA = signTenantToken("HS256", `{"tenant":"a"}`, "s3cret")
+	)
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{
+			name: "anonymous",
+			want: "anonymous",
+		},
+		{
+			name:   "api key",
+			header: "X-Api-Key",
+			value:  "admin-token",
+			want:   "token:",
+		},
+		{
+			name:   "tenant token",
+			header: "Authorization",
+			value:  "Bearer " + tenantA,
+			want:   "tenant:a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/apps/web/reset", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+
+			actor := s.actorOf(req)
+			if !strings.HasPrefix(actor.Name, tt.want) || actor.Remote != req.RemoteAddr {
+				t.Errorf("actor = %+v, want %s from %s", actor, tt.want, req.RemoteAddr)
+			}
+			if strings.Contains(actor.Name, "admin-token") {
+				t.Errorf("actor %s should not carry the token", actor.Name)
+			}
+		})
+	}
+}
+
+func TestAuditTransitions(t *testing.T) {
+	var (
+		buf = new(bytes.Buffer)
+		db  = newFakeStore(
+			&types.Application{ID: "web", OpStatus: types.OpStatusNoop},
+			&types.Application{ID: "db", OpStatus: types.OpStatusUpdating},
+		)
+		s = NewServer(&Config{Advertise: "192.168.1.101:9999", AuditLog: buf}, nil, &fakeDriver{}, db)
+	)
+	s.UpdateLeader("192.168.1.101:9999")
+
+	operator := &Actor{Name: "token:5e7a0b1c", Remote: "192.168.1.10:52000"}
+	s.memoAppStatus(operator, "web", types.OpStatusScalingUp, "")
+	s.memoAppStatus(operator, "web", types.OpStatusScalingUp, "") // not a transition
+	s.memoAppStatus(operator, "web", types.OpStatusNoop, "scale up app error: no offers")
+
+	// reset by the request
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/apps/db/reset", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset code = %d: %s", w.Code, w.Body)
+	}
+
+	want := []struct{ app, from, to, actor string }{
+		{"web", types.OpStatusNoop, types.OpStatusScalingUp, operator.Name},
+		{"web", types.OpStatusScalingUp, types.OpStatusNoop, operator.Name},
+		{"db", types.OpStatusUpdating, types.OpStatusNoop, "anonymous"},
+	}
+
+	// appended to the log as json lines
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("audit log = %q, want %d lines", buf, len(want))
+	}
+	for i, line := range lines {
+		var rec AuditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("unmarshal audit line %q error = %v", line, err)
+		}
+		if w := want[i]; rec.AppID != w.app || rec.From != w.from || rec.To != w.to || rec.Actor == nil || rec.Actor.Name != w.actor || rec.Time.IsZero() {
+			t.Errorf("audit record %d = %+v, want %+v", i, rec, w)
+		}
+	}
+
+	// the reset is requested with the request id
+	if got := s.audit.list("db"); len(got) != 1 || got[0].Reason != "reset" || got[0].Actor.RequestID == "" {
+		t.Errorf("reset audit records = %+v, want one with reason and request id", got)
+	}
+
+	// queried by the app
+	w = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/audit?app_id=web", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("audit code = %d: %s", w.Code, w.Body)
+	}
+
+	var got []*AuditRecord
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1].Reason != "scale up app error: no offers" {
+		t.Errorf("web audit records = %+v, want 2 with the last failed", got)
+	}
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authenticate verify the bearer token or api key carried by the request,
+// always pass if authentication is not enabled or the path is exempted.
+func (s *Server) authenticate(r *http.Request) bool {
+	if len(s.cfg.AuthTokens) == 0 {
+		return true
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	for _, p := range s.cfg.AuthExemptPaths {
+		if path == strings.TrimSuffix(p, "/") {
+			return true
+		}
+	}
+
+	token := requestToken(r)
+	if token == "" {
+		return false
+	}
+
+	for _, t := range s.cfg.AuthTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	// the tenant tokens are only allowed to subscribe the events within their scope
+	if path == "/v1/events" && s.tenantScope(r) != nil {
+		return true
+	}
+
+	return false
+}
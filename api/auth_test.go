@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	var (
+		secret = "s3cret"
+		tenant = signTenantToken("HS256", `{"tenant":"a","apps":["web.a.bbk.dataman"]}`, secret)
+		forged = signTenantToken("HS256", `{"tenant":"a","apps":["web.a.bbk.dataman"]}`, "guess")
+	)
+
+	s := NewServer(&Config{
+		AuthTokens:        []string{"t0ken", "an0ther"},
+		AuthExemptPaths:   []string{"/v1/version/"},
+		TenantTokenSecret: secret,
+	}, nil, &fakeDriver{}, nil)
+
+	tests := []struct {
+		name   string
+		path   string
+		bearer string
+		apiKey string
+		want   bool
+	}{
+		{name: "valid bearer token", path: "/v1/apps", bearer: "t0ken", want: true},
+		{name: "valid api key", path: "/v1/apps", apiKey: "an0ther", want: true},
+		{name: "missing token", path: "/v1/apps", want: false},
+		{name: "wrong token", path: "/v1/apps", bearer: "t0ke", want: false},
+		{name: "exempt path", path: "/v1/version", want: true},
+		{name: "exempt path with trailing slash", path: "/v1/version/", want: true},
+		{name: "tenant token on events", path: "/v1/events", bearer: tenant, want: true},
+		{name: "tenant token on others", path: "/v1/apps", bearer: tenant, want: false},
+		{name: "forged tenant token on events", path: "/v1/events", bearer: forged, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			if tt.apiKey != "" {
+				r.Header.Set("X-Api-Key", tt.apiKey)
+			}
+
+			if got := s.authenticate(r); got != tt.want {
+				t.Errorf("authenticate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// always pass if disabled
+	disabled := NewServer(&Config{}, nil, &fakeDriver{}, nil)
+	if !disabled.authenticate(httptest.NewRequest("GET", "/v1/apps", nil)) {
+		t.Error("authenticate() = false, want true if disabled")
+	}
+}
+
+func TestAuthenticateRoutes(t *testing.T) {
+	cfg := &Config{Advertise: "192.168.1.101:9999", AuthTokens: []string{"t0ken"}}
+	s := NewServer(cfg, nil, &fakeDriver{connected: true}, nil)
+	s.UpdateLeader(cfg.Advertise)
+
+	tests := []struct {
+		name     string
+		path     string
+		token    string
+		wantCode int
+	}{
+		{name: "missing token", path: "/ping", wantCode: http.StatusUnauthorized},
+		{name: "wrong token", path: "/ping", token: "guess", wantCode: http.StatusUnauthorized},
+		{name: "valid token", path: "/ping", token: "t0ken", wantCode: http.StatusOK},
+		{name: "liveness probe", path: "/healthz", wantCode: http.StatusOK},
+		{name: "readiness probe", path: "/readyz", wantCode: http.StatusServiceUnavailable}, // not serving
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("%s code = %d, want %d", tt.path, w.Code, tt.wantCode)
+			}
+			if challenged := w.Header().Get("WWW-Authenticate") != ""; challenged != (tt.wantCode == http.StatusUnauthorized) {
+				t.Errorf("WWW-Authenticate = %q, want challenged only on 401", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -17,6 +17,8 @@ import (
 )
 
 func (r *Server) runComposeNG(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	if err := req.ParseForm(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -183,7 +185,7 @@ func (r *Server) runComposeNG(w http.ResponseWriter, req *http.Request) {
 
 			if err = r.db.CreateVersion(appId, ver); err != nil {
 				err = fmt.Errorf("create App %s db Version error: %v", appId, err)
-				r.memoAppStatus(appId, types.OpStatusNoop, err.Error())
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, err.Error())
 				return
 			}
 
@@ -222,7 +224,7 @@ func (r *Server) runComposeNG(w http.ResponseWriter, req *http.Request) {
 				log.Debugf("Create task %s in db", taskId)
 				if err = r.db.CreateTask(appId, task); err != nil {
 					err = fmt.Errorf("create db task failed: %s", err)
-					r.memoAppStatus(appId, types.OpStatusNoop, err.Error())
+					r.memoAppStatus(actor, appId, types.OpStatusNoop, err.Error())
 					return
 				}
 
@@ -236,7 +238,7 @@ func (r *Server) runComposeNG(w http.ResponseWriter, req *http.Request) {
 				err = r.driver.LaunchTasks(tasks)
 				if err != nil {
 					err = fmt.Errorf("launch compose tasks %s error: %v", taskName, err)
-					r.memoAppStatus(appId, types.OpStatusNoop, err.Error())
+					r.memoAppStatus(actor, appId, types.OpStatusNoop, err.Error())
 					return
 				}
 			}
@@ -246,12 +248,12 @@ func (r *Server) runComposeNG(w http.ResponseWriter, req *http.Request) {
 
 			// max wait for 5 seconds to confirm the preivous app get normal
 			if err = r.ensureAppReady(appId, time.Second*5); err != nil {
-				r.memoAppStatus(appId, types.OpStatusNoop, err.Error())
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, err.Error())
 				return
 			}
 
 			// mark app status
-			r.memoAppStatus(appId, types.OpStatusNoop, "")
+			r.memoAppStatus(actor, appId, types.OpStatusNoop, "")
 			log.Printf("compose app %s launch succeed", appId)
 		}
 
@@ -348,6 +350,8 @@ func (r *Server) parseComposeToVersions(w http.ResponseWriter, req *http.Request
 }
 
 func (r *Server) deleteComposeNG(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	var (
 		composeId = mux.Vars(req)["compose_id"]
 	)
@@ -406,7 +410,7 @@ func (r *Server) deleteComposeNG(w http.ResponseWriter, req *http.Request) {
 			}
 
 			// mark app op status to prevent rescheduling
-			if err := r.memoAppStatus(appId, types.OpStatusDeleting, ""); err != nil {
+			if err := r.memoAppStatus(actor, appId, types.OpStatusDeleting, ""); err != nil {
 				err = fmt.Errorf("update App %s opstatus to deleting got error: %v", appId, err)
 				return
 			}
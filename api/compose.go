@@ -16,6 +16,8 @@ import (
 )
 
 func (r *Server) runCompose(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	var err error
 
 	if err = checkForJSON(req); err != nil {
@@ -150,7 +152,7 @@ func (r *Server) runCompose(w http.ResponseWriter, req *http.Request) {
 
 			if err = r.db.CreateVersion(appId, ver); err != nil {
 				err = fmt.Errorf("create App %s db Version error: %v", appId, err)
-				r.memoAppStatus(appId, types.OpStatusNoop, err.Error())
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, err.Error())
 				return
 			}
 
@@ -180,7 +182,7 @@ func (r *Server) runCompose(w http.ResponseWriter, req *http.Request) {
 				log.Debugf("Create task %s in db", taskId)
 				if err = r.db.CreateTask(appId, task); err != nil {
 					err = fmt.Errorf("create db task failed: %s", err)
-					r.memoAppStatus(appId, types.OpStatusNoop, err.Error())
+					r.memoAppStatus(actor, appId, types.OpStatusNoop, err.Error())
 					return
 				}
 
@@ -194,7 +196,7 @@ func (r *Server) runCompose(w http.ResponseWriter, req *http.Request) {
 				err = r.driver.LaunchTasks(tasks)
 				if err != nil {
 					err = fmt.Errorf("launch compose tasks %s error: %v", taskName, err)
-					r.memoAppStatus(appId, types.OpStatusNoop, err.Error())
+					r.memoAppStatus(actor, appId, types.OpStatusNoop, err.Error())
 					return
 				}
 			}
@@ -203,12 +205,12 @@ func (r *Server) runCompose(w http.ResponseWriter, req *http.Request) {
 
 			// max wait for 5 seconds to confirm the preivous app get normal
 			if err = r.ensureAppReady(appId, time.Second*5); err != nil {
-				r.memoAppStatus(appId, types.OpStatusNoop, err.Error())
+				r.memoAppStatus(actor, appId, types.OpStatusNoop, err.Error())
 				return
 			}
 
 			// mark app status
-			r.memoAppStatus(appId, types.OpStatusNoop, "")
+			r.memoAppStatus(actor, appId, types.OpStatusNoop, "")
 			log.Printf("compose app %s launch succeed", appId)
 
 			// wait delay before next service
@@ -301,6 +303,8 @@ func (r *Server) getComposeDependency(w http.ResponseWriter, req *http.Request)
 }
 
 func (r *Server) deleteCompose(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	composeId := mux.Vars(req)["compose_id"]
 	cmp, err := r.db.GetCompose(composeId)
 	if err != nil {
@@ -355,7 +359,7 @@ func (r *Server) deleteCompose(w http.ResponseWriter, req *http.Request) {
 			}
 
 			// mark app op status
-			if err := r.memoAppStatus(appId, types.OpStatusDeleting, ""); err != nil {
+			if err := r.memoAppStatus(actor, appId, types.OpStatusDeleting, ""); err != nil {
 				err = fmt.Errorf("update app opstatus to deleting got error: %v", err)
 				log.Errorf("deleteCompose(): %v", err)
 				return
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+// constraintsRequest carries the constraints in text form, eg: `rack == r1`
+type constraintsRequest struct {
+	Constraints []string `json:"constraints"`
+}
+
+// evaluateConstraints tells how many of the agents holding offers the constraints would match
+func (s *Server) evaluateConstraints(w http.ResponseWriter, r *http.Request) {
+	var body constraintsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("decode constraints error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Constraints) == 0 {
+		http.Error(w, "at least one constraint required", http.StatusBadRequest)
+		return
+	}
+
+	cs, err := types.ParseConstraints(body.Constraints)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.driver.EvaluateConstraints(cs))
+}
+
+// constraintsValidation is the result of validating the constraints
+type constraintsValidation struct {
+	Valid  bool               `json:"valid"`
+	Errors []*constraintIssue `json:"errors,omitempty"`
+}
+
+type constraintIssue struct {
+	Index int    `json:"index"` // position within the requested constraints
+	Field string `json:"field"` // constraint / attribute / operator / value
+	Value string `json:"value"` // offending token
+	Error string `json:"error"`
+}
+
+// validateConstraints validates the constraints without evaluating them, all of the
+// problems are reported at once.
+func (s *Server) validateConstraints(w http.ResponseWriter, r *http.Request) {
+	var body constraintsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("decode constraints error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	ret := &constraintsValidation{Valid: true}
+
+	_, err := types.ParseConstraints(body.Constraints)
+	if errs, ok := err.(types.ConstraintErrors); ok {
+		ret.Valid = false
+		for _, e := range errs {
+			ret.Errors = append(ret.Errors, &constraintIssue{
+				Index: e.Index,
+				Field: e.Field,
+				Value: e.Value,
+				Error: e.Err.Error(),
+			})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+func TestEvaluateConstraints(t *testing.T) {
+	s := &Server{driver: &fakeDriver{}}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{
+			name:     "valid",
+			body:     `{"constraints": ["rack == r1", "hostname unique"]}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "malformed",
+			body:     `{"constraints": ["rack"]}`,
+			wantCode: http.StatusBadRequest,
+			wantErr:  "attribute operator [value]",
+		},
+		{
+			name:     "invalid",
+			body:     `{"constraints": ["rack max abc"]}`,
+			wantCode: http.StatusBadRequest,
+			wantErr:  "positive integer",
+		},
+		{
+			name:     "empty",
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.evaluateConstraints(w, httptest.NewRequest("POST", "/v1/debug/constraints/evaluate", strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantErr) {
+				t.Errorf("response = %s, want containing %q", w.Body, tt.wantErr)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got []*types.Constraint
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil || len(got) != 2 || got[1].Operator != "unique" {
+				t.Errorf("evaluated = %+v, error %v, want the parsed constraints", got, err)
+			}
+		})
+	}
+}
+
+func TestValidateConstraints(t *testing.T) {
+	s := &Server{}
+
+	tests := []struct {
+		name      string
+		body      string
+		wantValid bool
+		wantErrs  []constraintIssue // without the error message
+	}{
+		{
+			name:      "valid",
+			body:      `{"constraints": ["rack == r1", "hostname unique", "zone in z1,z2"]}`,
+			wantValid: true,
+		},
+		{
+			name: "all of the problems at once",
+			body: `{"constraints": ["rack", "rack == r1", "zone like z1", "rack max 0", "hostname ~= ["]}`,
+			wantErrs: []constraintIssue{
+				{Index: 0, Field: "constraint", Value: "rack"},
+				{Index: 2, Field: "operator", Value: "like"},
+				{Index: 3, Field: "value", Value: "0"},
+				{Index: 4, Field: "value", Value: "["},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.validateConstraints(w, httptest.NewRequest("POST", "/v1/constraints/validate", strings.NewReader(tt.body)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("code = %d: %s", w.Code, w.Body)
+			}
+
+			var got constraintsValidation
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Valid != tt.wantValid || len(got.Errors) != len(tt.wantErrs) {
+				t.Fatalf("validation = %v with %d errors, want %v with %d", got.Valid, len(got.Errors), tt.wantValid, len(tt.wantErrs))
+			}
+			for i, e := range got.Errors {
+				want := tt.wantErrs[i]
+				if e.Index != want.Index || e.Field != want.Field || e.Value != want.Value || e.Error == "" {
+					t.Errorf("errors[%d] = %+v, want %+v with message", i, e, want)
+				}
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	s.validateConstraints(w, httptest.NewRequest("POST", "/v1/constraints/validate", strings.NewReader("not json")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("malformed body code = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// enableCORS sets the cross-origin response headers if the request origin is allowed
+func (s *Server) enableCORS(w http.ResponseWriter, r *http.Request) bool {
+	if !s.cfg.EnableCORS {
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	w.Header().Add("Vary", "Origin")
+
+	if !s.originAllowed(origin) {
+		return false
+	}
+
+	// the wildcard origin is not allowed with credentials, echo the origin instead
+	if s.originWildcard() && !s.cfg.CORSAllowCredentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if s.cfg.CORSAllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	return true
+}
+
+// preflight answers the cross-origin OPTIONS preflight requests
+func (s *Server) preflight(w http.ResponseWriter, r *http.Request) {
+	if !s.enableCORS(w, r) {
+		http.Error(w, "cross-origin request not allowed", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(s.cfg.CORSAllowedMethods, ", "))
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(s.cfg.CORSAllowedHeaders, ", "))
+	w.Header().Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) originAllowed(origin string) bool {
+	for _, o := range s.cfg.CORSAllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) originWildcard() bool {
+	for _, o := range s.cfg.CORSAllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
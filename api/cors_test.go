@@ -0,0 +1,150 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newCORSServer(origins []string, credentials bool) *Server {
+	cfg := &Config{
+		Advertise:            "192.168.1.101:9999",
+		EnableCORS:           true,
+		CORSAllowedOrigins:   origins,
+		CORSAllowedMethods:   []string{"GET", "POST", "DELETE"},
+		CORSAllowedHeaders:   []string{"Authorization", "Content-Type"},
+		CORSAllowCredentials: credentials,
+	}
+	s := NewServer(cfg, nil, &fakeDriver{}, nil)
+	s.UpdateLeader(cfg.Advertise)
+	return s
+}
+
+func TestCORSPreflight(t *testing.T) {
+	s := newCORSServer([]string{"https://ui.example.com"}, true)
+
+	tests := []struct {
+		name     string
+		origin   string
+		wantCode int
+		want     map[string]string
+	}{
+		{
+			name:     "allowed origin",
+			origin:   "https://UI.example.com",
+			wantCode: http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://UI.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "GET, POST, DELETE",
+				"Access-Control-Allow-Headers":     "Authorization, Content-Type",
+				"Access-Control-Max-Age":           "600",
+				"Vary":                             "Origin",
+			},
+		},
+		{
+			name:     "disallowed origin",
+			origin:   "https://evil.example.com",
+			wantCode: http.StatusForbidden,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Methods": "",
+				"Vary":                         "Origin",
+			},
+		},
+		{
+			name:     "without origin",
+			wantCode: http.StatusForbidden,
+			want:     map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("OPTIONS", "/v1/apps", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			r.Header.Set("Access-Control-Request-Method", "POST")
+
+			w := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("preflight code = %d, want %d", w.Code, tt.wantCode)
+			}
+			for k, v := range tt.want {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("%s = %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		origin      string
+		wantOrigin  string
+		wantCreds   string
+	}{
+		{
+			name:        "allowed origin with credentials",
+			origins:     []string{"https://ui.example.com"},
+			credentials: true,
+			origin:      "https://ui.example.com",
+			wantOrigin:  "https://ui.example.com",
+			wantCreds:   "true",
+		},
+		{
+			name:       "wildcard",
+			origins:    []string{"*"},
+			origin:     "https://ui.example.com",
+			wantOrigin: "*",
+		},
+		{
+			name:        "wildcard echoed with credentials",
+			origins:     []string{"*"},
+			credentials: true,
+			origin:      "https://ui.example.com",
+			wantOrigin:  "https://ui.example.com",
+			wantCreds:   "true",
+		},
+		{
+			name:    "disallowed origin",
+			origins: []string{"https://ui.example.com"},
+			origin:  "https://evil.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newCORSServer(tt.origins, tt.credentials)
+
+			r := httptest.NewRequest("GET", "/ping", nil)
+			r.Header.Set("Origin", tt.origin)
+
+			w := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("code = %d, want 200 served anyway", w.Code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCreds)
+			}
+			if got := w.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("Vary = %q, want Origin", got)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != "" {
+				t.Errorf("Access-Control-Allow-Methods = %q, want only on the preflight", got)
+			}
+		})
+	}
+}
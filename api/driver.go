@@ -14,8 +14,12 @@ type Driver interface {
 	LaunchTasks([]*mesos.Task) error
 
 	ClusterName() string
+	Connected() bool
 
-	SubscribeEvent(io.Writer, string) error
+	SubscribeEvent(io.Writer, string, mesos.EventOptions) error
+	PublishEvent(types.EventPayload) error
+	CloseEventListeners()
+	EventStats() *mesos.EventStats
 	FullTaskEventsAndRecords() []*types.CombinedEvents
 	SendEvent(string, *types.Task) error
 
@@ -28,6 +32,8 @@ type Driver interface {
 	// for debug convenience
 	Dump() interface{}
 	Offers() interface{}
+	ExplainConstraints(string, []*types.Constraint) interface{}
+	EvaluateConstraints([]*types.Constraint) interface{}
 	Load() map[string]interface{}
 	FrameworkInfo() *types.FrameworkInfo
 }
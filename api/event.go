@@ -1,14 +1,132 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+
+	"github.com/Dataman-Cloud/swan/mesos"
+	"github.com/Dataman-Cloud/swan/types"
+	"github.com/Dataman-Cloud/swan/utils"
+	"github.com/Dataman-Cloud/swan/utils/labels"
+)
+
+// the upper bounds of the event batching
+const (
+	maxEventBatchWindow = time.Second
+	maxEventBatchSize   = 1000
 )
 
 func (r *Server) events(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Content-Type", "text/event-stream")
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the low priority listener is dropped once it's too slow to consume the events
+	priority := strings.ToLower(req.Form.Get("priority"))
+	if priority == "" {
+		priority = mesos.EventPriorityLow
+	}
+	if !mesos.ValidEventPriority(priority) {
+		http.Error(w, fmt.Sprintf("unsupported event priority: %s", priority), http.StatusBadRequest)
+		return
+	}
+
+	format := strings.ToLower(req.Form.Get("format"))
+	if format == "" {
+		format = types.EventFormatSSE
+	}
+	if !types.ValidEventFormat(format) {
+		http.Error(w, fmt.Sprintf("unsupported event format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	// coalesce the events arrived within the window into one write, bounded to keep the latency low
+	var (
+		batchWindow time.Duration
+		batchSize   int
+	)
+	if v := req.Form.Get("batchWindow"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxEventBatchWindow {
+			http.Error(w, fmt.Sprintf("batchWindow %s should be a duration within (0, %s]", v, maxEventBatchWindow), http.StatusBadRequest)
+			return
+		}
+		if format == types.EventFormatLegacy {
+			http.Error(w, "batching is not supported by the legacy format", http.StatusBadRequest)
+			return
+		}
+		batchWindow = d
+	}
+	if v := req.Form.Get("batchSize"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxEventBatchSize {
+			http.Error(w, fmt.Sprintf("batchSize %s should be an integer within (0, %d]", v, maxEventBatchSize), http.StatusBadRequest)
+			return
+		}
+		batchSize = n
+	}
+
+	// suppress the identical consecutive events of one task, eg: the flapping health events
+	var (
+		dedupWindow time.Duration
+		dedupKey    []string
+	)
+	if v := req.Form.Get("dedupWindow"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, fmt.Sprintf("dedupWindow %s should be a positive duration", v), http.StatusBadRequest)
+			return
+		}
+		dedupWindow = d
+	}
+	if v := req.Form.Get("dedupKey"); v != "" {
+		dedupKey = strings.Split(v, ",")
+	}
+
+	// enrich the task events with the allocated resources & agent
+	details := strings.ToLower(req.Form.Get("details")) == "true"
+
+	// filter by the originating app's labels, eg: env=prod,tier!=cache,zone in (bj,sh)
+	var selector labels.Selector
+	if v := req.Form.Get("labelSelector"); v != "" {
+		sel, err := labels.Parse(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("parse label selector %s failed: %v", v, err), http.StatusBadRequest)
+			return
+		}
+		selector = sel
+	}
+
+	// filter by the originating apps, eg: nginx.default.bbk.dataman,redis.default.bbk.dataman
+	var appIDs []string
+	if v := req.Form.Get("appId"); v != "" {
+		appIDs = strings.Split(v, ",")
+	}
+
+	opts := mesos.EventOptions{
+		Priority:      priority,
+		Format:        format,
+		AppIDs:        appIDs,
+		LabelSelector: selector,
+		Scope:         r.tenantScope(req), // enforced regardless of the requested apps
+		BatchWindow:   batchWindow,
+		BatchSize:     batchSize,
+		DedupWindow:   dedupWindow,
+		DedupKey:      dedupKey,
+		Details:       details,
+	}
+
+	if format == types.EventFormatNDJSON {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/event-stream")
+	}
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Write(nil)
 
@@ -16,26 +134,59 @@ func (r *Server) events(w http.ResponseWriter, req *http.Request) {
 		f.Flush()
 	}
 
-	if err := req.ParseForm(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// notify new client all of current tasks' stats by sse firstly
+	// notify new client all of current tasks' stats firstly
 	if catchUp := req.Form.Get("catchUp"); strings.ToLower(catchUp) == "true" {
+		appLabels := make(map[string]map[string]string) // app id -> labels
 		for _, cmbEv := range r.driver.FullTaskEventsAndRecords() {
-			if _, err := w.Write(cmbEv.Event.Format()); err != nil {
-				log.Errorf("write event message to client [%s] error: [%v]", req.RemoteAddr, err)
+			ev := types.NewEvent(cmbEv.Event)
+
+			if opts.NeedLabels() {
+				set, ok := appLabels[ev.AppID]
+				if !ok {
+					set = r.appLabels(ev.AppID)
+					appLabels[ev.AppID] = set
+				}
+				ev.Labels = set
+			}
+			if !opts.Match(ev) {
+				continue
+			}
+
+			if details {
+				ev = ev.WithDetails()
+			}
+
+			if _, err := w.Write(ev.Encode(format)); err != nil {
+				log.Errorf("[%s] write event message to client [%s] error: [%v]", utils.RequestID(req.Context()), req.RemoteAddr, err)
 				continue
 			}
 			w.(http.Flusher).Flush()
 		}
 	}
 
-	if err := r.driver.SubscribeEvent(w, req.RemoteAddr); err != nil {
+	reqID := utils.RequestID(req.Context())
+	log.Printf("[%s] event listener [%s] subscribing", reqID, req.RemoteAddr)
+	defer log.Printf("[%s] event listener [%s] closed", reqID, req.RemoteAddr)
+
+	if err := r.driver.SubscribeEvent(w, req.RemoteAddr, opts); err != nil {
 		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
 		return
 	}
 
 	return
 }
+
+// appLabels returns the labels of the app's current version
+func (r *Server) appLabels(appID string) map[string]string {
+	app, err := r.db.GetApp(appID)
+	if err != nil || len(app.Version) == 0 {
+		return nil
+	}
+
+	ver, err := r.db.GetVersion(appID, app.Version[0])
+	if err != nil {
+		return nil
+	}
+
+	return ver.Labels
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// explainConstraints tells why the app's tasks can't be placed on the agents
+func (s *Server) explainConstraints(w http.ResponseWriter, r *http.Request) {
+	appId := mux.Vars(r)["app_id"]
+
+	app, err := s.db.GetApp(appId)
+	if err != nil {
+		if s.db.IsErrNotFound(err) {
+			http.Error(w, fmt.Sprintf("app %s not exists", appId), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ver, err := s.db.GetVersion(appId, app.Version[0])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("get app version error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.driver.ExplainConstraints(appId, ver.Constraints))
+}
@@ -1,8 +1,28 @@
 package api
 
-import "net/http"
+import (
+	"net/http"
+	"sort"
+
+	"github.com/Dataman-Cloud/swan/utils/pagination"
+)
 
 func (r *Server) fullEventsAndRecords(w http.ResponseWriter, req *http.Request) {
+	page, err := pagination.Parse(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	ret := r.driver.FullTaskEventsAndRecords()
-	writeJSON(w, http.StatusOK, ret)
+	if page == nil {
+		writeJSON(w, http.StatusOK, ret)
+		return
+	}
+
+	// stable order across the pages
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Event.TaskID < ret[j].Event.TaskID })
+
+	start, end := page.Bounds(len(ret))
+	writeJSON(w, http.StatusOK, page.Page(len(ret), ret[start:end]))
 }
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+func TestFullSyncPagination(t *testing.T) {
+	var (
+		cfg    = &Config{Advertise: "192.168.1.101:9999"}
+		driver = &fakeDriver{}
+	)
+	for _, id := range []string{"2.web", "0.web", "1.web"} {
+		driver.events = append(driver.events, &types.CombinedEvents{Event: &types.TaskEvent{TaskID: id}})
+	}
+
+	s := NewServer(cfg, nil, driver, nil)
+	s.UpdateLeader(cfg.Advertise)
+
+	do := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/fullsync"+query, nil))
+		return w
+	}
+
+	// unpaginated as is
+	var all []*types.CombinedEvents
+	if err := json.NewDecoder(do("").Body).Decode(&all); err != nil || len(all) != 3 {
+		t.Fatalf("full sync = %d events, error %v, want 3", len(all), err)
+	}
+
+	var page struct {
+		Items []*types.CombinedEvents `json:"items"`
+		Total int                     `json:"total"`
+		Next  string                  `json:"next"`
+	}
+	if err := json.NewDecoder(do("?limit=2&cursor=1").Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 3 || len(page.Items) != 2 || page.Items[0].Event.TaskID != "1.web" || page.Next != "" {
+		t.Errorf("page = %+v, want the sorted 1.web & 2.web of 3 without next", page)
+	}
+
+	if w := do("?limit=abc"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid limit code = %d, want 400", w.Code)
+	}
+}
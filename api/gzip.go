@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// compress wraps the route handler to gzip the json responses if the client accepts,
+// the streaming and prefix(redirect) routes are always skipped.
+func (s *Server) compress(r *Route, handler http.HandlerFunc) http.HandlerFunc {
+	if !s.cfg.EnableGzip || r.prefix || streamingRoutes[r.Path()] {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		gw := &gzipWriter{ResponseWriter: w, code: http.StatusOK}
+
+		handler(gw, req)
+
+		accept := strings.Contains(req.Header.Get("Accept-Encoding"), "gzip")
+		gw.finish(accept, s.cfg.GzipMinSize)
+	}
+}
+
+// gzipWriter buffers the whole response to decide whether to compress it by size
+type gzipWriter struct {
+	http.ResponseWriter
+	buf      bytes.Buffer
+	code     int
+	hijacked bool
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+func (w *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("not support http hijack: %T", w.ResponseWriter)
+	}
+	w.hijacked = true
+	return hj.Hijack()
+}
+
+func (w *gzipWriter) finish(accept bool, minSize int) {
+	if w.hijacked {
+		return
+	}
+
+	var (
+		header = w.Header()
+		body   = w.buf.Bytes()
+	)
+
+	header.Add("Vary", "Accept-Encoding")
+
+	if !accept || len(body) < minSize || header.Get("Content-Encoding") != "" ||
+		!strings.HasPrefix(header.Get("Content-Type"), "application/json") {
+		w.ResponseWriter.WriteHeader(w.code)
+		w.ResponseWriter.Write(body)
+		return
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.code)
+
+	gz := gzip.NewWriter(w.ResponseWriter)
+	gz.Write(body)
+	gz.Close()
+}
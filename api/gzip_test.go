@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	var (
+		large = `{"apps": "` + strings.Repeat("nginx.default.bbk.dataman,", 100) + `"}`
+		small = `{"apps": "nginx"}`
+	)
+
+	s := NewServer(&Config{EnableGzip: true, GzipMinSize: 1024}, nil, &fakeDriver{}, nil)
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		accept      string
+		wantGzip    bool
+	}{
+		{name: "above threshold", body: large, contentType: "application/json", accept: "gzip, deflate", wantGzip: true},
+		{name: "below threshold", body: small, contentType: "application/json", accept: "gzip"},
+		{name: "client without gzip", body: large, contentType: "application/json", accept: "deflate"},
+		{name: "client without accept encoding", body: large, contentType: "application/json"},
+		{name: "not json", body: large, contentType: "text/plain", accept: "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := s.compress(NewRoute("GET", "/v1/apps", nil), func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(tt.body))
+			})
+
+			r := httptest.NewRequest("GET", "/v1/apps", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != http.StatusCreated {
+				t.Errorf("code = %d, want 201 kept", w.Code)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+
+			body := w.Body.Bytes()
+			if tt.wantGzip {
+				if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+					t.Fatalf("Content-Encoding = %q, want gzip", got)
+				}
+				if len(body) >= len(tt.body) {
+					t.Errorf("compressed %d bytes, want less than %d", len(body), len(tt.body))
+				}
+
+				gz, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = ioutil.ReadAll(gz); err != nil {
+					t.Fatal(err)
+				}
+			} else if got := w.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q, want passed through", got)
+			}
+
+			if string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestCompressSkipped(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   *Config
+		route *Route
+	}{
+		{name: "disabled", cfg: &Config{GzipMinSize: 1}, route: NewRoute("GET", "/v1/apps", nil)},
+		{name: "streaming route", cfg: &Config{EnableGzip: true, GzipMinSize: 1}, route: NewRoute("GET", "/v1/events", nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(tt.cfg, nil, &fakeDriver{}, nil)
+
+			handler := s.compress(tt.route, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"apps": []}`))
+			})
+
+			r := httptest.NewRequest("GET", tt.route.Path(), nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if got := w.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q, want skipped", got)
+			}
+			if got := w.Body.String(); got != `{"apps": []}` {
+				t.Errorf("body = %q, want passed through", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// readiness is the dependency state reported by the readiness probe
+type readiness struct {
+	Ready     bool   `json:"ready"`
+	Serving   bool   `json:"serving"`   // the api server is serving
+	Leader    string `json:"leader"`    // the current leader, empty if not elected yet
+	IsLeader  bool   `json:"is_leader"` // this manager is the leader
+	Connected bool   `json:"connected"` // the scheduler is subscribed to mesos, only the leader subscribes
+}
+
+// healthz is the liveness probe, the process is up as long as it answers.
+func (r *Server) healthz(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, "ok")
+}
+
+// readyz is the readiness probe. the leader is ready once subscribed to mesos,
+// while the followers are ready once the leader is known to forward the requests to.
+func (r *Server) readyz(w http.ResponseWriter, req *http.Request) {
+	ret := r.readiness()
+
+	code := http.StatusOK
+	if !ret.Ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, code, ret)
+}
+
+func (r *Server) readiness() *readiness {
+	leader := r.GetLeader()
+
+	ret := &readiness{
+		Serving:  atomic.LoadInt32(&r.serving) == 1,
+		Leader:   leader,
+		IsLeader: leader != "" && leader == r.cfg.Advertise,
+	}
+
+	if ret.IsLeader {
+		ret.Connected = r.driver.Connected()
+	}
+
+	ret.Ready = ret.Serving && leader != "" && (!ret.IsLeader || ret.Connected)
+
+	return ret
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/mesos"
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+// fakeDriver only answers the connection state, the full task events, echoes
+// the evaluated constraints and records the published events & the task operations
+type fakeDriver struct {
+	Driver
+	connected bool
+	events    []*types.CombinedEvents
+
+	sync.Mutex
+	published []types.EventPayload
+	ops       []string // "drain|kill <task id>" or "launch <task name>" in the order
+}
+
+func (d *fakeDriver) Connected() bool {
+	return d.connected
+}
+
+func (d *fakeDriver) FullTaskEventsAndRecords() []*types.CombinedEvents {
+	return d.events
+}
+
+func (d *fakeDriver) EvaluateConstraints(cs []*types.Constraint) interface{} {
+	return cs
+}
+
+func (d *fakeDriver) PublishEvent(p types.EventPayload) error {
+	d.Lock()
+	d.published = append(d.published, p)
+	d.Unlock()
+	return nil
+}
+
+func (d *fakeDriver) SendEvent(appId string, task *types.Task) error {
+	if task.Weight == 0 {
+		d.record("drain " + task.ID)
+	}
+	return nil
+}
+
+func (d *fakeDriver) KillTask(taskId, agentId string, gracePeriod int64) error {
+	d.record("kill " + taskId)
+	return nil
+}
+
+func (d *fakeDriver) LaunchTasks(tasks []*mesos.Task) error {
+	for _, task := range tasks {
+		d.record("launch " + task.GetName())
+	}
+	return nil
+}
+
+func (d *fakeDriver) record(op string) {
+	d.Lock()
+	d.ops = append(d.ops, op)
+	d.Unlock()
+}
+
+func (d *fakeDriver) recorded() []string {
+	d.Lock()
+	defer d.Unlock()
+	return append([]string{}, d.ops...)
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name      string
+		serving   bool
+		leader    string
+		connected bool
+		want      int
+	}{
+		{
+			name:      "leader connected",
+			serving:   true,
+			leader:    "192.168.1.101:9999",
+			connected: true,
+			want:      http.StatusOK,
+		},
+		{
+			name:    "leader not connected to mesos",
+			serving: true,
+			leader:  "192.168.1.101:9999",
+			want:    http.StatusServiceUnavailable,
+		},
+		{
+			name:    "follower knowing the leader",
+			serving: true,
+			leader:  "192.168.1.102:9999",
+			want:    http.StatusOK,
+		},
+		{
+			name:    "not elected yet",
+			serving: true,
+			want:    http.StatusServiceUnavailable,
+		},
+		{
+			name:      "not serving",
+			leader:    "192.168.1.101:9999",
+			connected: true,
+			want:      http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(&Config{Advertise: "192.168.1.101:9999", AuthTokens: []string{"token"}}, nil, &fakeDriver{connected: tt.connected}, nil)
+			s.UpdateLeader(tt.leader)
+			if tt.serving {
+				s.serving = 1
+			}
+
+			// unauthenticated & answered locally
+			w := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+			if w.Code != tt.want {
+				t.Fatalf("readyz code = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			var got readiness
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("decode readiness error = %v", err)
+			}
+			if got.Ready != (tt.want == http.StatusOK) {
+				t.Errorf("ready = %v, want %v", got.Ready, tt.want == http.StatusOK)
+			}
+
+			w = httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("healthz code = %d, want 200", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// upper bounds of the request latency histogram buckets
+	latencyBuckets = []time.Duration{
+		time.Millisecond * 5,
+		time.Millisecond * 10,
+		time.Millisecond * 25,
+		time.Millisecond * 50,
+		time.Millisecond * 100,
+		time.Millisecond * 250,
+		time.Millisecond * 500,
+		time.Second,
+		time.Millisecond * 2500,
+		time.Second * 5,
+		time.Second * 10,
+	}
+
+	// the long-lived streaming routes are only counted, without latency observed
+	streamingRoutes = map[string]bool{
+		"/v1/events": true,
+	}
+)
+
+// metrics holds the per-route request statistics of the api server
+type metrics struct {
+	sync.Mutex
+	routes map[string]*RouteCounter // route name -> counter
+}
+
+// RouteCounter hold one route's current statistics
+type RouteCounter struct {
+	Requests  uint64            `json:"requests"`          // nb of requests
+	Codes     map[string]uint64 `json:"codes"`             // status code -> nb of responses
+	Streaming bool              `json:"streaming"`         // long-lived streaming route or not
+	Latency   *Histogram        `json:"latency,omitempty"` // nil for streaming route
+}
+
+// Histogram hold the request latency distribution of one route
+type Histogram struct {
+	Buckets []uint64 `json:"buckets"` // nb of requests within each of latencyBuckets, the last one for +Inf
+	Count   uint64   `json:"count"`   // nb of observed requests
+	SumMs   float64  `json:"sum_ms"`  // total latency in milliseconds
+	P50     string   `json:"p50"`
+	P95     string   `json:"p95"`
+	P99     string   `json:"p99"`
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		routes: make(map[string]*RouteCounter),
+	}
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{
+		Buckets: make([]uint64, len(latencyBuckets)+1),
+	}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	idx := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if d <= bound {
+			idx = i
+			break
+		}
+	}
+
+	h.Buckets[idx]++
+	h.Count++
+	h.SumMs += float64(d) / float64(time.Millisecond)
+}
+
+// quantile estimates the q-quantile latency by the upper bound of the
+// bucket which the q-quantile falls in.
+func (h *Histogram) quantile(q float64) string {
+	if h.Count == 0 {
+		return ""
+	}
+
+	var (
+		rank = uint64(q * float64(h.Count))
+		cum  uint64
+	)
+
+	for i, n := range h.Buckets {
+		cum += n
+		if cum > rank || cum == h.Count {
+			if i == len(latencyBuckets) {
+				return "+Inf"
+			}
+			return latencyBuckets[i].String()
+		}
+	}
+
+	return "+Inf"
+}
+
+func (m *metrics) observe(route string, code int, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	c, ok := m.routes[route]
+	if !ok {
+		c = &RouteCounter{Codes: make(map[string]uint64)}
+		m.routes[route] = c
+	}
+
+	c.Requests++
+	c.Codes[strconv.Itoa(code)]++
+
+	if d < 0 {
+		c.Streaming = true
+		return
+	}
+
+	if c.Latency == nil {
+		c.Latency = newHistogram()
+	}
+	c.Latency.observe(d)
+}
+
+// snapshot returns a copy of current per-route statistics with the quantiles calculated
+func (m *metrics) snapshot() map[string]*RouteCounter {
+	m.Lock()
+	defer m.Unlock()
+
+	ret := make(map[string]*RouteCounter, len(m.routes))
+	for route, c := range m.routes {
+		cp := &RouteCounter{
+			Requests:  c.Requests,
+			Codes:     make(map[string]uint64, len(c.Codes)),
+			Streaming: c.Streaming,
+		}
+
+		for code, n := range c.Codes {
+			cp.Codes[code] = n
+		}
+
+		if c.Latency != nil {
+			h := *c.Latency
+			h.Buckets = append([]uint64(nil), c.Latency.Buckets...)
+			h.P50, h.P95, h.P99 = h.quantile(0.50), h.quantile(0.95), h.quantile(0.99)
+			cp.Latency = &h
+		}
+
+		ret[route] = cp
+	}
+
+	return ret
+}
+
+// instrument wraps the route handler to record the request's status code and latency
+func (s *Server) instrument(r *Route, handler http.HandlerFunc) http.HandlerFunc {
+	var (
+		name      = fmt.Sprintf("%s %s", r.method, r.Path())
+		streaming = streamingRoutes[r.Path()]
+	)
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		var (
+			sw    = &statusWriter{ResponseWriter: w, code: http.StatusOK}
+			start = time.Now()
+		)
+
+		handler(sw, req)
+
+		d := time.Since(start)
+		if streaming {
+			d = -1 // don't skew the latency buckets by the long-lived streaming
+		}
+
+		s.metrics.observe(name, sw.code, d)
+
+		log.Debugf("[%s] %s %s %d %s", utils.RequestID(req.Context()), req.Method, req.URL.RequestURI(), sw.code, time.Since(start))
+	}
+}
+
+// statusWriter records the response status code, it keeps the
+// flush / close notify / hijack capabilities of the underlying writer.
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) CloseNotify() <-chan bool {
+	if n, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return n.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("not support http hijack: %T", w.ResponseWriter)
+	}
+	w.wroteHeader = true // the response is taken over by the hijacker
+	return hj.Hijack()
+}
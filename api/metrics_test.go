@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetricsObserved(t *testing.T) {
+	s := newTestServer(&fakeDriver{}, nil)
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("ping code = %d", w.Code)
+		}
+	}
+
+	got, ok := s.metrics.snapshot()["GET /ping"]
+	if !ok {
+		t.Fatalf("route GET /ping not counted: %v", s.metrics.snapshot())
+	}
+	if got.Requests != 3 || got.Codes["200"] != 3 {
+		t.Errorf("requests = %d codes = %v, want 3 of 200", got.Requests, got.Codes)
+	}
+	if got.Streaming || got.Latency == nil || got.Latency.Count != 3 {
+		t.Fatalf("latency = %+v, want 3 observed", got.Latency)
+	}
+
+	var n uint64
+	for _, b := range got.Latency.Buckets {
+		n += b
+	}
+	if n != 3 || got.Latency.P50 == "" {
+		t.Errorf("buckets = %v p50 = %q, want 3 within the buckets", got.Latency.Buckets, got.Latency.P50)
+	}
+}
+
+func TestMetricsStreamingExcluded(t *testing.T) {
+	s := newTestServer(&fakeDriver{}, nil)
+
+	// the events stream lasts long, then ends by the client
+	handler := s.instrument(NewRoute("GET", "/v1/events", nil), func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 20)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/events", nil))
+
+	got, ok := s.metrics.snapshot()["GET /v1/events"]
+	if !ok {
+		t.Fatal("route GET /v1/events not counted")
+	}
+	if got.Requests != 1 || !got.Streaming {
+		t.Errorf("requests = %d streaming = %v, want 1 streaming", got.Requests, got.Streaming)
+	}
+	if got.Latency != nil {
+		t.Errorf("latency = %+v, want excluded", got.Latency)
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Dataman-Cloud/swan/types"
+
+	"github.com/gorilla/mux"
+)
+
+// pauseApp freezes the app for maintenance: its tasks keep running as is, but neither
+// the operations are accepted nor the failed tasks are rescheduled until resumed.
+func (r *Server) pauseApp(w http.ResponseWriter, req *http.Request) {
+	r.switchPaused(w, req, types.OpStatusNoop, types.OpStatusPaused)
+}
+
+// resumeApp gets the paused app back to noop
+func (r *Server) resumeApp(w http.ResponseWriter, req *http.Request) {
+	r.switchPaused(w, req, types.OpStatusPaused, types.OpStatusNoop)
+}
+
+func (r *Server) switchPaused(w http.ResponseWriter, req *http.Request, from, to string) {
+	appId := mux.Vars(req)["app_id"]
+
+	app, err := r.db.GetApp(appId)
+	if err != nil {
+		if r.db.IsErrNotFound(err) {
+			http.Error(w, fmt.Sprintf("app %s not exists", appId), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if app.OpStatus != from {
+		r.opNotAllowed(w, app.OpStatus, to)
+		return
+	}
+
+	if err := r.memoAppStatus(r.actorOf(req), appId, to, ""); err != nil {
+		http.Error(w, fmt.Sprintf("update app opstatus to %s got error: %v", to, err), memoErrCode(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"previous": from,
+		"current":  to,
+	})
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+func TestPauseResume(t *testing.T) {
+	var (
+		db = newFakeStore(&types.Application{ID: "web", OpStatus: types.OpStatusNoop, Version: []string{"v1"}})
+		s  = newTestServer(&fakeDriver{}, db)
+	)
+	db.tasks["web"] = []*types.Task{{ID: "t0", Name: "0.web.default.bbk.dataman"}}
+	db.versions["web"] = []*types.Version{{ID: "v1"}}
+
+	do := func(method, path, body string) int {
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w.Code
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		want     int
+		wantOpSt string
+	}{
+		{name: "resume not paused", method: "POST", path: "/v1/apps/web/resume", want: http.StatusLocked, wantOpSt: types.OpStatusNoop},
+		{name: "pause", method: "POST", path: "/v1/apps/web/pause", want: http.StatusOK, wantOpSt: types.OpStatusPaused},
+		{name: "pause again", method: "POST", path: "/v1/apps/web/pause", want: http.StatusLocked, wantOpSt: types.OpStatusPaused},
+		{name: "scale while paused", method: "POST", path: "/v1/apps/web/scale", body: `{"instances": 0}`, want: http.StatusLocked, wantOpSt: types.OpStatusPaused},
+		{name: "update while paused", method: "PUT", path: "/v1/apps/web", body: `{}`, want: http.StatusLocked, wantOpSt: types.OpStatusPaused},
+		{name: "resume", method: "POST", path: "/v1/apps/web/resume", want: http.StatusOK, wantOpSt: types.OpStatusNoop},
+		{name: "unknown app", method: "POST", path: "/v1/apps/absent/pause", want: http.StatusNotFound, wantOpSt: types.OpStatusNoop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := do(tt.method, tt.path, tt.body); got != tt.want {
+				t.Errorf("%s %s code = %d, want %d", tt.method, tt.path, got, tt.want)
+			}
+			if app, _ := db.GetApp("web"); app.OpStatus != tt.wantOpSt {
+				t.Errorf("op status = %s, want %s", app.OpStatus, tt.wantOpSt)
+			}
+		})
+	}
+
+	// the tasks are preserved
+	if tasks, _ := db.ListTasks("web"); len(tasks) != 1 {
+		t.Errorf("tasks = %+v, want preserved", tasks)
+	}
+
+	if h := s.history.get("web"); len(h) != 2 || h[0].To != types.OpStatusPaused || h[1].To != types.OpStatusNoop {
+		t.Errorf("history = %+v, want paused & resumed", h)
+	}
+}
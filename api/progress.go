@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/store"
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+// OpProgress is the progress of the app's latest scaling or updating
+type OpProgress struct {
+	Op        string    `json:"op"`
+	Completed int       `json:"completed"` // nb of the tasks scaled or updated
+	Total     int       `json:"total"`
+	Percent   int       `json:"percent"`
+	Summary   string    `json:"summary"` // eg: 7 of 10 updating
+	Done      bool      `json:"done"`    // the operation finished, succeed or not
+	Started   time.Time `json:"started"`
+
+	launching []string // the new tasks of scaling up, completed once running
+}
+
+// opProgress tracks the progress of the operations by the apps, the latest one
+// of each app is kept after finished.
+type opProgress struct {
+	sync.Mutex
+	apps map[string]*OpProgress
+}
+
+func newOpProgress() *opProgress {
+	return &opProgress{
+		apps: make(map[string]*OpProgress),
+	}
+}
+
+func (p *opProgress) start(appID, op string, total int) {
+	p.Lock()
+	p.apps[appID] = &OpProgress{Op: op, Total: total, Started: time.Now()}
+	p.Unlock()
+}
+
+// advance marks one more task of the operation completed
+func (p *opProgress) advance(appID string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if prog, ok := p.apps[appID]; ok && !prog.Done {
+		prog.Completed++
+	}
+}
+
+// launch sets the new tasks of scaling up, their progress is tracked by the task status
+func (p *opProgress) launch(appID string, taskIDs []string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if prog, ok := p.apps[appID]; ok {
+		prog.launching = taskIDs
+	}
+}
+
+func (p *opProgress) finish(appID string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if prog, ok := p.apps[appID]; ok {
+		prog.Done = true
+	}
+}
+
+func (p *opProgress) remove(appID string) {
+	p.Lock()
+	delete(p.apps, appID)
+	p.Unlock()
+}
+
+// get returns the current progress of the app, nil if none
+func (p *opProgress) get(appID string, db store.Store) *OpProgress {
+	p.Lock()
+	prog, ok := p.apps[appID]
+	if !ok {
+		p.Unlock()
+		return nil
+	}
+	cp := *prog
+	p.Unlock()
+
+	for _, id := range cp.launching {
+		if task, err := db.GetTask(appID, id); err == nil && task.Status == "TASK_RUNNING" {
+			cp.Completed++
+		}
+	}
+
+	if cp.Completed > cp.Total {
+		cp.Completed = cp.Total
+	}
+
+	cp.Percent = 100
+	if cp.Total > 0 {
+		cp.Percent = cp.Completed * 100 / cp.Total
+	}
+	cp.Summary = fmt.Sprintf("%d of %d %s", cp.Completed, cp.Total, cp.Op)
+	cp.launching = nil
+
+	return &cp
+}
+
+// tracked tells if the progress of the op status is tracked
+func tracked(op string) bool {
+	switch op {
+	case types.OpStatusScalingUp, types.OpStatusScalingDown, types.OpStatusUpdating:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+func TestOpProgress(t *testing.T) {
+	var (
+		db = newFakeStore(&types.Application{ID: "web"})
+		p  = newOpProgress()
+	)
+	db.tasks["web"] = []*types.Task{
+		{ID: "t0", Status: "pending"},
+		{ID: "t1", Status: "pending"},
+	}
+
+	if got := p.get("web", db); got != nil {
+		t.Fatalf("progress before started = %+v, want nil", got)
+	}
+
+	check := func(step string, completed, percent int, done bool) {
+		got := p.get("web", db)
+		if got == nil {
+			t.Fatalf("%s: progress = nil", step)
+		}
+		if got.Completed != completed || got.Percent != percent || got.Done != done {
+			t.Errorf("%s: progress = %d %d%% done %v, want %d %d%% done %v", step, got.Completed, got.Percent, got.Done, completed, percent, done)
+		}
+	}
+
+	p.start("web", types.OpStatusScalingUp, 4)
+	p.advance("web")
+	check("advanced", 1, 25, false)
+
+	p.launch("web", []string{"t0", "t1"})
+	check("launched", 1, 25, false)
+
+	db.tasks["web"][0].Status = "TASK_RUNNING"
+	check("one running", 2, 50, false)
+
+	db.tasks["web"][1].Status = "TASK_RUNNING"
+	p.advance("web")
+	check("all running", 4, 100, false)
+
+	if got := p.get("web", db).Summary; got != "4 of 4 scaling_up" {
+		t.Errorf("summary = %q, want 4 of 4 scaling_up", got)
+	}
+
+	p.finish("web")
+	p.advance("web")
+	check("finished", 4, 100, true)
+
+	p.start("web", types.OpStatusUpdating, 0)
+	check("nothing to update", 0, 100, false)
+
+	p.remove("web")
+	if got := p.get("web", db); got != nil {
+		t.Errorf("progress after removed = %+v, want nil", got)
+	}
+}
+
+func TestScaleDownProgress(t *testing.T) {
+	var (
+		db = newFakeStore(&types.Application{ID: "web", OpStatus: types.OpStatusNoop, Version: []string{"v1"}})
+		s  = newTestServer(&fakeDriver{}, db)
+	)
+	db.tasks["web"] = scaleTestTasks()
+	db.versions["web"] = []*types.Version{{ID: "v1", Instances: 4}}
+
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/apps/web/scale", strings.NewReader(`{"instances": 1}`)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("scale code = %d: %s", w.Code, w.Body)
+	}
+
+	for i := 0; i < 200; i++ {
+		if app, _ := db.GetApp("web"); app.OpStatus == types.OpStatusNoop {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	w = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/apps/web/state", nil))
+
+	var state AppState
+	if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+		t.Fatalf("decode state %s: %v", w.Body, err)
+	}
+
+	got := state.Progress
+	if got == nil {
+		t.Fatalf("state progress = nil: %s", w.Body)
+	}
+	if got.Op != types.OpStatusScalingDown || got.Completed != 3 || got.Total != 3 || got.Percent != 100 || !got.Done {
+		t.Errorf("progress = %+v, want scaling_down 3 of 3 done", got)
+	}
+}
@@ -8,6 +8,8 @@ import (
 )
 
 func (r *Server) purge(w http.ResponseWriter, req *http.Request) {
+	actor := r.actorOf(req)
+
 	apps, err := r.db.ListApps()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -37,7 +39,7 @@ func (r *Server) purge(w http.ResponseWriter, req *http.Request) {
 				continue
 			}
 			// mark app op status
-			if err := r.memoAppStatus(appId, types.OpStatusDeleting, ""); err != nil {
+			if err := r.memoAppStatus(actor, appId, types.OpStatusDeleting, ""); err != nil {
 				log.Errorf("Purge() update app opstatus to deleting got error: %v", err)
 				continue
 			}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/Dataman-Cloud/swan/utils"
+	log "github.com/Sirupsen/logrus"
+)
+
+// recovery catches the panic within the route handler and responses 500 instead of crashing.
+// if the response has been partially written, eg: the events streaming, the connection
+// is aborted so that the client could tell the broken response and reconnect.
+func (s *Server) recovery(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			log.Errorf("[%s] panic on serving %s %s: %v\n%s", utils.RequestID(r.Context()), r.Method, r.URL.Path, err, debug.Stack())
+
+			if sw, ok := w.(*statusWriter); ok && sw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}()
+
+		handler(w, r)
+	}
+}
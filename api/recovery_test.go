@@ -0,0 +1,65 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRecovery(t *testing.T) {
+	s := newTestServer(&fakeDriver{}, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", s.instrument(NewRoute("GET", "/panic", nil), s.recovery(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++ // nil map
+	})))
+	mux.HandleFunc("/partial", s.instrument(NewRoute("GET", "/partial", nil), s.recovery(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		panic("broken in the middle")
+	})))
+	mux.HandleFunc("/ping", s.recovery(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(srv.URL + "/panic")
+		if err != nil {
+			t.Fatalf("request error = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("panic code = %d, want 500", resp.StatusCode)
+		}
+	}
+
+	// the partially written response is aborted
+	resp, err := http.Get(srv.URL + "/partial")
+	if err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Error("partial response read completely, want aborted")
+	}
+
+	// keeps serving
+	resp, err = http.Get(srv.URL + "/ping")
+	if err != nil {
+		t.Fatalf("request after panic error = %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "pong" {
+		t.Errorf("after panic got %d %q, want 200 pong", resp.StatusCode, body)
+	}
+
+	if got := s.metrics.snapshot()["GET /panic"]; got == nil || got.Codes["500"] != 2 {
+		t.Errorf("panic route counter = %+v, want 2 of 500", got)
+	}
+}
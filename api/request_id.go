@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/Dataman-Cloud/swan/utils"
+)
+
+// tagRequest attaches the request id to the request, the inbound `X-Request-ID` is honored,
+// otherwise a new one is generated. the id is echoed by the response and forwarded to the leader.
+func (s *Server) tagRequest(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, id := utils.TagRequestID(r)
+		w.Header().Set(utils.RequestIDHeader, id)
+
+		handler(w, r)
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/utils"
+)
+
+func TestRequestID(t *testing.T) {
+	var (
+		cfg = &Config{Advertise: "192.168.1.101:9999"}
+		s   = NewServer(cfg, nil, &fakeDriver{}, nil)
+	)
+	s.UpdateLeader(cfg.Advertise)
+
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	tests := []struct {
+		name    string
+		inbound string
+	}{
+		{name: "generated when absent"},
+		{name: "preserved when present", inbound: "trace-from-ui"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := s.tagRequest(func(w http.ResponseWriter, r *http.Request) {
+				seen = utils.RequestID(r.Context())
+				if fwd := r.Header.Get(utils.RequestIDHeader); fwd != seen {
+					t.Errorf("forwarding header %q, want %q", fwd, seen)
+				}
+			})
+
+			req := httptest.NewRequest("GET", "/ping", nil)
+			if tt.inbound != "" {
+				req.Header.Set(utils.RequestIDHeader, tt.inbound)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			got := w.Header().Get(utils.RequestIDHeader)
+			if got != seen {
+				t.Errorf("responded request id %q, handler context %q", got, seen)
+			}
+			if tt.inbound != "" && got != tt.inbound {
+				t.Errorf("request id = %q, want inbound %q", got, tt.inbound)
+			}
+			if tt.inbound == "" && !uuid.MatchString(got) {
+				t.Errorf("generated request id = %q, want an uuid", got)
+			}
+		})
+	}
+
+	// tagged by the routes
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+	if w.Header().Get(utils.RequestIDHeader) == "" {
+		t.Errorf("route response should carry the request id")
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/Dataman-Cloud/swan/types"
 
@@ -35,6 +36,12 @@ func (r *Server) resetStatus(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if current != desired {
+		now := time.Now()
+		r.audit.record(&AuditRecord{AppID: id, From: current, To: desired, Actor: r.actorOf(req), Reason: "reset", Time: now})
+		r.history.record(id, &types.OpTransition{From: current, To: desired, Reason: "reset", Time: now})
+	}
+
 	writeJSON(w, http.StatusOK, map[string]string{
 		"previous": current,
 		"current":  desired,
@@ -12,6 +12,18 @@ type Route struct {
 	path    string
 	handler HandlerFunc
 	prefix  bool // flag on prefix route matcher or not
+
+	// the annotations described by the swagger spec
+	summary string
+	params  []*RouteParam
+	returns map[int]string // status code -> description
+}
+
+// RouteParam is a query parameter of the route, the path parameters are derived from the path
+type RouteParam struct {
+	Name        string
+	Type        string // string, integer, boolean
+	Description string
 }
 
 func (r *Route) Methods() []string {
@@ -29,20 +41,41 @@ func (r *Route) Handler() HandlerFunc {
 	return r.handler
 }
 
+// Doc sets the summary of the route
+func (r *Route) Doc(summary string) *Route {
+	r.summary = summary
+	return r
+}
+
+// Param declares a query parameter of the route
+func (r *Route) Param(name, typ, desc string) *Route {
+	r.params = append(r.params, &RouteParam{Name: name, Type: typ, Description: desc})
+	return r
+}
+
+// Returns declares a response of the route
+func (r *Route) Returns(code int, desc string) *Route {
+	if r.returns == nil {
+		r.returns = make(map[int]string)
+	}
+	r.returns[code] = desc
+	return r
+}
+
 func NewRoute(method, path string, handler HandlerFunc) *Route {
 	return &Route{
-		method,
-		strings.TrimSuffix(path, "/"),
-		handler,
-		false,
+		method:  method,
+		path:    strings.TrimSuffix(path, "/"),
+		handler: handler,
+		prefix:  false,
 	}
 }
 
 func NewPrefixRoute(method, path string, handler HandlerFunc) *Route {
 	return &Route{
-		method,
-		path,
-		handler,
-		true,
+		method:  method,
+		path:    path,
+		handler: handler,
+		prefix:  true,
 	}
 }
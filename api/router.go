@@ -7,9 +7,12 @@ import (
 
 func (s *Server) setupRoutes(mux *mux.Router) {
 	routes := []*Route{
-		NewRoute("GET", "/v1/apps", s.listApps),
-		NewRoute("POST", "/v1/apps", s.createApp),
-		NewRoute("GET", "/v1/apps/{app_id}", s.getApp),
+		NewRoute("GET", "/v1/apps", s.listApps).Doc("List apps").
+			Param("labels", "string", "label selector of the apps, eg: env=prod").
+			Param("fields", "string", "field selector of the apps").
+			Returns(400, "Invalid selector"),
+		NewRoute("POST", "/v1/apps", s.createApp).Doc("Create app"),
+		NewRoute("GET", "/v1/apps/{app_id}", s.getApp).Doc("Inspect app").Returns(404, "App not found"),
 		NewRoute("DELETE", "/v1/apps/{app_id}", s.deleteApp),
 		NewRoute("POST", "/v1/apps/{app_id}/scale", s.scaleApp),
 		NewRoute("PUT", "/v1/apps/{app_id}", s.updateApp),
@@ -19,6 +22,14 @@ func (s *Server) setupRoutes(mux *mux.Router) {
 		NewRoute("POST", "/v1/apps/{app_id}/rollback", s.rollback),
 		NewRoute("PUT", "/v1/apps/{app_id}/weights", s.updateWeights),
 		NewRoute("POST", "/v1/apps/{app_id}/reset", s.resetStatus),
+		NewRoute("POST", "/v1/apps/{app_id}/pause", s.pauseApp).Doc("Pause app for maintenance").
+			Returns(404, "App not found").Returns(423, "App not in noop"),
+		NewRoute("POST", "/v1/apps/{app_id}/resume", s.resumeApp).Doc("Resume the paused app").
+			Returns(404, "App not found").Returns(423, "App not paused"),
+		NewRoute("GET", "/v1/apps/{app_id}/state", s.getAppState).Doc("Inspect app op status with the recent transitions").
+			Returns(404, "App not found"),
+		NewRoute("POST", "/v1/apps/{app_id}/state/dryrun", s.dryRunTransition).Doc("Check the transition without executing").
+			Returns(400, "Malformed transition").Returns(404, "App not found"),
 
 		NewRoute("GET", "/v1/apps/{app_id}/tasks", s.getTasks),
 		NewRoute("GET", "/v1/apps/{app_id}/tasks/{task_id}", s.getTask),
@@ -48,9 +59,20 @@ func (s *Server) setupRoutes(mux *mux.Router) {
 		NewRoute("GET", "/v1/compose-ng/{compose_id}/debug/versions", s.parseComposeToVersions),
 		NewRoute("DELETE", "/v1/compose-ng/{compose_id}", s.deleteComposeNG),
 
-		NewRoute("GET", "/ping", s.ping),
-		NewRoute("GET", "/v1/events", s.events),
-		NewRoute("GET", "/v1/stats", s.stats),
+		NewRoute("GET", "/ping", s.ping).Doc("Health check"),
+		NewRoute("GET", "/v1/events", s.events).Doc("Subscribe the events stream").
+			Param("appId", "string", "comma separated apps to stream the events of").
+			Param("catchUp", "boolean", "replay all of the current tasks' events firstly").
+			Param("labelSelector", "string", "stream the events of the apps whose labels match").
+			Param("format", "string", "sse(default), ndjson or legacy").
+			Param("priority", "string", "low(default) or high").
+			Param("batchWindow", "string", "coalesce the events within the window, at most 1s").
+			Param("batchSize", "integer", "max nb of events within one batch, at most 1000").
+			Param("dedupWindow", "string", "suppress the identical consecutive events within the window").
+			Param("dedupKey", "string", "comma separated fields identifying the identical events").
+			Param("details", "boolean", "enrich the task events with the resources and agent").
+			Returns(400, "Invalid parameters"),
+		NewRoute("GET", "/v1/stats", s.stats).Doc("Requests and events statistics"),
 		NewRoute("GET", "/version", s.version),
 		NewRoute("GET", "/v1/leader", s.getLeader),
 		NewRoute("POST", "/v1/purge", s.purge),
@@ -59,7 +81,23 @@ func (s *Server) setupRoutes(mux *mux.Router) {
 		NewRoute("GET", "/v1/debug/dump", s.dump),
 		NewRoute("GET", "/v1/debug/load", s.load),
 		NewRoute("GET", "/v1/debug/offers", s.offers),
-		NewRoute("GET", "/v1/fullsync", s.fullEventsAndRecords),
+		NewRoute("GET", "/v1/debug/apps/{app_id}/explain", s.explainConstraints),
+		NewRoute("POST", "/v1/debug/constraints/evaluate", s.evaluateConstraints).Doc("Evaluate the constraints against the current offers").
+			Returns(400, "Malformed or invalid constraints"),
+		NewRoute("POST", "/v1/constraints/validate", s.validateConstraints).Doc("Validate the constraints").
+			Returns(400, "Malformed request body"),
+		NewRoute("GET", "/v1/fullsync", s.fullEventsAndRecords).Doc("List the current tasks' events and records").
+			Param("limit", "integer", "page size, at most 1000").
+			Param("offset", "integer", "nb of items skipped").
+			Param("cursor", "string", "the next of the previous page").
+			Returns(400, "Invalid pagination"),
+		NewRoute("GET", "/v1/audit", s.listAudit).Doc("List the audited op status transitions with the actors").
+			Param("app_id", "string", "only the transitions of the app").
+			Param("limit", "integer", "page size, at most 1000").
+			Param("offset", "integer", "nb of items skipped").
+			Param("cursor", "string", "the next of the previous page").
+			Returns(400, "Invalid pagination"),
+		NewRoute("GET", "/swagger.json", s.swagger).Doc("The swagger spec of the api"),
 
 		NewRoute("PUT", "/v1/debug", s.enableDebug),
 		NewRoute("DELETE", "/v1/debug", s.disableDebug),
@@ -82,11 +120,13 @@ func (s *Server) setupRoutes(mux *mux.Router) {
 		NewRoute("GET", "/v1/apps/{app_id}/proxy/traffics", s.getAppTraffics),
 	}
 
+	s.routes = routes
+
 	log.Debug("Registering HTTP route")
 
 	for _, r := range routes {
 		var (
-			handler = s.makeHTTPHandler(r.Handler())
+			handler = s.tagRequest(s.instrument(r, s.recovery(s.compress(r, s.makeHTTPHandler(r.Handler())))))
 			path    = r.Path()
 			methods = r.Methods()
 		)
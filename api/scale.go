@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/types"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// drainingTasks returns the n tasks to be removed on scaling down by the drain order,
+// in the order they're drained.
+func drainingTasks(tasks []*types.Task, n int, order string) ([]*types.Task, error) {
+	sorted := append([]*types.Task{}, tasks...)
+
+	switch order {
+	case "", types.DrainOrderIndex:
+		types.TaskList(sorted).Reverse()
+	case types.DrainOrderAge:
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Created.Before(sorted[j].Created) })
+	default:
+		return nil, fmt.Errorf("unknown drain order %q, should be one of %s, %s", order, types.DrainOrderIndex, types.DrainOrderAge)
+	}
+
+	if n > len(sorted) {
+		n = len(sorted)
+	}
+
+	return sorted[:n], nil
+}
+
+// drainTask removes the task from the proxies by the zero weight, so that it takes no
+// new requests except the existing sessions.
+func (r *Server) drainTask(appId string, task *types.Task) error {
+	task.Weight = 0
+
+	if err := r.db.UpdateTask(appId, task); err != nil {
+		return fmt.Errorf("update task %s weight got error: %v", task.ID, err)
+	}
+
+	if err := r.driver.SendEvent(appId, task); err != nil {
+		return fmt.Errorf("sending task %s event got error: %v", task.ID, err)
+	}
+
+	return nil
+}
+
+// drainTasks drains all of the tasks in order and waits for the grace period.
+// the tasks failed to drain are still killed after all.
+func (r *Server) drainTasks(appId string, tasks []*types.Task, gracePeriod time.Duration) {
+	var drained int
+	for _, task := range tasks {
+		if err := r.drainTask(appId, task); err != nil {
+			log.Errorf("drain task %s got error: %v", task.ID, err)
+			continue
+		}
+
+		drained++
+	}
+
+	if drained > 0 && gracePeriod > 0 {
+		log.Printf("waiting %s for the %d drained tasks of app %s", gracePeriod, drained, appId)
+		time.Sleep(gracePeriod)
+	}
+}
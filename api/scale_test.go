@@ -0,0 +1,139 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+// scaleTestTasks are indexed 0-3, while the 2nd is the oldest and the 0th is the newest
+func scaleTestTasks() []*types.Task {
+	now := time.Now()
+	return []*types.Task{
+		{ID: "t0", Name: "0.web.default.bbk.dataman", Weight: 100, Created: now},
+		{ID: "t1", Name: "1.web.default.bbk.dataman", Weight: 100, Created: now.Add(-time.Hour)},
+		{ID: "t2", Name: "2.web.default.bbk.dataman", Weight: 100, Created: now.Add(-time.Hour * 3)},
+		{ID: "t3", Name: "3.web.default.bbk.dataman", Weight: 100, Created: now.Add(-time.Hour * 2)},
+	}
+}
+
+func TestDrainingTasks(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		order   string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "highest indexed by default",
+			n:    2,
+			want: []string{"t3", "t2"},
+		},
+		{
+			name:  "by index",
+			n:     3,
+			order: types.DrainOrderIndex,
+			want:  []string{"t3", "t2", "t1"},
+		},
+		{
+			name:  "oldest first",
+			n:     2,
+			order: types.DrainOrderAge,
+			want:  []string{"t2", "t3"},
+		},
+		{
+			name:  "more than all",
+			n:     5,
+			order: types.DrainOrderAge,
+			want:  []string{"t2", "t3", "t1", "t0"},
+		},
+		{
+			name:    "unknown order",
+			n:       1,
+			order:   "connections",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks := scaleTestTasks()
+
+			got, err := drainingTasks(tasks, tt.n, tt.order)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("drainingTasks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var ids []string
+			for _, task := range got {
+				ids = append(ids, task.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("drainingTasks() = %v, want %v", ids, tt.want)
+			}
+
+			if tasks[0].ID != "t0" {
+				t.Errorf("the given tasks should not be reordered")
+			}
+		})
+	}
+}
+
+func TestScaleDownDrainBeforeKill(t *testing.T) {
+	var (
+		driver = &fakeDriver{}
+		db     = newFakeStore(&types.Application{ID: "web", OpStatus: types.OpStatusNoop, Version: []string{"v1"}})
+		s      = newTestServer(driver, db)
+	)
+	db.tasks["web"] = scaleTestTasks()
+	db.versions["web"] = []*types.Version{{ID: "v1"}}
+
+	w := httptest.NewRecorder()
+	body := `{"instances": 2, "drainOrder": "age", "gracePeriod": 0}`
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/apps/web/scale", strings.NewReader(body)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("scale code = %d: %s", w.Code, w.Body)
+	}
+
+	// wait for the scaling
+	for i := 0; i < 100; i++ {
+		if app, _ := db.GetApp("web"); app.OpStatus == types.OpStatusNoop {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	ops := driver.recorded()
+	if len(ops) != 4 {
+		t.Fatalf("ops = %v, want 2 drains and 2 kills", ops)
+	}
+
+	// drained in order before any of them killed, the kills are concurrent
+	if want := []string{"drain t2", "drain t3"}; !reflect.DeepEqual(ops[:2], want) {
+		t.Errorf("drains = %v, want %v", ops[:2], want)
+	}
+	kills := ops[2:]
+	sort.Strings(kills)
+	if want := []string{"kill t2", "kill t3"}; !reflect.DeepEqual(kills, want) {
+		t.Errorf("kills = %v, want %v", kills, want)
+	}
+
+	remained, _ := db.ListTasks("web")
+	if len(remained) != 2 || remained[0].ID != "t0" || remained[1].ID != "t1" {
+		t.Errorf("remained tasks = %+v, want t0 & t1", remained)
+	}
+
+	// unknown drain order is rejected
+	w = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/apps/web/scale", strings.NewReader(`{"instances": 1, "drainOrder": "random"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown drain order code = %d, want 400", w.Code)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -8,6 +9,7 @@ import (
 	"net/http/pprof"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Dataman-Cloud/swan/store"
@@ -18,26 +20,56 @@ import (
 type Config struct {
 	Advertise string
 	LogLevel  string
+
+	EnableGzip  bool
+	GzipMinSize int // responses smaller than this size are not compressed
+
+	AuthTokens      []string // empty to disable authentication
+	AuthExemptPaths []string
+
+	TenantTokenSecret string // secret signing the tenant scoped event tokens, empty to disable
+
+	AuditLog io.Writer // the op status transitions are appended to, optional
+
+	EnableCORS           bool
+	CORSAllowedOrigins   []string // `*` to allow any origin, empty to deny all
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSAllowCredentials bool
 }
 
 type Server struct {
-	cfg      *Config
-	listener net.Listener // specified net listener
-	leader   string
-	server   *http.Server
-	driver   Driver
-	db       store.Store
+	cfg         *Config
+	listener    net.Listener // specified net listener
+	leader      string
+	server      *http.Server
+	driver      Driver
+	db          store.Store
+	metrics     *metrics
+	history     *opHistory      // recent op status transitions of the apps
+	audit       *auditLog       // op status transitions with the actors
+	progress    *opProgress     // progress of the scaling & updating
+	transitions *transitCounter // op status transitions statistics
+	routes      []*Route        // registered routes
+	serving     int32           // atomic, 1 while serving the requests
+
+	transitMu sync.Mutex // serializes the op status transitions
 
 	sync.Mutex
 }
 
 func NewServer(cfg *Config, l net.Listener, driver Driver, db store.Store) *Server {
 	s := &Server{
-		cfg:      cfg,
-		listener: l,
-		leader:   "",
-		driver:   driver,
-		db:       db,
+		cfg:         cfg,
+		listener:    l,
+		leader:      "",
+		driver:      driver,
+		db:          db,
+		metrics:     newMetrics(),
+		history:     newOpHistory(),
+		audit:       newAuditLog(cfg.AuditLog),
+		progress:    newOpProgress(),
+		transitions: newTransitCounter(),
 	}
 
 	s.server = &http.Server{
@@ -51,8 +83,15 @@ func NewServer(cfg *Config, l net.Listener, driver Driver, db store.Store) *Serv
 func (s *Server) createMux() *mux.Router {
 	m := mux.NewRouter().StrictSlash(true)
 
+	// the probes are neither authenticated nor forwarded to the leader
+	m.Path("/healthz").Methods("GET").HandlerFunc(s.healthz)
+	m.Path("/readyz").Methods("GET").HandlerFunc(s.readyz)
+
 	s.setupRoutes(m)
 
+	// answer the cross-origin preflight requests on any path
+	m.Methods("OPTIONS").HandlerFunc(s.preflight)
+
 	if s.cfg.LogLevel == "debug" {
 		profilerSetup(m, "/debug/")
 	}
@@ -60,12 +99,6 @@ func (s *Server) createMux() *mux.Router {
 	return m
 }
 
-func (s *Server) enableCORS(w http.ResponseWriter) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, X-Registry-Auth")
-	w.Header().Add("Access-Control-Allow-Methods", "HEAD, GET, POST, DELETE, PUT, OPTIONS")
-}
-
 func profilerSetup(r *mux.Router, path string) {
 	var m = r.PathPrefix(path).Subrouter()
 	m.HandleFunc("/pprof/", pprof.Index)
@@ -81,7 +114,13 @@ func profilerSetup(r *mux.Router, path string) {
 
 func (s *Server) makeHTTPHandler(handler HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		s.enableCORS(w)
+		s.enableCORS(w, r)
+
+		if !s.authenticate(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="swan"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
 
 		if s.cfg.Advertise != s.GetLeader() {
 			s.forwardRequest(w, r)
@@ -93,15 +132,24 @@ func (s *Server) makeHTTPHandler(handler HandlerFunc) http.HandlerFunc {
 }
 
 func (s *Server) Run() error {
+	atomic.StoreInt32(&s.serving, 1)
+	defer atomic.StoreInt32(&s.serving, 0)
+
 	return s.server.Serve(s.listener)
 }
 
-// gracefully shutdown.
-func (s *Server) Shutdown() error {
+// gracefully shutdown. release all of the event listeners firstly so they could
+// reconnect to other managers, then stop accepting new connections and wait for
+// the in-flight requests until the ctx done.
+func (s *Server) Shutdown(ctx context.Context) error {
 	// If s.server is nil, api server is not running.
 	if s.server != nil {
+		atomic.StoreInt32(&s.serving, 0) // not ready any more while draining
+
+		s.driver.CloseEventListeners()
+
 		// NOTE(nmg): need golang 1.8+ to run this method.
-		return s.server.Shutdown(nil)
+		return s.server.Shutdown(ctx)
 	}
 
 	return nil
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/mesos"
+)
+
+// streamDriver holds the event listeners until they are closed
+type streamDriver struct {
+	fakeDriver
+
+	mu        sync.Mutex
+	listeners map[string]chan struct{}
+}
+
+func (d *streamDriver) SubscribeEvent(w io.Writer, remote string, opts mesos.EventOptions) error {
+	release := make(chan struct{})
+
+	d.mu.Lock()
+	d.listeners[remote] = release
+	d.mu.Unlock()
+
+	<-release
+
+	d.mu.Lock()
+	delete(d.listeners, remote)
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *streamDriver) CloseEventListeners() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, release := range d.listeners {
+		close(release)
+	}
+}
+
+func (d *streamDriver) size() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.listeners)
+}
+
+func TestShutdownReleasesEventStreams(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		driver = &streamDriver{listeners: make(map[string]chan struct{})}
+		cfg    = &Config{Advertise: l.Addr().String()}
+		s      = NewServer(cfg, l, driver, nil)
+	)
+	s.UpdateLeader(cfg.Advertise)
+
+	go s.Run()
+
+	resp, err := http.Get("http://" + l.Addr().String() + "/v1/events")
+	if err != nil {
+		t.Fatalf("subscribe events error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	for driver.size() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v, want the event stream released before the deadline", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("Shutdown() returned after the deadline")
+	}
+
+	// the stream ends so that the client could reconnect to another manager
+	ended := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(resp.Body)
+		ended <- err
+	}()
+	select {
+	case <-ended:
+	case <-time.After(time.Second * 5):
+		t.Fatal("event stream not ended by shutdown")
+	}
+
+	if n := driver.size(); n != 0 {
+		t.Errorf("event listeners after shutdown = %d, want 0", n)
+	}
+	if _, err := net.DialTimeout("tcp", l.Addr().String(), time.Second); err == nil {
+		t.Error("dial after shutdown succeeded, want the listener closed")
+	}
+}
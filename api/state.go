@@ -0,0 +1,281 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/types"
+	"github.com/gorilla/mux"
+)
+
+// maxOpHistory is the max nb of recent op status transitions kept per app
+const maxOpHistory = 20
+
+// errOpStatusReentered is returned on entering the in-progress op status which the app is already in
+var errOpStatusReentered = errors.New("app is in the op status already, operation not allowed")
+
+// errOpStatusNotAllowed is returned on the op status transition not allowed by the transition table,
+// eg: the update raced with the scaling which began first
+var errOpStatusNotAllowed = errors.New("app op status transition not allowed")
+
+// memoErrCode returns the response code of failing to memo the op status
+func memoErrCode(err error) int {
+	if err == errOpStatusReentered || err == errOpStatusNotAllowed {
+		return http.StatusLocked
+	}
+	return http.StatusInternalServerError
+}
+
+// opHistory holds the recent op status transitions of the apps since the leader started
+type opHistory struct {
+	sync.Mutex
+	apps map[string][]*types.OpTransition // app id -> transitions, the oldest first
+}
+
+func newOpHistory() *opHistory {
+	return &opHistory{
+		apps: make(map[string][]*types.OpTransition),
+	}
+}
+
+func (h *opHistory) record(appID string, t *types.OpTransition) {
+	h.Lock()
+	defer h.Unlock()
+
+	ts := append(h.apps[appID], t)
+	if len(ts) > maxOpHistory {
+		ts = ts[len(ts)-maxOpHistory:]
+	}
+	h.apps[appID] = ts
+}
+
+func (h *opHistory) get(appID string) []*types.OpTransition {
+	h.Lock()
+	defer h.Unlock()
+
+	return append([]*types.OpTransition{}, h.apps[appID]...)
+}
+
+func (h *opHistory) remove(appID string) {
+	h.Lock()
+	delete(h.apps, appID)
+	h.Unlock()
+}
+
+// TransitionCounter counts the op status transitions by from -> to, the statuses
+// not defined are counted as "unknown" to keep the cardinality bounded.
+type TransitionCounter struct {
+	Succeeded map[string]map[string]uint64 `json:"succeeded"`
+	Rejected  map[string]map[string]uint64 `json:"rejected"` // the operations not allowed in the current status
+}
+
+// transitCounter holds the op status transitions statistics since the leader started
+type transitCounter struct {
+	sync.Mutex
+	c *TransitionCounter
+}
+
+func newTransitCounter() *transitCounter {
+	return &transitCounter{
+		c: &TransitionCounter{
+			Succeeded: make(map[string]map[string]uint64),
+			Rejected:  make(map[string]map[string]uint64),
+		},
+	}
+}
+
+func (tc *transitCounter) succeed(from, to string) {
+	tc.Lock()
+	incrTransition(tc.c.Succeeded, from, to)
+	tc.Unlock()
+}
+
+func (tc *transitCounter) reject(from, to string) {
+	tc.Lock()
+	incrTransition(tc.c.Rejected, from, to)
+	tc.Unlock()
+}
+
+func (tc *transitCounter) snapshot() *TransitionCounter {
+	tc.Lock()
+	defer tc.Unlock()
+
+	return &TransitionCounter{
+		Succeeded: copyTransitions(tc.c.Succeeded),
+		Rejected:  copyTransitions(tc.c.Rejected),
+	}
+}
+
+func incrTransition(m map[string]map[string]uint64, from, to string) {
+	if !types.IsKnownOpStatus(from) {
+		from = "unknown"
+	}
+	if !types.IsKnownOpStatus(to) {
+		to = "unknown"
+	}
+
+	tos, ok := m[from]
+	if !ok {
+		tos = make(map[string]uint64)
+		m[from] = tos
+	}
+	tos[to]++
+}
+
+func copyTransitions(m map[string]map[string]uint64) map[string]map[string]uint64 {
+	ret := make(map[string]map[string]uint64, len(m))
+	for from, tos := range m {
+		cp := make(map[string]uint64, len(tos))
+		for to, n := range tos {
+			cp[to] = n
+		}
+		ret[from] = cp
+	}
+	return ret
+}
+
+// opNotAllowed rejects the operation which would transit the app from the op status
+func (r *Server) opNotAllowed(w http.ResponseWriter, from, to string) {
+	r.transitions.reject(from, to)
+	http.Error(w, fmt.Sprintf("app status is %s, operation not allowed.", from), http.StatusLocked)
+}
+
+// AppState is the current op status of the app together with how it got there
+type AppState struct {
+	AppID    string                `json:"app_id"`
+	Current  string                `json:"current"`
+	ErrMsg   string                `json:"errmsg,omitempty"`
+	Allowed  []string              `json:"allowed"`            // the op statuses could be transited to
+	History  []*types.OpTransition `json:"history"`            // the recent transitions, the oldest first
+	Progress *OpProgress           `json:"progress,omitempty"` // the latest scaling or updating
+	Since    time.Time             `json:"since"`              // the last updated time
+}
+
+func (r *Server) getAppState(w http.ResponseWriter, req *http.Request) {
+	appId := mux.Vars(req)["app_id"]
+
+	app, err := r.db.GetApp(appId)
+	if err != nil {
+		if r.db.IsErrNotFound(err) {
+			http.Error(w, fmt.Sprintf("app %s not exists", appId), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &AppState{
+		AppID:    app.ID,
+		Current:  app.OpStatus,
+		ErrMsg:   app.ErrMsg,
+		Allowed:  types.AllowedOpStatus(app.OpStatus),
+		History:  r.history.get(app.ID),
+		Progress: r.progress.get(app.ID, r.db),
+		Since:    app.UpdatedAt,
+	})
+}
+
+// TransitionCheck is the dry-run transition requested, either to the op status, or
+// the scaling by the instances.
+type TransitionCheck struct {
+	To        string   `json:"to"`
+	Instances *int     `json:"instances"`
+	IPs       []string `json:"ips"`
+}
+
+// TransitionCheckResult tells if the transition would be accepted and the target op status
+type TransitionCheckResult struct {
+	Current    string   `json:"current"`
+	Target     string   `json:"target"`
+	Valid      bool     `json:"valid"`
+	Violations []string `json:"violations"`
+}
+
+// dryRunTransition checks the transition without any side effect.
+func (r *Server) dryRunTransition(w http.ResponseWriter, req *http.Request) {
+	appId := mux.Vars(req)["app_id"]
+
+	var check TransitionCheck
+	if err := decode(req.Body, &check); err != nil {
+		http.Error(w, fmt.Sprintf("decode transition error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if check.Instances == nil && check.To == "" {
+		http.Error(w, "either to or instances required", http.StatusBadRequest)
+		return
+	}
+
+	app, err := r.db.GetApp(appId)
+	if err != nil {
+		if r.db.IsErrNotFound(err) {
+			http.Error(w, fmt.Sprintf("app %s not exists", appId), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ret := &TransitionCheckResult{
+		Current:    app.OpStatus,
+		Target:     check.To,
+		Violations: []string{},
+	}
+
+	if check.Instances != nil {
+		tasks, err := r.db.ListTasks(appId)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("list tasks got error for scale app. %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		ver, err := r.db.GetVersion(appId, app.Version[0])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("get version got error for scale app. %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		var target string
+		target, ret.Violations = checkScale(len(tasks), *check.Instances, ver, check.IPs)
+		if ret.Target != "" && ret.Target != target {
+			ret.Violations = append(ret.Violations, fmt.Sprintf("scaling to %d instances transits to %s rather than %s", *check.Instances, target, ret.Target))
+		}
+		ret.Target = target
+	}
+
+	if ret.Target != "" && !types.CanTransitOpStatus(app.OpStatus, ret.Target) {
+		ret.Violations = append(ret.Violations, fmt.Sprintf("app status is %s, transition to %s not allowed", app.OpStatus, ret.Target))
+	}
+
+	ret.Valid = len(ret.Violations) == 0
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// checkScale returns the target op status of scaling the current instances to the goal,
+// and the violations which the scaling would be rejected by.
+func checkScale(current, goal int, ver *types.Version, ips []string) (string, []string) {
+	violations := []string{}
+
+	switch {
+	case goal < 0:
+		return "", append(violations, "the goal count can't be negative")
+	case goal == current:
+		return types.OpStatusNoop, append(violations, "instances not changed")
+	case goal < current:
+		return types.OpStatusScalingDown, violations
+	}
+
+	if ver.Container != nil && ver.Container.Docker != nil {
+		if net := ver.Container.Docker.Network; net != "host" && net != "bridge" && len(ips) < goal-current {
+			violations = append(violations, "IP number cannot be less than the instance number")
+		}
+	}
+
+	return types.OpStatusScalingUp, violations
+}
@@ -0,0 +1,459 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/store"
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+var errFakeNotFound = errors.New("not found")
+
+// fakeStore only holds the apps with their tasks & versions
+type fakeStore struct {
+	store.Store
+	sync.Mutex
+	apps     map[string]*types.Application
+	tasks    map[string][]*types.Task
+	versions map[string][]*types.Version
+}
+
+func newFakeStore(apps ...*types.Application) *fakeStore {
+	s := &fakeStore{
+		apps:     make(map[string]*types.Application),
+		tasks:    make(map[string][]*types.Task),
+		versions: make(map[string][]*types.Version),
+	}
+	for _, app := range apps {
+		s.apps[app.ID] = app
+	}
+	return s
+}
+
+func (s *fakeStore) GetApp(id string) (*types.Application, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	app, ok := s.apps[id]
+	if !ok {
+		return nil, errFakeNotFound
+	}
+	cp := *app
+	return &cp, nil
+}
+
+func (s *fakeStore) UpdateApp(app *types.Application) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.apps[app.ID]; !ok {
+		return errFakeNotFound
+	}
+	cp := *app
+	s.apps[app.ID] = &cp
+	return nil
+}
+
+func (s *fakeStore) ListTasks(appId string) ([]*types.Task, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return append([]*types.Task{}, s.tasks[appId]...), nil
+}
+
+func (s *fakeStore) GetTask(appId, taskId string) (*types.Task, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, t := range s.tasks[appId] {
+		if t.ID == taskId {
+			cp := *t
+			return &cp, nil
+		}
+	}
+	return nil, errFakeNotFound
+}
+
+func (s *fakeStore) CreateTask(appId string, task *types.Task) error {
+	s.Lock()
+	defer s.Unlock()
+
+	cp := *task
+	s.tasks[appId] = append(s.tasks[appId], &cp)
+	return nil
+}
+
+func (s *fakeStore) UpdateTask(appId string, task *types.Task) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, t := range s.tasks[appId] {
+		if t.ID == task.ID {
+			cp := *task
+			s.tasks[appId][i] = &cp
+			return nil
+		}
+	}
+	return errFakeNotFound
+}
+
+func (s *fakeStore) DeleteTask(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for appId, tasks := range s.tasks {
+		for i, t := range tasks {
+			if t.ID == id {
+				s.tasks[appId] = append(tasks[:i:i], tasks[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return errFakeNotFound
+}
+
+func (s *fakeStore) CreateVersion(appId string, ver *types.Version) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.versions[appId] = append(s.versions[appId], ver)
+	return nil
+}
+
+func (s *fakeStore) GetVersion(appId, versionId string) (*types.Version, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, ver := range s.versions[appId] {
+		if ver.ID == versionId {
+			return ver, nil
+		}
+	}
+	return nil, errFakeNotFound
+}
+
+func (s *fakeStore) IsErrNotFound(err error) bool {
+	return err == errFakeNotFound
+}
+
+// newTestServer builds the leader api server on the fake driver & store
+func newTestServer(driver Driver, db store.Store) *Server {
+	cfg := &Config{Advertise: "192.168.1.101:9999"}
+	s := NewServer(cfg, nil, driver, db)
+	s.UpdateLeader(cfg.Advertise)
+	return s
+}
+
+func TestAppState(t *testing.T) {
+	var (
+		db = newFakeStore(&types.Application{ID: "web", OpStatus: types.OpStatusNoop})
+		s  = newTestServer(&fakeDriver{}, db)
+	)
+
+	s.memoAppStatus(&Actor{Name: "anonymous"}, "web", types.OpStatusScalingUp, "")
+	s.memoAppStatus(&Actor{Name: "anonymous"}, "web", types.OpStatusNoop, "scale up app error: no offers")
+
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/apps/web/state", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("state code = %d: %s", w.Code, w.Body)
+	}
+
+	var got AppState
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Current != types.OpStatusNoop || !reflect.DeepEqual(got.Allowed, types.AllowedOpStatus(types.OpStatusNoop)) {
+		t.Errorf("state = %s allowed %v, want noop with its allowed", got.Current, got.Allowed)
+	}
+	if len(got.History) != 2 {
+		t.Fatalf("history = %+v, want 2 transitions", got.History)
+	}
+	if last := got.History[1]; last.From != types.OpStatusScalingUp || last.To != types.OpStatusNoop || last.Reason == "" || last.Time.IsZero() {
+		t.Errorf("last transition = %+v, want scaling_up -> noop with reason and time", last)
+	}
+
+	w = httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/apps/absent/state", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown app state code = %d, want 404", w.Code)
+	}
+}
+
+func TestDryRunTransition(t *testing.T) {
+	var (
+		app = &types.Application{ID: "web", OpStatus: types.OpStatusNoop, Version: []string{"v1"}}
+		db  = newFakeStore(app)
+		s   = newTestServer(&fakeDriver{}, db)
+	)
+	db.tasks["web"] = []*types.Task{{ID: "0.web"}, {ID: "1.web"}}
+	db.versions["web"] = []*types.Version{{ID: "v1", Container: &types.Container{Docker: &types.Docker{Network: "macvlan"}}}}
+
+	tests := []struct {
+		name       string
+		opStatus   string
+		body       string
+		wantCode   int
+		wantTarget string
+		wantValid  bool
+	}{
+		{
+			name:       "scale up with ips",
+			body:       `{"instances": 3, "ips": ["192.168.1.201"]}`,
+			wantCode:   http.StatusOK,
+			wantTarget: types.OpStatusScalingUp,
+			wantValid:  true,
+		},
+		{
+			name:       "scale up without enough ips",
+			body:       `{"instances": 4, "ips": ["192.168.1.201"]}`,
+			wantCode:   http.StatusOK,
+			wantTarget: types.OpStatusScalingUp,
+		},
+		{
+			name:       "scale down",
+			body:       `{"instances": 1}`,
+			wantCode:   http.StatusOK,
+			wantTarget: types.OpStatusScalingDown,
+			wantValid:  true,
+		},
+		{
+			name:       "negative instances",
+			body:       `{"instances": -1}`,
+			wantCode:   http.StatusOK,
+			wantTarget: "",
+		},
+		{
+			name:       "instances not changed",
+			body:       `{"instances": 2}`,
+			wantCode:   http.StatusOK,
+			wantTarget: types.OpStatusNoop,
+		},
+		{
+			name:       "scale while updating",
+			opStatus:   types.OpStatusUpdating,
+			body:       `{"instances": 1}`,
+			wantCode:   http.StatusOK,
+			wantTarget: types.OpStatusScalingDown,
+		},
+		{
+			name:       "to status",
+			body:       `{"to": "updating"}`,
+			wantCode:   http.StatusOK,
+			wantTarget: types.OpStatusUpdating,
+			wantValid:  true,
+		},
+		{
+			name:       "mismatched status of the scaling",
+			body:       `{"to": "scaling_up", "instances": 1}`,
+			wantCode:   http.StatusOK,
+			wantTarget: types.OpStatusScalingDown,
+		},
+		{
+			name:     "empty transition",
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opStatus := tt.opStatus
+			if opStatus == "" {
+				opStatus = types.OpStatusNoop
+			}
+			db.apps["web"].OpStatus = opStatus
+
+			w := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/apps/web/state/dryrun", strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("dryrun code = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+
+			// nothing changed by the dry-run
+			if got := db.apps["web"].OpStatus; got != opStatus {
+				t.Errorf("op status = %s after dry-run, want %s", got, opStatus)
+			}
+			if h := s.history.get("web"); len(h) != 0 {
+				t.Errorf("history = %+v after dry-run, want none", h)
+			}
+
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got TransitionCheckResult
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Current != opStatus || got.Target != tt.wantTarget || got.Valid != tt.wantValid {
+				t.Errorf("dryrun = %+v, want %s -> %s valid %v", got, opStatus, tt.wantTarget, tt.wantValid)
+			}
+			if got.Valid != (len(got.Violations) == 0) {
+				t.Errorf("violations = %v, inconsistent with valid %v", got.Violations, got.Valid)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/apps/absent/state/dryrun", strings.NewReader(`{"instances": 1}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown app dryrun code = %d, want 404", w.Code)
+	}
+}
+
+func TestOpStatusReentry(t *testing.T) {
+	var (
+		driver = &fakeDriver{}
+		db     = newFakeStore(&types.Application{ID: "web", OpStatus: types.OpStatusNoop})
+		s      = newTestServer(driver, db)
+		actor  = &Actor{Name: "anonymous"}
+	)
+
+	// the concurrent operations begin only once
+	var (
+		wg       sync.WaitGroup
+		errs     = make(chan error, 5)
+		reenters int
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.memoAppStatus(actor, "web", types.OpStatusScalingUp, "")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err == errOpStatusReentered {
+			reenters++
+		} else if err != nil {
+			t.Fatalf("memoAppStatus() error = %v", err)
+		}
+	}
+	if reenters != 4 {
+		t.Errorf("reentered = %d, want 4", reenters)
+	}
+
+	if got := memoErrCode(errOpStatusReentered); got != http.StatusLocked {
+		t.Errorf("reentered code = %d, want 423", got)
+	}
+
+	// memo noop twice is idempotent
+	if err := s.memoAppStatus(actor, "web", types.OpStatusNoop, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.memoAppStatus(actor, "web", types.OpStatusNoop, "scale up app error: no offers"); err != nil {
+		t.Errorf("memo noop again error = %v", err)
+	}
+	if app, _ := db.GetApp("web"); app.ErrMsg != "scale up app error: no offers" {
+		t.Errorf("errmsg = %q, want updated", app.ErrMsg)
+	}
+
+	// no duplicated side effects
+	if h := s.history.get("web"); len(h) != 2 {
+		t.Errorf("history = %+v, want 2 transitions", h)
+	}
+	if n := len(s.audit.list("web")); n != 2 {
+		t.Errorf("audit records = %d, want 2", n)
+	}
+	driver.Lock()
+	defer driver.Unlock()
+	if n := len(driver.published); n != 2 {
+		t.Errorf("published = %d events, want 2", n)
+	}
+}
+
+func TestOpStatusNotAllowed(t *testing.T) {
+	var (
+		db    = newFakeStore(&types.Application{ID: "web", OpStatus: types.OpStatusNoop})
+		s     = newTestServer(&fakeDriver{}, db)
+		actor = &Actor{Name: "anonymous"}
+	)
+
+	// both of the scaling and the updating saw noop, the later one is rejected
+	if err := s.memoAppStatus(actor, "web", types.OpStatusScalingUp, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		to   string
+		want error
+	}{
+		{name: "updating while scaling up", to: types.OpStatusUpdating, want: errOpStatusNotAllowed},
+		{name: "rollback while scaling up", to: types.OpStatusRollback, want: errOpStatusNotAllowed},
+		{name: "scaling up again", to: types.OpStatusScalingUp, want: errOpStatusReentered},
+		{name: "finished", to: types.OpStatusNoop},
+		{name: "updating once finished", to: types.OpStatusUpdating},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.memoAppStatus(actor, "web", tt.to, ""); err != tt.want {
+				t.Errorf("memoAppStatus(%s) error = %v, want %v", tt.to, err, tt.want)
+			}
+		})
+	}
+
+	if app, _ := db.GetApp("web"); app.OpStatus != types.OpStatusUpdating {
+		t.Errorf("op status = %s, want updating", app.OpStatus)
+	}
+	if got := memoErrCode(errOpStatusNotAllowed); got != http.StatusLocked {
+		t.Errorf("not allowed code = %d, want 423", got)
+	}
+	if n := s.transitions.snapshot().Rejected[types.OpStatusScalingUp][types.OpStatusUpdating]; n != 1 {
+		t.Errorf("rejected scaling_up -> updating = %d, want 1", n)
+	}
+}
+
+func TestTransitionCounters(t *testing.T) {
+	var (
+		db    = newFakeStore(&types.Application{ID: "web", OpStatus: types.OpStatusNoop})
+		s     = newTestServer(&fakeDriver{}, db)
+		actor = &Actor{Name: "anonymous"}
+	)
+
+	s.memoAppStatus(actor, "web", types.OpStatusScalingUp, "")
+	s.memoAppStatus(actor, "web", types.OpStatusScalingUp, "") // reentered
+
+	// the operations not allowed while scaling up
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		s.server.Handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/apps/web/pause", nil))
+		if w.Code != http.StatusLocked {
+			t.Fatalf("pause code = %d, want 423", w.Code)
+		}
+	}
+
+	s.memoAppStatus(actor, "web", types.OpStatusNoop, "")
+	s.memoAppStatus(actor, "web", "bogus", "") // not allowed, counted as unknown
+
+	got := s.transitions.snapshot()
+
+	wantSucceeded := map[string]map[string]uint64{
+		types.OpStatusNoop:      {types.OpStatusScalingUp: 1},
+		types.OpStatusScalingUp: {types.OpStatusNoop: 1},
+	}
+	if !reflect.DeepEqual(got.Succeeded, wantSucceeded) {
+		t.Errorf("succeeded = %v, want %v", got.Succeeded, wantSucceeded)
+	}
+
+	wantRejected := map[string]map[string]uint64{
+		types.OpStatusNoop:      {"unknown": 1},
+		types.OpStatusScalingUp: {types.OpStatusScalingUp: 1, types.OpStatusPaused: 3},
+	}
+	if !reflect.DeepEqual(got.Rejected, wantRejected) {
+		t.Errorf("rejected = %v, want %v", got.Rejected, wantRejected)
+	}
+}
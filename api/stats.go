@@ -5,5 +5,9 @@ import (
 )
 
 func (r *Server) stats(w http.ResponseWriter, req *http.Request) {
-
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"routes":      r.metrics.snapshot(),
+		"events":      r.driver.EventStats(),
+		"transitions": r.transitions.snapshot(),
+	})
 }
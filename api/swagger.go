@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Dataman-Cloud/swan/version"
+)
+
+var pathParamRegexp = regexp.MustCompile(`{([^}]+)}`)
+
+// swaggerSpec is the swagger 2.0 spec of the api, only the parts we describe
+type swaggerSpec struct {
+	Swagger  string                                  `json:"swagger"`
+	Info     swaggerInfo                             `json:"info"`
+	BasePath string                                  `json:"basePath"`
+	Produces []string                                `json:"produces"`
+	Paths    map[string]map[string]*swaggerOperation `json:"paths"` // path -> method -> operation
+}
+
+type swaggerInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type swaggerOperation struct {
+	Summary    string                      `json:"summary,omitempty"`
+	Parameters []*swaggerParameter         `json:"parameters,omitempty"`
+	Responses  map[string]*swaggerResponse `json:"responses"`
+}
+
+type swaggerParameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"` // path, query
+	Type        string `json:"type"`
+	Required    bool   `json:"required,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type swaggerResponse struct {
+	Description string `json:"description"`
+}
+
+func (r *Server) swagger(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, buildSwaggerSpec(r.routes))
+}
+
+// buildSwaggerSpec describes the registered routes with their annotations
+func buildSwaggerSpec(routes []*Route) *swaggerSpec {
+	spec := &swaggerSpec{
+		Swagger:  "2.0",
+		Info:     swaggerInfo{Title: "Swan API", Version: version.GetVersion().Version},
+		BasePath: "/",
+		Produces: []string{"application/json"},
+		Paths:    make(map[string]map[string]*swaggerOperation),
+	}
+
+	for _, route := range routes {
+		path := route.Path()
+
+		ops, ok := spec.Paths[path]
+		if !ok {
+			ops = make(map[string]*swaggerOperation)
+			spec.Paths[path] = ops
+		}
+
+		for _, method := range route.Methods() {
+			ops[strings.ToLower(method)] = newSwaggerOperation(route)
+		}
+	}
+
+	return spec
+}
+
+func newSwaggerOperation(route *Route) *swaggerOperation {
+	op := &swaggerOperation{
+		Summary: route.summary,
+		Responses: map[string]*swaggerResponse{
+			"200": {Description: "OK"},
+		},
+	}
+
+	for _, m := range pathParamRegexp.FindAllStringSubmatch(route.Path(), -1) {
+		op.Parameters = append(op.Parameters, &swaggerParameter{Name: m[1], In: "path", Type: "string", Required: true})
+	}
+
+	for _, p := range route.params {
+		op.Parameters = append(op.Parameters, &swaggerParameter{Name: p.Name, In: "query", Type: p.Type, Description: p.Description})
+	}
+
+	codes := make([]int, 0, len(route.returns))
+	for code := range route.returns {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	for _, code := range codes {
+		op.Responses[strconv.Itoa(code)] = &swaggerResponse{Description: route.returns[code]}
+	}
+
+	return op
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSwaggerSpec(t *testing.T) {
+	cfg := &Config{Advertise: "192.168.1.101:9999"}
+	s := NewServer(cfg, nil, &fakeDriver{}, nil)
+	s.UpdateLeader(cfg.Advertise)
+
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("GET", "/swagger.json", nil))
+	if w.Code != 200 {
+		t.Fatalf("swagger.json code = %d: %s", w.Code, w.Body)
+	}
+
+	var spec swaggerSpec
+	if err := json.NewDecoder(w.Body).Decode(&spec); err != nil {
+		t.Fatalf("decode spec error = %v", err)
+	}
+
+	events := spec.Paths["/v1/events"]["get"]
+	if events == nil {
+		t.Fatalf("events route not described: %+v", spec.Paths["/v1/events"])
+	}
+
+	params := make(map[string]*swaggerParameter)
+	for _, p := range events.Parameters {
+		params[p.Name] = p
+	}
+	for name, typ := range map[string]string{"appId": "string", "catchUp": "boolean"} {
+		if p := params[name]; p == nil || p.In != "query" || p.Type != typ {
+			t.Errorf("events parameter %s = %+v, want %s query", name, p, typ)
+		}
+	}
+	if events.Responses["400"] == nil {
+		t.Errorf("events responses = %+v, want 400 described", events.Responses)
+	}
+
+	// path parameters are derived from the route path
+	app := spec.Paths["/v1/apps/{app_id}"]["get"]
+	if app == nil || len(app.Parameters) == 0 || app.Parameters[0].Name != "app_id" || !app.Parameters[0].Required {
+		t.Errorf("app route = %+v, want the required app_id path parameter", app)
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/mesos"
+	"github.com/Dataman-Cloud/swan/utils/labels"
+)
+
+// tenantClaims is the event subscription scope carried by the tenant token,
+// which is a HS256 jwt signed by the tenant token secret.
+type tenantClaims struct {
+	Tenant string   `json:"tenant"`
+	Apps   []string `json:"apps"`   // the visible app ids
+	Labels string   `json:"labels"` // the visible apps by label selector, eg: tenant=a
+	Exp    int64    `json:"exp"`    // optional
+}
+
+// requestToken returns the bearer token or api key carried by the request
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("X-Api-Key")
+}
+
+// tenantScope returns the event scope by the tenant token carried by the request,
+// nil if the request doesn't carry a valid tenant token.
+func (s *Server) tenantScope(r *http.Request) *mesos.EventScope {
+	if s.cfg.TenantTokenSecret == "" {
+		return nil
+	}
+
+	token := requestToken(r)
+	if token == "" {
+		return nil
+	}
+
+	scope, err := parseTenantToken(token, []byte(s.cfg.TenantTokenSecret), time.Now())
+	if err != nil {
+		return nil
+	}
+
+	return scope
+}
+
+func parseTenantToken(token string, secret []byte, now time.Time) (*mesos.EventScope, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	hdr, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("decode header error: %v", err)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(hdr, &header); err != nil {
+		return nil, fmt.Errorf("unmarshal header error: %v", err)
+	}
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("unsupported alg %s", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode signature error: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("signature mismatched")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("decode claims error: %v", err)
+	}
+
+	var claims tenantClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("unmarshal claims error: %v", err)
+	}
+
+	if claims.Exp > 0 && now.Unix() >= claims.Exp {
+		return nil, errors.New("token expired")
+	}
+
+	scope := &mesos.EventScope{
+		Tenant: claims.Tenant,
+		Apps:   claims.Apps,
+	}
+
+	if claims.Labels != "" {
+		sel, err := labels.Parse(claims.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("parse labels %s error: %v", claims.Labels, err)
+		}
+		scope.Labels = sel
+	}
+
+	return scope, nil
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/mesos"
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+func signTenantToken(alg, claims, secret string) string {
+	var (
+		enc     = base64.RawURLEncoding
+		signing = enc.EncodeToString([]byte(`{"alg":"`+alg+`","typ":"JWT"}`)) + "." + enc.EncodeToString([]byte(claims))
+		mac     = hmac.New(sha256.New, []byte(secret))
+	)
+	mac.Write([]byte(signing))
+	return signing + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseTenantToken(t *testing.T) {
+	var (
+		secret = "s3cret"
+		now    = time.Unix(1500000000, 0)
+	)
+
+	tests := []struct {
+		name     string
+		token    string
+		wantApps []string
+		wantErr  bool
+	}{
+		{
+			name:     "valid",
+			token:    signTenantToken("HS256", `{"tenant":"a","apps":["web.a.bbk.dataman"]}`, secret),
+			wantApps: []string{"web.a.bbk.dataman"},
+		},
+		{
+			name:     "not expired",
+			token:    signTenantToken("HS256", `{"tenant":"a","apps":["web.a.bbk.dataman"],"exp":1500000001}`, secret),
+			wantApps: []string{"web.a.bbk.dataman"},
+		},
+		{
+			name:    "expired",
+			token:   signTenantToken("HS256", `{"tenant":"a","exp":1500000000}`, secret),
+			wantErr: true,
+		},
+		{
+			name:    "signed by other secret",
+			token:   signTenantToken("HS256", `{"tenant":"a"}`, "other"),
+			wantErr: true,
+		},
+		{
+			name:    "unsupported alg",
+			token:   signTenantToken("none", `{"tenant":"a"}`, secret),
+			wantErr: true,
+		},
+		{
+			name:    "invalid labels",
+			token:   signTenantToken("HS256", `{"tenant":"a","labels":"tenant in a"}`, secret),
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			token:   "api-token",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, err := parseTenantToken(tt.token, []byte(secret), now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTenantToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(scope.Apps, tt.wantApps) {
+				t.Errorf("scope apps = %v, want %v", scope.Apps, tt.wantApps)
+			}
+		})
+	}
+}
+
+func TestTenantEventScope(t *testing.T) {
+	var (
+		s = &Server{cfg: &Config{
+			AuthTokens:        []string{"admin-token"},
+			TenantTokenSecret: "s3cret",
+		}}
+		tenantA = signTenantToken("HS256", `{"tenant":"a","apps":["web.a.bbk.dataman"],"labels":"tenant=a"}`, "s3cret")
+	)
+
+	// the tenant token is only allowed to subscribe the events
+	for path, want := range map[string]bool{"/v1/events": true, "/v1/apps": false} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+tenantA)
+		if got := s.authenticate(req); got != want {
+			t.Errorf("tenant token authenticate %s = %v, want %v", path, got, want)
+		}
+	}
+
+	// the admin token sees everything
+	req := httptest.NewRequest("GET", "/v1/events", nil)
+	req.Header.Set("X-Api-Key", "admin-token")
+	if scope := s.tenantScope(req); scope != nil {
+		t.Errorf("admin token scope = %+v, want nil", scope)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/events", nil)
+	req.Header.Set("Authorization", "Bearer "+tenantA)
+	scope := s.tenantScope(req)
+	if scope == nil || scope.Tenant != "a" {
+		t.Fatalf("tenant scope = %+v, want tenant a", scope)
+	}
+
+	event := func(appID string, labels map[string]string) *types.Event {
+		ev := types.NewEvent(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: appID})
+		ev.Labels = labels
+		return ev
+	}
+
+	tests := []struct {
+		name   string
+		appIDs []string // requested by the tenant
+		ev     *types.Event
+		want   bool
+	}{
+		{
+			name: "listed app",
+			ev:   event("web.a.bbk.dataman", nil),
+			want: true,
+		},
+		{
+			name: "app labeled of the tenant",
+			ev:   event("db.a.bbk.dataman", map[string]string{"tenant": "a"}),
+			want: true,
+		},
+		{
+			name: "app of another tenant",
+			ev:   event("web.b.bbk.dataman", map[string]string{"tenant": "b"}),
+		},
+		{
+			name:   "app of another tenant explicitly requested",
+			appIDs: []string{"web.a.bbk.dataman", "web.b.bbk.dataman"},
+			ev:     event("web.b.bbk.dataman", map[string]string{"tenant": "b"}),
+		},
+		{
+			name:   "authorized app within the requested",
+			appIDs: []string{"web.a.bbk.dataman", "web.b.bbk.dataman"},
+			ev:     event("web.a.bbk.dataman", nil),
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := mesos.EventOptions{AppIDs: tt.appIDs, Scope: scope}
+			if got := opts.Match(tt.ev); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/mesos"
+	"github.com/Dataman-Cloud/swan/types"
+	"github.com/Dataman-Cloud/swan/utils"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// the interval of polling the new task's health during the rolling update
+var healthPollInterval = time.Second
+
+// updatedTask is the old task replaced by the new one in the rolling update
+type updatedTask struct {
+	idx int
+	old *types.Task
+	new *types.Task
+}
+
+// waitTaskHealthy waits for the task to be healthy until the deadline. the task without
+// health check is taken as healthy once running.
+func (r *Server) waitTaskHealthy(appId, taskId string, deadline time.Duration) bool {
+	timeout := time.After(deadline)
+
+	for {
+		task, err := r.db.GetTask(appId, taskId)
+		if err == nil {
+			switch task.Healthy {
+			case types.TaskHealthy:
+				return true
+			case types.TaskHealthyUnset:
+				if task.Status == "TASK_RUNNING" {
+					return true
+				}
+			}
+		}
+
+		select {
+		case <-timeout:
+			return false
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// autoRollback restores the updated tasks to their previous versions, the latest updated first.
+func (r *Server) autoRollback(actor *Actor, appId, verId string, updated []*updatedTask, failures int) error {
+	reason := fmt.Sprintf("%d new tasks of version %s unhealthy, rolling back", failures, verId)
+	log.Printf("update app %s: %s", appId, reason)
+
+	if err := r.memoAppStatus(actor, appId, types.OpStatusRollback, reason); err != nil {
+		return err
+	}
+
+	r.driver.PublishEvent(&types.AutoRollbackEvent{
+		AppID:    appId,
+		Phase:    types.AutoRollbackStarted,
+		Version:  verId,
+		Failures: failures,
+		Tasks:    len(updated),
+	})
+
+	var err error
+	for i := len(updated) - 1; i >= 0; i-- {
+		u := updated[i]
+		if err = r.restoreTask(appId, u); err != nil {
+			break
+		}
+	}
+
+	ev := &types.AutoRollbackEvent{
+		AppID:    appId,
+		Phase:    types.AutoRollbackCompleted,
+		Version:  verId,
+		Failures: failures,
+		Tasks:    len(updated),
+	}
+	if err != nil {
+		ev.ErrMsg = err.Error()
+	}
+	r.driver.PublishEvent(ev)
+
+	if err != nil {
+		return fmt.Errorf("rollback got error: %v", err)
+	}
+
+	return fmt.Errorf("rolled back, %d new tasks unhealthy", failures)
+}
+
+// restoreTask replaces the new task by the one of the old task's version
+func (r *Server) restoreTask(appId string, u *updatedTask) error {
+	ver, err := r.db.GetVersion(appId, u.old.Version)
+	if err != nil {
+		return fmt.Errorf("get previous version %s error: %v", u.old.Version, err)
+	}
+
+	if err := r.delTask(appId, u.new); err != nil {
+		return fmt.Errorf("remove new task error: %v", err)
+	}
+
+	var (
+		restart = ver.RestartPolicy
+		retries = 3
+	)
+
+	if restart != nil && restart.Retries >= 0 {
+		retries = restart.Retries
+	}
+
+	task := &types.Task{
+		ID:         fmt.Sprintf("%s.%s", utils.RandomString(12), u.old.Name),
+		Name:       u.old.Name,
+		Weight:     100,
+		Status:     "pending",
+		Healthy:    types.TaskHealthyUnset,
+		Version:    ver.ID,
+		MaxRetries: retries,
+		Created:    u.old.Created,
+		Updated:    time.Now(),
+	}
+	if ver.IsHealthSet() {
+		task.Healthy = types.TaskUnHealthy
+	}
+
+	if err := r.db.CreateTask(appId, task); err != nil {
+		return fmt.Errorf("create db task error: %v", err)
+	}
+
+	cfg := types.NewTaskConfig(ver, u.idx)
+	if err := r.driver.LaunchTasks([]*mesos.Task{mesos.NewTask(cfg, task.ID, task.Name)}); err != nil {
+		task.Status = "Failed"
+		task.ErrMsg = fmt.Sprintf("launch task failed: %v", err)
+		if err := r.db.UpdateTask(appId, task); err != nil {
+			log.Errorf("update task %s got error: %v", task.ID, err)
+		}
+
+		return fmt.Errorf("launch runtime task %s error: %v", task.ID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+func TestUpdateRollbackOnUnhealthy(t *testing.T) {
+	defer func(d time.Duration) { healthPollInterval = d }(healthPollInterval)
+	healthPollInterval = time.Millisecond * 5
+
+	var (
+		driver = &fakeDriver{}
+		db     = newFakeStore(&types.Application{ID: "web", OpStatus: types.OpStatusNoop, Version: []string{"v1"}})
+		s      = newTestServer(driver, db)
+		docker = &types.Docker{Image: "nginx", Network: "bridge"}
+	)
+	db.versions["web"] = []*types.Version{{ID: "v1", Name: "web", Container: &types.Container{Type: "docker", Docker: docker}}}
+	db.tasks["web"] = []*types.Task{
+		{ID: "t0", Name: "0.web.default.bbk.dataman", Version: "v1", Status: "TASK_RUNNING", Healthy: types.TaskHealthyUnset},
+		{ID: "t1", Name: "1.web.default.bbk.dataman", Version: "v1", Status: "TASK_RUNNING", Healthy: types.TaskHealthyUnset},
+	}
+
+	// the new tasks never get running
+	body := `{
+		"name": "web", "runAs": "bbk", "instances": 2, "cpus": 0.01, "mem": 32,
+		"container": {"type": "docker", "docker": {"image": "nginx:unknown", "network": "bridge"}},
+		"update": {"delay": 0, "onFailure": "rollback", "healthDeadline": 0.05, "maxFailures": 1}
+	}`
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, httptest.NewRequest("PUT", "/v1/apps/web", strings.NewReader(body)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("update code = %d: %s", w.Code, w.Body)
+	}
+
+	var app *types.Application
+	for i := 0; i < 200; i++ {
+		if app, _ = db.GetApp("web"); app.OpStatus == types.OpStatusNoop {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if app.OpStatus != types.OpStatusNoop || !strings.Contains(app.ErrMsg, "rolled back") {
+		t.Fatalf("app = %s %q, want noop rolled back", app.OpStatus, app.ErrMsg)
+	}
+
+	// the updated task is restored, the other is untouched
+	tasks, _ := db.ListTasks("web")
+	if len(tasks) != 2 {
+		t.Fatalf("tasks = %+v, want 2", tasks)
+	}
+	for _, task := range tasks {
+		if task.Version != "v1" {
+			t.Errorf("task %s of version %s, want v1", task.Name, task.Version)
+		}
+	}
+	if tasks[0].ID != "t1" || tasks[1].Name != "0.web.default.bbk.dataman" {
+		t.Errorf("tasks = %s %s, want t1 and the restored 0th", tasks[0].ID, tasks[1].Name)
+	}
+
+	var (
+		transitions []string
+		rollbacks   []*types.AutoRollbackEvent
+	)
+	driver.Lock()
+	for _, p := range driver.published {
+		switch ev := p.(type) {
+		case *types.StateTransitionEvent:
+			transitions = append(transitions, ev.To)
+		case *types.AutoRollbackEvent:
+			rollbacks = append(rollbacks, ev)
+		}
+	}
+	driver.Unlock()
+
+	if want := []string{types.OpStatusUpdating, types.OpStatusRollback, types.OpStatusNoop}; strings.Join(transitions, ",") != strings.Join(want, ",") {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+	if len(rollbacks) != 2 || rollbacks[0].Phase != types.AutoRollbackStarted || rollbacks[1].Phase != types.AutoRollbackCompleted {
+		t.Fatalf("rollback events = %+v, want started & completed", rollbacks)
+	}
+	if ev := rollbacks[1]; ev.Failures != 1 || ev.Tasks != 1 || ev.ErrMsg != "" {
+		t.Errorf("rollback completed = %+v, want 1 failure 1 task without error", ev)
+	}
+}
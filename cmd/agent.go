@@ -31,6 +31,24 @@ func AgentCmd() cli.Command {
 		FlagGatewayTLSListenAddr(),
 		FlagGatewayTLSCertFile(),
 		FlagGatewayTLSKeyFile(),
+		FlagGatewayTLSCertDir(),
+		FlagGatewayConsulEnabled(),
+		FlagGatewayConsulAddr(),
+		FlagGatewayNamingPolicy(),
+		FlagGatewayBalance(),
+		FlagGatewaySlowStart(),
+		FlagGatewayEtcdAddrs(),
+		FlagGatewayEtcdPrefix(),
+		FlagGatewayProxyProtocol(),
+		FlagGatewayPoolMaxIdle(),
+		FlagGatewayPoolMaxConns(),
+		FlagGatewayPoolIdleTimeout(),
+		FlagGatewaySessionsFile(),
+		FlagGatewaySessionsInterval(),
+		FlagGatewayDrainTimeout(),
+		FlagGatewayUDPMaxSessions(),
+		FlagGatewayAccessLog(),
+		FlagGatewayAccessLogSinks(),
 		FlagDNSEnabled(),
 		FlagDNSListenAddr(),
 		FlagDNSTTL(),
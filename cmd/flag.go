@@ -56,6 +56,94 @@ func FlagEnableCORS() cli.Flag {
 	}
 }
 
+func FlagCORSAllowedOrigins() cli.Flag {
+	return cli.StringFlag{
+		Name:   "cors-allowed-origins",
+		Usage:  "cross-origin allowed origins splited by ',', '*' to allow any origin, empty to deny all",
+		EnvVar: "SWAN_CORS_ALLOWED_ORIGINS",
+		Value:  "",
+	}
+}
+
+func FlagCORSAllowedMethods() cli.Flag {
+	return cli.StringFlag{
+		Name:   "cors-allowed-methods",
+		Usage:  "cross-origin allowed methods splited by ','",
+		EnvVar: "SWAN_CORS_ALLOWED_METHODS",
+		Value:  "HEAD,GET,POST,DELETE,PUT,OPTIONS",
+	}
+}
+
+func FlagCORSAllowedHeaders() cli.Flag {
+	return cli.StringFlag{
+		Name:   "cors-allowed-headers",
+		Usage:  "cross-origin allowed headers splited by ','",
+		EnvVar: "SWAN_CORS_ALLOWED_HEADERS",
+		Value:  "Origin,X-Requested-With,Content-Type,Accept,Authorization,X-Api-Key",
+	}
+}
+
+func FlagCORSAllowCredentials() cli.Flag {
+	return cli.BoolFlag{
+		Name:   "cors-allow-credentials",
+		Usage:  "allow cross-origin requests with credentials",
+		EnvVar: "SWAN_CORS_ALLOW_CREDENTIALS",
+	}
+}
+
+func FlagEnableGzip() cli.Flag {
+	return cli.BoolFlag{
+		Name:   "enable-gzip",
+		Usage:  "enable gzip compression for api json responses",
+		EnvVar: "SWAN_ENABLE_GZIP",
+	}
+}
+
+func FlagGzipMinSize() cli.Flag {
+	return cli.IntFlag{
+		Name:   "gzip-min-size",
+		Usage:  "the api json responses smaller than this size in bytes are not compressed",
+		EnvVar: "SWAN_GZIP_MIN_SIZE",
+		Value:  1024,
+	}
+}
+
+func FlagAuthTokens() cli.Flag {
+	return cli.StringFlag{
+		Name:   "auth-tokens",
+		Usage:  "api bearer tokens splited by ',', empty to disable api authentication",
+		EnvVar: "SWAN_AUTH_TOKENS",
+		Value:  "",
+	}
+}
+
+func FlagAuthExemptPaths() cli.Flag {
+	return cli.StringFlag{
+		Name:   "auth-exempt-paths",
+		Usage:  "api paths splited by ',' which are not required authentication",
+		EnvVar: "SWAN_AUTH_EXEMPT_PATHS",
+		Value:  "/ping,/version,/v1/leader,/v1/fullsync,/v1/agents/query_id",
+	}
+}
+
+func FlagTenantTokenSecret() cli.Flag {
+	return cli.StringFlag{
+		Name:   "tenant-token-secret",
+		Usage:  "secret signing the tenant scoped event subscription tokens (HS256 jwt), empty to disable",
+		EnvVar: "SWAN_TENANT_TOKEN_SECRET",
+		Value:  "",
+	}
+}
+
+func FlagAuditLogFile() cli.Flag {
+	return cli.StringFlag{
+		Name:   "audit-log-file",
+		Usage:  "file the app op status transitions with the actors are appended to as json lines, empty to disable",
+		EnvVar: "SWAN_AUDIT_LOG_FILE",
+		Value:  "",
+	}
+}
+
 func FlagReconciliationInterval() cli.Flag {
 	return cli.Float64Flag{
 		Name:   "reconciliation-interval",
@@ -119,6 +207,50 @@ func FlagEnableCheckPoint() cli.Flag {
 	}
 }
 
+func FlagOfferBackoffInitial() cli.Flag {
+	return cli.Float64Flag{
+		Name:   "offer-backoff-initial",
+		Usage:  "The initial interval, in seconds, of retrying to obtain the proper offers, doubled on each retry.",
+		EnvVar: "SWAN_OFFER_BACKOFF_INITIAL",
+		Value:  0.5,
+	}
+}
+
+func FlagOfferBackoffMax() cli.Flag {
+	return cli.Float64Flag{
+		Name:   "offer-backoff-max",
+		Usage:  "The max interval, in seconds, of retrying to obtain the proper offers.",
+		EnvVar: "SWAN_OFFER_BACKOFF_MAX",
+		Value:  30,
+	}
+}
+
+func FlagOfferWaitTimeout() cli.Flag {
+	return cli.Float64Flag{
+		Name:   "offer-wait-timeout",
+		Usage:  "The time, in seconds, waiting for the proper offers before the tasks failed.",
+		EnvVar: "SWAN_OFFER_WAIT_TIMEOUT",
+		Value:  86400,
+	}
+}
+
+func FlagConstraintMissingAttr() cli.Flag {
+	return cli.StringFlag{
+		Name:   "constraint-missing-attribute",
+		Usage:  "policy on the constraints referencing the attribute the agent doesn't have, reject: the agent rejected, permit: satisfied as don't care",
+		EnvVar: "SWAN_CONSTRAINT_MISSING_ATTRIBUTE",
+		Value:  "reject",
+	}
+}
+
+func FlagLogConstraintRejects() cli.Flag {
+	return cli.BoolFlag{
+		Name:   "log-constraint-rejects",
+		Usage:  "log each offer rejected by the constraints with the app, host and failed constraint, for debugging the placement",
+		EnvVar: "SWAN_LOG_CONSTRAINT_REJECTS",
+	}
+}
+
 func FlagJoinAddrs() cli.Flag {
 	return cli.StringFlag{
 		Name:   "join-addrs",
@@ -184,6 +316,166 @@ func FlagGatewayTLSKeyFile() cli.Flag {
 	}
 }
 
+func FlagGatewayTLSCertDir() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-tls-cert-dir",
+		Usage:  "gateway tls certificates directory, holds {server name}.crt & {server name}.key selected by SNI",
+		Value:  "",
+		EnvVar: "SWAN_GATEWAY_TLS_CERT_DIR",
+	}
+}
+
+func FlagGatewayConsulEnabled() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-consul-enabled",
+		Usage:  "register gateway upstream backends to consul or not",
+		EnvVar: "SWAN_GATEWAY_CONSUL_ENABLED",
+		Value:  "false",
+	}
+}
+
+func FlagGatewayConsulAddr() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-consul-addr",
+		Usage:  "consul agent http address",
+		Value:  "127.0.0.1:8500",
+		EnvVar: "SWAN_GATEWAY_CONSUL_ADDR",
+	}
+}
+
+func FlagGatewayNamingPolicy() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-naming-policy",
+		Usage:  "upstream backend id naming validation policy, strict: suffixed by '.{app id}', relaxed: non-empty only",
+		Value:  "strict",
+		EnvVar: "SWAN_GATEWAY_NAMING_POLICY",
+	}
+}
+
+func FlagGatewayBalance() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-balance",
+		Usage:  "default balancer of the upstreams without balance specified, wrr: weighted round-robin, swrr: smooth weighted round-robin, leasttime: least response time, leasttime_conn: least response time weighted by active connections, wlc: least active connections per weight",
+		Value:  "wrr",
+		EnvVar: "SWAN_GATEWAY_BALANCE",
+	}
+}
+
+func FlagGatewaySlowStart() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-slow-start",
+		Usage:  "weight ramp up window of newly added upstream backends, eg: 30s, 0 to disable",
+		Value:  "0s",
+		EnvVar: "SWAN_GATEWAY_SLOW_START",
+	}
+}
+
+func FlagGatewayEtcdAddrs() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-etcd-addrs",
+		Usage:  "etcd cluster address splited by ',' to watch the upstream backends changes, empty to disable",
+		Value:  "",
+		EnvVar: "SWAN_GATEWAY_ETCD_ADDRS",
+	}
+}
+
+func FlagGatewayEtcdPrefix() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-etcd-prefix",
+		Usage:  "etcd key prefix to watch the upstream backends changes",
+		Value:  "/swan/janitor/backends",
+		EnvVar: "SWAN_GATEWAY_ETCD_PREFIX",
+	}
+}
+
+func FlagGatewayProxyProtocol() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-proxy-protocol",
+		Usage:  "require the PROXY protocol (v1 / v2) header on inbound connections or not, enable it behind a L4 load balancer",
+		EnvVar: "SWAN_GATEWAY_PROXY_PROTOCOL",
+		Value:  "false",
+	}
+}
+
+func FlagGatewayPoolMaxIdle() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-pool-max-idle",
+		Usage:  "max idle keep-alive connections pooled to each upstream backend, 0 to disable pooling",
+		Value:  "32",
+		EnvVar: "SWAN_GATEWAY_POOL_MAX_IDLE",
+	}
+}
+
+func FlagGatewayPoolMaxConns() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-pool-max-conns",
+		Usage:  "max concurrent pooled connections to each upstream backend, 0 for unlimited",
+		Value:  "0",
+		EnvVar: "SWAN_GATEWAY_POOL_MAX_CONNS",
+	}
+}
+
+func FlagGatewayPoolIdleTimeout() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-pool-idle-timeout",
+		Usage:  "idle pooled connections to upstream backends are closed after the timeout, eg: 90s",
+		Value:  "90s",
+		EnvVar: "SWAN_GATEWAY_POOL_IDLE_TIMEOUT",
+	}
+}
+
+func FlagGatewaySessionsFile() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-sessions-file",
+		Usage:  "file to persist the sticky sessions across restarts, empty to disable",
+		Value:  "",
+		EnvVar: "SWAN_GATEWAY_SESSIONS_FILE",
+	}
+}
+
+func FlagGatewaySessionsInterval() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-sessions-interval",
+		Usage:  "interval of saving the sticky sessions to the sessions file, eg: 30s",
+		Value:  "30s",
+		EnvVar: "SWAN_GATEWAY_SESSIONS_INTERVAL",
+	}
+}
+
+func FlagGatewayDrainTimeout() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-drain-timeout",
+		Usage:  "max time for the connections of the listeners replaced by reload to finish, eg: 30s",
+		Value:  "30s",
+		EnvVar: "SWAN_GATEWAY_DRAIN_TIMEOUT",
+	}
+}
+
+func FlagGatewayUDPMaxSessions() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-udp-max-sessions",
+		Usage:  "max sessions of each udp listener, the new clients beyond are dropped, 0 for unlimited",
+		Value:  "10000",
+		EnvVar: "SWAN_GATEWAY_UDP_MAX_SESSIONS",
+	}
+}
+
+func FlagGatewayAccessLog() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-access-log",
+		Usage:  "default access log sink of the apps: file path, udp://host:port or tcp://host:port, empty to disable",
+		EnvVar: "SWAN_GATEWAY_ACCESS_LOG",
+	}
+}
+
+func FlagGatewayAccessLogSinks() cli.Flag {
+	return cli.StringFlag{
+		Name:   "gateway-access-log-sinks",
+		Usage:  "access log sinks of the apps, eg: nginx.default.bbk.dataman=/var/log/nginx.log,web.default.bbk.dataman=udp://10.0.0.1:514",
+		EnvVar: "SWAN_GATEWAY_ACCESS_LOG_SINKS",
+	}
+}
+
 // Dns
 //
 func FlagDNSEnabled() cli.Flag {
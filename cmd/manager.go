@@ -27,6 +27,16 @@ func ManagerCmd() cli.Command {
 		FlagLogLevel(),
 		FlagStrategy(),
 		FlagEnableCORS(),
+		FlagCORSAllowedOrigins(),
+		FlagCORSAllowedMethods(),
+		FlagCORSAllowedHeaders(),
+		FlagCORSAllowCredentials(),
+		FlagEnableGzip(),
+		FlagGzipMinSize(),
+		FlagAuthTokens(),
+		FlagAuthExemptPaths(),
+		FlagTenantTokenSecret(),
+		FlagAuditLogFile(),
 		FlagReconciliationInterval(),
 		FlagReconciliationStep(),
 		FlagReconciliationStepDelay(),
@@ -34,6 +44,11 @@ func ManagerCmd() cli.Command {
 		FlagMaxTasksPerOffer(),
 		FlagEnableCapabilityKilling(),
 		FlagEnableCheckPoint(),
+		FlagOfferBackoffInitial(),
+		FlagOfferBackoffMax(),
+		FlagOfferWaitTimeout(),
+		FlagConstraintMissingAttr(),
+		FlagLogConstraintRejects(),
 	}
 
 	return cmd
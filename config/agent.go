@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -43,8 +44,34 @@ type Janitor struct {
 	TLSListenAddr string `json:"tlsListenAddr"`
 	TLSCertFile   string `json:"tlsCertFile"`
 	TLSKeyFile    string `json:"tlsKeyFile"`
+	TLSCertDir    string `json:"tlsCertDir"` // certificates selected by SNI: {server name}.crt & .key
 	Domain        string `json:"domain"`
 	AdvertiseIP   string `json:"advertiseIP"`
+	ConsulEnabled bool   `json:"consulEnabled"`
+	ConsulAddr    string `json:"consulAddr"`
+	NamingPolicy  string `json:"namingPolicy"` // backend id naming validation: strict / relaxed
+	Balance       string `json:"balance"`      // default balancer of upstreams: wrr / swrr / leasttime / leasttime_conn / wlc
+
+	SlowStart time.Duration `json:"slowStart"` // weight ramp up window of newly added backends, 0 to disable
+
+	EtcdAddrs  []string `json:"etcdAddrs"`  // watch upstream backends changes on etcd, empty to disable
+	EtcdPrefix string   `json:"etcdPrefix"` // etcd key prefix to watch
+
+	ProxyProtocol bool `json:"proxyProtocol"` // require the PROXY protocol header on inbound connections
+
+	PoolMaxIdle     int           `json:"poolMaxIdle"`     // max idle keep-alive connections to each backend, 0 to disable pooling
+	PoolMaxConns    int           `json:"poolMaxConns"`    // max concurrent pooled connections to each backend, 0 for unlimited
+	PoolIdleTimeout time.Duration `json:"poolIdleTimeout"` // idle pooled connections are closed after the timeout
+
+	SessionsFile     string        `json:"sessionsFile"`     // persist the sticky sessions across restarts, empty to disable
+	SessionsInterval time.Duration `json:"sessionsInterval"` // interval of saving the sticky sessions
+
+	DrainTimeout time.Duration `json:"drainTimeout"` // max time for the connections of the listeners replaced by reload to finish
+
+	UDPMaxSessions int `json:"udpMaxSessions"` // max sessions of each udp listener, 0 for unlimited
+
+	AccessLog      string            `json:"accessLog"`      // default access log sink: file path, udp://host:port or tcp://host:port, empty to disable
+	AccessLogSinks map[string]string `json:"accessLogSinks"` // app id -> access log sink of the app
 }
 
 type IPAM struct {
@@ -69,9 +96,14 @@ func NewAgentConfig(c *cli.Context) (*AgentConfig, error) {
 			ExchangeTimeout: time.Second * 3,
 		},
 		Janitor: &Janitor{
-			Enabled:    true,
-			ListenAddr: "0.0.0.0:80",
-			Domain:     "swan.com",
+			Enabled:          true,
+			ListenAddr:       "0.0.0.0:80",
+			Domain:           "swan.com",
+			PoolMaxIdle:      32,
+			PoolIdleTimeout:  time.Second * 90,
+			SessionsInterval: time.Second * 30,
+			DrainTimeout:     time.Second * 30,
+			UDPMaxSessions:   10000,
 		},
 		IPAM: &IPAM{
 			Enabled:   true,
@@ -127,6 +159,113 @@ func NewAgentConfig(c *cli.Context) (*AgentConfig, error) {
 		cfg.Janitor.TLSKeyFile = c.String("gateway-tls-key-file")
 	}
 
+	if c.String("gateway-tls-cert-dir") != "" {
+		cfg.Janitor.TLSCertDir = c.String("gateway-tls-cert-dir")
+	}
+
+	if v := c.String("gateway-consul-enabled"); v != "" {
+		cfg.Janitor.ConsulEnabled, _ = strconv.ParseBool(v)
+	}
+
+	if c.String("gateway-consul-addr") != "" {
+		cfg.Janitor.ConsulAddr = c.String("gateway-consul-addr")
+	}
+
+	if c.String("gateway-naming-policy") != "" {
+		cfg.Janitor.NamingPolicy = c.String("gateway-naming-policy")
+	}
+
+	if c.String("gateway-balance") != "" {
+		cfg.Janitor.Balance = c.String("gateway-balance")
+	}
+
+	if v := c.String("gateway-slow-start"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gateway slow start window: %v", err)
+		}
+		cfg.Janitor.SlowStart = d
+	}
+
+	if addrs := c.String("gateway-etcd-addrs"); addrs != "" {
+		cfg.Janitor.EtcdAddrs = strings.Split(addrs, ",")
+	}
+
+	if c.String("gateway-etcd-prefix") != "" {
+		cfg.Janitor.EtcdPrefix = c.String("gateway-etcd-prefix")
+	}
+
+	if v := c.String("gateway-proxy-protocol"); v != "" {
+		cfg.Janitor.ProxyProtocol, _ = strconv.ParseBool(v)
+	}
+
+	if v := c.String("gateway-pool-max-idle"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid gateway pool max idle: %s", v)
+		}
+		cfg.Janitor.PoolMaxIdle = n
+	}
+
+	if v := c.String("gateway-pool-max-conns"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid gateway pool max conns: %s", v)
+		}
+		cfg.Janitor.PoolMaxConns = n
+	}
+
+	if v := c.String("gateway-pool-idle-timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gateway pool idle timeout: %v", err)
+		}
+		cfg.Janitor.PoolIdleTimeout = d
+	}
+
+	if c.String("gateway-sessions-file") != "" {
+		cfg.Janitor.SessionsFile = c.String("gateway-sessions-file")
+	}
+
+	if v := c.String("gateway-sessions-interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid gateway sessions interval: %s", v)
+		}
+		cfg.Janitor.SessionsInterval = d
+	}
+
+	if v := c.String("gateway-drain-timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid gateway drain timeout: %s", v)
+		}
+		cfg.Janitor.DrainTimeout = d
+	}
+
+	if v := c.String("gateway-udp-max-sessions"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid gateway udp max sessions: %s", v)
+		}
+		cfg.Janitor.UDPMaxSessions = n
+	}
+
+	if c.String("gateway-access-log") != "" {
+		cfg.Janitor.AccessLog = c.String("gateway-access-log")
+	}
+
+	if v := c.String("gateway-access-log-sinks"); v != "" {
+		cfg.Janitor.AccessLogSinks = make(map[string]string)
+		for _, pair := range strings.Split(v, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, fmt.Errorf("invalid gateway access log sink: %s, should be app=sink", pair)
+			}
+			cfg.Janitor.AccessLogSinks[kv[0]] = kv[1]
+		}
+	}
+
 	// dns
 	if v := c.String("dns-enabled"); v != "" {
 		cfg.DNS.Enabled, _ = strconv.ParseBool(v)
@@ -169,22 +308,82 @@ func NewAgentConfig(c *cli.Context) (*AgentConfig, error) {
 }
 
 func (c *AgentConfig) validate() error {
+	return c.Janitor.Validate()
+}
+
+// Validate verify the janitor settings, it's also used to verify the settings on reloading
+func (c *Janitor) Validate() error {
 	// verify Janitor.AdvertiseIP is valid ip addr
-	if ip := c.Janitor.AdvertiseIP; ip != "" {
+	if ip := c.AdvertiseIP; ip != "" {
 		if net.ParseIP(ip) == nil {
 			return fmt.Errorf("invalid janitor advertise ip: %v", ip)
 		}
 	}
 
 	// verify Janitor.TLS cert/key files exist if gateway tls enabled
-	if c.Janitor.TLSListenAddr != "" {
-		if _, err := os.Stat(c.Janitor.TLSCertFile); err != nil {
+	if c.TLSListenAddr != "" {
+		if _, err := os.Stat(c.TLSCertFile); err != nil {
 			return fmt.Errorf("tsl cert file: %v", err)
 		}
-		if _, err := os.Stat(c.Janitor.TLSKeyFile); err != nil {
+		if _, err := os.Stat(c.TLSKeyFile); err != nil {
 			return fmt.Errorf("tsl key file: %v", err)
 		}
+		if dir := c.TLSCertDir; dir != "" {
+			if _, err := os.Stat(dir); err != nil {
+				return fmt.Errorf("tsl cert dir: %v", err)
+			}
+		}
+	}
+
+	// verify Janitor.NamingPolicy is known
+	switch c.NamingPolicy {
+	case "", "strict", "relaxed":
+	default:
+		return fmt.Errorf("invalid janitor naming policy: %v, should be strict or relaxed", c.NamingPolicy)
 	}
 
+	// verify Janitor.Balance is known
+	switch c.Balance {
+	case "", "wrr", "swrr", "leasttime", "leasttime_conn", "wlc":
+	default:
+		return fmt.Errorf("invalid janitor balance: %v, should be one of wrr, swrr, leasttime, leasttime_conn, wlc", c.Balance)
+	}
+
+	if c.DrainTimeout < 0 {
+		return fmt.Errorf("invalid janitor drain timeout: %v", c.DrainTimeout)
+	}
+
+	if c.UDPMaxSessions < 0 {
+		return fmt.Errorf("invalid janitor udp max sessions: %v", c.UDPMaxSessions)
+	}
+
+	// verify Janitor.AccessLog & AccessLogSinks are file paths or collector addresses
+	if c.AccessLog != "" {
+		if err := validAccessLogSink(c.AccessLog); err != nil {
+			return err
+		}
+	}
+	for app, sink := range c.AccessLogSinks {
+		if err := validAccessLogSink(sink); err != nil {
+			return fmt.Errorf("app %s: %v", app, err)
+		}
+	}
+
+	return nil
+}
+
+// validAccessLogSink verify the access log sink is a file path or udp://host:port, tcp://host:port
+func validAccessLogSink(sink string) error {
+	if sink == "" {
+		return errors.New("access log sink empty")
+	}
+
+	for _, scheme := range []string{"udp://", "tcp://"} {
+		if strings.HasPrefix(sink, scheme) {
+			if _, _, err := net.SplitHostPort(strings.TrimPrefix(sink, scheme)); err != nil {
+				return fmt.Errorf("invalid access log collector %s: %v", sink, err)
+			}
+		}
+	}
 	return nil
 }
@@ -15,6 +15,21 @@ type ManagerConfig struct {
 	Advertise  string `json:"advertise_addr"`
 	EnableCORS bool
 
+	CORSAllowedOrigins   []string `json:"cors_allowed_origins"`
+	CORSAllowedMethods   []string `json:"cors_allowed_methods"`
+	CORSAllowedHeaders   []string `json:"cors_allowed_headers"`
+	CORSAllowCredentials bool     `json:"cors_allow_credentials"`
+
+	EnableGzip  bool `json:"enable_gzip"`
+	GzipMinSize int  `json:"gzip_min_size"`
+
+	AuthTokens      []string `json:"auth_tokens"`       // api bearer tokens, empty to disable authentication
+	AuthExemptPaths []string `json:"auth_exempt_paths"` // api paths without authentication
+
+	TenantTokenSecret string `json:"tenant_token_secret"` // secret signing the tenant scoped event tokens, empty to disable
+
+	AuditLogFile string `json:"audit_log_file"` // file the op status transitions are appended to, empty to disable
+
 	MesosURL *url.URL `json:"mesosURL"` // mesos zk url
 
 	StoreType string   `json:"store_type"` // db store type
@@ -30,6 +45,13 @@ type ManagerConfig struct {
 	MaxTasksPerOffer        int     `json:"maxTasksPerOffer"`
 	EnableCapabilityKilling bool    `json:"enableCapabilityKilling"`
 	EnableCheckPoint        bool    `json:"enableCheckPoint"`
+
+	OfferBackoffInitial float64 `json:"offerBackoffInitial"`
+	OfferBackoffMax     float64 `json:"offerBackoffMax"`
+	OfferWaitTimeout    float64 `json:"offerWaitTimeout"`
+
+	ConstraintMissingAttr string `json:"constraint_missing_attribute"` // policy on the constraints referencing the missing attributes: reject / permit
+	LogConstraintRejects  bool   `json:"logConstraintRejects"`         // log the offers rejected by the constraints
 }
 
 func NewManagerConfig(c *cli.Context) (*ManagerConfig, error) {
@@ -68,6 +90,41 @@ func NewManagerConfig(c *cli.Context) (*ManagerConfig, error) {
 		cfg.Advertise = cfg.Listen
 	}
 
+	cfg.EnableCORS = c.BoolT("enable-cors")
+
+	if c.String("cors-allowed-origins") != "" {
+		cfg.CORSAllowedOrigins = strings.Split(c.String("cors-allowed-origins"), ",")
+	}
+
+	if c.String("cors-allowed-methods") != "" {
+		cfg.CORSAllowedMethods = strings.Split(c.String("cors-allowed-methods"), ",")
+	}
+
+	if c.String("cors-allowed-headers") != "" {
+		cfg.CORSAllowedHeaders = strings.Split(c.String("cors-allowed-headers"), ",")
+	}
+
+	cfg.CORSAllowCredentials = c.Bool("cors-allow-credentials")
+
+	cfg.EnableGzip = c.Bool("enable-gzip")
+	cfg.GzipMinSize = c.Int("gzip-min-size")
+
+	if c.String("auth-tokens") != "" {
+		cfg.AuthTokens = strings.Split(c.String("auth-tokens"), ",")
+	}
+
+	if c.String("auth-exempt-paths") != "" {
+		cfg.AuthExemptPaths = strings.Split(c.String("auth-exempt-paths"), ",")
+	}
+
+	if c.String("tenant-token-secret") != "" {
+		cfg.TenantTokenSecret = c.String("tenant-token-secret")
+	}
+
+	if c.String("audit-log-file") != "" {
+		cfg.AuditLogFile = c.String("audit-log-file")
+	}
+
 	if c.String("log-level") != "" {
 		cfg.LogLevel = c.String("log-level")
 	}
@@ -104,6 +161,24 @@ func NewManagerConfig(c *cli.Context) (*ManagerConfig, error) {
 		cfg.EnableCheckPoint, _ = strconv.ParseBool(ckpoint)
 	}
 
+	if c.Float64("offer-backoff-initial") != 0 {
+		cfg.OfferBackoffInitial = c.Float64("offer-backoff-initial")
+	}
+
+	if c.Float64("offer-backoff-max") != 0 {
+		cfg.OfferBackoffMax = c.Float64("offer-backoff-max")
+	}
+
+	if c.Float64("offer-wait-timeout") != 0 {
+		cfg.OfferWaitTimeout = c.Float64("offer-wait-timeout")
+	}
+
+	if policy := c.String("constraint-missing-attribute"); policy != "" {
+		cfg.ConstraintMissingAttr = policy
+	}
+
+	cfg.LogConstraintRejects = c.Bool("log-constraint-rejects")
+
 	if err := cfg.validate(); err != nil {
 		return nil, err
 	}
@@ -143,5 +218,11 @@ func (c *ManagerConfig) validate() error {
 		return fmt.Errorf("reconciliation step delay must be positive")
 	}
 
+	switch c.ConstraintMissingAttr {
+	case "", "reject", "permit":
+	default:
+		return fmt.Errorf("constraint missing attribute policy must be one of the 'reject, permit'")
+	}
+
 	return nil
 }
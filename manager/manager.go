@@ -1,8 +1,13 @@
 package manager
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Dataman-Cloud/swan/api"
@@ -15,6 +20,9 @@ import (
 	"github.com/samuel/go-zookeeper/zk"
 )
 
+// max duration to wait for the in-flight api requests on shutdown
+const shutdownTimeout = time.Second * 10
+
 type Manager struct {
 	sched         *mesos.Scheduler
 	apiserver     *api.Server
@@ -68,6 +76,11 @@ func New(cfg *config.ManagerConfig) (*Manager, error) {
 		MaxTasksPerOffer:        cfg.MaxTasksPerOffer,
 		EnableCapabilityKilling: cfg.EnableCapabilityKilling,
 		EnableCheckPoint:        cfg.EnableCheckPoint,
+		OfferBackoffInitial:     cfg.OfferBackoffInitial,
+		OfferBackoffMax:         cfg.OfferBackoffMax,
+		OfferWaitTimeout:        cfg.OfferWaitTimeout,
+		ConstraintMissingAttr:   cfg.ConstraintMissingAttr,
+		LogConstraintRejects:    cfg.LogConstraintRejects,
 	}
 
 	sched, err := mesos.NewScheduler(&scfg, db, clusterMaster)
@@ -77,9 +90,29 @@ func New(cfg *config.ManagerConfig) (*Manager, error) {
 
 	// api server setup
 	srvcfg := api.Config{
-		Advertise: cfg.Advertise,
-		LogLevel:  cfg.LogLevel,
+		Advertise:       cfg.Advertise,
+		LogLevel:        cfg.LogLevel,
+		EnableGzip:      cfg.EnableGzip,
+		GzipMinSize:     cfg.GzipMinSize,
+		AuthTokens:      cfg.AuthTokens,
+		AuthExemptPaths: cfg.AuthExemptPaths,
+
+		TenantTokenSecret: cfg.TenantTokenSecret,
+
+		EnableCORS:           cfg.EnableCORS,
+		CORSAllowedOrigins:   cfg.CORSAllowedOrigins,
+		CORSAllowedMethods:   cfg.CORSAllowedMethods,
+		CORSAllowedHeaders:   cfg.CORSAllowedHeaders,
+		CORSAllowCredentials: cfg.CORSAllowCredentials,
+	}
+	if cfg.AuditLogFile != "" {
+		f, err := os.OpenFile(cfg.AuditLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log file %s error: %v", cfg.AuditLogFile, err)
+		}
+		srvcfg.AuditLog = f
 	}
+
 	srv := api.NewServer(&srvcfg, hl, sched, db)
 
 	// final
@@ -149,8 +182,15 @@ func (m *Manager) start() error {
 		}
 	}()
 
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
 	for {
 		select {
+		case sig := <-sigCh:
+			log.Warnf("received signal %v, shutting down ...", sig)
+			return m.shutdown()
+
 		case c := <-m.leadershipChangeCh:
 			switch c {
 			case LeadershipLeader:
@@ -173,3 +213,16 @@ func (m *Manager) start() error {
 	}
 
 }
+
+// shutdown gracefully shutdown the api server within shutdownTimeout
+func (m *Manager) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := m.apiserver.Shutdown(ctx); err != nil {
+		log.Errorf("shutdown apiserver error: %v", err)
+		return err
+	}
+
+	return nil
+}
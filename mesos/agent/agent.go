@@ -2,6 +2,7 @@ package mesos
 
 import (
 	"encoding/json"
+	"sort"
 	"sync"
 
 	"github.com/Dataman-Cloud/swan/mesosproto"
@@ -101,6 +102,46 @@ func (s *Agent) Resources() (cpus, mem, disk float64, ports []uint64) {
 	return
 }
 
+// Gpus returns the nb of the offered gpus, false if none of the offers has the gpus resource
+func (s *Agent) Gpus() (gpus float64, ok bool) {
+	for _, offer := range s.GetOffers() {
+		if n, has := offer.GetGpus(); has {
+			gpus += n
+			ok = true
+		}
+	}
+
+	return
+}
+
+// Reservations returns the sorted roles and reservation labels of the offered resources
+func (s *Agent) Reservations() (roles, labels []string) {
+	var (
+		rs = make(map[string]bool)
+		ls = make(map[string]bool)
+	)
+
+	for _, offer := range s.GetOffers() {
+		for _, role := range offer.GetRoles() {
+			rs[role] = true
+		}
+		for _, label := range offer.GetReservationLabels() {
+			ls[label] = true
+		}
+	}
+
+	for role := range rs {
+		roles = append(roles, role)
+	}
+	for label := range ls {
+		labels = append(labels, label)
+	}
+	sort.Strings(roles)
+	sort.Strings(labels)
+
+	return
+}
+
 func (s *Agent) Attributes() map[string]string {
 	attrs := make(map[string]string)
 
@@ -112,5 +153,8 @@ func (s *Agent) Attributes() map[string]string {
 		attrs["hostname"] = offer.GetHostname()
 	}
 
+	// add agent id as an extra attribute
+	attrs["agentid"] = s.id
+
 	return attrs
 }
@@ -2,6 +2,9 @@ package mesos
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/Dataman-Cloud/swan/mesosproto"
 )
@@ -11,11 +14,15 @@ type Offer struct {
 	cpus       float64
 	mem        float64
 	disk       float64
+	gpus       float64
+	hasGpus    bool // the gpus resource offered, even 0
 	ports      []uint64
 	portRanges []*portRange
 	attrs      map[string]string
 	hostname   string
 	agentId    string
+	roles      []string // roles of the resources, "*" for unreserved
+	labels     []string // reservation labels of the resources, in form of key=value
 }
 
 type portRange struct {
@@ -36,11 +43,23 @@ func NewOffer(offer *mesosproto.Offer) *Offer {
 
 	var (
 		cpus, mem, disk float64
+		gpus            float64
+		hasGpus         bool
 		ports           []uint64
 		portRanges      []*portRange
 	)
 
+	var (
+		roles  = make(map[string]bool)
+		labels = make(map[string]bool)
+	)
+
 	for _, resource := range offer.Resources {
+		roles[resource.GetRole()] = true
+		for _, label := range resource.GetReservation().GetLabels().GetLabels() {
+			labels[label.GetKey()+"="+label.GetValue()] = true
+		}
+
 		if *resource.Name == "cpus" {
 			cpus += *resource.Scalar.Value
 		}
@@ -53,6 +72,11 @@ func NewOffer(offer *mesosproto.Offer) *Offer {
 			disk += *resource.Scalar.Value
 		}
 
+		if *resource.Name == "gpus" {
+			gpus += resource.GetScalar().GetValue()
+			hasGpus = true
+		}
+
 		if *resource.Name == "ports" {
 			for _, r := range resource.GetRanges().GetRange() {
 				var (
@@ -72,13 +96,22 @@ func NewOffer(offer *mesosproto.Offer) *Offer {
 	f.cpus = cpus
 	f.mem = mem
 	f.disk = disk
+	f.gpus = gpus
+	f.hasGpus = hasGpus
 	f.ports = ports
 	f.portRanges = portRanges
 
+	for role := range roles {
+		f.roles = append(f.roles, role)
+	}
+	for label := range labels {
+		f.labels = append(f.labels, label)
+	}
+
 	attrs := make(map[string]string, 0)
 	for _, attr := range offer.Attributes {
-		if attr.GetType() == mesosproto.Value_TEXT {
-			attrs[attr.GetName()] = attr.GetText().GetValue()
+		if v, ok := attrValue(attr); ok {
+			attrs[attr.GetName()] = v
 		}
 	}
 
@@ -88,6 +121,25 @@ func NewOffer(offer *mesosproto.Offer) *Offer {
 
 }
 
+// attrValue stringifies the attribute for evaluating constraints: the TEXT as is, the SCALAR
+// as the number (eg: 2.5) to be compared numerically, and the RANGES as the comma separated
+// `begin-end` items (eg: 1000-2000,3000-3000) to be matched by has / == / ~=. the SET is skipped.
+func attrValue(attr *mesosproto.Attribute) (string, bool) {
+	switch attr.GetType() {
+	case mesosproto.Value_TEXT:
+		return attr.GetText().GetValue(), true
+	case mesosproto.Value_SCALAR:
+		return strconv.FormatFloat(attr.GetScalar().GetValue(), 'f', -1, 64), true
+	case mesosproto.Value_RANGES:
+		rs := make([]string, 0)
+		for _, r := range attr.GetRanges().GetRange() {
+			rs = append(rs, fmt.Sprintf("%d-%d", r.GetBegin(), r.GetEnd()))
+		}
+		return strings.Join(rs, ","), true
+	}
+	return "", false
+}
+
 func (f *Offer) GetId() string {
 	return f.id
 }
@@ -104,6 +156,11 @@ func (f *Offer) GetDisk() float64 {
 	return f.disk
 }
 
+// GetGpus returns the nb of the offered gpus, false if the gpus resource is not offered
+func (f *Offer) GetGpus() (float64, bool) {
+	return f.gpus, f.hasGpus
+}
+
 func (f *Offer) GetPorts() (ports []uint64) {
 	return f.ports
 }
@@ -126,6 +183,16 @@ func (f *Offer) GetAttrs() map[string]string {
 	return f.attrs
 }
 
+// GetRoles returns the roles of the offered resources, "*" for the unreserved
+func (f *Offer) GetRoles() []string {
+	return f.roles
+}
+
+// GetReservationLabels returns the reservation labels of the offered resources, in form of key=value
+func (f *Offer) GetReservationLabels() []string {
+	return f.labels
+}
+
 func (f *Offer) GetHostname() string {
 	return f.hostname
 }
@@ -136,6 +203,7 @@ func (f *Offer) MarshalJSON() ([]byte, error) {
 		"cpus":     f.cpus,
 		"mem":      f.mem,
 		"disk":     f.disk,
+		"gpus":     f.gpus,
 		"ports":    f.portRanges,
 		"hostname": f.hostname,
 		"attrs":    f.attrs,
@@ -0,0 +1,89 @@
+package mesos
+
+import (
+	"math/rand"
+	"time"
+)
+
+// the defaults of waiting for the proper offers
+const (
+	defaultOfferBackoffInitial = time.Millisecond * 500
+	defaultOfferBackoffMax     = time.Second * 30
+	defaultOfferWaitTimeout    = time.Second * 86400
+)
+
+// clock is the source of time, replaced by the tests
+type clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// backoff is the exponential backoff with jitter. the interval doubles on each retry
+// until the max, and the jitter randomizes the interval within [interval/2, interval].
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	rand    func() float64 // [0, 1)
+
+	interval time.Duration // the next interval before jitter
+}
+
+func newBackoff(initial, max time.Duration) *backoff {
+	if max < initial {
+		max = initial
+	}
+
+	return &backoff{
+		initial:  initial,
+		max:      max,
+		rand:     rand.Float64,
+		interval: initial,
+	}
+}
+
+// Next returns the interval to wait before the next retry
+func (b *backoff) Next() time.Duration {
+	d := b.interval
+
+	b.interval *= 2
+	if b.interval > b.max {
+		b.interval = b.max
+	}
+
+	return d/2 + time.Duration(b.rand()*float64(d/2))
+}
+
+// Reset restarts the backoff from the initial interval once progress is made
+func (b *backoff) Reset() {
+	b.interval = b.initial
+}
+
+// offerBackoff returns the backoff of waiting for the proper offers by the config
+func (s *Scheduler) offerBackoff() *backoff {
+	var (
+		initial = defaultOfferBackoffInitial
+		max     = defaultOfferBackoffMax
+	)
+
+	if s.cfg.OfferBackoffInitial > 0 {
+		initial = time.Duration(s.cfg.OfferBackoffInitial * float64(time.Second))
+	}
+	if s.cfg.OfferBackoffMax > 0 {
+		max = time.Duration(s.cfg.OfferBackoffMax * float64(time.Second))
+	}
+
+	return newBackoff(initial, max)
+}
+
+// offerWaitTimeout returns how long to wait for the proper offers before the tasks failed
+func (s *Scheduler) offerWaitTimeout() time.Duration {
+	if s.cfg.OfferWaitTimeout > 0 {
+		return time.Duration(s.cfg.OfferWaitTimeout * float64(time.Second))
+	}
+	return defaultOfferWaitTimeout
+}
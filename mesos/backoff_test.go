@@ -0,0 +1,92 @@
+package mesos
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	magent "github.com/Dataman-Cloud/swan/mesos/agent"
+	"github.com/Dataman-Cloud/swan/mesos/filter"
+)
+
+// fakeClock advances at once on waiting, and records the waited durations
+type fakeClock struct {
+	now    time.Time
+	waited []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waited = append(c.waited, d)
+	c.now = c.now.Add(d)
+
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name string
+		rand float64
+		want []time.Duration
+	}{
+		{
+			name: "without jitter",
+			rand: 1,
+			want: []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 5, time.Second * 5},
+		},
+		{
+			name: "max jitter",
+			rand: 0,
+			want: []time.Duration{time.Millisecond * 500, time.Second, time.Second * 2, time.Millisecond * 2500, time.Millisecond * 2500},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoff(time.Second, time.Second*5)
+			b.rand = func() float64 { return tt.rand }
+
+			var got []time.Duration
+			for range tt.want {
+				got = append(got, b.Next())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("intervals = %v, want %v", got, tt.want)
+			}
+
+			b.Reset()
+			if got := b.Next(); got != tt.want[0] {
+				t.Errorf("interval after reset = %s, want %s", got, tt.want[0])
+			}
+		})
+	}
+}
+
+func TestWaitOffersBackoff(t *testing.T) {
+	var (
+		clk = &fakeClock{now: time.Unix(1500000000, 0)}
+		s   = &Scheduler{
+			cfg:    &SchedulerConfig{OfferWaitTimeout: 10},
+			agents: make(map[string]*magent.Agent),
+			sem:    make(chan struct{}, 1),
+			clock:  clk,
+		}
+		b = newBackoff(time.Second, time.Second*4)
+	)
+	b.rand = func() float64 { return 1 }
+
+	// no agents at all till the deadline
+	_, err := s.waitOffers(&filter.FilterOptions{Replicas: 1}, b)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("waitOffers() error = %v, want timeout", err)
+	}
+
+	want := []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 4}
+	if !reflect.DeepEqual(clk.waited, want) {
+		t.Errorf("waited = %v, want %v", clk.waited, want)
+	}
+}
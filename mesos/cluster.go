@@ -60,19 +60,19 @@ func (s *Scheduler) broadcastEventRecords(ev *types.TaskEvent) error {
 				wg.Done()
 			}()
 
-			funcDoReq := func(req *http.Request) error {
+			funcDoReq := func(req *http.Request) (http.Header, error) {
 				resp, err := agent.Client().Do(req)
 				if err != nil {
-					return err
+					return nil, err
 				}
 				defer resp.Body.Close()
 
 				if code := resp.StatusCode; code >= 400 {
 					bs, _ := ioutil.ReadAll(resp.Body)
-					return fmt.Errorf("%d - %s", code, string(bs))
+					return nil, fmt.Errorf("%d - %s", code, string(bs))
 				}
 
-				return nil
+				return resp.Header, nil
 			}
 
 			reqDNS, err := s.buildAgentDNSReq(ev)
@@ -82,7 +82,7 @@ func (s *Scheduler) broadcastEventRecords(ev *types.TaskEvent) error {
 			reqDNS.Close = true
 			reqDNS.Header.Set("Connection", "close")
 			reqDNS.Host = agent.ID()
-			err = funcDoReq(reqDNS)
+			_, err = funcDoReq(reqDNS)
 			if err != nil {
 				return
 			}
@@ -98,11 +98,13 @@ func (s *Scheduler) broadcastEventRecords(ev *types.TaskEvent) error {
 			reqProxy.Close = true
 			reqProxy.Header.Set("Connection", "close")
 			reqProxy.Host = agent.ID()
-			err = funcDoReq(reqProxy)
+			hdr, err := funcDoReq(reqProxy)
 			if err != nil {
 				return
 			}
 
+			s.publishTargetChange(agent.ID(), ev, hdr.Get(upstream.TargetChangeHeader))
+
 		}(agent)
 	}
 
@@ -115,6 +117,24 @@ func (s *Scheduler) broadcastEventRecords(ev *types.TaskEvent) error {
 	return res
 }
 
+// publishTargetChange publishes the target change applied by the agent's proxy
+func (s *Scheduler) publishTargetChange(agentID string, ev *types.TaskEvent, change string) {
+	if change == "" { // nothing changed or the agent doesn't report it
+		return
+	}
+
+	s.eventmgr.broadcast(&types.TargetChangeEvent{
+		Change:   change,
+		Agent:    agentID,
+		AppID:    ev.AppID,
+		Upstream: ev.AppID,
+		TaskID:   ev.TaskID,
+		IP:       ev.IP,
+		Port:     ev.Port,
+		Weight:   ev.Weight,
+	})
+}
+
 func (s *Scheduler) buildAgentDNSRecord(ev *types.TaskEvent) *resolver.Record {
 	return &resolver.Record{
 		ID:          ev.TaskID,
@@ -164,6 +184,7 @@ func (s *Scheduler) buildAgentProxyRecord(ev *types.TaskEvent) *upstream.Backend
 			Version:    ev.VersionID,
 			Weight:     ev.Weight,
 			CleanName:  "",
+			Labels:     ev.Labels,
 		},
 	}
 }
@@ -4,12 +4,30 @@ import (
 	"io"
 	"net/http"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+
+	"github.com/Dataman-Cloud/swan/types"
+	"github.com/Dataman-Cloud/swan/utils/labels"
+)
+
+// event listener priorities
+const (
+	EventPriorityLow  = "low"  // default, dropped once it's too slow to consume, eg: ui dashboards
+	EventPriorityHigh = "high" // larger buffer and never dropped, eg: the internal syncers
 )
 
-type event interface {
-	Format() []byte
+// buffer sizes of the event listeners by priority
+var eventBufferSizes = map[string]int{
+	EventPriorityLow:  1024,
+	EventPriorityHigh: 8192,
+}
+
+// ValidEventPriority verify if the event listener priority is supported
+func ValidEventPriority(priority string) bool {
+	_, ok := eventBufferSizes[priority]
+	return ok
 }
 
 type eventClient struct {
@@ -17,20 +35,45 @@ type eventClient struct {
 	f http.Flusher
 	n http.CloseNotifier
 
+	priority string
+	format   string       // stream format
+	filter   EventOptions // filter events by the subscribed options
+
+	batchWindow time.Duration // coalesce the events arrived within the window, 0 to disable batching
+	batchSize   int           // max nb of events within one batch
+
+	dedup    *eventDedup // suppress the repeated events, nil to disable
+	details  bool        // stream the events with details, eg: task resources
+	counters *eventCounters
+
 	wait chan struct{}
-	recv chan []byte
+	quit chan struct{} // closed to release the client on shutdown or dropped as slow
+	once sync.Once     // protect quit closed only once
+	recv chan *types.Event
+}
+
+// release notify the client writer to quit
+func (c *eventClient) release() {
+	c.once.Do(func() {
+		close(c.quit)
+	})
 }
 
 type eventManager struct {
-	sync.RWMutex                         // protect m
+	sync.RWMutex                         // protect m & closed
 	m            map[string]*eventClient // store of online event clients
 	max          int                     // max nb of clients, avoid bomber
+	closed       bool                    // all clients released, no more new clients accepted
+
+	labelsOf func(appID string) map[string]string // resolve the app labels for label selector filtering
+	counters *eventCounters
 }
 
 func NewEventManager() *eventManager {
 	return &eventManager{
-		m:   make(map[string]*eventClient),
-		max: 1024,
+		m:        make(map[string]*eventClient),
+		max:      1024,
+		counters: newEventCounters(),
 	}
 }
 
@@ -38,75 +81,305 @@ func (em *eventManager) clients() map[string]*eventClient {
 	em.RLock()
 	defer em.RUnlock()
 
-	return em.m
+	m := make(map[string]*eventClient, len(em.m))
+	for addr, c := range em.m {
+		m[addr] = c
+	}
+	return m
 }
 
-// broadcast message to all event clients
-func (em *eventManager) broadcast(e event) error {
-	for _, c := range em.clients() {
+// broadcast the event to all event clients. each client is fed by its own buffered
+// channel and writer goroutine, so a slow client never blocks the broadcasting nor
+// the others, it only backs up its own buffer until it's dropped by priority.
+func (em *eventManager) broadcast(p types.EventPayload) error {
+	var (
+		ev      = types.NewEvent(p)
+		clients = em.clients()
+	)
+	em.counters.publish(ev.Type)
+
+	// resolve the app labels only if any client filters by them
+	for _, c := range clients {
+		if c.filter.NeedLabels() && em.labelsOf != nil {
+			ev.Labels = em.labelsOf(ev.AppID)
+			break
+		}
+	}
+
+	for addr, c := range clients {
+		if !c.filter.Match(ev) {
+			continue
+		}
+
 		select {
-		case c.recv <- e.Format():
+		case c.recv <- ev:
 		default:
+			em.slow(addr, c)
 		}
 	}
 	return nil
 }
 
-// subscribe() add an event client
-func (em *eventManager) subscribe(remoteAddr string, w io.Writer) {
+// slow handles the client whose buffer is full. the low priority client is dropped
+// so it could reconnect and catch up, the high priority client is kept with the
+// message skipped.
+func (em *eventManager) slow(remoteAddr string, c *eventClient) {
+	em.counters.add(&em.counters.dropped, 1)
+
+	if c.priority == EventPriorityHigh {
+		log.Warnf("event client [%s] is too slow, event message skipped", remoteAddr)
+		return
+	}
+
+	log.Warnf("event client [%s] is too slow, dropped", remoteAddr)
+	em.counters.add(&em.counters.droppedListeners, 1)
+
+	em.unsubscribe(remoteAddr, c)
+}
+
+// EventOptions is the options of the event client subscription
+type EventOptions struct {
+	Priority      string          // EventPriorityLow by default
+	Format        string          // types.EventFormatSSE by default
+	AppIDs        []string        // filter events by the originating apps, optional
+	LabelSelector labels.Selector // filter events by the originating app's labels, optional
+	Scope         *EventScope     // the visible apps of the tenant, nil for everything
+	BatchWindow   time.Duration   // coalesce the events arrived within the window into one write, 0 to disable
+	BatchSize     int             // max nb of events within one batch, DefaultEventBatchSize by default
+	DedupWindow   time.Duration   // suppress the identical consecutive events of one task within the window, 0 to disable
+	DedupKey      []string        // the fields identifying the identical events, DefaultEventDedupKey by default
+	Details       bool            // stream the events with details, eg: the task resources & agent
+}
+
+// EventScope is the visible apps of a tenant, the events of the apps either listed
+// or matched by the labels are visible.
+type EventScope struct {
+	Tenant string
+	Apps   []string        // the visible app ids
+	Labels labels.Selector // the visible apps by labels, optional
+}
+
+func (s *EventScope) visible(ev *types.Event) bool {
+	for _, id := range s.Apps {
+		if id == ev.AppID {
+			return true
+		}
+	}
+	return s.Labels != nil && s.Labels.Matches(labels.Set(ev.Labels))
+}
+
+// Match verify if the event is matched by the filters & visible by the scope,
+// the app labels should be resolved if needed.
+func (o EventOptions) Match(ev *types.Event) bool {
+	if o.Scope != nil && !o.Scope.visible(ev) {
+		return false
+	}
+
+	if len(o.AppIDs) > 0 {
+		var found bool
+		for _, id := range o.AppIDs {
+			if id == ev.AppID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if o.LabelSelector != nil && !o.LabelSelector.Matches(labels.Set(ev.Labels)) {
+		return false
+	}
+
+	return true
+}
+
+// NeedLabels verify if the app labels are needed for matching
+func (o EventOptions) NeedLabels() bool {
+	return o.LabelSelector != nil || (o.Scope != nil && o.Scope.Labels != nil)
+}
+
+// DefaultEventBatchSize is the default max nb of events within one batch
+const DefaultEventBatchSize = 100
+
+// subscribe() add an event client with the options
+func (em *eventManager) subscribe(remoteAddr string, w io.Writer, opts EventOptions) *eventClient {
+	priority := opts.Priority
+	if priority == "" {
+		priority = EventPriorityLow
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = types.EventFormatSSE
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultEventBatchSize
+	}
+
 	c := &eventClient{
 		w: w,
 		f: w.(http.Flusher),
 		n: w.(http.CloseNotifier),
 
+		priority: priority,
+		format:   format,
+		filter:   opts,
+
+		batchWindow: opts.BatchWindow,
+		batchSize:   batchSize,
+		details:     opts.Details,
+		counters:    em.counters,
+
 		wait: make(chan struct{}),
-		recv: make(chan []byte, 1024),
+		quit: make(chan struct{}),
+		recv: make(chan *types.Event, eventBufferSizes[priority]),
+	}
+
+	if opts.DedupWindow > 0 {
+		c.dedup = newEventDedup(opts.DedupWindow, opts.DedupKey)
 	}
 
+	em.Lock()
+	em.m[remoteAddr] = c
+	em.Unlock()
+
 	go func(em *eventManager, c *eventClient, remoteAddr string) {
-		defer em.evict(remoteAddr)
+		defer em.evict(remoteAddr, c)
 		for {
+			// quit preferentially even if there're pending messages
+			select {
+			case <-c.quit:
+				return
+			default:
+			}
+
 			select {
 			case <-c.n.CloseNotify():
 				return
-			case msg := <-c.recv:
+			case <-c.quit:
+				return
+			case ev := <-c.recv:
+				if c.suppressed(ev) {
+					continue
+				}
+				ev = c.view(ev)
+
+				var (
+					evs = []*types.Event{ev}
+					msg []byte
+				)
+				if c.batchWindow > 0 {
+					evs = c.collect(ev)
+					msg = types.EncodeBatch(c.format, evs)
+				} else {
+					msg = ev.Encode(c.format)
+				}
+				if msg == nil { // not streamed by the format
+					continue
+				}
 				if _, err := c.w.Write(msg); err != nil {
 					log.Errorf("write event message to client [%s] error: [%v]", remoteAddr, err)
 					return
 				}
 				c.f.Flush()
+				c.counters.add(&c.counters.delivered, len(evs))
 			}
 		}
 	}(em, c, remoteAddr)
 
-	em.Lock()
-	em.m[remoteAddr] = c
-	em.Unlock()
+	return c
 }
 
-func (em *eventManager) wait(remoteAddr string) {
-	em.RLock()
-	c, ok := em.m[remoteAddr]
-	em.RUnlock()
-	if !ok {
-		return
+func (c *eventClient) suppressed(ev *types.Event) bool {
+	if c.dedup != nil && c.dedup.suppress(ev, time.Now()) {
+		c.counters.add(&c.counters.suppressed, 1)
+		return true
+	}
+	return false
+}
+
+// view returns the event as the client requested
+func (c *eventClient) view(ev *types.Event) *types.Event {
+	if c.details {
+		return ev.WithDetails()
+	}
+	return ev
+}
+
+// collect coalesces the events arrived within the batch window following
+// the first one, until the batch is full or the client released.
+func (c *eventClient) collect(first *types.Event) []*types.Event {
+	evs := []*types.Event{first}
+
+	timer := time.NewTimer(c.batchWindow)
+	defer timer.Stop()
+
+	for len(evs) < c.batchSize {
+		select {
+		case ev := <-c.recv:
+			if !c.suppressed(ev) {
+				evs = append(evs, c.view(ev))
+			}
+		case <-timer.C:
+			return evs
+		case <-c.quit:
+			return evs
+		}
+	}
+
+	return evs
+}
+
+// unsubscribe removes the event client and releases its writer, the writer quits
+// once the pending write returns.
+func (em *eventManager) unsubscribe(remoteAddr string, c *eventClient) {
+	em.Lock()
+	if em.m[remoteAddr] == c {
+		delete(em.m, remoteAddr)
 	}
-	<-c.wait
+	em.Unlock()
+
+	c.release()
 }
 
-func (em *eventManager) evict(remoteAddr string) {
+func (em *eventManager) evict(remoteAddr string, c *eventClient) {
 	log.Debugln("evict event listener ", remoteAddr)
 
 	em.Lock()
 	defer em.Unlock()
 
-	c, ok := em.m[remoteAddr]
-	if !ok {
-		return
+	if em.m[remoteAddr] == c {
+		delete(em.m, remoteAddr)
 	}
 
 	close(c.wait)
-	delete(em.m, remoteAddr)
+}
+
+// closeAll releases all of the event clients so that they could reconnect to
+// another manager, and no more new clients will be accepted.
+func (em *eventManager) closeAll() {
+	em.Lock()
+	defer em.Unlock()
+
+	if em.closed {
+		return
+	}
+
+	em.closed = true
+	for _, c := range em.m {
+		c.release()
+	}
+}
+
+func (em *eventManager) Closed() bool {
+	em.RLock()
+	defer em.RUnlock()
+
+	return em.closed
 }
 
 func (em *eventManager) Full() bool {
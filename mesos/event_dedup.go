@@ -0,0 +1,83 @@
+package mesos
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+// DefaultEventDedupKey is the default fields identifying the duplicated events
+var DefaultEventDedupKey = []string{"type", "app_id", "task_id"}
+
+// eventDedup suppresses the identical consecutive events of one task seen within the
+// window. only the repeats of the last delivered event are suppressed, so the final
+// state after flapping is always delivered. it's only used by the client writer.
+type eventDedup struct {
+	window time.Duration
+	fields []string              // the fields composing the dedup key
+	last   map[string]dedupEntry // app & task -> last delivered
+}
+
+type dedupEntry struct {
+	key string
+	at  time.Time
+}
+
+func newEventDedup(window time.Duration, fields []string) *eventDedup {
+	if len(fields) == 0 {
+		fields = DefaultEventDedupKey
+	}
+
+	return &eventDedup{
+		window: window,
+		fields: fields,
+		last:   make(map[string]dedupEntry),
+	}
+}
+
+// suppress verify if the event is a repeat of the last delivered one,
+// otherwise it's recorded as the last delivered.
+func (d *eventDedup) suppress(ev *types.Event, now time.Time) bool {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(ev.Payload, &payload); err != nil {
+		return false
+	}
+
+	var (
+		scope  = fmt.Sprintf("%v/%v", payload["app_id"], payload["task_id"])
+		values = make([]string, len(d.fields))
+	)
+	for i, field := range d.fields {
+		if field == "type" {
+			values[i] = ev.Type
+			continue
+		}
+		values[i] = fmt.Sprintf("%v", payload[field])
+	}
+	key := strings.Join(values, "/")
+
+	if last, ok := d.last[scope]; ok && last.key == key && now.Sub(last.at) < d.window {
+		return true
+	}
+
+	d.gc(now)
+	d.last[scope] = dedupEntry{key: key, at: now}
+
+	return false
+}
+
+// gc drops the expired entries once there're too many of them
+func (d *eventDedup) gc(now time.Time) {
+	if len(d.last) < 1024 {
+		return
+	}
+
+	for scope, e := range d.last {
+		if now.Sub(e.at) >= d.window {
+			delete(d.last, scope)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package mesos
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// eventCounters holds the event subsystem statistics since started
+type eventCounters struct {
+	published        uint64 // broadcasted events
+	delivered        uint64 // events written to the clients
+	dropped          uint64 // events skipped by the full buffers
+	suppressed       uint64 // events suppressed by dedup
+	droppedListeners uint64 // slow clients dropped
+
+	sync.Mutex
+	byType map[string]uint64 // event type -> nb of published
+}
+
+func newEventCounters() *eventCounters {
+	return &eventCounters{
+		byType: make(map[string]uint64),
+	}
+}
+
+func (c *eventCounters) publish(typ string) {
+	atomic.AddUint64(&c.published, 1)
+
+	c.Lock()
+	c.byType[typ]++
+	c.Unlock()
+}
+
+func (c *eventCounters) add(counter *uint64, n int) {
+	atomic.AddUint64(counter, uint64(n))
+}
+
+// EventStats is the snapshot of the event subsystem statistics
+type EventStats struct {
+	Listeners        int                         `json:"listeners"` // nb of active listeners
+	Published        uint64                      `json:"published"`
+	PublishedByType  map[string]uint64           `json:"published_by_type"`
+	Delivered        uint64                      `json:"delivered"`
+	Dropped          uint64                      `json:"dropped"`    // skipped by the full buffers
+	Suppressed       uint64                      `json:"suppressed"` // suppressed by dedup
+	DroppedListeners uint64                      `json:"dropped_listeners"`
+	Buffers          map[string]EventBufferStats `json:"buffers"` // listener -> buffer occupancy
+}
+
+// EventBufferStats is the buffer occupancy of one listener
+type EventBufferStats struct {
+	Priority string `json:"priority"`
+	Used     int    `json:"used"`
+	Size     int    `json:"size"`
+}
+
+// stats returns the current statistics of the event manager
+func (em *eventManager) stats() *EventStats {
+	var (
+		c       = em.counters
+		clients = em.clients()
+		ret     = &EventStats{
+			Listeners:        len(clients),
+			Published:        atomic.LoadUint64(&c.published),
+			PublishedByType:  make(map[string]uint64),
+			Delivered:        atomic.LoadUint64(&c.delivered),
+			Dropped:          atomic.LoadUint64(&c.dropped),
+			Suppressed:       atomic.LoadUint64(&c.suppressed),
+			DroppedListeners: atomic.LoadUint64(&c.droppedListeners),
+			Buffers:          make(map[string]EventBufferStats, len(clients)),
+		}
+	)
+
+	c.Lock()
+	for typ, n := range c.byType {
+		ret.PublishedByType[typ] = n
+	}
+	c.Unlock()
+
+	for addr, client := range clients {
+		ret.Buffers[addr] = EventBufferStats{
+			Priority: client.priority,
+			Used:     len(client.recv),
+			Size:     cap(client.recv),
+		}
+	}
+
+	return ret
+}
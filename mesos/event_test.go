@@ -0,0 +1,574 @@
+package mesos
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dataman-Cloud/swan/types"
+	"github.com/Dataman-Cloud/swan/utils/labels"
+)
+
+// fakeListener is a stream writer, stalled until unblocked
+type fakeListener struct {
+	sync.Mutex
+	buf     bytes.Buffer
+	block   chan struct{} // writes block until closed
+	closing chan bool
+}
+
+func newFakeListener(stalled bool) *fakeListener {
+	l := &fakeListener{
+		block:   make(chan struct{}),
+		closing: make(chan bool),
+	}
+	if !stalled {
+		close(l.block)
+	}
+	return l
+}
+
+func (l *fakeListener) Write(p []byte) (int, error) {
+	<-l.block
+	l.Lock()
+	defer l.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *fakeListener) Flush() {}
+
+func (l *fakeListener) CloseNotify() <-chan bool {
+	return l.closing
+}
+
+func TestEventListenerPriority(t *testing.T) {
+	var (
+		em   = NewEventManager()
+		low  = newFakeListener(true)
+		high = newFakeListener(true)
+	)
+
+	lc := em.subscribe("low", low, EventOptions{})
+	hc := em.subscribe("high", high, EventOptions{Priority: EventPriorityHigh})
+
+	if lc.priority != EventPriorityLow {
+		t.Errorf("default priority = %s, want %s", lc.priority, EventPriorityLow)
+	}
+	if cap(hc.recv) <= cap(lc.recv) {
+		t.Errorf("high priority buffer %d should be larger than low priority %d", cap(hc.recv), cap(lc.recv))
+	}
+
+	// overflow the low priority buffer while both of the listeners stalled
+	for i := 0; i < eventBufferSizes[EventPriorityLow]+10; i++ {
+		em.broadcast(&types.TaskEvent{Type: types.EventTypeTaskHealthy})
+	}
+
+	clients := em.clients()
+	if _, ok := clients["low"]; ok {
+		t.Errorf("slow low priority listener should be dropped")
+	}
+	if _, ok := clients["high"]; !ok {
+		t.Errorf("slow high priority listener should survive")
+	}
+
+	// the dropped listener is released once its pending write returns
+	close(low.block)
+	select {
+	case <-lc.wait:
+	case <-time.After(time.Second * 2):
+		t.Fatalf("dropped listener not released")
+	}
+
+	// the high priority listener catches up all of the events
+	close(high.block)
+	want := eventBufferSizes[EventPriorityLow] + 10
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		high.Lock()
+		got := bytes.Count(high.buf.Bytes(), []byte("event: task_healthy"))
+		high.Unlock()
+		if got == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("high priority listener received %d events, want %d", got, want)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	em.closeAll()
+	select {
+	case <-hc.wait:
+	case <-time.After(time.Second * 2):
+		t.Fatalf("high priority listener not released on close")
+	}
+}
+
+// received returns the app ids of the events received by the listener
+func (l *fakeListener) received(t *testing.T, n int) []string {
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		l.Lock()
+		got := bytes.Count(l.buf.Bytes(), []byte("event: "))
+		l.Unlock()
+		if got >= n || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	var ids []string
+	for _, msg := range bytes.Split(l.buf.Bytes(), []byte("\n\n")) {
+		if len(msg) == 0 {
+			continue
+		}
+		var ev types.Event
+		if err := json.Unmarshal(bytes.TrimPrefix(msg[bytes.IndexByte(msg, '\n')+1:], []byte("data: ")), &ev); err != nil {
+			t.Fatalf("unmarshal event %q error: %v", msg, err)
+		}
+		p, err := ev.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, p.EventAppID())
+	}
+	return ids
+}
+
+func TestEventLabelSelector(t *testing.T) {
+	appLabels := map[string]map[string]string{
+		"web-prod":   {"env": "prod", "tier": "web"},
+		"cache-prod": {"env": "prod", "tier": "cache"},
+		"web-test":   {"env": "test", "tier": "web"},
+		"nolabels":   nil,
+	}
+	apps := []string{"web-prod", "cache-prod", "web-test", "nolabels"}
+
+	tests := []struct {
+		name     string
+		selector string
+		want     []string
+	}{
+		{
+			name: "everything",
+			want: apps,
+		},
+		{
+			name:     "equality",
+			selector: "env=prod",
+			want:     []string{"web-prod", "cache-prod"},
+		},
+		{
+			name:     "inequality",
+			selector: "env=prod,tier!=cache",
+			want:     []string{"web-prod"},
+		},
+		{
+			name:     "set based",
+			selector: "env in (prod,test),tier notin (cache)",
+			want:     []string{"web-prod", "web-test"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			em := NewEventManager()
+			em.labelsOf = func(appID string) map[string]string {
+				return appLabels[appID]
+			}
+
+			opts := EventOptions{}
+			if tt.selector != "" {
+				sel, err := labels.Parse(tt.selector)
+				if err != nil {
+					t.Fatalf("labels.Parse(%s) error = %v", tt.selector, err)
+				}
+				opts.LabelSelector = sel
+			}
+
+			l := newFakeListener(false)
+			em.subscribe("client", l, opts)
+			defer em.closeAll()
+
+			for _, app := range apps {
+				em.broadcast(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: app})
+			}
+
+			if got := l.received(t, len(tt.want)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("received %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventBatching(t *testing.T) {
+	tests := []struct {
+		name        string
+		window      time.Duration
+		size        int
+		events      int
+		wantFrames  []int // nb of events within each written frame
+		wantBatched bool
+	}{
+		{
+			name:       "unbatched",
+			events:     5,
+			wantFrames: []int{1, 1, 1, 1, 1},
+		},
+		{
+			name:        "within window",
+			window:      time.Millisecond * 200,
+			events:      5,
+			wantFrames:  []int{5},
+			wantBatched: true,
+		},
+		{
+			name:        "bounded by size",
+			window:      time.Millisecond * 200,
+			size:        3,
+			events:      5,
+			wantFrames:  []int{3, 2},
+			wantBatched: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				em = NewEventManager()
+				l  = newFakeListener(false)
+			)
+			em.subscribe("client", l, EventOptions{BatchWindow: tt.window, BatchSize: tt.size})
+			defer em.closeAll()
+
+			for i := 0; i < tt.events; i++ {
+				em.broadcast(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "nginx"})
+			}
+
+			deadline := time.Now().Add(time.Second * 2)
+			var frames [][]byte
+			for {
+				l.Lock()
+				frames = bytes.Split(bytes.TrimSuffix(l.buf.Bytes(), []byte("\n\n")), []byte("\n\n"))
+				done := bytes.Count(l.buf.Bytes(), []byte(`"app_id":"nginx"`)) >= tt.events
+				l.Unlock()
+				if done || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(time.Millisecond * 10)
+			}
+
+			if len(frames) != len(tt.wantFrames) {
+				t.Fatalf("%d frames written, want %d", len(frames), len(tt.wantFrames))
+			}
+
+			for i, frame := range frames {
+				if got := bytes.HasPrefix(frame, []byte("event: batch\n")); got != tt.wantBatched {
+					t.Fatalf("frame %q batched = %v, want %v", frame, got, tt.wantBatched)
+				}
+				if !tt.wantBatched {
+					continue
+				}
+
+				var evs []*types.Event
+				if err := json.Unmarshal(bytes.TrimPrefix(frame, []byte("event: batch\ndata: ")), &evs); err != nil {
+					t.Fatalf("unmarshal batch error: %v", err)
+				}
+				if len(evs) != tt.wantFrames[i] {
+					t.Errorf("frame %d carries %d events, want %d", i, len(evs), tt.wantFrames[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEventDedup(t *testing.T) {
+	var (
+		healthy = func(task string) *types.Event {
+			return types.NewEvent(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "nginx", TaskID: task, Weight: 100})
+		}
+		unhealthy = func(task string) *types.Event {
+			return types.NewEvent(&types.TaskEvent{Type: types.EventTypeTaskUnhealthy, AppID: "nginx", TaskID: task})
+		}
+		now = time.Now()
+	)
+
+	tests := []struct {
+		name   string
+		key    []string
+		events []*types.Event
+		at     []time.Duration // offsets of the events
+		want   []bool          // suppressed or not
+	}{
+		{
+			name:   "repeats suppressed",
+			events: []*types.Event{healthy("0"), healthy("0"), healthy("0")},
+			at:     []time.Duration{0, time.Millisecond * 10, time.Millisecond * 20},
+			want:   []bool{false, true, true},
+		},
+		{
+			name:   "repeats beyond window delivered",
+			events: []*types.Event{healthy("0"), healthy("0")},
+			at:     []time.Duration{0, time.Second},
+			want:   []bool{false, false},
+		},
+		{
+			name:   "distinct tasks delivered",
+			events: []*types.Event{healthy("0"), healthy("1"), healthy("0")},
+			at:     []time.Duration{0, time.Millisecond * 10, time.Millisecond * 20},
+			want:   []bool{false, false, true},
+		},
+		{
+			name:   "flapping delivers final state",
+			events: []*types.Event{healthy("0"), unhealthy("0"), unhealthy("0"), healthy("0")},
+			at:     []time.Duration{0, time.Millisecond * 10, time.Millisecond * 20, time.Millisecond * 30},
+			want:   []bool{false, false, true, false},
+		},
+		{
+			name: "default key ignores other fields",
+			events: []*types.Event{
+				types.NewEvent(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "nginx", TaskID: "0", IP: "192.168.1.101"}),
+				types.NewEvent(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "nginx", TaskID: "0", IP: "192.168.1.102"}),
+			},
+			at:   []time.Duration{0, time.Millisecond * 10},
+			want: []bool{false, true},
+		},
+		{
+			name: "custom key",
+			key:  []string{"type", "app_id", "task_id", "task_ip"},
+			events: []*types.Event{
+				types.NewEvent(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "nginx", TaskID: "0", IP: "192.168.1.101"}),
+				types.NewEvent(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "nginx", TaskID: "0", IP: "192.168.1.102"}),
+				types.NewEvent(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "nginx", TaskID: "0", IP: "192.168.1.102"}),
+			},
+			at:   []time.Duration{0, time.Millisecond * 10, time.Millisecond * 20},
+			want: []bool{false, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newEventDedup(time.Millisecond*500, tt.key)
+			for i, ev := range tt.events {
+				if got := d.suppress(ev, now.Add(tt.at[i])); got != tt.want[i] {
+					t.Errorf("event %d suppressed = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEventDedupListener(t *testing.T) {
+	var (
+		em = NewEventManager()
+		l  = newFakeListener(false)
+	)
+	em.subscribe("client", l, EventOptions{DedupWindow: time.Second})
+	defer em.closeAll()
+
+	for _, typ := range []string{
+		types.EventTypeTaskHealthy, types.EventTypeTaskHealthy, types.EventTypeTaskHealthy,
+		types.EventTypeTaskUnhealthy, types.EventTypeTaskHealthy,
+	} {
+		em.broadcast(&types.TaskEvent{Type: typ, AppID: "nginx", TaskID: "0"})
+	}
+	em.broadcast(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "other", TaskID: "0"})
+
+	if got := l.received(t, 4); !reflect.DeepEqual(got, []string{"nginx", "nginx", "nginx", "other"}) {
+		t.Errorf("received %v, want the repeats suppressed", got)
+	}
+
+	l.Lock()
+	last := bytes.LastIndex(l.buf.Bytes(), []byte("event: task_healthy\ndata: {\"type\":\"task_healthy\",\"schema_version\":1"))
+	l.Unlock()
+	if last < 0 {
+		t.Errorf("the final healthy state should be delivered")
+	}
+}
+
+func TestEventDetailsListener(t *testing.T) {
+	var (
+		em       = NewEventManager()
+		plain    = newFakeListener(false)
+		detailed = newFakeListener(false)
+	)
+	em.subscribe("plain", plain, EventOptions{})
+	em.subscribe("detailed", detailed, EventOptions{Details: true})
+	defer em.closeAll()
+
+	ev := &types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "nginx", TaskID: "0"}
+	ev.SetDetails(&types.Task{AgentId: "5e7a-S1"}, &types.Version{CPUs: 1, Mem: 256})
+	em.broadcast(ev)
+
+	plain.received(t, 1)
+	detailed.received(t, 1)
+
+	plain.Lock()
+	defer plain.Unlock()
+	detailed.Lock()
+	defer detailed.Unlock()
+
+	if bytes.Contains(plain.buf.Bytes(), []byte(`"resources"`)) {
+		t.Errorf("plain listener should not receive details")
+	}
+	if !bytes.Contains(detailed.buf.Bytes(), []byte(`"agent_id":"5e7a-S1","resources":{"cpus":1,"gpus":0,"mem":256,"disk":0}`)) {
+		t.Errorf("detailed listener got %s, want the resources & agent", detailed.buf.Bytes())
+	}
+}
+
+func TestEventStalledListenerIsolated(t *testing.T) {
+	var (
+		em      = NewEventManager()
+		stalled = newFakeListener(true)
+		healthy = newFakeListener(false)
+	)
+	sc := em.subscribe("stalled", stalled, EventOptions{})
+	em.subscribe("healthy", healthy, EventOptions{})
+	defer em.closeAll()
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		em.broadcast(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "nginx"})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("broadcasting blocked by the stalled listener for %s", elapsed)
+	}
+
+	if got := len(healthy.received(t, 100)); got != 100 {
+		t.Errorf("healthy listener received %d events while the other stalled, want 100", got)
+	}
+
+	// the writer of the removed listener exits once the pending write returns
+	em.unsubscribe("stalled", sc)
+	if _, ok := em.clients()["stalled"]; ok {
+		t.Errorf("removed listener still subscribed")
+	}
+	close(stalled.block)
+	select {
+	case <-sc.wait:
+	case <-time.After(time.Second * 2):
+		t.Fatalf("writer of the removed listener not exited")
+	}
+}
+
+func TestEventTenantScope(t *testing.T) {
+	var (
+		em = NewEventManager()
+		l  = newFakeListener(false)
+	)
+	em.labelsOf = func(appID string) map[string]string {
+		return map[string]map[string]string{
+			"web.a": {"tenant": "a"},
+			"web.b": {"tenant": "b"},
+		}[appID]
+	}
+
+	sel, _ := labels.Parse("tenant=a")
+	em.subscribe("tenant-a", l, EventOptions{
+		AppIDs: []string{"web.a", "web.b"}, // requests another tenant's app
+		Scope:  &EventScope{Tenant: "a", Labels: sel},
+	})
+	defer em.closeAll()
+
+	for _, app := range []string{"web.b", "web.a", "web.b", "web.a"} {
+		em.broadcast(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: app})
+	}
+
+	if got := l.received(t, 2); !reflect.DeepEqual(got, []string{"web.a", "web.a"}) {
+		t.Errorf("tenant received %v, want only its own web.a", got)
+	}
+}
+
+func TestEventStats(t *testing.T) {
+	var (
+		em      = NewEventManager()
+		healthy = newFakeListener(false)
+		stalled = newFakeListener(true)
+	)
+	defer close(stalled.block)
+
+	if got := em.stats().Listeners; got != 0 {
+		t.Fatalf("listeners = %d, want 0", got)
+	}
+
+	hc := em.subscribe("healthy", healthy, EventOptions{})
+	em.subscribe("stalled", stalled, EventOptions{})
+
+	if got := em.stats().Listeners; got != 2 {
+		t.Errorf("listeners = %d, want 2", got)
+	}
+
+	for i := 0; i < 10; i++ {
+		em.broadcast(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "nginx"})
+	}
+	em.broadcast(&types.StateTransitionEvent{AppID: "nginx", From: types.OpStatusNoop, To: types.OpStatusUpdating})
+	healthy.received(t, 11)
+
+	stats := em.stats()
+	if stats.Published != 11 {
+		t.Errorf("published = %d, want 11", stats.Published)
+	}
+	if want := map[string]uint64{types.EventTypeTaskHealthy: 10, types.EventTypeStateTransition: 1}; !reflect.DeepEqual(stats.PublishedByType, want) {
+		t.Errorf("published by type = %v, want %v", stats.PublishedByType, want)
+	}
+	if stats.Delivered < 11 {
+		t.Errorf("delivered = %d, want at least 11", stats.Delivered)
+	}
+	// one event may be pending on the stalled write, the others are buffered
+	if b := stats.Buffers["stalled"]; b.Used < 10 || b.Used > 11 || b.Size != eventBufferSizes[EventPriorityLow] {
+		t.Errorf("stalled buffer = %+v, want 10 or 11 of %d used", b, eventBufferSizes[EventPriorityLow])
+	}
+
+	// overflow the stalled listener
+	for i := 0; i < eventBufferSizes[EventPriorityLow]; i++ {
+		em.broadcast(&types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "nginx"})
+	}
+	stats = em.stats()
+	if stats.Dropped != 1 || stats.DroppedListeners != 1 {
+		t.Errorf("dropped = %d, dropped listeners = %d, want 1 & 1", stats.Dropped, stats.DroppedListeners)
+	}
+	if stats.Listeners != 1 {
+		t.Errorf("listeners after dropping = %d, want 1", stats.Listeners)
+	}
+
+	// the gauge goes down as the listener left
+	em.unsubscribe("healthy", hc)
+	<-hc.wait
+	if got := em.stats().Listeners; got != 0 {
+		t.Errorf("listeners after left = %d, want 0", got)
+	}
+}
+
+func TestEventTargetChange(t *testing.T) {
+	var (
+		s = &Scheduler{eventmgr: NewEventManager()}
+		l = newFakeListener(false)
+	)
+	s.eventmgr.subscribe("web", l, EventOptions{AppIDs: []string{"web"}})
+	defer s.eventmgr.closeAll()
+
+	task := &types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "web", TaskID: "0.web", IP: "192.168.1.101", Port: 31000, Weight: 100}
+	s.publishTargetChange("agent-1", task, "") // nothing changed
+	s.publishTargetChange("agent-1", &types.TaskEvent{Type: types.EventTypeTaskHealthy, AppID: "db"}, "add")
+	s.publishTargetChange("agent-1", task, "add")
+	s.publishTargetChange("agent-2", task, "add")
+
+	if got := l.received(t, 2); !reflect.DeepEqual(got, []string{"web", "web"}) {
+		t.Fatalf("received %v, want the target changes of web from two agents", got)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if got := bytes.Count(l.buf.Bytes(), []byte("event: "+types.EventTypeTargetChange+"\n")); got != 2 {
+		t.Errorf("received %d target changes, want 2", got)
+	}
+	if !bytes.Contains(l.buf.Bytes(), []byte(`"agent":"agent-2"`)) {
+		t.Errorf("target change %s should carry the agent", l.buf.Bytes())
+	}
+}
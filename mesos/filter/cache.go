@@ -0,0 +1,75 @@
+package filter
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	magent "github.com/Dataman-Cloud/swan/mesos/agent"
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+// EvalCache memoizes the results of the constraints matching the agents within one
+// scheduling cycle, eg: the groups of a high-replica app evaluated against the same offers.
+// the result is keyed by the constraint and the agent's current offers, so it misses once
+// the offers changed. the placement constraints depends on the tasks placed meanwhile, they
+// are evaluated by filterByPlacements every time and never cached.
+type EvalCache struct {
+	sync.Mutex
+	results map[evalKey]bool
+	evals   int // nb of the actual evaluations
+}
+
+type evalKey struct {
+	cons   *types.Constraint
+	offers string
+}
+
+func NewEvalCache() *EvalCache {
+	return &EvalCache{
+		results: make(map[evalKey]bool),
+	}
+}
+
+// Evals returns the nb of the actual evaluations, excluding the cached ones
+func (c *EvalCache) Evals() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.evals
+}
+
+// match tells if the attributes of the agent holding the offers satisfy the constraint,
+// evaluated every time if the cache is nil
+func (c *EvalCache) match(cons *types.Constraint, offers string, attrs map[string]string) bool {
+	if c == nil {
+		return cons.Match(attrs)
+	}
+
+	key := evalKey{cons, offers}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if ok, hit := c.results[key]; hit {
+		return ok
+	}
+
+	ok := cons.Match(attrs)
+	c.results[key] = ok
+	c.evals++
+	return ok
+}
+
+// offersKey identifies the agent's current offers, the attributes of the agent
+// including the resources derived ones are determined by the offers.
+func offersKey(agent *magent.Agent) string {
+	offers := agent.GetOffers()
+
+	ids := make([]string, 0, len(offers))
+	for _, offer := range offers {
+		ids = append(ids, offer.GetId())
+	}
+	sort.Strings(ids)
+
+	return agent.ID() + "/" + strings.Join(ids, ",")
+}
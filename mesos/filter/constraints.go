@@ -2,14 +2,36 @@ package filter
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
 
 	magent "github.com/Dataman-Cloud/swan/mesos/agent"
+	"github.com/Dataman-Cloud/swan/types"
 )
 
 var (
 	errNoSatisfiedAgent = errors.New("no satisfied agent")
 )
 
+// logRejections is 1 if the offers rejected by the constraints are logged, atomic
+var logRejections int32
+
+// SetLogRejections enables the logs of the offers rejected by the hard constraints, one line per
+// rejected agent with the app, host and the failed constraint. it's off by default as the offers
+// are evaluated again on every retry, which floods the logs.
+func SetLogRejections(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&logRejections, v)
+}
+
 type constraintsFilter struct{}
 
 func NewConstraintsFilter() *constraintsFilter {
@@ -19,16 +41,29 @@ func NewConstraintsFilter() *constraintsFilter {
 func (f *constraintsFilter) Filter(opts *FilterOptions, agents []*magent.Agent) ([]*magent.Agent, error) {
 	var (
 		constraints = opts.Constraints
+		cache       = opts.Cache
 		candidates  = make([]*magent.Agent, 0)
+		known       = make(map[string]bool) // all of attribute names known by agents
 	)
 
 	for _, agent := range agents {
-		match := true
+		attrs := attributes(agent)
+		for name := range attrs {
+			known[name] = true
+		}
+
+		var (
+			match  = true
+			offers = offersKey(agent)
+		)
 		for _, constraint := range constraints {
-			if constraint.Match(agent.Attributes()) {
+			if constraint.Prefer || cache.match(constraint, offers, attrs) {
 				continue
 			}
 			match = false
+			if atomic.LoadInt32(&logRejections) == 1 {
+				logRejection(opts.AppID, agent, attrs, constraint)
+			}
 			break
 		}
 
@@ -37,8 +72,101 @@ func (f *constraintsFilter) Filter(opts *FilterOptions, agents []*magent.Agent)
 		}
 	}
 
+	// apply placement constraints on the matched agents
+	for _, constraint := range constraints {
+		if len(candidates) == 0 {
+			break
+		}
+		if constraint.NeedPlacements() {
+			candidates = filterByPlacements(constraint, opts, candidates)
+		}
+	}
+
+	// the most preferred agents go first
+	rank(cache, constraints, candidates)
+
 	if len(candidates) == 0 {
+		// tell the unknown attributes which are not defined on any of agents
+		for _, constraint := range constraints {
+			if !constraint.Prefer && !known[constraint.Attribute] {
+				return nil, fmt.Errorf("%v: attribute [%s] not found on any agent", errNoSatisfiedAgent, constraint.Attribute)
+			}
+		}
 		return nil, errNoSatisfiedAgent
 	}
 	return candidates, nil
 }
+
+// logRejection logs the agent's offers rejected by the constraint together with the reason
+func logRejection(app string, agent *magent.Agent, attrs map[string]string, constraint *types.Constraint) {
+	_, reason := constraint.Explain(attrs)
+	log.WithFields(log.Fields{
+		"app":        app,
+		"agent":      agent.ID(),
+		"host":       attrs["hostname"],
+		"offers":     len(agent.GetOffers()),
+		"constraint": fmt.Sprintf("%s %s %q", constraint.Attribute, constraint.Operator, constraint.Value),
+		"reason":     reason,
+	}).Info("offer rejected by constraint")
+}
+
+// score returns the nb of preferred constraints satisfied by the attributes
+func score(cache *EvalCache, constraints []*types.Constraint, offers string, attrs map[string]string) int {
+	n := 0
+	for _, constraint := range constraints {
+		if constraint.Prefer && cache.match(constraint, offers, attrs) {
+			n++
+		}
+	}
+	return n
+}
+
+// rank sorts the agents by score of the preferred constraints in descending order,
+// the agents with the same score keep the original order.
+func rank(cache *EvalCache, constraints []*types.Constraint, agents []*magent.Agent) {
+	scores := make(map[string]int, len(agents))
+	for _, agent := range agents {
+		scores[agent.ID()] = score(cache, constraints, offersKey(agent), attributes(agent))
+	}
+
+	sort.SliceStable(agents, func(i, j int) bool {
+		return scores[agents[i].ID()] > scores[agents[j].ID()]
+	})
+}
+
+// attributes returns the agent attributes for evaluating constraints, the
+// agent's avaliable resources are added as extra numeric attributes:
+// cpus, mem, disk and ports(nb of avaliable ports), together with the
+// comma separated roles and reservation labels(key=value) of the resources.
+// gpus is added if the gpus resource offered, or else the numeric `gpu` attribute.
+// the agent attributes with the same name take precedence, eg: disk:ssd
+func attributes(agent *magent.Agent) map[string]string {
+	var (
+		attrs                  = agent.Attributes()
+		cpus, mem, disk, ports = agent.Resources()
+		roles, labels          = agent.Reservations()
+	)
+
+	resources := map[string]string{
+		"cpus":        strconv.FormatFloat(cpus, 'f', -1, 64),
+		"mem":         strconv.FormatFloat(mem, 'f', -1, 64),
+		"disk":        strconv.FormatFloat(disk, 'f', -1, 64),
+		"ports":       strconv.Itoa(len(ports)),
+		"roles":       strings.Join(roles, ","),
+		"reservation": strings.Join(labels, ","),
+	}
+
+	if gpus, ok := agent.Gpus(); ok {
+		resources["gpus"] = strconv.FormatFloat(gpus, 'f', -1, 64)
+	} else if _, err := strconv.ParseFloat(attrs["gpu"], 64); err == nil {
+		resources["gpus"] = attrs["gpu"]
+	}
+
+	for k, v := range resources {
+		if _, ok := attrs[k]; !ok {
+			attrs[k] = v
+		}
+	}
+
+	return attrs
+}
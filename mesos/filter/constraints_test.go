@@ -0,0 +1,1080 @@
+package filter
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/golang/protobuf/proto"
+
+	magent "github.com/Dataman-Cloud/swan/mesos/agent"
+	"github.com/Dataman-Cloud/swan/mesosproto"
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+// newTestAgent build an agent with one offer carrying the given text attributes and resources
+func newTestAgent(id string, attrs map[string]string, resources ...*mesosproto.Resource) *magent.Agent {
+	offer := &mesosproto.Offer{
+		Id:        &mesosproto.OfferID{Value: proto.String("offer-" + id)},
+		AgentId:   &mesosproto.AgentID{Value: proto.String(id)},
+		Hostname:  proto.String(id),
+		Resources: resources,
+	}
+
+	for k, v := range attrs {
+		offer.Attributes = append(offer.Attributes, &mesosproto.Attribute{
+			Name: proto.String(k),
+			Type: mesosproto.Value_TEXT.Enum(),
+			Text: &mesosproto.Value_Text{Value: proto.String(v)},
+		})
+	}
+
+	agent := magent.NewAgent(id, id, offer.Attributes)
+	agent.AddOffer(magent.NewOffer(offer))
+	return agent
+}
+
+func newTestScalar(name string, value float64) *mesosproto.Resource {
+	return &mesosproto.Resource{
+		Name:   proto.String(name),
+		Type:   mesosproto.Value_SCALAR.Enum(),
+		Scalar: &mesosproto.Value_Scalar{Value: proto.Float64(value)},
+	}
+}
+
+func agentIDs(agents []*magent.Agent) []string {
+	ids := make([]string, 0)
+	for _, agent := range agents {
+		ids = append(ids, agent.ID())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestConstraintsFilterGroupBy(t *testing.T) {
+	var (
+		a1 = newTestAgent("a1", map[string]string{"rack": "r1"})
+		a2 = newTestAgent("a2", map[string]string{"rack": "r1"})
+		a3 = newTestAgent("a3", map[string]string{"rack": "r2"})
+		a4 = newTestAgent("a4", map[string]string{"rack": "r3"})
+
+		agents = []*magent.Agent{a1, a2, a3, a4}
+		r1     = map[string]string{"rack": "r1"}
+		r2     = map[string]string{"rack": "r2"}
+		r3     = map[string]string{"rack": "r3"}
+	)
+
+	tests := []struct {
+		name       string
+		cons       *types.Constraint
+		placements []map[string]string
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "no placements",
+			cons:       &types.Constraint{Attribute: "rack", Operator: "groupby", Value: ""},
+			placements: nil,
+			want:       []string{"a1", "a2", "a3", "a4"},
+		},
+		{
+			name:       "fewest tasks",
+			cons:       &types.Constraint{Attribute: "rack", Operator: "groupby", Value: ""},
+			placements: []map[string]string{r1, r2, r3, r1, r3},
+			want:       []string{"a3"},
+		},
+		{
+			name:       "even spread",
+			cons:       &types.Constraint{Attribute: "rack", Operator: "groupby", Value: ""},
+			placements: []map[string]string{r2, r3},
+			want:       []string{"a1", "a2"},
+		},
+		{
+			name:       "below limit",
+			cons:       &types.Constraint{Attribute: "rack", Operator: "groupby", Value: "2"},
+			placements: []map[string]string{r1, r2, r2, r3, r3},
+			want:       []string{"a1", "a2"},
+		},
+		{
+			name:       "all reach limit",
+			cons:       &types.Constraint{Attribute: "rack", Operator: "groupby", Value: "1"},
+			placements: []map[string]string{r1, r2, r3},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    1,
+				Constraints: []*types.Constraint{tt.cons},
+				Placements:  tt.placements,
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestConstraintsFilterMax(t *testing.T) {
+	var (
+		a1 = newTestAgent("a1", map[string]string{"rack": "r1"})
+		a2 = newTestAgent("a2", map[string]string{"rack": "r2"})
+
+		agents = []*magent.Agent{a1, a2}
+		r1     = map[string]string{"rack": "r1"}
+		r2     = map[string]string{"rack": "r2"}
+	)
+
+	tests := []struct {
+		name       string
+		cons       *types.Constraint
+		placements []map[string]string
+		replicas   int
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "below cap",
+			cons:       &types.Constraint{Attribute: "rack", Operator: "max", Value: "2"},
+			placements: []map[string]string{r1, r2, r2},
+			replicas:   1,
+			want:       []string{"a1"},
+		},
+		{
+			name:       "over cap with replicas",
+			cons:       &types.Constraint{Attribute: "rack", Operator: "max", Value: "2"},
+			placements: []map[string]string{r2},
+			replicas:   2,
+			want:       []string{"a1"},
+		},
+		{
+			name:       "all at cap",
+			cons:       &types.Constraint{Attribute: "rack", Operator: "max", Value: "2"},
+			placements: []map[string]string{r1, r1, r2, r2},
+			replicas:   1,
+			wantErr:    true,
+		},
+		{
+			name:       "unique",
+			cons:       &types.Constraint{Attribute: "rack", Operator: "unique"},
+			placements: []map[string]string{r2},
+			replicas:   1,
+			want:       []string{"a1"},
+		},
+		{
+			name:       "unique at cap",
+			cons:       &types.Constraint{Attribute: "rack", Operator: "unique"},
+			placements: []map[string]string{r1, r2},
+			replicas:   1,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    tt.replicas,
+				Constraints: []*types.Constraint{tt.cons},
+				Placements:  tt.placements,
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstraintsFilterMaxPerHost(t *testing.T) {
+	var (
+		h1 = newTestAgent("h1", nil)
+		h2 = newTestAgent("h2", nil)
+
+		agents = []*magent.Agent{h1, h2}
+		cons   = &types.Constraint{Attribute: "hostname", Operator: "max", Value: "3"}
+		p1     = h1.Attributes()
+		p2     = h2.Attributes()
+	)
+
+	tests := []struct {
+		name       string
+		placements []map[string]string
+		replicas   int
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "below cap",
+			placements: []map[string]string{p1, p1, p2},
+			replicas:   1,
+			want:       []string{"h1", "h2"},
+		},
+		{
+			name:       "reaching cap",
+			placements: []map[string]string{p1, p2, p2},
+			replicas:   2,
+			want:       []string{"h1"},
+		},
+		{
+			name:       "at cap",
+			placements: []map[string]string{p1, p1, p1, p2},
+			replicas:   1,
+			want:       []string{"h2"},
+		},
+		{
+			name:       "above cap",
+			placements: []map[string]string{p1, p1, p1, p1, p2, p2, p2},
+			replicas:   1,
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    tt.replicas,
+				Constraints: []*types.Constraint{cons},
+				Placements:  tt.placements,
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	var (
+		a1 = newTestAgent("a1", map[string]string{"rack": "r1", "vcluster": "dataman"})
+		a2 = newTestAgent("a2", map[string]string{"rack": "r2"})
+	)
+
+	opts := &FilterOptions{
+		Replicas: 1,
+		Constraints: []*types.Constraint{
+			{Attribute: "vcluster", Operator: "==", Value: "dataman"},
+			{Attribute: "rack", Operator: "==", Value: "r2"},
+		},
+	}
+
+	exps := Explain(opts, []*magent.Agent{a1, a2})
+	if len(exps) != 2 {
+		t.Fatalf("Explain() got %d explanations, want 2", len(exps))
+	}
+
+	tests := []struct {
+		name   string
+		exp    *Explanation
+		passed []bool
+		reason []string
+	}{
+		{
+			name:   "second constraint failed",
+			exp:    exps[0],
+			passed: []bool{true, false},
+			reason: []string{"", `attribute [rack] value "r1" not satisfy [== "r2"]`},
+		},
+		{
+			name:   "first constraint failed",
+			exp:    exps[1],
+			passed: []bool{false, true},
+			reason: []string{"attribute [vcluster] not found", ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.exp.Passed {
+				t.Errorf("Explain() agent %s passed, want rejected", tt.exp.AgentID)
+			}
+			for i, trace := range tt.exp.Traces {
+				if trace.Passed != tt.passed[i] || trace.Reason != tt.reason[i] {
+					t.Errorf("Explain() traces[%d] = [%v %q], want [%v %q]",
+						i, trace.Passed, trace.Reason, tt.passed[i], tt.reason[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	agents := []*magent.Agent{
+		newTestAgent("a1", map[string]string{"rack": "r1"}),
+		newTestAgent("a2", map[string]string{"rack": "r2"}),
+		newTestAgent("a3", map[string]string{"rack": "r1"}),
+	}
+
+	tests := []struct {
+		name      string
+		cons      *types.Constraint
+		wantHosts []string
+	}{
+		{
+			name:      "matching",
+			cons:      &types.Constraint{Attribute: "rack", Operator: "==", Value: "r1"},
+			wantHosts: []string{"a1", "a3"},
+		},
+		{
+			name:      "non-matching",
+			cons:      &types.Constraint{Attribute: "rack", Operator: "==", Value: "r9"},
+			wantHosts: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Evaluate(&FilterOptions{Replicas: 1, Constraints: []*types.Constraint{tt.cons}}, agents)
+			if got.Count != len(tt.wantHosts) || !equalStrings(got.Hostnames, tt.wantHosts) {
+				t.Errorf("Evaluate() = %d %v, want %v", got.Count, got.Hostnames, tt.wantHosts)
+			}
+			if len(got.Agents) != len(agents) {
+				t.Errorf("Evaluate() explained %d agents, want %d", len(got.Agents), len(agents))
+			}
+		})
+	}
+}
+
+func TestConstraintsFilterConjunction(t *testing.T) {
+	var (
+		a1 = newTestAgent("a1", map[string]string{"rack": "r1", "vcluster": "dataman", "disk": "ssd"})
+		a2 = newTestAgent("a2", map[string]string{"rack": "r1", "vcluster": "dataman", "disk": "hdd"})
+		a3 = newTestAgent("a3", map[string]string{"rack": "r2", "vcluster": "dataman", "disk": "ssd"})
+
+		agents = []*magent.Agent{a1, a2, a3}
+	)
+
+	tests := []struct {
+		name    string
+		cs      []*types.Constraint
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "three operands",
+			cs: []*types.Constraint{
+				{Attribute: "vcluster", Operator: "==", Value: "dataman"},
+				{Attribute: "disk", Operator: "==", Value: "ssd"},
+				{Attribute: "hostname", Operator: "in", Value: "a1,a3"},
+			},
+			want: []string{"a1", "a3"},
+		},
+		{
+			name: "four operands",
+			cs: []*types.Constraint{
+				{Attribute: "vcluster", Operator: "==", Value: "dataman"},
+				{Attribute: "disk", Operator: "==", Value: "ssd"},
+				{Attribute: "hostname", Operator: "in", Value: "a1,a3"},
+				{Attribute: "rack", Operator: "!=", Value: "r1"},
+			},
+			want: []string{"a3"},
+		},
+		{
+			name: "four operands unsatisfied",
+			cs: []*types.Constraint{
+				{Attribute: "vcluster", Operator: "==", Value: "dataman"},
+				{Attribute: "disk", Operator: "==", Value: "hdd"},
+				{Attribute: "agentid", Operator: "notin", Value: "a2"},
+				{Attribute: "rack", Operator: "~=", Value: "^r"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    1,
+				Constraints: tt.cs,
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstraintsFilterResources(t *testing.T) {
+	var (
+		a1 = newTestAgent("a1", nil, newTestScalar("cpus", 2), newTestScalar("mem", 4096))
+		a2 = newTestAgent("a2", nil, newTestScalar("cpus", 8), newTestScalar("mem", 16384))
+
+		agents = []*magent.Agent{a1, a2}
+	)
+
+	tests := []struct {
+		name    string
+		cs      []*types.Constraint
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "cpus above threshold",
+			cs:   []*types.Constraint{{Attribute: "cpus", Operator: ">=", Value: "4"}},
+			want: []string{"a2"},
+		},
+		{
+			name: "mem below threshold",
+			cs:   []*types.Constraint{{Attribute: "mem", Operator: "<", Value: "8192"}},
+			want: []string{"a1"},
+		},
+		{
+			name: "both roomy",
+			cs: []*types.Constraint{
+				{Attribute: "cpus", Operator: ">", Value: "1.5"},
+				{Attribute: "mem", Operator: "<=", Value: "16384"},
+			},
+			want: []string{"a1", "a2"},
+		},
+		{
+			name:    "none roomy",
+			cs:      []*types.Constraint{{Attribute: "mem", Operator: ">=", Value: "32768"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    1,
+				Constraints: tt.cs,
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstraintsFilterExists(t *testing.T) {
+	var (
+		a1 = newTestAgent("a1", map[string]string{"fpga": "xilinx"})
+		a2 = newTestAgent("a2", map[string]string{"rack": "r2"})
+
+		agents = []*magent.Agent{a1, a2}
+	)
+
+	tests := []struct {
+		name string
+		cons *types.Constraint
+		want []string
+	}{
+		{
+			name: "exists",
+			cons: &types.Constraint{Attribute: "fpga", Operator: "exists"},
+			want: []string{"a1"},
+		},
+		{
+			name: "notexists",
+			cons: &types.Constraint{Attribute: "fpga", Operator: "notexists"},
+			want: []string{"a2"},
+		},
+		{
+			name: "notexists on none",
+			cons: &types.Constraint{Attribute: "gpu", Operator: "notexists"},
+			want: []string{"a1", "a2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    1,
+				Constraints: []*types.Constraint{tt.cons},
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if err != nil {
+				t.Fatalf("Filter() error = %v", err)
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstraintsFilterPin(t *testing.T) {
+	var (
+		s1  = newTestAgent("5e7a-S1", nil)
+		s10 = newTestAgent("5e7a-S10", nil)
+		s2  = newTestAgent("5e7a-S2", map[string]string{"agentid": "5e7a-S3"}) // the real id wins
+
+		agents = []*magent.Agent{s1, s10, s2}
+	)
+
+	tests := []struct {
+		name string
+		cons *types.Constraint
+		want []string
+	}{
+		{name: "in the set", cons: &types.Constraint{Attribute: "agentid", Operator: "pin", Value: "5e7a-S1,5e7a-S2"}, want: []string{"5e7a-S1", "5e7a-S2"}},
+		{name: "exact id", cons: &types.Constraint{Attribute: "agentid", Operator: "pin", Value: "5e7a-S10"}, want: []string{"5e7a-S10"}},
+		{name: "not regexp", cons: &types.Constraint{Attribute: "agentid", Operator: "pin", Value: "5e7a-S.*"}, want: nil},
+		{name: "out of the set", cons: &types.Constraint{Attribute: "agentid", Operator: "pin", Value: "5e7a-S3,5e7a-S4"}, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    1,
+				Constraints: []*types.Constraint{tt.cons},
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if tt.want == nil {
+				if err != errNoSatisfiedAgent {
+					t.Fatalf("Filter() error = %v, want %v", err, errNoSatisfiedAgent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Filter() error = %v", err)
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstraintsFilterLogRejections(t *testing.T) {
+	var (
+		buf    bytes.Buffer
+		logger = log.StandardLogger()
+		out    = logger.Out
+	)
+	log.SetOutput(&buf)
+	defer func() {
+		log.SetOutput(out)
+		SetLogRejections(false)
+	}()
+
+	var (
+		a1 = newTestAgent("a1", map[string]string{"rack": "r1"})
+		a2 = newTestAgent("a2", map[string]string{"rack": "r2"})
+
+		opts = &FilterOptions{
+			AppID:       "web.default.bbk.dataman",
+			Replicas:    1,
+			Constraints: []*types.Constraint{{Attribute: "rack", Operator: "==", Value: "r1"}},
+		}
+	)
+
+	// off by default
+	if _, err := NewConstraintsFilter().Filter(opts, []*magent.Agent{a1, a2}); err != nil {
+		t.Fatalf("Filter() error = %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("rejection logged while disabled: %s", buf.String())
+	}
+
+	SetLogRejections(true)
+	if _, err := NewConstraintsFilter().Filter(opts, []*magent.Agent{a1, a2}); err != nil {
+		t.Fatalf("Filter() error = %v", err)
+	}
+
+	// only the rejected one is logged
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want 1: %s", len(lines), buf.String())
+	}
+	for _, want := range []string{"offer rejected by constraint", "app=web.default.bbk.dataman", "host=a2", `constraint="rack == \"r1\""`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("rejection log %s, want containing %s", lines[0], want)
+		}
+	}
+}
+
+func TestConstraintsFilterAttributeTypes(t *testing.T) {
+	// the agent with the typed attributes besides the text rack
+	typed := func(id string, attrs ...*mesosproto.Attribute) *magent.Agent {
+		attrs = append(attrs, &mesosproto.Attribute{
+			Name: proto.String("rack"),
+			Type: mesosproto.Value_TEXT.Enum(),
+			Text: &mesosproto.Value_Text{Value: proto.String("r-" + id)},
+		})
+
+		agent := magent.NewAgent(id, id, attrs)
+		agent.AddOffer(magent.NewOffer(&mesosproto.Offer{
+			Id:         &mesosproto.OfferID{Value: proto.String("offer-" + id)},
+			AgentId:    &mesosproto.AgentID{Value: proto.String(id)},
+			Hostname:   proto.String(id),
+			Attributes: attrs,
+		}))
+		return agent
+	}
+
+	scalar := func(name string, v float64) *mesosproto.Attribute {
+		return &mesosproto.Attribute{
+			Name:   proto.String(name),
+			Type:   mesosproto.Value_SCALAR.Enum(),
+			Scalar: &mesosproto.Value_Scalar{Value: proto.Float64(v)},
+		}
+	}
+
+	ranges := func(name string, rs ...uint64) *mesosproto.Attribute {
+		attr := &mesosproto.Attribute{
+			Name:   proto.String(name),
+			Type:   mesosproto.Value_RANGES.Enum(),
+			Ranges: &mesosproto.Value_Ranges{},
+		}
+		for i := 0; i+1 < len(rs); i += 2 {
+			attr.Ranges.Range = append(attr.Ranges.Range, &mesosproto.Value_Range{Begin: proto.Uint64(rs[i]), End: proto.Uint64(rs[i+1])})
+		}
+		return attr
+	}
+
+	var (
+		a1 = typed("a1", scalar("cores", 16), ranges("vlans", 100, 200, 300, 300))
+		a2 = typed("a2", scalar("cores", 4.5), ranges("vlans", 500, 600))
+
+		agents = []*magent.Agent{a1, a2}
+	)
+
+	tests := []struct {
+		name string
+		cons *types.Constraint
+		want []string
+	}{
+		{name: "scalar compared", cons: &types.Constraint{Attribute: "cores", Operator: ">=", Value: "8"}, want: []string{"a1"}},
+		{name: "scalar between", cons: &types.Constraint{Attribute: "cores", Operator: "between", Value: "4,5"}, want: []string{"a2"}},
+		{name: "scalar formatted", cons: &types.Constraint{Attribute: "cores", Operator: "==", Value: "4.5"}, want: []string{"a2"}},
+		{name: "ranges has", cons: &types.Constraint{Attribute: "vlans", Operator: "has", Value: "300-300"}, want: []string{"a1"}},
+		{name: "ranges formatted", cons: &types.Constraint{Attribute: "vlans", Operator: "==", Value: "100-200,300-300"}, want: []string{"a1"}},
+		{name: "ranges like", cons: &types.Constraint{Attribute: "vlans", Operator: "~=", Value: "^5"}, want: []string{"a2"}},
+		{name: "text unchanged", cons: &types.Constraint{Attribute: "rack", Operator: "==", Value: "r-a2"}, want: []string{"a2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    1,
+				Constraints: []*types.Constraint{tt.cons},
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if err != nil {
+				t.Fatalf("Filter() error = %v", err)
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstraintsFilterGpus(t *testing.T) {
+	var (
+		g4 = newTestAgent("g4", nil, newTestScalar("cpus", 8), newTestScalar("gpus", 4))
+		g1 = newTestAgent("g1", nil, newTestScalar("cpus", 8), newTestScalar("gpus", 1))
+		g0 = newTestAgent("g0", nil, newTestScalar("cpus", 8))
+		ga = newTestAgent("ga", map[string]string{"gpu": "2"}, newTestScalar("cpus", 8))
+
+		agents = []*magent.Agent{g4, g1, g0, ga}
+	)
+
+	tests := []struct {
+		name    string
+		cs      []*types.Constraint
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "any gpu",
+			cs:   []*types.Constraint{{Attribute: "gpus", Operator: ">=", Value: "1"}},
+			want: []string{"g1", "g4", "ga"},
+		},
+		{
+			name: "enough gpus",
+			cs:   []*types.Constraint{{Attribute: "gpus", Operator: ">=", Value: "2"}},
+			want: []string{"g4", "ga"},
+		},
+		{
+			name: "gpu-less excluded even by less than",
+			cs:   []*types.Constraint{{Attribute: "gpus", Operator: "<", Value: "2"}},
+			want: []string{"g1"},
+		},
+		{
+			name:    "not enough gpus",
+			cs:      []*types.Constraint{{Attribute: "gpus", Operator: ">", Value: "4"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    1,
+				Constraints: tt.cs,
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstraintsFilterReservations(t *testing.T) {
+	reserved := newTestScalar("cpus", 2)
+	reserved.Role = proto.String("prod")
+	reserved.Reservation = &mesosproto.Resource_ReservationInfo{
+		Labels: &mesosproto.Labels{
+			Labels: []*mesosproto.Label{{Key: proto.String("team"), Value: proto.String("search")}},
+		},
+	}
+
+	var (
+		a1 = newTestAgent("a1", nil, reserved, newTestScalar("mem", 4096))
+		a2 = newTestAgent("a2", nil, newTestScalar("cpus", 2), newTestScalar("mem", 4096))
+		a3 = newTestAgent("a3", map[string]string{"roles": "prod"}, newTestScalar("cpus", 2))
+
+		agents = []*magent.Agent{a1, a2, a3}
+	)
+
+	tests := []struct {
+		name    string
+		cs      []*types.Constraint
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "reserved role",
+			cs:   []*types.Constraint{{Attribute: "roles", Operator: "has", Value: "prod"}},
+			want: []string{"a1", "a3"},
+		},
+		{
+			name: "unreserved role",
+			cs:   []*types.Constraint{{Attribute: "roles", Operator: "has", Value: "*"}},
+			want: []string{"a1", "a2"},
+		},
+		{
+			name: "reservation label",
+			cs:   []*types.Constraint{{Attribute: "reservation", Operator: "has", Value: "team=search"}},
+			want: []string{"a1"},
+		},
+		{
+			name:    "reservation label mismatch",
+			cs:      []*types.Constraint{{Attribute: "reservation", Operator: "has", Value: "team=ads"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    1,
+				Constraints: tt.cs,
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstraintsFilterAvoid(t *testing.T) {
+	var (
+		a1 = newTestAgent("a1", map[string]string{"rack": "r1"})
+		a2 = newTestAgent("a2", map[string]string{"rack": "r1"})
+		a3 = newTestAgent("a3", map[string]string{"rack": "r2"})
+
+		agents = []*magent.Agent{a1, a2, a3}
+	)
+
+	tests := []struct {
+		name    string
+		cons    *types.Constraint
+		avoids  map[string][]map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "conflict on host",
+			cons:   &types.Constraint{Attribute: "hostname", Operator: "avoid", Value: "appA"},
+			avoids: map[string][]map[string]string{"appA": {{"hostname": "a1", "rack": "r1"}}},
+			want:   []string{"a2", "a3"},
+		},
+		{
+			name:   "conflict on rack",
+			cons:   &types.Constraint{Attribute: "rack", Operator: "avoid", Value: "appA"},
+			avoids: map[string][]map[string]string{"appA": {{"hostname": "a1", "rack": "r1"}}},
+			want:   []string{"a3"},
+		},
+		{
+			name:   "referenced app not exists",
+			cons:   &types.Constraint{Attribute: "hostname", Operator: "avoid", Value: "appB"},
+			avoids: map[string][]map[string]string{"appA": {{"hostname": "a1", "rack": "r1"}}},
+			want:   []string{"a1", "a2", "a3"},
+		},
+		{
+			name:    "conflict on all racks",
+			cons:    &types.Constraint{Attribute: "rack", Operator: "avoid", Value: "appA"},
+			avoids:  map[string][]map[string]string{"appA": {{"rack": "r1"}, {"rack": "r2"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    1,
+				Constraints: []*types.Constraint{tt.cons},
+				Avoids:      tt.avoids,
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Filter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstraintsFilterPrefer(t *testing.T) {
+	var (
+		a1 = newTestAgent("a1", map[string]string{"rack": "rack-b", "vcluster": "dataman"})
+		a2 = newTestAgent("a2", map[string]string{"rack": "rack-a", "vcluster": "dataman"})
+		a3 = newTestAgent("a3", map[string]string{"rack": "rack-a", "vcluster": "dataman", "disk": "ssd"})
+		a4 = newTestAgent("a4", map[string]string{"rack": "rack-a", "vcluster": "dev", "disk": "ssd"})
+
+		agents = []*magent.Agent{a1, a2, a3, a4}
+	)
+
+	tests := []struct {
+		name  string
+		cs    []*types.Constraint
+		want  []string
+		first string
+	}{
+		{
+			name: "preferred rack",
+			cs: []*types.Constraint{
+				{Attribute: "vcluster", Operator: "==", Value: "dataman"},
+				{Attribute: "rack", Operator: "~=", Value: "^rack-a", Prefer: true},
+			},
+			want:  []string{"a1", "a2", "a3"},
+			first: "a2",
+		},
+		{
+			name: "most preferred",
+			cs: []*types.Constraint{
+				{Attribute: "vcluster", Operator: "==", Value: "dataman"},
+				{Attribute: "rack", Operator: "==", Value: "rack-a", Prefer: true},
+				{Attribute: "disk", Operator: "==", Value: "ssd", Prefer: true},
+			},
+			want:  []string{"a1", "a2", "a3"},
+			first: "a3",
+		},
+		{
+			name: "none preferred",
+			cs: []*types.Constraint{
+				{Attribute: "vcluster", Operator: "==", Value: "dataman"},
+				{Attribute: "zone", Operator: "==", Value: "bj", Prefer: true},
+			},
+			want:  []string{"a1", "a2", "a3"},
+			first: "a1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &FilterOptions{
+				Replicas:    1,
+				Constraints: tt.cs,
+			}
+
+			got, err := NewConstraintsFilter().Filter(opts, agents)
+			if err != nil {
+				t.Fatalf("Filter() error = %v", err)
+			}
+
+			if got[0].ID() != tt.first {
+				t.Errorf("Filter() first = %s, want %s", got[0].ID(), tt.first)
+			}
+
+			if ids := agentIDs(got); !equalStrings(ids, tt.want) {
+				t.Errorf("Filter() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestConstraintsFilterCache(t *testing.T) {
+	var (
+		a1 = newTestAgent("a1", map[string]string{"rack": "r1", "disk": "ssd"})
+		a2 = newTestAgent("a2", map[string]string{"rack": "r2", "disk": "hdd"})
+
+		agents = []*magent.Agent{a1, a2}
+		cache  = NewEvalCache()
+		opts   = &FilterOptions{
+			Replicas: 1,
+			Constraints: []*types.Constraint{
+				{Attribute: "disk", Operator: "==", Value: "ssd"},
+				{Attribute: "rack", Operator: "==", Value: "r1", Prefer: true},
+			},
+			Cache: cache,
+		}
+	)
+
+	for i := 0; i < 10; i++ {
+		got, err := NewConstraintsFilter().Filter(opts, agents)
+		if err != nil {
+			t.Fatalf("Filter() error = %v", err)
+		}
+		if ids := agentIDs(got); !equalStrings(ids, []string{"a1"}) {
+			t.Fatalf("Filter() = %v, want [a1]", ids)
+		}
+	}
+
+	// the hard one against both agents, the preferred one against the candidate a1
+	if got := cache.Evals(); got != 3 {
+		t.Errorf("Evals() = %d, want 3", got)
+	}
+
+	// the changed offers are evaluated again
+	a1.AddOffer(magent.NewOffer(&mesosproto.Offer{
+		Id:       &mesosproto.OfferID{Value: proto.String("offer-a1-2")},
+		AgentId:  &mesosproto.AgentID{Value: proto.String("a1")},
+		Hostname: proto.String("a1"),
+	}))
+	if _, err := NewConstraintsFilter().Filter(opts, agents); err != nil {
+		t.Fatalf("Filter() error = %v", err)
+	}
+	if got := cache.Evals(); got != 5 {
+		t.Errorf("Evals() after offers changed = %d, want 5", got)
+	}
+}
+
+func BenchmarkConstraintsFilter(b *testing.B) {
+	agents := make([]*magent.Agent, 0, 100)
+	for i := 0; i < 100; i++ {
+		id := "a" + strconv.Itoa(i)
+		agents = append(agents, newTestAgent(id, map[string]string{
+			"rack": "r" + strconv.Itoa(i%10),
+			"zone": strconv.Itoa(i % 5),
+		}))
+	}
+
+	cs := []*types.Constraint{
+		{Attribute: "hostname", Operator: "~=", Value: "^a[0-9]+$"},
+		{Attribute: "zone", Operator: "between", Value: "1,3"},
+		{Attribute: "rack", Operator: "in", Value: "r1,r2,r3,r6,r7,r8"},
+	}
+
+	// one scheduling cycle of an app with 1000 replicas, launched in groups of 10
+	for _, cached := range []bool{false, true} {
+		name := "uncached"
+		if cached {
+			name = "cached"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				opts := &FilterOptions{Replicas: 10, Constraints: cs}
+				if cached {
+					opts.Cache = NewEvalCache()
+				}
+
+				for i := 0; i < 100; i++ {
+					if _, err := NewConstraintsFilter().Filter(opts, agents); err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				if cached && n == 0 {
+					// vs 100 groups * 100 agents * up to 3 constraints uncached
+					b.Logf("evaluated %d times", opts.Cache.Evals())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+
+	magent "github.com/Dataman-Cloud/swan/mesos/agent"
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+// Explanation records the evaluation trace of the constraints against one agent
+type Explanation struct {
+	AgentID  string   `json:"agent_id"`
+	Hostname string   `json:"hostname"`
+	Passed   bool     `json:"passed"`
+	Score    int      `json:"score"` // nb of satisfied preferred constraints
+	Traces   []*Trace `json:"traces"`
+}
+
+// Trace records the evaluation result of one constraint
+type Trace struct {
+	Constraint *types.Constraint `json:"constraint"`
+	Passed     bool              `json:"passed"`
+	Reason     string            `json:"reason,omitempty"`
+}
+
+// Evaluation is the result of evaluating the constraints against the agents
+type Evaluation struct {
+	Count     int            `json:"count"`     // nb of matched agents
+	Hostnames []string       `json:"hostnames"` // hostnames of the matched agents
+	Agents    []*Explanation `json:"agents"`
+}
+
+// Evaluate tells which of the agents match the constraints, with the explanations
+func Evaluate(opts *FilterOptions, agents []*magent.Agent) *Evaluation {
+	ret := &Evaluation{
+		Hostnames: make([]string, 0),
+		Agents:    Explain(opts, agents),
+	}
+
+	for _, exp := range ret.Agents {
+		if exp.Passed {
+			ret.Hostnames = append(ret.Hostnames, exp.Hostname)
+		}
+	}
+	sort.Strings(ret.Hostnames)
+	ret.Count = len(ret.Hostnames)
+
+	return ret
+}
+
+// Explain evaluates the constraints against each of the agents the same way as
+// the constraints filter, and tells which constraint rejected which agent and why.
+// it's much more expensive than Filter, only used for diagnosing placement failures.
+func Explain(opts *FilterOptions, agents []*magent.Agent) []*Explanation {
+	var (
+		ret        = make([]*Explanation, 0, len(agents))
+		candidates = make([]*magent.Agent, 0)
+		byAgent    = make(map[string]*Explanation)
+	)
+
+	for _, agent := range agents {
+		attrs := attributes(agent)
+
+		exp := &Explanation{
+			AgentID:  agent.ID(),
+			Hostname: attrs["hostname"],
+			Passed:   true,
+			Traces:   make([]*Trace, 0, len(opts.Constraints)),
+		}
+
+		for _, constraint := range opts.Constraints {
+			passed, reason := constraint.Explain(attrs)
+			switch {
+			case constraint.Prefer && passed:
+				exp.Score++
+			case !constraint.Prefer && !passed:
+				exp.Passed = false
+			}
+			exp.Traces = append(exp.Traces, &Trace{constraint, passed, reason})
+		}
+
+		if exp.Passed {
+			candidates = append(candidates, agent)
+		}
+
+		ret = append(ret, exp)
+		byAgent[agent.ID()] = exp
+	}
+
+	// apply placement constraints on the matched agents
+	for idx, constraint := range opts.Constraints {
+		if !constraint.NeedPlacements() || len(candidates) == 0 {
+			continue
+		}
+
+		var (
+			accepted = filterByPlacements(constraint, opts, candidates)
+			kept     = make(map[string]bool)
+			counts   = countBy(constraint.Attribute, opts.Placements)
+		)
+
+		if app := constraint.AvoidApp(); app != "" {
+			counts = countBy(constraint.Attribute, opts.Avoids[app])
+		}
+
+		for _, agent := range accepted {
+			kept[agent.ID()] = true
+		}
+
+		for _, agent := range candidates {
+			if kept[agent.ID()] {
+				continue
+			}
+
+			v := agent.Attributes()[constraint.Attribute]
+
+			exp := byAgent[agent.ID()]
+			exp.Passed = false
+			exp.Traces[idx].Passed = false
+			exp.Traces[idx].Reason = fmt.Sprintf("attribute [%s] value %q already holds %d tasks", constraint.Attribute, v, counts[v])
+		}
+
+		candidates = accepted
+	}
+
+	return ret
+}
@@ -10,12 +10,26 @@ type Filter interface {
 }
 
 type FilterOptions struct {
+	// id of the app to be placed, optional, for logging only
+	AppID string
+
 	// resources requirements
 	ResRequired types.ResourcesRequired
 	Replicas    int
 
 	// constraints
 	Constraints []*types.Constraint
+
+	// attributes of the agents which are current running the app's tasks,
+	// one item per task. required by placement constraints, eg: groupby
+	Placements []map[string]string
+
+	// attributes of the agents which are current running the referenced apps' tasks,
+	// keyed by app id. required by avoid constraints
+	Avoids map[string][]map[string]string
+
+	// memoizes the constraints evaluation within the scheduling cycle, optional
+	Cache *EvalCache
 }
 
 // the returned agents contains at least one proper agent
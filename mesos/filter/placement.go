@@ -0,0 +1,96 @@
+package filter
+
+import (
+	magent "github.com/Dataman-Cloud/swan/mesos/agent"
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+// filterByPlacements filter agents according by the app's current task placements
+func filterByPlacements(c *types.Constraint, opts *FilterOptions, agents []*magent.Agent) []*magent.Agent {
+	switch c.Operator {
+	case "groupby":
+		return groupBy(c, opts, agents)
+	case "max", "unique":
+		return maxPer(c, opts, agents)
+	case "avoid":
+		return avoid(c, opts, agents)
+	}
+	return agents
+}
+
+// groupBy prefers the agents whose attribute value currently holds the fewest
+// tasks of the app, so tasks could be evenly spread across the attribute values.
+// the attribute values which already reach the limit will be excluded.
+func groupBy(c *types.Constraint, opts *FilterOptions, agents []*magent.Agent) []*magent.Agent {
+	var (
+		counts     = countBy(c.Attribute, opts.Placements)
+		limit      = c.Limit()
+		min        = -1
+		candidates = make([]*magent.Agent, 0)
+	)
+
+	for _, agent := range agents {
+		n := counts[agent.Attributes()[c.Attribute]]
+
+		if limit > 0 && n+opts.Replicas > limit {
+			continue
+		}
+
+		if min == -1 || n < min {
+			min = n
+			candidates = candidates[:0]
+		}
+
+		if n == min {
+			candidates = append(candidates, agent)
+		}
+	}
+
+	return candidates
+}
+
+// maxPer excludes the agents whose attribute value would hold more than
+// the limit of the app's tasks after placement, `unique` is the case of limit 1.
+func maxPer(c *types.Constraint, opts *FilterOptions, agents []*magent.Agent) []*magent.Agent {
+	var (
+		counts     = countBy(c.Attribute, opts.Placements)
+		limit      = c.Limit()
+		candidates = make([]*magent.Agent, 0)
+	)
+
+	for _, agent := range agents {
+		if counts[agent.Attributes()[c.Attribute]]+opts.Replicas <= limit {
+			candidates = append(candidates, agent)
+		}
+	}
+
+	return candidates
+}
+
+// avoid excludes the agents whose attribute value already holds any task of the
+// referenced app, the app which doesn't exist or without tasks has no conflict.
+func avoid(c *types.Constraint, opts *FilterOptions, agents []*magent.Agent) []*magent.Agent {
+	var (
+		counts     = countBy(c.Attribute, opts.Avoids[c.AvoidApp()])
+		candidates = make([]*magent.Agent, 0)
+	)
+
+	for _, agent := range agents {
+		if counts[agent.Attributes()[c.Attribute]] == 0 {
+			candidates = append(candidates, agent)
+		}
+	}
+
+	return candidates
+}
+
+// countBy counts the app's current tasks by the value of specified attribute
+func countBy(attribute string, placements []map[string]string) map[string]int {
+	counts := make(map[string]int)
+	for _, attrs := range placements {
+		if v, ok := attrs[attribute]; ok {
+			counts[v]++
+		}
+	}
+	return counts
+}
@@ -3,6 +3,7 @@ package mesos
 import (
 	"encoding/json"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	magent "github.com/Dataman-Cloud/swan/mesos/agent"
@@ -28,6 +29,7 @@ func (s *Scheduler) subscribedHandler(event *mesosproto.Event) {
 	log.Printf("Subscription successful with frameworkId %s", id.GetValue())
 
 	s.framework.Id = id
+	atomic.StoreInt32(&s.connected, 1)
 
 	if err := s.db.UpdateFrameworkId(id.GetValue()); err != nil {
 		log.Errorf("update frameworkid got error:%s", err)
@@ -281,6 +283,7 @@ func (s *Scheduler) updateHandler(event *mesosproto.Event) {
 					Weight:         task.Weight,
 					GatewayEnabled: proxyEnabled,
 				}
+				taskEv.SetDetails(task, ver)
 
 				log.Debugln("sending task changed event", taskId)
 				log.Debugln("event ->", taskEv)
@@ -296,10 +299,7 @@ func (s *Scheduler) updateHandler(event *mesosproto.Event) {
 		}
 	}
 
-	// FIXME enable reschedule only on app is running, creating, starting, scaling-up ...
-	switch ops {
-	case types.OpStatusNoop, types.OpStatusCreating, types.OpStatusStarting, types.OpStatusScalingUp:
-	default:
+	if !reschedulable(ops) {
 		return
 	}
 
@@ -357,3 +357,14 @@ func (s *Scheduler) failureHandler(event *mesosproto.Event) {
 
 func (s *Scheduler) messageHandler(event *mesosproto.Event) {
 }
+
+// reschedulable tells if the failed tasks of the app in the op status should be rescheduled.
+// FIXME enable reschedule only on app is running, creating, starting, scaling-up ...
+// the paused apps are not rescheduled until resumed.
+func reschedulable(ops string) bool {
+	switch ops {
+	case types.OpStatusNoop, types.OpStatusCreating, types.OpStatusStarting, types.OpStatusScalingUp:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+func TestReschedulable(t *testing.T) {
+	tests := []struct {
+		ops  string
+		want bool
+	}{
+		{ops: types.OpStatusNoop, want: true},
+		{ops: types.OpStatusScalingUp, want: true},
+		{ops: types.OpStatusUpdating},
+		{ops: types.OpStatusPaused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ops, func(t *testing.T) {
+			if got := reschedulable(tt.ops); got != tt.want {
+				t.Errorf("reschedulable(%s) = %v, want %v", tt.ops, got, tt.want)
+			}
+		})
+	}
+}
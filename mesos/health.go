@@ -54,6 +54,7 @@ func (s *Scheduler) FullTaskEventsAndRecords() []*types.CombinedEvents {
 				IP:     task.IP,
 				Weight: task.Weight,
 			}
+			taskEv.SetDetails(task, ver)
 
 			if ver.Proxy != nil {
 				proxyEnabled := ver.Proxy.Enabled
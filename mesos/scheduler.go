@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -39,6 +40,14 @@ type SchedulerConfig struct {
 	MaxTasksPerOffer        int
 	EnableCapabilityKilling bool
 	EnableCheckPoint        bool
+
+	// waiting for the proper offers, in seconds
+	OfferBackoffInitial float64
+	OfferBackoffMax     float64
+	OfferWaitTimeout    float64 // the tasks failed once exceeded
+
+	ConstraintMissingAttr string // policy on the constraints referencing the missing attributes: reject / permit
+	LogConstraintRejects  bool   // log the offers rejected by the constraints, for debugging the placement
 }
 
 // Scheduler represents a client interacting with mesos master via x-protobuf
@@ -56,9 +65,10 @@ type Scheduler struct {
 
 	handlers map[mesosproto.Event_Type]eventHandler
 
-	sync.RWMutex                          // protect followings two
+	sync.RWMutex                          // protect followings three
 	agents       map[string]*magent.Agent // holding offers (agents)
 	pendingTasks map[string]*Task
+	agentAttrs   map[string]map[string]string // agent id -> latest known agent attributes
 
 	reconcileTimer *time.Ticker
 
@@ -70,6 +80,10 @@ type Scheduler struct {
 	clusterMaster *mole.Master
 
 	sem chan struct{} // to order the mesos offer acquirement by multi app launching
+
+	clock clock
+
+	connected int32 // atomic, 1 while subscribed to the mesos leader
 }
 
 // NewScheduler...
@@ -79,13 +93,22 @@ func NewScheduler(cfg *SchedulerConfig, db store.Store, clusterMaster *mole.Mast
 		quit:          make(chan struct{}),
 		agents:        make(map[string]*magent.Agent),
 		pendingTasks:  make(map[string]*Task),
+		agentAttrs:    make(map[string]map[string]string),
 		db:            db,
 		strategy:      strategy.NewBinPackStrategy(), // default strategy
 		filters:       []filter.Filter{filter.NewConstraintsFilter(), filter.NewResourceFilter()},
 		eventmgr:      NewEventManager(),
 		clusterMaster: clusterMaster,
 		sem:           make(chan struct{}, 1), // allow only one offer acquirement at one time
+		clock:         realClock{},
+	}
+
+	s.eventmgr.labelsOf = s.appLabels
+
+	if err := types.SetMissingAttrPolicy(cfg.ConstraintMissingAttr); err != nil {
+		return nil, err
 	}
+	filter.SetLogRejections(cfg.LogConstraintRejects)
 
 	switch cfg.Strategy {
 	case "random":
@@ -226,6 +249,11 @@ func (s *Scheduler) Subscribe() error {
 	return nil
 }
 
+// Connected verify if the scheduler is subscribed to the mesos leader
+func (s *Scheduler) Connected() bool {
+	return atomic.LoadInt32(&s.connected) == 1
+}
+
 func (s *Scheduler) Unsubscribe() error {
 	log.Println("Unscribing from mesos leader %s", s.leader)
 	return nil
@@ -263,6 +291,7 @@ func (s *Scheduler) watchEvents(resp *http.Response) {
 	for {
 		if err = dec.Decode(&ev); err != nil {
 			log.Errorf("mesos events subscriber decode events error: %v", err)
+			atomic.StoreInt32(&s.connected, 0)
 			resp.Body.Close()
 			go s.reconnect()
 			return
@@ -320,6 +349,8 @@ func (s *Scheduler) addOffer(offer *mesosproto.Offer) {
 		f.GetId(), f.GetCpus(), f.GetMem()/1024, f.GetDisk()/1024, f.GetPortRange(), f.GetHostname())
 
 	a.AddOffer(f)
+	s.memoAgentAttrs(a)
+
 	time.AfterFunc(time.Second*5, func() { // release the offer later
 		if s.removeOffer(f) {
 			s.declineOffers([]*magent.Offer{f})
@@ -411,6 +442,56 @@ func (s *Scheduler) getAgents() []*magent.Agent {
 	return agents
 }
 
+// memo the agent attributes, so we could still know the attributes of
+// agents which are running tasks but without offers currently.
+func (s *Scheduler) memoAgentAttrs(a *magent.Agent) {
+	attrs := a.Attributes()
+
+	s.Lock()
+	s.agentAttrs[a.ID()] = attrs
+	s.Unlock()
+}
+
+// liveTask tells whether the task holds a live instance on its agent, which is launched but not
+// reported yet, staging, starting or running. the failed & finished ones don't count.
+func liveTask(status string) bool {
+	switch status {
+	case "pending",
+		mesosproto.TaskState_TASK_STAGING.String(),
+		mesosproto.TaskState_TASK_STARTING.String(),
+		mesosproto.TaskState_TASK_RUNNING.String():
+		return true
+	}
+	return false
+}
+
+// placements returns the agent attributes of each app's live task which has been placed
+func (s *Scheduler) placements(appId string) []map[string]string {
+	ret := make([]map[string]string, 0)
+
+	tasks, err := s.db.ListTasks(appId)
+	if err != nil {
+		if !s.db.IsErrNotFound(err) {
+			log.Errorf("list tasks of app %s for placements error: %v", appId, err)
+		}
+		return ret
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, task := range tasks {
+		if task.AgentId == "" || !liveTask(task.Status) {
+			continue
+		}
+		if attrs, ok := s.agentAttrs[task.AgentId]; ok {
+			ret = append(ret, attrs)
+		}
+	}
+
+	return ret
+}
+
 func (s *Scheduler) addPendingTask(t *Task) {
 	log.Debugf("Add pending task %s", t.TaskId.GetValue())
 
@@ -565,17 +646,52 @@ func (s *Scheduler) AckUpdateEvent(status *mesosproto.TaskStatus) error {
 	return nil
 }
 
-func (s *Scheduler) SubscribeEvent(w io.Writer, remote string) error {
+// SubscribeEvent add an event listener with the options and wait until it's released
+func (s *Scheduler) SubscribeEvent(w io.Writer, remote string, opts EventOptions) error {
+	if s.eventmgr.Closed() {
+		return fmt.Errorf("%s", "event subscription closed")
+	}
+
 	if s.eventmgr.Full() {
 		return fmt.Errorf("%s", "too many event clients")
 	}
 
-	s.eventmgr.subscribe(remote, w)
-	s.eventmgr.wait(remote)
+	c := s.eventmgr.subscribe(remote, w, opts)
+	<-c.wait
 
 	return nil
 }
 
+// PublishEvent broadcasts the event to all of the event listeners
+func (s *Scheduler) PublishEvent(p types.EventPayload) error {
+	return s.eventmgr.broadcast(p)
+}
+
+// appLabels returns the labels of the app's current version, nil if not found
+func (s *Scheduler) appLabels(appID string) map[string]string {
+	app, err := s.db.GetApp(appID)
+	if err != nil || len(app.Version) == 0 {
+		return nil
+	}
+
+	ver, err := s.db.GetVersion(appID, app.Version[0])
+	if err != nil {
+		return nil
+	}
+
+	return ver.Labels
+}
+
+// EventStats returns the statistics of the event listeners & events
+func (s *Scheduler) EventStats() *EventStats {
+	return s.eventmgr.stats()
+}
+
+// CloseEventListeners releases all of the event subscribers on shutdown
+func (s *Scheduler) CloseEventListeners() {
+	s.eventmgr.closeAll()
+}
+
 func (s *Scheduler) runReconcile() {
 	var (
 		step  = int(s.cfg.ReconciliationStep)
@@ -689,14 +805,53 @@ func (s *Scheduler) Offers() interface{} {
 	return offers
 }
 
+// ExplainConstraints tells which constraint rejected which agent and why, for debug convenience
+func (s *Scheduler) ExplainConstraints(appId string, constraints []*types.Constraint) interface{} {
+	opts := &filter.FilterOptions{
+		AppID:       appId,
+		Replicas:    1,
+		Constraints: constraints,
+		Placements:  s.placements(appId),
+		Avoids:      make(map[string][]map[string]string),
+	}
+
+	for _, cons := range constraints {
+		if app := cons.AvoidApp(); app != "" {
+			opts.Avoids[app] = s.placements(app)
+		}
+	}
+
+	return filter.Explain(opts, s.getAgents())
+}
+
+// EvaluateConstraints evaluates the constraints against the agents holding offers currently,
+// as for a new app without any task placed yet.
+func (s *Scheduler) EvaluateConstraints(constraints []*types.Constraint) interface{} {
+	opts := &filter.FilterOptions{
+		Replicas:    1,
+		Constraints: constraints,
+		Avoids:      make(map[string][]map[string]string),
+	}
+
+	for _, cons := range constraints {
+		if app := cons.AvoidApp(); app != "" {
+			opts.Avoids[app] = s.placements(app)
+		}
+	}
+
+	return filter.Evaluate(opts, s.getAgents())
+}
+
 // wait proper offers according by grouped-task's constraints & resources requirments
-func (s *Scheduler) waitOffers(filterOpts *filter.FilterOptions) ([]*magent.Offer, error) {
+// waitOffers retries with the backoff until the proper offers obtained, the backoff is
+// reset once the offers obtained.
+func (s *Scheduler) waitOffers(filterOpts *filter.FilterOptions, b *backoff) ([]*magent.Offer, error) {
 	log.Debugln("Finding suitable agent to run tasks")
 
 	var (
 		offers         = make([]*magent.Offer, 0, 0)
-		maxWait        = time.Second * 86400
-		waitTimeout    = time.After(maxWait)
+		maxWait        = s.offerWaitTimeout()
+		deadline       = s.clock.Now().Add(maxWait)
 		err            error // global final error
 		filteredAgents []*magent.Agent
 	)
@@ -705,8 +860,8 @@ func (s *Scheduler) waitOffers(filterOpts *filter.FilterOptions) ([]*magent.Offe
 		// make the offer exclusively
 		s.lockOffer()
 
-		select {
-		case <-waitTimeout:
+		switch {
+		case !s.clock.Now().Before(deadline):
 			s.unlockOffer() // make other launchers avaliable to mesos offers
 			if err != nil {
 				return nil, fmt.Errorf("without proper agents: %s", err.Error())
@@ -729,6 +884,7 @@ func (s *Scheduler) waitOffers(filterOpts *filter.FilterOptions) ([]*magent.Offe
 				goto RETRY
 			}
 
+			// the filtered agents are ranked by preferred constraints, pick the first one
 			offers = filteredAgents[0].GetOffers()
 			log.Debugf("Found %d agents with %d offers avaliable", len(filteredAgents), len(offers))
 
@@ -738,13 +894,14 @@ func (s *Scheduler) waitOffers(filterOpts *filter.FilterOptions) ([]*magent.Offe
 					s.removeOffer(offer)
 				}
 				s.unlockOffer()
+				b.Reset()
 				return offers, nil
 			}
 
 		RETRY:
-			// no proper offers, delay for a while and try again ...
+			// no proper offers, back off for a while and try again ...
 			s.unlockOffer()
-			time.Sleep(time.Millisecond * 500)
+			<-s.clock.After(b.Next())
 		}
 	}
 }
@@ -757,6 +914,8 @@ func (s *Scheduler) LaunchTasks(tasks []*Task) error {
 		count  = len(tasks)
 		step   = s.cfg.MaxTasksPerOffer
 		cfg    = tasks[0].cfg
+		retry  = s.offerBackoff()      // shared by the groups
+		cache  = filter.NewEvalCache() // shared by the groups of this cycle
 	)
 
 	var errs struct {
@@ -822,14 +981,35 @@ func (s *Scheduler) LaunchTasks(tasks []*Task) error {
 		}
 
 		// try to use filter options to obtain proper offers
+		appId := strings.SplitN(group[0].ID(), ".", 3)[2]
 		filterOpts := &filter.FilterOptions{
+			AppID:       appId,
 			ResRequired: cfg.ResourcesRequired(),
 			Replicas:    len(group),
 			Constraints: cfg.Constraints,
+			Cache:       cache,
+		}
+
+		for _, cons := range cfg.Constraints {
+			if !cons.NeedPlacements() {
+				continue
+			}
+
+			if app := cons.AvoidApp(); app != "" {
+				if filterOpts.Avoids == nil {
+					filterOpts.Avoids = make(map[string][]map[string]string)
+				}
+				filterOpts.Avoids[app] = s.placements(app)
+				continue
+			}
+
+			if filterOpts.Placements == nil {
+				filterOpts.Placements = s.placements(appId)
+			}
 		}
 
 		// try obtain proper offers
-		offers, err := s.waitOffers(filterOpts)
+		offers, err := s.waitOffers(filterOpts, retry)
 		if err != nil {
 			for _, task := range group {
 				if err := s.updateTask(task.ID(), err.Error(), "failed"); err != nil {
@@ -1128,6 +1308,7 @@ func (s *Scheduler) SendEvent(appId string, task *types.Task) error {
 		IP:     task.IP,
 		Weight: task.Weight,
 	}
+	taskEv.SetDetails(task, ver)
 
 	if ver.Proxy != nil {
 		for i, proxy := range ver.Proxy.Proxies {
@@ -0,0 +1,50 @@
+package mesos
+
+import (
+	"testing"
+
+	"github.com/Dataman-Cloud/swan/store"
+	"github.com/Dataman-Cloud/swan/types"
+)
+
+// taskStore only lists the tasks of the app
+type taskStore struct {
+	store.Store
+	tasks []*types.Task
+}
+
+func (s *taskStore) ListTasks(appId string) ([]*types.Task, error) {
+	return s.tasks, nil
+}
+
+func TestPlacementsLiveTasks(t *testing.T) {
+	s := &Scheduler{
+		db: &taskStore{tasks: []*types.Task{
+			{ID: "0", AgentId: "a1", Status: "TASK_RUNNING"},
+			{ID: "1", AgentId: "a2", Status: "TASK_STAGING"},
+			{ID: "2", AgentId: "a3", Status: "TASK_STARTING"},
+			{ID: "3", AgentId: "a4", Status: "pending"}, // launched, not reported yet
+			{ID: "4", AgentId: "a5", Status: "TASK_FAILED"},
+			{ID: "5", AgentId: "a5", Status: "TASK_FINISHED"},
+			{ID: "6", AgentId: "a5", Status: "TASK_KILLED"},
+			{ID: "7", Status: "pending"}, // not placed yet
+		}},
+		agentAttrs: map[string]map[string]string{
+			"a1": {"hostname": "h1"},
+			"a2": {"hostname": "h2"},
+			"a3": {"hostname": "h3"},
+			"a4": {"hostname": "h4"},
+			"a5": {"hostname": "h5"},
+		},
+	}
+
+	got := s.placements("web")
+	if len(got) != 4 {
+		t.Fatalf("placements() = %v, want the 4 live tasks", got)
+	}
+	for i, want := range []string{"h1", "h2", "h3", "h4"} {
+		if got[i]["hostname"] != want {
+			t.Errorf("placements()[%d] = %v, want hostname %s", i, got[i], want)
+		}
+	}
+}
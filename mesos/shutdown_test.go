@@ -0,0 +1,64 @@
+package mesos
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// streamWriter is the event stream of a subscriber which never disconnects
+type streamWriter struct {
+	closing chan bool
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *streamWriter) Flush() {}
+
+func (w *streamWriter) CloseNotify() <-chan bool {
+	return w.closing
+}
+
+func TestEventCloseAll(t *testing.T) {
+	s := &Scheduler{eventmgr: NewEventManager()}
+
+	var (
+		writers = []*streamWriter{{closing: make(chan bool)}, {closing: make(chan bool)}}
+		done    = make(chan error, len(writers))
+	)
+	for i, w := range writers {
+		go func(remote string, w *streamWriter) {
+			done <- s.SubscribeEvent(w, remote, EventOptions{})
+		}(fmt.Sprintf("listener-%d", i), w)
+	}
+
+	for s.eventmgr.size() < len(writers) {
+		time.Sleep(time.Millisecond)
+	}
+
+	s.CloseEventListeners()
+
+	for range writers {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("SubscribeEvent() error = %v", err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("event listener not released by closing all")
+		}
+	}
+
+	if n := s.eventmgr.size(); n != 0 {
+		t.Errorf("listeners after closing all = %d, want 0", n)
+	}
+
+	// no more new listeners accepted
+	if err := s.SubscribeEvent(&streamWriter{closing: make(chan bool)}, "late", EventOptions{}); err == nil {
+		t.Error("SubscribeEvent() after closing all = nil, want error")
+	}
+
+	s.CloseEventListeners() // idempotent
+}
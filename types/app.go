@@ -20,6 +20,7 @@ const (
 	OpStatusStopping         = "stopping"
 	OpStatusDeleting         = "deleting"
 	OpStatusRollback         = "rollbacking"
+	OpStatusPaused           = "paused" // no operations nor rescheduling until resumed
 )
 
 type Application struct {
@@ -4,46 +4,436 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
 )
 
-var supportedOperator = []string{"==", "!=", "~="}
+var supportedOperator = []string{"==", "!=", "~=", "contains", "prefix", "suffix", "has", "in", "notin", "pin", "exists", "notexists", "groupby", "max", "unique", "avoid", ">=", "<=", ">", "<", "between"}
+
+// the policies on the constraints referencing the attribute the agent doesn't have
+const (
+	MissingAttrReject = "reject" // not satisfied, the agent is rejected (default, fail-closed)
+	MissingAttrPermit = "permit" // satisfied, the missing attribute means don't care (fail-open)
+)
+
+// permitMissing is 1 if the missing attribute policy is permit, atomic
+var permitMissing int32
+
+// SetMissingAttrPolicy setup the global policy on the constraints referencing the missing
+// attributes, which is consulted by the value operators, eg: `==`, `~=`, `contains`, `>=`.
+// the presence operators `exists` / `notexists` and the placement ones are not affected.
+func SetMissingAttrPolicy(policy string) error {
+	switch policy {
+	case "", MissingAttrReject:
+		atomic.StoreInt32(&permitMissing, 0)
+	case MissingAttrPermit:
+		atomic.StoreInt32(&permitMissing, 1)
+	default:
+		return fmt.Errorf("missing attribute policy [%s] invalid, should be reject or permit", policy)
+	}
+	return nil
+}
 
 type Constraint struct {
 	Attribute string `yaml:"attribute" json:"attribute"`
 	Operator  string `yaml:"operator" json:"operator"`
 	Value     string `yaml:"value" json:"value"`
+
+	// soft constraint, the agents which don't satisfy it are not rejected
+	// but less preferred than those satisfied.
+	Prefer bool `yaml:"prefer" json:"prefer"`
+}
+
+// ConstraintError describes an invalid constraint together with its position
+// within the constraints list and the offending field, so that it can be
+// surfaced to api clients as is.
+type ConstraintError struct {
+	Index int    // position of the constraint within the constraints list
+	Field string // offending field: attribute / operator / value
+	Value string // offending token
+	Err   error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("constraints[%d].%s %q: %v", e.Index, e.Field, e.Value, e.Err)
+}
+
+// ConstraintErrors holds all of the errors found within a constraints list.
+type ConstraintErrors []*ConstraintError
+
+func (es ConstraintErrors) Error() string {
+	msgs := make([]string, 0, len(es))
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ValidateConstraints verify all of the constraints and returns ConstraintErrors
+// which contains every invalid constraint instead of only the first one.
+func ValidateConstraints(cs []*Constraint) error {
+	var errs ConstraintErrors
+	for i, c := range cs {
+		if err := c.validate(); err != nil {
+			err.Index = i
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// ParseConstraint parses the constraint from the text form `attribute operator [value]`,
+// eg: `rack == r1`, `hostname in h1,h2`, `rack unique`, `hostname ~= "web 0[1-3]"`.
+// the agent ids of `pin` could be also separated by spaces, eg: `agentid pin S1, S2 S3`.
+func ParseConstraint(text string) (*Constraint, error) {
+	fields, err := splitFields(text)
+	if err != nil {
+		return nil, &ConstraintError{Field: "constraint", Value: text, Err: err}
+	}
+
+	switch {
+	case len(fields) == 2:
+		return &Constraint{Attribute: fields[0], Operator: fields[1]}, nil
+	case len(fields) == 3:
+		return &Constraint{Attribute: fields[0], Operator: fields[1], Value: fields[2]}, nil
+	case len(fields) > 3 && fields[1] == "pin":
+		return &Constraint{Attribute: fields[0], Operator: fields[1], Value: strings.Join(valueList(strings.Join(fields[2:], ",")), ",")}, nil
+	}
+
+	return nil, &ConstraintError{
+		Field: "constraint",
+		Value: text,
+		Err:   errors.New("should be in form of `attribute operator [value]`"),
+	}
 }
 
-func (c *Constraint) validate() error {
+// splitFields splits the text around the spaces, except the ones in the double quoted
+// fields. within the quotes, `\"` and `\\` stand for the literal quote and backslash, the
+// other backslashes are kept as is, so that the regexps need no double escaping.
+// the unquoted fields are taken literally for compatibility.
+func splitFields(text string) ([]string, error) {
+	var (
+		fields []string
+		i      int
+	)
+
+	for {
+		for i < len(text) && unicode.IsSpace(rune(text[i])) {
+			i++
+		}
+		if i == len(text) {
+			return fields, nil
+		}
+
+		if text[i] != '"' {
+			j := i
+			for j < len(text) && !unicode.IsSpace(rune(text[j])) {
+				j++
+			}
+			fields = append(fields, text[i:j])
+			i = j
+			continue
+		}
+
+		var (
+			field  []byte
+			closed bool
+		)
+		for i++; i < len(text); i++ {
+			c := text[i]
+			if c == '\\' && i+1 < len(text) && (text[i+1] == '"' || text[i+1] == '\\') {
+				i++
+				field = append(field, text[i])
+				continue
+			}
+			if c == '"' {
+				closed = true
+				i++
+				break
+			}
+			field = append(field, c)
+		}
+
+		if !closed {
+			return nil, errors.New("unterminated quoted value")
+		}
+		if i < len(text) && !unicode.IsSpace(rune(text[i])) {
+			return nil, errors.New("quoted value should be followed by space")
+		}
+		fields = append(fields, string(field))
+	}
+}
+
+// ParseConstraints parses & validates the constraints in text form, the returned
+// ConstraintErrors contains every malformed or invalid one instead of only the first one.
+func ParseConstraints(texts []string) ([]*Constraint, error) {
+	var (
+		cs   = make([]*Constraint, 0, len(texts))
+		errs ConstraintErrors
+	)
+
+	for i, text := range texts {
+		c, err := ParseConstraint(text)
+		if err != nil {
+			cerr := err.(*ConstraintError)
+			cerr.Index = i
+			errs = append(errs, cerr)
+			continue
+		}
+
+		if cerr := c.validate(); cerr != nil {
+			cerr.Index = i
+			errs = append(errs, cerr)
+			continue
+		}
+
+		cs = append(cs, c)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return cs, nil
+}
+
+// ValidateConstraintsFailFast is similar as ValidateConstraints, but fail fast on the first
+// invalid constraint, which is cheaper for the internal callers only caring whether it's valid.
+func ValidateConstraintsFailFast(cs []*Constraint) error {
+	for i, c := range cs {
+		if err := c.validate(); err != nil {
+			err.Index = i
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Constraint) validate() *ConstraintError {
+	if c == nil {
+		return &ConstraintError{Field: "attribute", Err: errors.New("attribute required for constraint")}
+	}
 	if c.Attribute == "" {
-		return errors.New("attribute required for constraint")
+		return &ConstraintError{Field: "attribute", Value: c.Attribute, Err: errors.New("attribute required for constraint")}
 	}
 	for _, str := range supportedOperator {
 		if str == c.Operator {
-			return nil
+			if c.Prefer && c.NeedPlacements() {
+				return &ConstraintError{
+					Field: "operator",
+					Value: c.Operator,
+					Err:   fmt.Errorf("operator %s can't be used as preferred constraint", c.Operator),
+				}
+			}
+			return c.validateValue()
 		}
 	}
 
-	return fmt.Errorf("Operator not supported. supported operators is %v", supportedOperator)
+	return &ConstraintError{
+		Field: "operator",
+		Value: c.Operator,
+		Err:   fmt.Errorf("Operator not supported. supported operators is %v", supportedOperator),
+	}
 }
 
-func (c *Constraint) Match(attrs map[string]string) bool {
-	for k, v := range attrs {
-		if k == c.Attribute {
-			switch c.Operator {
-			case "==":
-				return equal(c.Value, v)
-			case "!=":
-				return not(c.Value, v)
-			case "~=":
-				return like(c.Value, v)
+func (c *Constraint) validateValue() *ConstraintError {
+	switch c.Operator {
+	case "~=":
+		if _, err := regexp.Compile(c.Value); err != nil {
+			return &ConstraintError{Field: "value", Value: c.Value, Err: err}
+		}
+	case "prefix", "suffix", "has":
+		if c.Value == "" {
+			return &ConstraintError{
+				Field: "value",
+				Value: c.Value,
+				Err:   fmt.Errorf("value required for operator %s", c.Operator),
+			}
+		}
+	case "in", "notin":
+		if len(valueList(c.Value)) == 0 {
+			return &ConstraintError{
+				Field: "value",
+				Value: c.Value,
+				Err:   fmt.Errorf("at least one value required for operator %s", c.Operator),
+			}
+		}
+	case "pin":
+		if c.Attribute != "agentid" {
+			return &ConstraintError{
+				Field: "attribute",
+				Value: c.Attribute,
+				Err:   fmt.Errorf("operator %s only applies to attribute agentid", c.Operator),
+			}
+		}
+		if len(valueList(c.Value)) == 0 {
+			return &ConstraintError{
+				Field: "value",
+				Value: c.Value,
+				Err:   fmt.Errorf("at least one agent id required for operator %s", c.Operator),
+			}
+		}
+	case "groupby":
+		if c.Value == "" {
+			return nil // without limit
+		}
+		if n, err := strconv.Atoi(c.Value); err != nil || n <= 0 {
+			return &ConstraintError{
+				Field: "value",
+				Value: c.Value,
+				Err:   fmt.Errorf("limit of operator %s must be a positive integer", c.Operator),
+			}
+		}
+	case ">=", "<=", ">", "<":
+		if _, err := strconv.ParseFloat(c.Value, 64); err != nil {
+			return &ConstraintError{
+				Field: "value",
+				Value: c.Value,
+				Err:   fmt.Errorf("value of operator %s must be a number", c.Operator),
+			}
+		}
+	case "between":
+		if _, _, err := bounds(c.Value); err != nil {
+			return &ConstraintError{
+				Field: "value",
+				Value: c.Value,
+				Err:   fmt.Errorf("value of operator %s %v", c.Operator, err),
+			}
+		}
+	case "max":
+		if n, err := strconv.Atoi(c.Value); err != nil || n <= 0 {
+			return &ConstraintError{
+				Field: "value",
+				Value: c.Value,
+				Err:   fmt.Errorf("limit of operator %s must be a positive integer", c.Operator),
+			}
+		}
+	case "avoid":
+		if c.Value == "" {
+			return &ConstraintError{
+				Field: "value",
+				Value: c.Value,
+				Err:   fmt.Errorf("app id required for operator %s", c.Operator),
+			}
+		}
+	case "unique", "exists", "notexists":
+		if c.Value != "" {
+			return &ConstraintError{
+				Field: "value",
+				Value: c.Value,
+				Err:   fmt.Errorf("no value expected for operator %s", c.Operator),
 			}
 		}
 	}
+	return nil
+}
 
+// NeedPlacements tells whether the constraint is evaluated against the
+// current placements of the app's tasks, rather than a single agent.
+func (c *Constraint) NeedPlacements() bool {
+	switch c.Operator {
+	case "groupby", "max", "unique", "avoid":
+		return true
+	}
 	return false
 }
 
+// AvoidApp returns the app id whose tasks should be avoided by the avoid constraint.
+func (c *Constraint) AvoidApp() string {
+	if c.Operator == "avoid" {
+		return c.Value
+	}
+	return ""
+}
+
+// Limit returns the max nb of tasks per attribute value for placement
+// constraints, 0 means no limit.
+func (c *Constraint) Limit() int {
+	if c.Operator == "unique" {
+		return 1
+	}
+	n, _ := strconv.Atoi(c.Value)
+	return n
+}
+
+func (c *Constraint) Match(attrs map[string]string) bool {
+	v, ok := attrs[c.Attribute]
+	if !ok {
+		return c.missing()
+	}
+
+	switch c.Operator {
+	case "==":
+		return equal(c.Value, v)
+	case "!=":
+		return not(c.Value, v)
+	case "~=":
+		return like(c.Value, v)
+	case "contains":
+		return contains(c.Value, v)
+	case "prefix":
+		return strings.HasPrefix(v, c.Value)
+	case "suffix":
+		return strings.HasSuffix(v, c.Value)
+	case "has":
+		return in(valueList(v), c.Value)
+	case "in":
+		return in(valueList(c.Value), v)
+	case "notin":
+		return !in(valueList(c.Value), v)
+	case "pin":
+		return in(valueList(c.Value), v) // exactly the agent id, not the pattern
+	case ">=", "<=", ">", "<":
+		return compare(c.Operator, c.Value, v)
+	case "between":
+		return between(c.Value, v)
+	case "exists":
+		return true
+	case "groupby", "max", "unique", "avoid":
+		return true // the agent must have the grouped attribute
+	}
+
+	return false
+}
+
+// missing tells whether the agent without the attribute satisfy the constraint, the value
+// operators follow the missing attribute policy, see SetMissingAttrPolicy.
+func (c *Constraint) missing() bool {
+	switch c.Operator {
+	case "notexists":
+		return true
+	case "exists", "groupby", "max", "unique", "avoid":
+		return false
+	}
+	return atomic.LoadInt32(&permitMissing) == 1
+}
+
+// Explain is similar as Match, but also tells the reason if the attributes
+// doesn't satisfy the constraint. it's used for debug purpose only.
+func (c *Constraint) Explain(attrs map[string]string) (bool, string) {
+	v, ok := attrs[c.Attribute]
+	if c.Operator == "notexists" && ok {
+		return false, fmt.Sprintf("attribute [%s] exists", c.Attribute)
+	}
+	if !ok {
+		if c.missing() {
+			return true, ""
+		}
+		return false, fmt.Sprintf("attribute [%s] not found", c.Attribute)
+	}
+
+	if c.Match(attrs) {
+		return true, ""
+	}
+
+	return false, fmt.Sprintf("attribute [%s] value %q not satisfy [%s %q]", c.Attribute, v, c.Operator, c.Value)
+}
+
 func equal(n, m string) bool {
 	return n == m
 }
@@ -56,3 +446,89 @@ func like(n, m string) bool {
 	matched, _ := regexp.MatchString(n, m)
 	return matched
 }
+
+func contains(n, m string) bool {
+	return strings.Contains(m, n)
+}
+
+// compare tells whether the numeric attribute value m satisfy `m op n`,
+// non-numeric attribute value never satisfy.
+func compare(op, n, m string) bool {
+	x, err := strconv.ParseFloat(m, 64)
+	if err != nil {
+		return false
+	}
+	y, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return false
+	}
+
+	switch op {
+	case ">=":
+		return x >= y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	case "<":
+		return x < y
+	}
+	return false
+}
+
+// bounds parses the range constraint value `lo,hi` into the numeric bounds
+func bounds(value string) (lo, hi float64, err error) {
+	vs := valueList(value)
+	if len(vs) != 2 {
+		return 0, 0, errors.New("must be two numbers in form of `lo,hi`")
+	}
+
+	if lo, err = strconv.ParseFloat(vs[0], 64); err != nil {
+		return 0, 0, errors.New("lower bound must be a number")
+	}
+	if hi, err = strconv.ParseFloat(vs[1], 64); err != nil {
+		return 0, 0, errors.New("upper bound must be a number")
+	}
+	if lo > hi {
+		return 0, 0, errors.New("lower bound must not be greater than the upper bound")
+	}
+
+	return lo, hi, nil
+}
+
+// between tells whether the numeric attribute value m falls within the inclusive
+// range n of `lo,hi`, non-numeric attribute value never satisfy.
+func between(n, m string) bool {
+	lo, hi, err := bounds(n)
+	if err != nil {
+		return false
+	}
+
+	x, err := strconv.ParseFloat(m, 64)
+	if err != nil {
+		return false
+	}
+
+	return x >= lo && x <= hi
+}
+
+func in(ns []string, m string) bool {
+	for _, n := range ns {
+		if n == m {
+			return true
+		}
+	}
+	return false
+}
+
+// valueList split the comma separated constraint value into a set of values,
+// empty items are ignored. eg: "h1, h2,h3" -> [h1 h2 h3]
+func valueList(value string) []string {
+	ret := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
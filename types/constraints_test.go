@@ -0,0 +1,551 @@
+package types
+
+import (
+	"testing"
+)
+
+func TestConstraintMatch(t *testing.T) {
+	attrs := map[string]string{
+		"hostname":    "192.168.1.101",
+		"agentid":     "5e7a-S1",
+		"vcluster":    "dataman",
+		"rack":        "rack-a-01",
+		"zone":        "3",
+		"temperature": "18.5",
+		"roles":       "*,prod",
+	}
+
+	tests := []struct {
+		name string
+		cons *Constraint
+		want bool
+	}{
+		{
+			name: "contains on custom attribute",
+			cons: &Constraint{Attribute: "rack", Operator: "contains", Value: "-a-"},
+			want: true,
+		},
+		{
+			name: "like on custom attribute",
+			cons: &Constraint{Attribute: "rack", Operator: "~=", Value: "^rack-b"},
+			want: false,
+		},
+		{
+			name: "pin on agent in the set",
+			cons: &Constraint{Attribute: "agentid", Operator: "pin", Value: "5e7a-S0, 5e7a-S1"},
+			want: true,
+		},
+		{
+			name: "pin matches the agent id exactly",
+			cons: &Constraint{Attribute: "agentid", Operator: "pin", Value: "5e7a-S,S1,5e7a-S.*"},
+			want: false,
+		},
+		{
+			name: "prefix on custom attribute",
+			cons: &Constraint{Attribute: "rack", Operator: "prefix", Value: "rack-a"},
+			want: true,
+		},
+		{
+			name: "prefix not at the beginning",
+			cons: &Constraint{Attribute: "rack", Operator: "prefix", Value: "a-01"},
+			want: false,
+		},
+		{
+			name: "prefix of special characters taken literally",
+			cons: &Constraint{Attribute: "hostname", Operator: "prefix", Value: "192.168.1."},
+			want: true,
+		},
+		{
+			name: "prefix regexp not interpreted",
+			cons: &Constraint{Attribute: "hostname", Operator: "prefix", Value: "192.168.."},
+			want: false,
+		},
+		{
+			name: "suffix on builtin attribute",
+			cons: &Constraint{Attribute: "agentid", Operator: "suffix", Value: "-S1"},
+			want: true,
+		},
+		{
+			name: "suffix not at the end",
+			cons: &Constraint{Attribute: "agentid", Operator: "suffix", Value: "5e7a"},
+			want: false,
+		},
+		{
+			name: "suffix on absent attribute",
+			cons: &Constraint{Attribute: "zone", Operator: "suffix", Value: "01"},
+			want: false,
+		},
+		{
+			name: "contains on builtin attribute",
+			cons: &Constraint{Attribute: "agentid", Operator: "contains", Value: "S1"},
+			want: true,
+		},
+		{
+			name: "compare non numeric attribute",
+			cons: &Constraint{Attribute: "rack", Operator: ">", Value: "1"},
+			want: false,
+		},
+		{
+			name: "between in range",
+			cons: &Constraint{Attribute: "zone", Operator: "between", Value: "1,5"},
+			want: true,
+		},
+		{
+			name: "between at lower bound",
+			cons: &Constraint{Attribute: "temperature", Operator: "between", Value: "18.5, 30"},
+			want: true,
+		},
+		{
+			name: "between at upper bound",
+			cons: &Constraint{Attribute: "temperature", Operator: "between", Value: "10,18.5"},
+			want: true,
+		},
+		{
+			name: "between out of range",
+			cons: &Constraint{Attribute: "zone", Operator: "between", Value: "4,8"},
+			want: false,
+		},
+		{
+			name: "between non numeric attribute",
+			cons: &Constraint{Attribute: "rack", Operator: "between", Value: "0,100"},
+			want: false,
+		},
+		{
+			name: "between absent attribute",
+			cons: &Constraint{Attribute: "floor", Operator: "between", Value: "0,100"},
+			want: false,
+		},
+		{
+			name: "has the role",
+			cons: &Constraint{Attribute: "roles", Operator: "has", Value: "prod"},
+			want: true,
+		},
+		{
+			name: "has not the role",
+			cons: &Constraint{Attribute: "roles", Operator: "has", Value: "pro"},
+			want: false,
+		},
+		{
+			name: "has on absent attribute",
+			cons: &Constraint{Attribute: "reservation", Operator: "has", Value: "team=search"},
+			want: false,
+		},
+		{
+			name: "exists present",
+			cons: &Constraint{Attribute: "rack", Operator: "exists"},
+			want: true,
+		},
+		{
+			name: "exists absent",
+			cons: &Constraint{Attribute: "gpu", Operator: "exists"},
+			want: false,
+		},
+		{
+			name: "notexists present",
+			cons: &Constraint{Attribute: "rack", Operator: "notexists"},
+			want: false,
+		},
+		{
+			name: "notexists absent",
+			cons: &Constraint{Attribute: "gpu", Operator: "notexists"},
+			want: true,
+		},
+		{
+			name: "in present",
+			cons: &Constraint{Attribute: "hostname", Operator: "in", Value: "192.168.1.100, 192.168.1.101"},
+			want: true,
+		},
+		{
+			name: "in absent",
+			cons: &Constraint{Attribute: "hostname", Operator: "in", Value: "192.168.1.100,192.168.1.102"},
+			want: false,
+		},
+		{
+			name: "notin present",
+			cons: &Constraint{Attribute: "vcluster", Operator: "notin", Value: "dataman,dev"},
+			want: false,
+		},
+		{
+			name: "notin absent",
+			cons: &Constraint{Attribute: "agentid", Operator: "notin", Value: "5e7a-S2,5e7a-S3"},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cons.Match(attrs); got != tt.want {
+				t.Errorf("Constraint.Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingAttrPolicy(t *testing.T) {
+	defer SetMissingAttrPolicy(MissingAttrReject)
+
+	attrs := map[string]string{"hostname": "192.168.1.101"} // without rack
+
+	tests := []struct {
+		name   string
+		cons   *Constraint
+		reject bool // satisfied under the reject policy
+		permit bool // satisfied under the permit policy
+	}{
+		{name: "==", cons: &Constraint{Attribute: "rack", Operator: "==", Value: "r1"}, reject: false, permit: true},
+		{name: "!=", cons: &Constraint{Attribute: "rack", Operator: "!=", Value: "r1"}, reject: false, permit: true},
+		{name: "~=", cons: &Constraint{Attribute: "rack", Operator: "~=", Value: "^r"}, reject: false, permit: true},
+		{name: "contains", cons: &Constraint{Attribute: "rack", Operator: "contains", Value: "r"}, reject: false, permit: true},
+		{name: "in", cons: &Constraint{Attribute: "rack", Operator: "in", Value: "r1,r2"}, reject: false, permit: true},
+		{name: ">=", cons: &Constraint{Attribute: "rack", Operator: ">=", Value: "1"}, reject: false, permit: true},
+		{name: "between", cons: &Constraint{Attribute: "rack", Operator: "between", Value: "1,2"}, reject: false, permit: true},
+		{name: "exists", cons: &Constraint{Attribute: "rack", Operator: "exists"}, reject: false, permit: false},
+		{name: "notexists", cons: &Constraint{Attribute: "rack", Operator: "notexists"}, reject: true, permit: true},
+		{name: "groupby", cons: &Constraint{Attribute: "rack", Operator: "groupby"}, reject: false, permit: false},
+		{name: "present", cons: &Constraint{Attribute: "hostname", Operator: "==", Value: "h1"}, reject: false, permit: false},
+	}
+
+	for _, policy := range []string{MissingAttrReject, MissingAttrPermit} {
+		if err := SetMissingAttrPolicy(policy); err != nil {
+			t.Fatalf("SetMissingAttrPolicy(%s) error = %v", policy, err)
+		}
+
+		for _, tt := range tests {
+			t.Run(policy+"/"+tt.name, func(t *testing.T) {
+				want := tt.reject
+				if policy == MissingAttrPermit {
+					want = tt.permit
+				}
+
+				if got := tt.cons.Match(attrs); got != want {
+					t.Errorf("Match() = %v, want %v", got, want)
+				}
+				if got, reason := tt.cons.Explain(attrs); got != want || (got && reason != "") {
+					t.Errorf("Explain() = %v %q, want %v", got, reason, want)
+				}
+			})
+		}
+	}
+
+	if err := SetMissingAttrPolicy("ignore"); err == nil {
+		t.Error("SetMissingAttrPolicy(ignore) succeed, want error")
+	}
+}
+
+func TestConstraintValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cons    *Constraint
+		wantErr bool
+	}{
+		{
+			name:    "in with values",
+			cons:    &Constraint{Attribute: "hostname", Operator: "in", Value: "h1,h2"},
+			wantErr: false,
+		},
+		{
+			name:    "in without values",
+			cons:    &Constraint{Attribute: "hostname", Operator: "in", Value: " , "},
+			wantErr: true,
+		},
+		{
+			name:    "pin with agent ids",
+			cons:    &Constraint{Attribute: "agentid", Operator: "pin", Value: "S1,S2"},
+			wantErr: false,
+		},
+		{
+			name:    "pin without agent ids",
+			cons:    &Constraint{Attribute: "agentid", Operator: "pin", Value: " , "},
+			wantErr: true,
+		},
+		{
+			name:    "pin on other attribute",
+			cons:    &Constraint{Attribute: "hostname", Operator: "pin", Value: "h1"},
+			wantErr: true,
+		},
+		{
+			name:    "prefix without value",
+			cons:    &Constraint{Attribute: "hostname", Operator: "prefix", Value: ""},
+			wantErr: true,
+		},
+		{
+			name:    "suffix with value",
+			cons:    &Constraint{Attribute: "hostname", Operator: "suffix", Value: ".example.com"},
+			wantErr: false,
+		},
+		{
+			name:    "malformed regexp",
+			cons:    &Constraint{Attribute: "hostname", Operator: "~=", Value: "web-("},
+			wantErr: true,
+		},
+		{
+			name:    "groupby without limit",
+			cons:    &Constraint{Attribute: "rack", Operator: "groupby", Value: ""},
+			wantErr: false,
+		},
+		{
+			name:    "groupby with invalid limit",
+			cons:    &Constraint{Attribute: "rack", Operator: "groupby", Value: "0"},
+			wantErr: true,
+		},
+		{
+			name:    "max without limit",
+			cons:    &Constraint{Attribute: "hostname", Operator: "max", Value: ""},
+			wantErr: true,
+		},
+		{
+			name:    "max with limit",
+			cons:    &Constraint{Attribute: "hostname", Operator: "max", Value: "2"},
+			wantErr: false,
+		},
+		{
+			name:    "unique with value",
+			cons:    &Constraint{Attribute: "hostname", Operator: "unique", Value: "1"},
+			wantErr: true,
+		},
+		{
+			name:    "compare with number",
+			cons:    &Constraint{Attribute: "cpus", Operator: ">=", Value: "0.5"},
+			wantErr: false,
+		},
+		{
+			name:    "compare with non number",
+			cons:    &Constraint{Attribute: "cpus", Operator: ">=", Value: "four"},
+			wantErr: true,
+		},
+		{
+			name:    "between with bounds",
+			cons:    &Constraint{Attribute: "zone", Operator: "between", Value: "-1.5,3"},
+			wantErr: false,
+		},
+		{
+			name:    "between with equal bounds",
+			cons:    &Constraint{Attribute: "zone", Operator: "between", Value: "3,3"},
+			wantErr: false,
+		},
+		{
+			name:    "between with one bound",
+			cons:    &Constraint{Attribute: "zone", Operator: "between", Value: "3"},
+			wantErr: true,
+		},
+		{
+			name:    "between with non number",
+			cons:    &Constraint{Attribute: "zone", Operator: "between", Value: "1,high"},
+			wantErr: true,
+		},
+		{
+			name:    "between with reversed bounds",
+			cons:    &Constraint{Attribute: "zone", Operator: "between", Value: "5,1"},
+			wantErr: true,
+		},
+		{
+			name:    "has without value",
+			cons:    &Constraint{Attribute: "roles", Operator: "has", Value: ""},
+			wantErr: true,
+		},
+		{
+			name:    "exists with value",
+			cons:    &Constraint{Attribute: "gpu", Operator: "exists", Value: "1"},
+			wantErr: true,
+		},
+		{
+			name:    "notexists without attribute",
+			cons:    &Constraint{Attribute: "", Operator: "notexists"},
+			wantErr: true,
+		},
+		{
+			name:    "avoid without app",
+			cons:    &Constraint{Attribute: "hostname", Operator: "avoid", Value: ""},
+			wantErr: true,
+		},
+		{
+			name:    "preferred placement",
+			cons:    &Constraint{Attribute: "rack", Operator: "groupby", Prefer: true},
+			wantErr: true,
+		},
+		{
+			name:    "unknown operator",
+			cons:    &Constraint{Attribute: "hostname", Operator: "xxx", Value: ""},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cons.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Constraint.validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConstraintsPosition(t *testing.T) {
+	tests := []struct {
+		name  string
+		cs    []*Constraint
+		index int
+		field string
+		value string
+	}{
+		{
+			name: "bad operator at second",
+			cs: []*Constraint{
+				{Attribute: "vcluster", Operator: "==", Value: "dataman"},
+				{Attribute: "hostname", Operator: "=~", Value: "web"},
+			},
+			index: 1,
+			field: "operator",
+			value: "=~",
+		},
+		{
+			name: "empty attribute at first",
+			cs: []*Constraint{
+				{Attribute: "", Operator: "==", Value: "dataman"},
+			},
+			index: 0,
+			field: "attribute",
+			value: "",
+		},
+		{
+			name: "empty value list at third",
+			cs: []*Constraint{
+				{Attribute: "vcluster", Operator: "==", Value: "dataman"},
+				{Attribute: "hostname", Operator: "~=", Value: "web"},
+				{Attribute: "agentid", Operator: "in", Value: ","},
+			},
+			index: 2,
+			field: "value",
+			value: ",",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateConstraintsFailFast(tt.cs)
+			cerr, ok := err.(*ConstraintError)
+			if !ok {
+				t.Fatalf("ValidateConstraintsFailFast() error = %v, want *ConstraintError", err)
+			}
+			if cerr.Index != tt.index || cerr.Field != tt.field || cerr.Value != tt.value {
+				t.Errorf("ValidateConstraintsFailFast() = [%d %s %q], want [%d %s %q]",
+					cerr.Index, cerr.Field, cerr.Value, tt.index, tt.field, tt.value)
+			}
+		})
+	}
+}
+
+func TestValidateConstraintsAggregate(t *testing.T) {
+	cs := []*Constraint{
+		{Attribute: "", Operator: "==", Value: "dataman"},
+		{Attribute: "vcluster", Operator: "==", Value: "dataman"},
+		{Attribute: "hostname", Operator: "=~", Value: "web"},
+		{Attribute: "agentid", Operator: "in", Value: ""},
+	}
+
+	err := ValidateConstraints(cs)
+	errs, ok := err.(ConstraintErrors)
+	if !ok {
+		t.Fatalf("ValidateConstraints() error = %v, want ConstraintErrors", err)
+	}
+
+	want := []int{0, 2, 3}
+	if len(errs) != len(want) {
+		t.Fatalf("ValidateConstraints() got %d errors, want %d: %v", len(errs), len(want), errs)
+	}
+	for i, idx := range want {
+		if errs[i].Index != idx {
+			t.Errorf("ValidateConstraints() errors[%d].Index = %d, want %d", i, errs[i].Index, idx)
+		}
+	}
+
+	// fail fast only reports the first one
+	if err := ValidateConstraintsFailFast(cs); err.(*ConstraintError).Index != 0 {
+		t.Errorf("ValidateConstraintsFailFast() = %v, want the first invalid constraint", err)
+	}
+
+	if err := ValidateConstraints(cs[1:2]); err != nil {
+		t.Errorf("ValidateConstraints() = %v, want nil", err)
+	}
+}
+
+func TestParseConstraint(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    *Constraint
+		wantErr bool
+	}{
+		{
+			name: "with value",
+			text: "rack == r1",
+			want: &Constraint{Attribute: "rack", Operator: "==", Value: "r1"},
+		},
+		{
+			name: "without value",
+			text: " rack  unique ",
+			want: &Constraint{Attribute: "rack", Operator: "unique"},
+		},
+		{
+			name:    "attribute only",
+			text:    "rack",
+			wantErr: true,
+		},
+		{
+			name:    "too many fields",
+			text:    "rack == r1 r2",
+			wantErr: true,
+		},
+		{
+			name: "pin agent ids separated by spaces",
+			text: "agentid pin S1, S2 S3",
+			want: &Constraint{Attribute: "agentid", Operator: "pin", Value: "S1,S2,S3"},
+		},
+		{
+			name: "quoted value with spaces",
+			text: `hostname == "web 01"`,
+			want: &Constraint{Attribute: "hostname", Operator: "==", Value: "web 01"},
+		},
+		{
+			name: "quoted value with escaped quotes",
+			text: `label contains "say \"hi\" \\o/"`,
+			want: &Constraint{Attribute: "label", Operator: "contains", Value: `say "hi" \o/`},
+		},
+		{
+			name: "quoted regexp",
+			text: `hostname ~= "^web (0[1-3]|\d+)$"`,
+			want: &Constraint{Attribute: "hostname", Operator: "~=", Value: `^web (0[1-3]|\d+)$`},
+		},
+		{
+			name: "quoted empty value",
+			text: `rack == ""`,
+			want: &Constraint{Attribute: "rack", Operator: "==", Value: ""},
+		},
+		{
+			name: "unquoted with quote inside",
+			text: `rack == r"1`,
+			want: &Constraint{Attribute: "rack", Operator: "==", Value: `r"1`},
+		},
+		{
+			name:    "unterminated quote",
+			text:    `hostname == "web 01`,
+			wantErr: true,
+		},
+		{
+			name:    "quote followed by text",
+			text:    `hostname == "web"01`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseConstraint(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseConstraint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && *got != *tt.want {
+				t.Errorf("ParseConstraint() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
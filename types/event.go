@@ -3,6 +3,7 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/Dataman-Cloud/swan/agent/janitor/upstream"
 	"github.com/Dataman-Cloud/swan/agent/resolver"
@@ -12,8 +13,156 @@ const (
 	EventTypeTaskHealthy      = "task_healthy"
 	EventTypeTaskWeightChange = "task_weight_change"
 	EventTypeTaskUnhealthy    = "task_unhealthy"
+	EventTypeTargetChange     = "target_change"
+	EventTypeStateTransition  = "state_transition"
+	EventTypeAutoRollback     = "auto_rollback"
+	EventTypeBatch            = "batch" // the coalesced events by batching
 )
 
+// EventSchemaVersion is the current schema version of the event envelope,
+// it should be bumped once any of the event payloads changed incompatibly.
+const EventSchemaVersion = 1
+
+// event stream formats
+const (
+	EventFormatSSE    = "sse"    // default, SSE text with the envelope as data
+	EventFormatNDJSON = "ndjson" // newline delimited envelope json
+	EventFormatLegacy = "legacy" // SSE text with the bare task event as data, only task events are streamed
+)
+
+// registered event payloads by event type
+var eventPayloads = map[string]func() EventPayload{
+	EventTypeTaskHealthy:      func() EventPayload { return new(TaskEvent) },
+	EventTypeTaskWeightChange: func() EventPayload { return new(TaskEvent) },
+	EventTypeTaskUnhealthy:    func() EventPayload { return new(TaskEvent) },
+	EventTypeTargetChange:     func() EventPayload { return new(TargetChangeEvent) },
+	EventTypeStateTransition:  func() EventPayload { return new(StateTransitionEvent) },
+	EventTypeAutoRollback:     func() EventPayload { return new(AutoRollbackEvent) },
+}
+
+// ValidEventFormat verify if the event stream format is supported
+func ValidEventFormat(format string) bool {
+	switch format {
+	case EventFormatSSE, EventFormatNDJSON, EventFormatLegacy:
+		return true
+	}
+	return false
+}
+
+// EventPayload is implemented by the concrete events carried by the envelope
+type EventPayload interface {
+	EventType() string
+	EventAppID() string // the originating app
+}
+
+// Event is the typed & versioned envelope of all of the streamed events
+type Event struct {
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	Time          time.Time       `json:"time"`
+	Payload       json.RawMessage `json:"payload"`
+
+	AppID    string            `json:"-"` // the originating app
+	Labels   map[string]string `json:"-"` // the originating app's labels, resolved for filtering only
+	Detailed json.RawMessage   `json:"-"` // the payload with details, nil if no details carried
+}
+
+// detailer is implemented by the payloads carrying optional details,
+// the details are only streamed to the listeners requesting them.
+type detailer interface {
+	WithoutDetails() EventPayload
+}
+
+// NewEvent wraps the payload within the envelope. the payload is encoded at once,
+// so the caller is free to reuse it afterwards.
+func NewEvent(p EventPayload) *Event {
+	ev := &Event{
+		Type:          p.EventType(),
+		SchemaVersion: EventSchemaVersion,
+		Time:          time.Now(),
+		AppID:         p.EventAppID(),
+	}
+
+	if d, ok := p.(detailer); ok {
+		ev.Detailed, _ = json.Marshal(p)
+		p = d.WithoutDetails()
+	}
+	ev.Payload, _ = json.Marshal(p)
+
+	return ev
+}
+
+// WithDetails returns the copy of event carrying the detailed payload
+func (e *Event) WithDetails() *Event {
+	if e.Detailed == nil {
+		return e
+	}
+
+	cp := *e
+	cp.Payload = e.Detailed
+	return &cp
+}
+
+// Decode decodes the payload by the event type
+func (e *Event) Decode() (EventPayload, error) {
+	if e.SchemaVersion > EventSchemaVersion {
+		return nil, fmt.Errorf("unsupported event schema version %d, max %d", e.SchemaVersion, EventSchemaVersion)
+	}
+
+	fn, ok := eventPayloads[e.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %s", e.Type)
+	}
+
+	p := fn()
+	if err := json.Unmarshal(e.Payload, p); err != nil {
+		return nil, fmt.Errorf("decode %s event error: %v", e.Type, err)
+	}
+
+	return p, nil
+}
+
+// Encode encodes the event by stream format, nil if the event is not streamed by the format
+func (e *Event) Encode(format string) []byte {
+	switch format {
+	case EventFormatNDJSON:
+		bs, _ := json.Marshal(e)
+		return append(bs, '\n')
+	case EventFormatLegacy:
+		if !e.isTaskEvent() {
+			return nil
+		}
+		return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", e.Type, string(e.Payload)))
+	default:
+		bs, _ := json.Marshal(e)
+		return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", e.Type, string(bs)))
+	}
+}
+
+// EncodeBatch encodes the events into a single frame by stream format: the sse `batch`
+// event with the array of envelopes as data, or one ndjson line of the array.
+// the legacy format is not batched.
+func EncodeBatch(format string, evs []*Event) []byte {
+	bs, _ := json.Marshal(evs)
+
+	switch format {
+	case EventFormatNDJSON:
+		return append(bs, '\n')
+	case EventFormatLegacy:
+		return nil
+	default:
+		return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", EventTypeBatch, string(bs)))
+	}
+}
+
+func (e *Event) isTaskEvent() bool {
+	switch e.Type {
+	case EventTypeTaskHealthy, EventTypeTaskWeightChange, EventTypeTaskUnhealthy:
+		return true
+	}
+	return false
+}
+
 type CombinedEvents struct {
 	Event *TaskEvent
 	Proxy *upstream.BackendCombined // built from event
@@ -34,6 +183,48 @@ type TaskEvent struct {
 	TargetPort     uint64  `json:"target_port"`
 	Weight         float64 `json:"weihgt"`
 	GatewayEnabled bool    `json:"gateway"` // for proxy
+
+	// details, only streamed on requested
+	AgentID   string            `json:"agent_id,omitempty"`
+	Resources *TaskResources    `json:"resources,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"` // the version labels, routed by the proxy
+}
+
+// TaskResources is the allocated resources of the task
+type TaskResources struct {
+	CPUs float64 `json:"cpus"`
+	GPUs float64 `json:"gpus"`
+	Mem  float64 `json:"mem"`
+	Disk float64 `json:"disk"`
+}
+
+// SetDetails fills the task event details by the db task & version
+func (e *TaskEvent) SetDetails(task *Task, ver *Version) {
+	e.AgentID = task.AgentId
+	e.Resources = &TaskResources{
+		CPUs: ver.CPUs,
+		GPUs: ver.GPUs,
+		Mem:  ver.Mem,
+		Disk: ver.Disk,
+	}
+	e.Labels = ver.Labels
+}
+
+// WithoutDetails returns the copy of task event without details
+func (e *TaskEvent) WithoutDetails() EventPayload {
+	cp := *e
+	cp.AgentID = ""
+	cp.Resources = nil
+	cp.Labels = nil
+	return &cp
+}
+
+func (e *TaskEvent) EventType() string {
+	return e.Type
+}
+
+func (e *TaskEvent) EventAppID() string {
+	return e.AppID
 }
 
 // Format format task events to SSE text
@@ -41,3 +232,63 @@ func (e *TaskEvent) Format() []byte {
 	bs, _ := json.Marshal(e)
 	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", e.Type, string(bs)))
 }
+
+// TargetChangeEvent is emitted once the janitor proxy target added, removed or updated
+type TargetChangeEvent struct {
+	Change   string  `json:"change"` // add, del, update
+	Agent    string  `json:"agent"`  // the agent whose proxy changed
+	AppID    string  `json:"app_id"`
+	Upstream string  `json:"upstream"`
+	TaskID   string  `json:"task_id"`
+	IP       string  `json:"task_ip"`
+	Port     uint64  `json:"task_port"`
+	Weight   float64 `json:"weight"`
+}
+
+func (e *TargetChangeEvent) EventType() string {
+	return EventTypeTargetChange
+}
+
+func (e *TargetChangeEvent) EventAppID() string {
+	return e.AppID
+}
+
+// StateTransitionEvent is emitted once the app's operation status changed
+type StateTransitionEvent struct {
+	AppID  string `json:"app_id"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	ErrMsg string `json:"errmsg,omitempty"`
+}
+
+func (e *StateTransitionEvent) EventType() string {
+	return EventTypeStateTransition
+}
+
+func (e *StateTransitionEvent) EventAppID() string {
+	return e.AppID
+}
+
+const (
+	AutoRollbackStarted   = "started"
+	AutoRollbackCompleted = "completed"
+)
+
+// AutoRollbackEvent is emitted once the rolling update is rolled back on the unhealthy new tasks,
+// and once the rollback completed.
+type AutoRollbackEvent struct {
+	AppID    string `json:"app_id"`
+	Phase    string `json:"phase"`   // started, completed
+	Version  string `json:"version"` // the version rolled back from
+	Failures int    `json:"failures"`
+	Tasks    int    `json:"tasks"` // nb of the updated tasks to restore
+	ErrMsg   string `json:"errmsg,omitempty"`
+}
+
+func (e *AutoRollbackEvent) EventType() string {
+	return EventTypeAutoRollback
+}
+
+func (e *AutoRollbackEvent) EventAppID() string {
+	return e.AppID
+}
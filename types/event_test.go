@@ -0,0 +1,165 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEventSerialization(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload EventPayload
+		wantTyp string
+	}{
+		{
+			name:    "task healthy",
+			payload: &TaskEvent{Type: EventTypeTaskHealthy, AppID: "nginx.default.bbk.dataman", TaskID: "0.nginx", IP: "192.168.1.101", Port: 31000},
+			wantTyp: EventTypeTaskHealthy,
+		},
+		{
+			name:    "task unhealthy",
+			payload: &TaskEvent{Type: EventTypeTaskUnhealthy, AppID: "nginx.default.bbk.dataman", TaskID: "0.nginx"},
+			wantTyp: EventTypeTaskUnhealthy,
+		},
+		{
+			name:    "task weight change",
+			payload: &TaskEvent{Type: EventTypeTaskWeightChange, AppID: "nginx.default.bbk.dataman", TaskID: "0.nginx", Weight: 50},
+			wantTyp: EventTypeTaskWeightChange,
+		},
+		{
+			name:    "target change",
+			payload: &TargetChangeEvent{Change: "add", AppID: "nginx.default.bbk.dataman", Upstream: "nginx.default.bbk.dataman", TaskID: "0.nginx", IP: "192.168.1.101", Port: 31000, Weight: 100},
+			wantTyp: EventTypeTargetChange,
+		},
+		{
+			name:    "state transition",
+			payload: &StateTransitionEvent{AppID: "nginx.default.bbk.dataman", From: OpStatusNoop, To: OpStatusScalingUp},
+			wantTyp: EventTypeStateTransition,
+		},
+		{
+			name:    "auto rollback",
+			payload: &AutoRollbackEvent{AppID: "nginx.default.bbk.dataman", Phase: AutoRollbackCompleted, Version: "1504231000000000000", Failures: 1, Tasks: 2},
+			wantTyp: EventTypeAutoRollback,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := NewEvent(tt.payload)
+			if ev.Type != tt.wantTyp || ev.SchemaVersion != EventSchemaVersion {
+				t.Fatalf("envelope = %s v%d, want %s v%d", ev.Type, ev.SchemaVersion, tt.wantTyp, EventSchemaVersion)
+			}
+
+			// sse
+			sse := ev.Encode(EventFormatSSE)
+			prefix := "event: " + tt.wantTyp + "\ndata: "
+			if !bytes.HasPrefix(sse, []byte(prefix)) || !bytes.HasSuffix(sse, []byte("\n\n")) {
+				t.Fatalf("sse = %q, want prefix %q", sse, prefix)
+			}
+			sseData := bytes.TrimSuffix(bytes.TrimPrefix(sse, []byte(prefix)), []byte("\n\n"))
+
+			// ndjson
+			nd := ev.Encode(EventFormatNDJSON)
+			if !bytes.HasSuffix(nd, []byte("\n")) || bytes.Count(nd, []byte("\n")) != 1 {
+				t.Fatalf("ndjson = %q, want one line", nd)
+			}
+			if !bytes.Equal(bytes.TrimSuffix(nd, []byte("\n")), sseData) {
+				t.Errorf("ndjson %s differs from sse data %s", nd, sseData)
+			}
+
+			// consumers decode the payload by the type
+			var got Event
+			if err := json.Unmarshal(sseData, &got); err != nil {
+				t.Fatalf("unmarshal envelope error = %v", err)
+			}
+			p, err := got.Decode()
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(p, tt.payload) {
+				t.Errorf("Decode() = %+v, want %+v", p, tt.payload)
+			}
+		})
+	}
+}
+
+func TestEventSchemaVersion(t *testing.T) {
+	ev := NewEvent(&TaskEvent{Type: EventTypeTaskHealthy, AppID: "nginx.default.bbk.dataman"})
+
+	// the envelopes from a newer producer are rejected rather than misread
+	ev.SchemaVersion = EventSchemaVersion + 1
+	if _, err := ev.Decode(); err == nil {
+		t.Errorf("Decode() of schema version %d expected error", ev.SchemaVersion)
+	}
+
+	ev.SchemaVersion = EventSchemaVersion
+	ev.Type = "unknown"
+	if _, err := ev.Decode(); err == nil {
+		t.Errorf("Decode() of unknown type expected error")
+	}
+}
+
+func TestEventLegacyFormat(t *testing.T) {
+	task := &TaskEvent{Type: EventTypeTaskHealthy, AppID: "nginx.default.bbk.dataman", TaskID: "0.nginx"}
+
+	if got, want := NewEvent(task).Encode(EventFormatLegacy), task.Format(); !bytes.Equal(got, want) {
+		t.Errorf("legacy = %q, want %q", got, want)
+	}
+
+	// the new event types are not streamed in legacy format
+	if got := NewEvent(&StateTransitionEvent{AppID: "nginx"}).Encode(EventFormatLegacy); got != nil {
+		t.Errorf("legacy state transition = %q, want nil", got)
+	}
+
+	if !strings.Contains(string(NewEvent(task).Encode(EventFormatSSE)), `"schema_version":1`) {
+		t.Errorf("sse should carry the schema version")
+	}
+}
+
+func TestEventDetails(t *testing.T) {
+	var (
+		task = &Task{ID: "0.nginx", AgentId: "5e7a-S1"}
+		ver  = &Version{CPUs: 0.5, Mem: 128, Disk: 64, Labels: map[string]string{"tier": "gold"}}
+		ev   = &TaskEvent{Type: EventTypeTaskHealthy, AppID: "nginx.default.bbk.dataman", TaskID: "0.nginx"}
+	)
+	ev.SetDetails(task, ver)
+
+	decode := func(e *Event) *TaskEvent {
+		p, err := e.Decode()
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		return p.(*TaskEvent)
+	}
+
+	wrapped := NewEvent(ev)
+
+	// not carried by default
+	if got := decode(wrapped); got.AgentID != "" || got.Resources != nil || got.Labels != nil {
+		t.Errorf("default payload = %+v, should not carry details", got)
+	}
+	if strings.Contains(string(wrapped.Encode(EventFormatSSE)), "resources") {
+		t.Errorf("default sse should not carry details")
+	}
+
+	// carried on requested
+	got := decode(wrapped.WithDetails())
+	if got.AgentID != "5e7a-S1" {
+		t.Errorf("agent id = %s, want 5e7a-S1", got.AgentID)
+	}
+	if want := (&TaskResources{CPUs: 0.5, Mem: 128, Disk: 64}); !reflect.DeepEqual(got.Resources, want) {
+		t.Errorf("resources = %+v, want %+v", got.Resources, want)
+	}
+	if got.Labels["tier"] != "gold" {
+		t.Errorf("labels = %v, want the version labels", got.Labels)
+	}
+
+	// the events without details are as is
+	st := NewEvent(&StateTransitionEvent{AppID: "nginx", From: OpStatusNoop, To: OpStatusUpdating})
+	if st.WithDetails() != st {
+		t.Errorf("WithDetails() of event without details should be as is")
+	}
+}
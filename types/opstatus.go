@@ -0,0 +1,65 @@
+package types
+
+import "time"
+
+// opStatusTransitions is the allowed op status transitions. the in-progress operations
+// return to noop once done, the canary update may stay unfinished, and deleting is
+// allowed from any status.
+var opStatusTransitions = map[string][]string{
+	OpStatusNoop: {
+		OpStatusScalingUp,
+		OpStatusScalingDown,
+		OpStatusUpdating,
+		OpStatusCanaryUpdating,
+		OpStatusStarting,
+		OpStatusStopping,
+		OpStatusRollback,
+		OpStatusPaused,
+		OpStatusDeleting,
+	},
+	OpStatusCreating:         {OpStatusNoop, OpStatusDeleting},
+	OpStatusScalingUp:        {OpStatusNoop, OpStatusDeleting},
+	OpStatusScalingDown:      {OpStatusNoop, OpStatusDeleting},
+	OpStatusUpdating:         {OpStatusNoop, OpStatusRollback, OpStatusDeleting}, // rollback on failure
+	OpStatusCanaryUpdating:   {OpStatusNoop, OpStatusCanaryUnfinished, OpStatusDeleting},
+	OpStatusCanaryUnfinished: {OpStatusCanaryUpdating, OpStatusWeightUpdating, OpStatusDeleting},
+	OpStatusWeightUpdating:   {OpStatusNoop, OpStatusCanaryUnfinished, OpStatusDeleting},
+	OpStatusStarting:         {OpStatusNoop, OpStatusDeleting},
+	OpStatusStopping:         {OpStatusNoop, OpStatusDeleting},
+	OpStatusRollback:         {OpStatusNoop, OpStatusDeleting},
+	OpStatusPaused:           {OpStatusNoop, OpStatusDeleting}, // resumed
+	OpStatusDeleting:         {OpStatusNoop},                   // on deleting failure
+}
+
+// AllowedOpStatus returns the op statuses which could be transited to from the op status
+func AllowedOpStatus(from string) []string {
+	allowed, ok := opStatusTransitions[from]
+	if !ok {
+		return []string{OpStatusNoop, OpStatusDeleting} // unknown status could only be reset or deleted
+	}
+	return append([]string(nil), allowed...)
+}
+
+// IsKnownOpStatus tells if the op status is one of the defined ones
+func IsKnownOpStatus(s string) bool {
+	_, ok := opStatusTransitions[s]
+	return ok
+}
+
+// CanTransitOpStatus verify if the op status transition is allowed
+func CanTransitOpStatus(from, to string) bool {
+	for _, s := range AllowedOpStatus(from) {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OpTransition records one op status transition of the app
+type OpTransition struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason,omitempty"` // the error message if any
+	Time   time.Time `json:"time"`
+}
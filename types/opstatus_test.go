@@ -0,0 +1,29 @@
+package types
+
+import "testing"
+
+func TestCanTransitOpStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     bool
+	}{
+		{name: "begin scaling", from: OpStatusNoop, to: OpStatusScalingUp, want: true},
+		{name: "another operation in progress", from: OpStatusScalingUp, to: OpStatusUpdating},
+		{name: "weights of unfinished canary", from: OpStatusCanaryUnfinished, to: OpStatusWeightUpdating, want: true},
+		{name: "weights without canary", from: OpStatusNoop, to: OpStatusWeightUpdating},
+		{name: "deleting in progress", from: OpStatusUpdating, to: OpStatusDeleting, want: true},
+		{name: "rollback on update failure", from: OpStatusUpdating, to: OpStatusRollback, want: true},
+		{name: "pause", from: OpStatusNoop, to: OpStatusPaused, want: true},
+		{name: "scaling while paused", from: OpStatusPaused, to: OpStatusScalingUp},
+		{name: "unknown status reset", from: "unknown", to: OpStatusNoop, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanTransitOpStatus(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransitOpStatus(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,14 @@
 package types
 
+const (
+	DrainOrderIndex = "index" // the highest indexed tasks first, by default
+	DrainOrderAge   = "age"   // the oldest tasks first
+)
+
 type Scale struct {
 	Instances int
 	IPs       []string
+
+	DrainOrder  string `json:"drainOrder"`  // which tasks are removed on scaling down
+	GracePeriod int64  `json:"gracePeriod"` // seconds to wait for the drained task before killing
 }
@@ -17,7 +17,7 @@ const (
 	// update onfailure action
 	UpdateStop     = "stop"
 	UpdateContinue = "continue"
-	UpdateRollback = "rollback" // TODO(nmg)
+	UpdateRollback = "rollback" // restore the updated tasks once too many new tasks unhealthy
 )
 
 type VersionList []*Version
@@ -196,12 +196,22 @@ func (p *RestartPolicy) Valid() error {
 type UpdatePolicy struct {
 	Delay     float64 `json:"delay"`
 	OnFailure string  `json:"onFailure,omitempty"`
+
+	// for the rollback on failure
+	HealthDeadline float64 `json:"healthDeadline,omitempty"` // seconds for the new task to be healthy, 0 to not wait
+	MaxFailures    int     `json:"maxFailures,omitempty"`    // failed new tasks triggering the rollback, 1 by default
 }
 
 func (p *UpdatePolicy) Valid() error {
 	if p.Delay < 0 {
 		return errors.New("UpdatePolicy.Delay can't be negative")
 	}
+	if p.HealthDeadline < 0 {
+		return errors.New("UpdatePolicy.HealthDeadline can't be negative")
+	}
+	if p.MaxFailures < 0 {
+		return errors.New("UpdatePolicy.MaxFailures can't be negative")
+	}
 	switch p.OnFailure {
 	case UpdateStop, UpdateContinue, UpdateRollback:
 	default:
@@ -448,10 +458,8 @@ func (v *Version) Validate() error {
 	}
 
 	// verify constraints
-	for _, cons := range v.Constraints {
-		if err := cons.validate(); err != nil {
-			return err
-		}
+	if err := ValidateConstraints(v.Constraints); err != nil {
+		return err
 	}
 
 	// verify proxy
@@ -0,0 +1,104 @@
+package pagination
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000 // the larger limit is capped
+)
+
+// Params is the requested page
+type Params struct {
+	Offset int
+	Limit  int
+}
+
+// Page is the response envelope of one page of the list
+type Page struct {
+	Items  interface{} `json:"items"`
+	Total  int         `json:"total"` // nb of all of the items
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Next   string      `json:"next,omitempty"` // cursor of the next page, empty on the last page
+}
+
+// Parse parses the page by the query parameters `limit`, `offset` or `cursor`,
+// the cursor is the `next` of the previous page. nil if none of them is specified,
+// so that the list is responded as is for the compatibility.
+func Parse(query url.Values) (*Params, error) {
+	var (
+		limit  = query.Get("limit")
+		offset = query.Get("offset")
+		cursor = query.Get("cursor")
+	)
+
+	if limit == "" && offset == "" && cursor == "" {
+		return nil, nil
+	}
+
+	if offset != "" && cursor != "" {
+		return nil, errors.New("offset and cursor are mutually exclusive")
+	}
+
+	p := &Params{Limit: DefaultLimit}
+
+	if limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit %q, should be a positive integer", limit)
+		}
+		if n > MaxLimit {
+			n = MaxLimit
+		}
+		p.Limit = n
+	}
+
+	if cursor != "" {
+		offset = cursor
+	}
+	if offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid offset or cursor %q", offset)
+		}
+		p.Offset = n
+	}
+
+	return p, nil
+}
+
+// Bounds returns the range [start, end) of the page within the total items
+func (p *Params) Bounds(total int) (int, int) {
+	start := p.Offset
+	if start > total {
+		start = total
+	}
+
+	end := start + p.Limit
+	if end > total {
+		end = total
+	}
+
+	return start, end
+}
+
+// Page wraps the items of the page within the envelope
+func (p *Params) Page(total int, items interface{}) *Page {
+	page := &Page{
+		Items:  items,
+		Total:  total,
+		Offset: p.Offset,
+		Limit:  p.Limit,
+	}
+
+	if _, end := p.Bounds(total); end < total {
+		page.Next = strconv.Itoa(end)
+	}
+
+	return page
+}
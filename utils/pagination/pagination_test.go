@@ -0,0 +1,105 @@
+package pagination
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestPagination(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		total     int
+		wantNil   bool
+		wantErr   bool
+		wantStart int
+		wantEnd   int
+		wantNext  string
+	}{
+		{
+			name:    "not paginated",
+			query:   "",
+			total:   10,
+			wantNil: true,
+		},
+		{
+			name:     "first page",
+			query:    "limit=4",
+			total:    10,
+			wantEnd:  4,
+			wantNext: "4",
+		},
+		{
+			name:      "middle page by cursor",
+			query:     "limit=4&cursor=4",
+			total:     10,
+			wantStart: 4,
+			wantEnd:   8,
+			wantNext:  "8",
+		},
+		{
+			name:      "last page",
+			query:     "limit=4&offset=8",
+			total:     10,
+			wantStart: 8,
+			wantEnd:   10,
+		},
+		{
+			name:      "out of range offset",
+			query:     "offset=20",
+			total:     10,
+			wantStart: 10,
+			wantEnd:   10,
+		},
+		{
+			name:    "capped limit",
+			query:   "limit=5000",
+			total:   2000,
+			wantEnd: MaxLimit,
+			// the next page follows the capped limit
+			wantNext: "1000",
+		},
+		{
+			name:    "zero limit",
+			query:   "limit=0",
+			wantErr: true,
+		},
+		{
+			name:    "negative offset",
+			query:   "offset=-1",
+			wantErr: true,
+		},
+		{
+			name:    "both offset and cursor",
+			query:   "offset=1&cursor=2",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, _ := url.ParseQuery(tt.query)
+			p, err := Parse(q)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (p == nil) != tt.wantNil {
+				t.Fatalf("Parse() = %+v, want nil %v", p, tt.wantNil)
+			}
+			if p == nil {
+				return
+			}
+
+			start, end := p.Bounds(tt.total)
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("Bounds() = [%d, %d), want [%d, %d)", start, end, tt.wantStart, tt.wantEnd)
+			}
+			if page := p.Page(tt.total, nil); page.Next != tt.wantNext || page.Total != tt.total {
+				t.Errorf("Page() next %q total %d, want %q %d", page.Next, page.Total, tt.wantNext, tt.total)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// RequestIDHeader is the header carrying the request correlation id across the
+// manager, the proxy and the backends.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// NewUUID returns a random (version 4) uuid
+func NewUUID() string {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic(err) // This shouldn't happen
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// TagRequestID honors the inbound request id or generates a new one, the id is set
+// onto the request header to be forwarded, and attached to the returned request's context.
+func TagRequestID(r *http.Request) (*http.Request, string) {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > 128 {
+		id = NewUUID()
+		r.Header.Set(RequestIDHeader, id)
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)), id
+}
+
+// RequestID returns the request id attached to the context, empty if not attached.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}